@@ -0,0 +1,611 @@
+package main
+
+import (
+	"fmt"
+	"math/bits"
+	"strings"
+)
+
+// the arm64 backend lowers every x86-64 instruction of the output to AArch64 instructions.
+// the registers of x86-64 live in x0-x15 and x29, and the stack of the compiled code is x28 instead of sp,
+// because it moves by 8 bytes while sp has to stay aligned to 16 bytes. calls push the return address to it like callq,
+// so the frames keep their layout. syscalls go through runtime.syscall, which maps the numbers and the registers to arm64.
+// x16, x17 and x19-x21 are scratch registers of the lowering, and x22-x27 are saved by runtime.syscall
+var arm64Regs = map[string]string{
+	"rax": "x0", "rbx": "x1", "rcx": "x2", "rdx": "x3", "rsi": "x4", "rdi": "x5",
+	"r8": "x8", "r9": "x9", "r10": "x10", "r11": "x11", "r12": "x12", "r13": "x13", "r14": "x14", "r15": "x15",
+	"rbp": "x29", "rsp": "x28",
+}
+
+// arm64Conds are the conditions of jcc, setcc and cmovcc. the carry of arm64 is the inverse of the borrow of x86-64 after cmp
+var arm64Conds = map[string]string{
+	"e": "eq", "z": "eq", "ne": "ne", "nz": "ne",
+	"l": "lt", "le": "le", "g": "gt", "ge": "ge",
+	"b": "lo", "be": "ls", "a": "hi", "ae": "hs",
+	"s": "mi", "ns": "pl",
+}
+
+var arm64Inverse = map[string]string{
+	"eq": "ne", "ne": "eq", "lt": "ge", "ge": "lt", "le": "gt", "gt": "le",
+	"lo": "hs", "hs": "lo", "ls": "hi", "hi": "ls", "mi": "pl", "pl": "mi",
+}
+
+// x86 syscall numbers to arm64. open is openat with AT_FDCWD
+var arm64Syscalls = [][2]int{{0, 63}, {1, 64}, {3, 57}, {9, 222}, {12, 214}, {60, 93}, {231, 94}}
+
+type arm64Lowering struct {
+	out    []*asmLine
+	labels int
+	text   bool // in the .text section
+}
+
+func lowerArm64(lines []*asmLine) []*asmLine {
+	a := &arm64Lowering{}
+	for _, line := range lines {
+		a.line(line)
+	}
+	a.syscall()
+	return a.out
+}
+
+func (a *arm64Lowering) emit(format string, args ...any) {
+	a.out = append(a.out, &asmLine{text: fmt.Sprintf(format, args...)})
+}
+
+func (a *arm64Lowering) ins(format string, args ...any) {
+	a.emit("  "+format, args...)
+}
+
+func (a *arm64Lowering) newLabel() string {
+	a.labels++
+	return fmt.Sprintf(".L.arm64.%d", a.labels)
+}
+
+func (a *arm64Lowering) line(line *asmLine) {
+	s := strings.TrimSpace(line.text)
+	switch {
+	case line.native:
+		a.out = append(a.out, line)
+	case s == "":
+		a.emit("")
+	case strings.HasPrefix(s, "#"):
+		a.emit("//%s", s[1:])
+	case isAsmLabel(line):
+		label, comment, _ := strings.Cut(line.text, " # ")
+		if comment != "" {
+			a.emit("%s // %s", label, comment)
+		} else {
+			a.emit("%s", label)
+		}
+		if s == "_start:" {
+			a.ins("mov x28, sp")
+		}
+	case line.op == "":
+		directive, _, _ := strings.Cut(s, " ")
+		text, comment := cutAsmComment(line.text)
+		if comment != "" {
+			text += " // " + comment
+		}
+		a.emit("%s", text)
+		switch directive {
+		case ".text":
+			a.text = true
+			a.ins(".p2align 2")
+		case ".data", ".section":
+			a.text = false
+		case ".quad", ".string", ".ascii", ".zero", ".byte":
+			if a.text { // keep the next instruction aligned
+				a.ins(".p2align 2")
+			}
+		}
+	default:
+		if line.comment != "" {
+			a.ins("// %s", line.comment)
+		}
+		var args []x86Operand
+		for _, arg := range line.args {
+			args = append(args, parseX86Operand(arg))
+		}
+		a.instr(line.op, args)
+	}
+}
+
+// reg returns the arm64 register of the x86-64 register r with width bits, wN for 32 bits or less
+func arm64Reg(r string, width int) string {
+	x, ok := arm64Regs[r]
+	if !ok {
+		must(fmt.Errorf("arm64: unknown register %s", r))
+	}
+	if width <= 32 {
+		return "w" + x[1:]
+	}
+	return x
+}
+
+func arm64Sized(x string, width int) string {
+	if width <= 32 {
+		return "w" + x[1:]
+	}
+	return x
+}
+
+// arm64Suffix returns the suffix of ldr and str for the width
+func arm64Suffix(width int) string {
+	switch width {
+	case 8:
+		return "b"
+	case 16:
+		return "h"
+	}
+	return ""
+}
+
+// imm loads v to the register x
+func (a *arm64Lowering) imm(x string, v int64) {
+	for _, ins := range arm64Imm(x, v) {
+		a.ins("%s", ins)
+	}
+}
+
+// arm64Imm returns the instructions loading v to the register x
+func arm64Imm(x string, v int64) []string {
+	if v >= -65536 && v < 65536 {
+		return []string{fmt.Sprintf("mov %s, #%d", x, v)}
+	}
+	var ins []string
+	u := uint64(v)
+	for shift := 0; shift < 64; shift += 16 {
+		chunk := (u >> shift) & 0xffff
+		if chunk == 0 {
+			continue
+		}
+		if len(ins) == 0 {
+			ins = append(ins, fmt.Sprintf("movz %s, #%d, lsl #%d", x, chunk, shift))
+		} else {
+			ins = append(ins, fmt.Sprintf("movk %s, #%d, lsl #%d", x, chunk, shift))
+		}
+	}
+	return ins
+}
+
+// addr computes the address of the memory operand m to the register x
+func (a *arm64Lowering) addr(x string, m x86Operand) {
+	switch {
+	case m.sym != "":
+		sym := m.sym
+		if m.disp != 0 {
+			sym = fmt.Sprintf("%s%+d", sym, m.disp)
+		}
+		a.ins("adrp %s, %s", x, sym)
+		a.ins("add %s, %s, :lo12:%s", x, x, sym)
+		if m.base != "" && m.base != "rip" {
+			a.ins("add %s, %s, %s", x, x, arm64Reg(m.base, 64))
+		}
+	case m.base == "":
+		a.imm(x, m.disp)
+	default:
+		a.ins("mov %s, %s", x, arm64Reg(m.base, 64))
+		a.addImm(x, m.disp)
+	}
+	if m.index != "" {
+		a.ins("add %s, %s, %s, lsl #%d", x, x, arm64Reg(m.index, 64), bits.TrailingZeros(uint(m.scale)))
+	}
+}
+
+// addImm adds v to the register x
+func (a *arm64Lowering) addImm(x string, v int64) {
+	switch {
+	case v == 0:
+	case v > 0 && v < 4096:
+		a.ins("add %s, %s, #%d", x, x, v)
+	case v < 0 && v > -4096:
+		a.ins("sub %s, %s, #%d", x, x, -v)
+	default:
+		a.imm("x21", v)
+		a.ins("add %s, %s, x21", x, x)
+	}
+}
+
+// mem returns the operand of ldr and str for the memory operand m accessing width bits.
+// the address is computed to x17 unless it is a register with an offset in the range of the instructions
+func (a *arm64Lowering) mem(m x86Operand, width int) string {
+	size := int64(width / 8)
+	if m.sym == "" && m.base != "" && m.index == "" {
+		base := arm64Reg(m.base, 64)
+		switch {
+		case m.disp == 0:
+			return fmt.Sprintf("[%s]", base)
+		case m.disp >= -256 && m.disp < 256, m.disp > 0 && m.disp%size == 0 && m.disp/size < 4096:
+			return fmt.Sprintf("[%s, #%d]", base, m.disp)
+		}
+	}
+	a.addr("x17", m)
+	return "[x17]"
+}
+
+func (a *arm64Lowering) load(x string, m x86Operand, width int) {
+	a.ins("ldr%s %s, %s", arm64Suffix(width), arm64Sized(x, width), a.mem(m, width))
+}
+
+func (a *arm64Lowering) store(x string, m x86Operand, width int) {
+	a.ins("str%s %s, %s", arm64Suffix(width), arm64Sized(x, width), a.mem(m, width))
+}
+
+// value returns a register of width bits holding the operand, which is loaded to the scratch register x if needed.
+// the bytes of registers are zero extended
+func (a *arm64Lowering) value(op x86Operand, width int, x string) string {
+	switch {
+	case op.reg != "":
+		r := arm64Reg(op.reg, width)
+		if width < 32 {
+			a.ins("uxt%s %s, %s", arm64Suffix(width), arm64Sized(x, 32), r)
+			return arm64Sized(x, 32)
+		}
+		return r
+	case op.isImm:
+		v := op.imm
+		if width == 32 {
+			v = int64(uint32(v))
+		}
+		a.imm(x, v)
+	case op.mem || op.sym != "":
+		op.mem = true
+		a.load(x, op, width)
+	}
+	return arm64Sized(x, width)
+}
+
+// operand is value, or an immediate of add, sub and cmp in the range of 12 bits
+func (a *arm64Lowering) operand(op x86Operand, width int, x string) string {
+	if op.isImm && op.imm >= 0 && op.imm < 4096 {
+		return fmt.Sprintf("#%d", op.imm)
+	}
+	return a.value(op, width, x)
+}
+
+// writeBack stores the register x to the destination operand dst of width bits.
+// bytes of registers keep the other bits like x86-64, while 32 bits are zero extended
+func (a *arm64Lowering) writeBack(x string, dst x86Operand, width int) {
+	x = "x" + x[1:]
+	if dst.reg == "" {
+		a.store(x, dst, width)
+		return
+	}
+	switch width {
+	case 8, 16:
+		a.ins("bfi %s, %s, #0, #%d", arm64Reg(dst.reg, 64), x, width)
+	case 32:
+		if d := arm64Reg(dst.reg, 32); d != arm64Sized(x, 32) {
+			a.ins("mov %s, %s", d, arm64Sized(x, 32))
+		}
+	default:
+		if d := arm64Reg(dst.reg, 64); d != x {
+			a.ins("mov %s, %s", d, x)
+		}
+	}
+}
+
+// call pushes the return address to the stack of x28 like callq
+func (a *arm64Lowering) call(target x86Operand) {
+	ret := a.newLabel()
+	a.ins("adr x16, %s", ret)
+	a.ins("str x16, [x28, #-8]!")
+	if target.reg != "" {
+		a.ins("br %s", arm64Reg(target.reg, 64))
+	} else {
+		a.ins("b %s", target.sym)
+	}
+	a.emit("%s:", ret)
+}
+
+// branch jumps to label on cond. b.cond reaches 1MB, so jumps to other than local labels branch over b
+func (a *arm64Lowering) branch(cond, label string) {
+	if strings.HasPrefix(label, ".L") {
+		a.ins("b.%s %s", cond, label)
+		return
+	}
+	skip := a.newLabel()
+	a.ins("b.%s %s", arm64Inverse[cond], skip)
+	a.ins("b %s", label)
+	a.emit("%s:", skip)
+}
+
+func (a *arm64Lowering) instr(op string, args []x86Operand) {
+	switch op {
+	case "ret":
+		a.ins("ldr x30, [x28], #8")
+		a.ins("ret")
+		return
+	case "leave":
+		a.ins("mov x28, x29")
+		a.ins("ldr x29, [x28], #8")
+		return
+	case "syscall":
+		a.ins("bl runtime.syscall")
+		return
+	case "cqto", "cqo":
+		a.ins("asr x3, x0, #63")
+		return
+	case "callq", "call":
+		a.call(args[0])
+		return
+	case "jmp":
+		if args[0].reg != "" {
+			a.ins("br %s", arm64Reg(args[0].reg, 64))
+		} else {
+			a.ins("b %s", args[0].sym)
+		}
+		return
+	case "rep", "repe":
+		a.repeat(op + " " + args[0].sym)
+		return
+	case "movzbq", "movzbl", "movzwq", "movzwl", "movsbq", "movsbl", "movswq", "movslq":
+		a.extend(op, args[0], args[1])
+		return
+	}
+	if cond, ok := arm64Conds[strings.TrimPrefix(op, "j")]; ok && op[0] == 'j' {
+		a.branch(cond, args[0].sym)
+		return
+	}
+	if cond, ok := arm64Conds[strings.TrimPrefix(op, "set")]; ok && strings.HasPrefix(op, "set") {
+		if args[0].reg != "" {
+			a.ins("cset %s, %s", arm64Reg(args[0].reg, 32), cond)
+		} else {
+			a.ins("cset w16, %s", cond)
+			a.store("x16", args[0], 8)
+		}
+		return
+	}
+	if cond, ok := arm64Conds[strings.TrimSuffix(strings.TrimPrefix(op, "cmov"), "q")]; ok && strings.HasPrefix(op, "cmov") {
+		src := a.value(args[0], 64, "x16")
+		dst := arm64Reg(args[1].reg, 64)
+		a.ins("csel %s, %s, %s, %s", dst, src, dst, cond)
+		return
+	}
+
+	width := x86Width(op)
+	base := op[:len(op)-1]
+	switch base {
+	case "mov":
+		a.move(args[0], args[1], width)
+	case "lea":
+		a.addr(arm64Reg(args[1].reg, 64), args[0])
+	case "push":
+		src := a.value(args[0], 64, "x16")
+		a.ins("str %s, [x28, #-8]!", src)
+	case "pop":
+		if args[0].reg != "" {
+			a.ins("ldr %s, [x28], #8", arm64Reg(args[0].reg, 64))
+		} else {
+			a.ins("ldr x16, [x28], #8")
+			a.store("x16", args[0], 64)
+		}
+	case "add", "sub", "and", "or", "xor":
+		a.arith(base, args[0], args[1], width)
+	case "cmp":
+		x := a.value(args[1], width, "x16")
+		if args[0].isImm && args[0].imm < 0 && args[0].imm > -4096 {
+			a.ins("cmn %s, #%d", x, -args[0].imm)
+		} else {
+			a.ins("cmp %s, %s", x, a.operand(args[0], width, "x19"))
+		}
+	case "test":
+		x := a.value(args[1], width, "x16")
+		a.ins("tst %s, %s", x, a.value(args[0], width, "x19"))
+	case "inc", "dec", "neg", "not":
+		x := a.value(args[0], width, "x19")
+		switch base {
+		case "inc":
+			a.ins("adds %s, %s, #1", x, x)
+		case "dec":
+			a.ins("subs %s, %s, #1", x, x)
+		case "neg":
+			a.ins("negs %s, %s", x, x)
+		case "not":
+			a.ins("mvn %s, %s", x, x)
+		}
+		a.writeBack(x, args[0], width)
+	case "imul":
+		if len(args) == 3 { // imulq $n, src, dst
+			a.ins("mul %s, %s, %s", arm64Reg(args[2].reg, 64), a.value(args[1], 64, "x19"), a.value(args[0], 64, "x16"))
+			return
+		}
+		x := a.value(args[1], 64, "x19")
+		a.ins("mul %s, %s, %s", x, x, a.value(args[0], 64, "x16"))
+		a.writeBack(x, args[1], 64)
+	case "idiv", "div":
+		d := a.value(args[0], 64, "x19")
+		div := map[string]string{"idiv": "sdiv", "div": "udiv"}[base]
+		a.ins("%s x16, x0, %s", div, d)
+		a.ins("msub x3, x16, %s, x0", d)
+		a.ins("mov x0, x16")
+	case "shl", "sal", "shr", "sar":
+		shift := map[string]string{"shl": "lsl", "sal": "lsl", "shr": "lsr", "sar": "asr"}[base]
+		dst := args[len(args)-1]
+		x := a.value(dst, width, "x19")
+		switch {
+		case len(args) == 1:
+			a.ins("%s %s, %s, #1", shift, x, x)
+		case args[0].isImm:
+			a.ins("%s %s, %s, #%d", shift, x, x, args[0].imm)
+		default: // %cl
+			a.ins("%s %s, %s, %s", shift, x, x, arm64Sized("x2", width))
+		}
+		a.ins("tst %s, %s", x, x) // the shifts of x86-64 set the flags like shrq $4, %rax; jne
+		a.writeBack(x, dst, width)
+	case "bts", "btr":
+		// the bit string at the memory operand, indexed by a register
+		bit := arm64Reg(args[0].reg, 64)
+		a.addr("x17", args[1])
+		a.ins("lsr x16, %s, #6", bit)
+		a.ins("add x17, x17, x16, lsl #3")
+		a.ins("ldr x19, [x17]")
+		a.ins("and x16, %s, #63", bit)
+		a.ins("mov x20, #1")
+		a.ins("lsl x20, x20, x16")
+		if base == "bts" {
+			a.ins("orr x19, x19, x20")
+		} else {
+			a.ins("bic x19, x19, x20")
+		}
+		a.ins("str x19, [x17]")
+	case "bsr":
+		src := a.value(args[0], 64, "x19")
+		a.ins("clz x16, %s", src)
+		a.ins("mov x17, #63")
+		a.ins("sub %s, x17, x16", arm64Reg(args[1].reg, 64))
+	default:
+		must(fmt.Errorf("arm64: unsupported instruction %s", op))
+	}
+}
+
+// move lowers mov from src to dst of width bits
+func (a *arm64Lowering) move(src, dst x86Operand, width int) {
+	if dst.reg == "" {
+		x := "x16"
+		if src.reg != "" {
+			x = arm64Regs[src.reg]
+		} else {
+			a.value(src, width, x)
+		}
+		a.store(x, dst, width)
+		return
+	}
+	if width < 32 {
+		a.value(src, width, "x16")
+		a.writeBack("x16", dst, width)
+		return
+	}
+	d := arm64Reg(dst.reg, width)
+	switch {
+	case src.reg != "":
+		a.ins("mov %s, %s", d, arm64Reg(src.reg, width))
+	case src.isImm:
+		v := src.imm
+		if width == 32 {
+			v = int64(uint32(v))
+		}
+		a.imm(arm64Reg(dst.reg, 64), v)
+	default:
+		a.load(arm64Regs[dst.reg], src, width)
+	}
+}
+
+// arith lowers add, sub, and, or and xor. add, sub and and set the flags like x86-64
+func (a *arm64Lowering) arith(op string, src, dst x86Operand, width int) {
+	insn := map[string]string{"add": "adds", "sub": "subs", "and": "ands", "or": "orr", "xor": "eor"}[op]
+	if src.isImm && src.imm < 0 && src.imm > -4096 && (op == "add" || op == "sub") {
+		insn = map[string]string{"add": "subs", "sub": "adds"}[op]
+		src.imm = -src.imm
+	}
+	x := "x19"
+	if dst.reg != "" && width >= 32 {
+		x = arm64Regs[dst.reg]
+	} else {
+		a.value(dst, width, x)
+	}
+	var s string
+	if op == "add" || op == "sub" {
+		s = a.operand(src, width, "x16")
+	} else {
+		s = a.value(src, width, "x16")
+	}
+	a.ins("%s %s, %s, %s", insn, arm64Sized(x, width), arm64Sized(x, width), s)
+	if dst.reg == "" || width < 32 {
+		a.writeBack(x, dst, width)
+	}
+}
+
+// extend lowers movzx and movsx like movzbq
+func (a *arm64Lowering) extend(op string, src, dst x86Operand) {
+	from := map[byte]int{'b': 8, 'w': 16, 'l': 32}[op[4]]
+	signed := op[3] == 's'
+	d := arm64Reg(dst.reg, 64)
+	if src.reg != "" {
+		if signed {
+			a.ins("sxt%s %s, %s", map[int]string{8: "b", 16: "h", 32: "w"}[from], d, arm64Reg(src.reg, 32))
+		} else {
+			a.ins("uxt%s %s, %s", arm64Suffix(from), arm64Sized(d, 32), arm64Reg(src.reg, 32))
+		}
+		return
+	}
+	if signed {
+		a.ins("ldrs%s %s, %s", map[int]string{8: "b", 16: "h", 32: "w"}[from], d, a.mem(src, from))
+	} else {
+		a.ins("ldr%s %s, %s", arm64Suffix(from), arm64Sized(d, 32), a.mem(src, from))
+	}
+}
+
+// repeat lowers rep movsb, rep stosb and repe cmpsb to loops counting rcx down
+func (a *arm64Lowering) repeat(op string) {
+	loop, done := a.newLabel(), a.newLabel()
+	a.ins("cbz x2, %s", done)
+	a.emit("%s:", loop)
+	switch op {
+	case "rep movsb":
+		a.ins("ldrb w16, [x4], #1")
+		a.ins("strb w16, [x5], #1")
+	case "rep stosb":
+		a.ins("strb w0, [x5], #1")
+	case "repe cmpsb":
+		a.ins("ldrb w16, [x4], #1")
+		a.ins("ldrb w17, [x5], #1")
+		a.ins("sub x2, x2, #1")
+		a.ins("cmp w16, w17")
+		a.ins("b.ne %s", done)
+		a.ins("cbnz x2, %s", loop)
+		a.emit("%s:", done)
+		return
+	default:
+		must(fmt.Errorf("arm64: unsupported instruction %s", op))
+	}
+	a.ins("sub x2, x2, #1")
+	a.ins("cbnz x2, %s", loop)
+	a.emit("%s:", done)
+}
+
+// syscall emits runtime.syscall, which makes the x86-64 syscall of rax with the arguments in rdi, rsi, rdx, r10, r8 and r9.
+// unknown numbers return -ENOSYS
+func (a *arm64Lowering) syscall() {
+	a.emit(".text")
+	a.emit("runtime.syscall:")
+	saved := []string{"x1", "x2", "x3", "x4", "x5", "x8"}
+	for i, x := range saved {
+		a.ins("mov x%d, %s", 22+i, x)
+	}
+	a.ins("mov x16, x0")
+	a.ins("mov x0, x5")
+	a.ins("mov x1, x4")
+	a.ins("mov x2, x3")
+	a.ins("mov x3, x10")
+	a.ins("mov x4, x27")
+	a.ins("mov x5, x9")
+	svc := a.newLabel()
+	for _, nr := range arm64Syscalls {
+		next := a.newLabel()
+		a.ins("cmp x16, #%d", nr[0])
+		a.ins("b.ne %s", next)
+		a.ins("mov x8, #%d", nr[1])
+		a.ins("b %s", svc)
+		a.emit("%s:", next)
+	}
+	open := a.newLabel()
+	a.ins("cmp x16, #2")
+	a.ins("b.eq %s", open)
+	a.ins("mov x0, #-38")
+	done := a.newLabel()
+	a.ins("b %s", done)
+	a.emit("%s:", open)
+	a.ins("mov x3, x2")
+	a.ins("mov x2, x1")
+	a.ins("mov x1, x0")
+	a.ins("mov x0, #-100") // AT_FDCWD
+	a.ins("mov x8, #56")
+	a.emit("%s:", svc)
+	a.ins("svc #0")
+	a.emit("%s:", done)
+	for i, x := range saved {
+		a.ins("mov %s, x%d", x, 22+i)
+	}
+	a.ins("ret")
+}
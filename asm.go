@@ -0,0 +1,195 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+)
+
+// asmLine is a line of the assembly output. instructions are split into the mnemonic and the operands
+// so that passes like peephole can match and rewrite them. labels, directives and comments keep only the text
+type asmLine struct {
+	text    string
+	op      string // mnemonic of an instruction, "" otherwise
+	args    []string
+	comment string // comment after an instruction, without #
+	removed bool
+	native  bool // an instruction of the target emitted by its code generator, which is kept as it is
+}
+
+// asmLines is the assembly emitted so far. it is written to stdout by flushAsm at the end of the compilation
+var asmLines []*asmLine
+
+// asmPartial is the text of the last line until its newline is emitted
+var asmPartial string
+
+// emit appends the text formatted like fmt.Printf to the assembly output
+func emit(format string, a ...any) {
+	text := asmPartial + fmt.Sprintf(format, a...)
+	lines := strings.Split(text, "\n")
+	for _, line := range lines[:len(lines)-1] {
+		asmLines = append(asmLines, parseAsmLine(line))
+	}
+	asmPartial = lines[len(lines)-1]
+}
+
+// emitNative appends an instruction of curTarget formatted like fmt.Printf. the peephole pass and the lowering
+// to the target don't change it
+func emitNative(format string, a ...any) {
+	asmLines = append(asmLines, &asmLine{text: "  " + fmt.Sprintf(format, a...), native: true})
+}
+
+// parseAsmLine splits an instruction like "  movq -8(%rbp), %rax # x" into movq, [-8(%rbp) %rax] and x
+func parseAsmLine(text string) *asmLine {
+	line := &asmLine{text: text}
+	s := strings.TrimSpace(text)
+	if s == "" || strings.HasPrefix(s, "#") || strings.HasPrefix(s, ".") || strings.HasSuffix(s, ":") || !strings.HasPrefix(text, " ") {
+		return line
+	}
+	if i := strings.Index(s, " #"); i >= 0 {
+		line.comment = strings.TrimSpace(s[i+2:])
+		s = strings.TrimSpace(s[:i])
+	}
+	op, operands, _ := strings.Cut(s, " ")
+	line.op = op
+	line.args = splitAsmOperands(operands)
+	return line
+}
+
+// splitAsmOperands splits the operands of an instruction or a directive at the commas.
+// commas in -8(%rbp,%rax,8) and "main.F[int,bool]" don't separate operands
+func splitAsmOperands(operands string) []string {
+	var args []string
+	depth, start, quoted := 0, 0, false
+	operands = strings.TrimSpace(operands)
+	for i, c := range operands {
+		switch c {
+		case '"':
+			quoted = !quoted
+		case '(':
+			depth++
+		case ')':
+			depth--
+		case ',':
+			if depth == 0 && !quoted {
+				args = append(args, strings.TrimSpace(operands[start:i]))
+				start = i + 1
+			}
+		}
+	}
+	if operands != "" {
+		args = append(args, strings.TrimSpace(operands[start:]))
+	}
+	return args
+}
+
+// asmInstr returns the line of an instruction
+func asmInstr(op string, args ...string) *asmLine {
+	return parseAsmLine(fmt.Sprintf("  %s %s", op, strings.Join(args, ", ")))
+}
+
+// asmListing is selected by -S. comments keeps the comments of the code generator, annotated adds the lines of the source
+// before the instructions made from them, and clean drops every comment
+var asmListing = "comments"
+
+// setAsmListing selects the comments of the assembly output
+func setAsmListing(name string) error {
+	switch name {
+	case "comments", "annotated", "clean":
+		asmListing = name
+		return nil
+	}
+	return fmt.Errorf("unsupported listing %s, expected one of comments, annotated, clean", name)
+}
+
+// renderListing drops the comments and the empty lines of asmLines for -S=clean
+func renderListing() {
+	if asmListing != "clean" {
+		return
+	}
+	var lines []*asmLine
+	for _, line := range asmLines {
+		s := strings.TrimSpace(line.text)
+		if line.removed || line.op == "" && (s == "" || strings.HasPrefix(s, "#")) {
+			continue
+		}
+		if !line.native {
+			line.text, _ = cutAsmComment(line.text)
+		}
+		line.comment = ""
+		lines = append(lines, line)
+	}
+	asmLines = lines
+}
+
+// flushAsm writes the assembly output to w
+func flushAsm(w io.Writer) {
+	for _, line := range asmLines {
+		if !line.removed {
+			fmt.Fprintln(w, line.text)
+		}
+	}
+	if asmPartial != "" {
+		fmt.Fprint(w, asmPartial)
+	}
+}
+
+// isAsmLabel reports whether line defines a label like "main.f: # args 8, locals 0"
+func isAsmLabel(line *asmLine) bool {
+	label, _, _ := strings.Cut(line.text, " # ")
+	return line.op == "" && !strings.HasPrefix(line.text, " ") && strings.HasSuffix(strings.TrimSpace(label), ":")
+}
+
+// cutAsmComment splits a directive like .ascii "a # b" # c at the comment outside the strings
+func cutAsmComment(text string) (string, string) {
+	quoted := false
+	for i := 0; i < len(text); i++ {
+		switch text[i] {
+		case '\\':
+			i++
+		case '"':
+			quoted = !quoted
+		case '#':
+			if !quoted {
+				return strings.TrimRight(text[:i], " "), strings.TrimSpace(text[i+1:])
+			}
+		}
+	}
+	return text, ""
+}
+
+// asmLabel returns the name of the label defined by a line like "main.(*T).M": # comment
+func asmLabel(line *asmLine) string {
+	label, _, _ := strings.Cut(line.text, " # ")
+	return asmUnquote(strings.TrimSuffix(strings.TrimSpace(label), ":"))
+}
+
+// asmUnquote returns the name of a symbol, which is quoted like "main.(*T).M" when it has characters other than letters, digits, _ and .
+func asmUnquote(name string) string {
+	if strings.HasPrefix(name, "\"") {
+		s, err := strconv.Unquote(name)
+		must(err)
+		return s
+	}
+	return name
+}
+
+// splitAsmSymbol splits an expression like main.x+8 or "main.(*T).M" into the unquoted name and the offset
+func splitAsmSymbol(expr string) (string, int64) {
+	name, offset := expr, int64(0)
+	if strings.HasPrefix(expr, "\"") {
+		quoted, err := strconv.QuotedPrefix(expr)
+		must(err)
+		name = expr[:len(quoted)]
+		if rest := expr[len(quoted):]; rest != "" {
+			offset, err = strconv.ParseInt(rest, 0, 64)
+			must(err)
+		}
+	} else if i := strings.LastIndexAny(expr, "+-"); i > 0 {
+		if v, err := strconv.ParseInt(expr[i:], 0, 64); err == nil {
+			name, offset = expr[:i], v
+		}
+	}
+	return asmUnquote(name), offset
+}
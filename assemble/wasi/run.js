@@ -0,0 +1,33 @@
+// run.js runs a module of the wasip1/wasm target with the WASI of node, like wasmtime:
+//   node --no-warnings assemble/wasi/run.js main.wasm args...
+const fs = require('fs');
+const v8 = require('v8');
+const { Worker, isMainThread, workerData, parentPort } = require('worker_threads');
+const { WASI } = require('wasi');
+
+// a call of the compiled code is a call of wasm too, so the module runs in a worker with a stack of 256MB
+// for the recursion that the 8MB stack of the compiled code in the memory allows
+if (isMainThread) {
+  const worker = new Worker(__filename, {
+    argv: process.argv.slice(2),
+    workerData: process.env,
+    resourceLimits: { stackSizeMb: 256 },
+  });
+  worker.on('message', (code) => { process.exitCode = code; });
+  return;
+}
+
+// the fast calls of WASI in node 20 keep the buffer of the memory, which is stale after memory.grow of mmap
+v8.setFlagsFromString('--no-turbo-fast-api-calls');
+
+const [file, ...args] = process.argv.slice(2);
+const wasi = new WASI({
+  version: 'preview1',
+  args: [file, ...args],
+  env: workerData,
+  preopens: { '/': '/' },
+  returnOnExit: true,
+});
+WebAssembly.instantiate(fs.readFileSync(file), wasi.getImportObject()).then(({ instance }) => {
+  parentPort.postMessage(wasi.start(instance));
+});
@@ -0,0 +1,25 @@
+package main
+
+// calls is a call-heavy benchmark comparing the stack ABI and the register ABI
+func fib(n int) int {
+	if n < 2 {
+		return n
+	}
+	return fib(n-1) + fib(n-2)
+}
+
+func add(a int, b int) int {
+	return a + b
+}
+
+func mix(a int, b int, c int, d int) int {
+	return add(a, b) - add(c, d)
+}
+
+func main() {
+	sum := 0
+	for i := 0; i < 50000000; i++ {
+		sum = add(sum, mix(i, 3, i, 1))
+	}
+	println(fib(35), sum)
+}
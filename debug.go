@@ -0,0 +1,529 @@
+package main
+
+import (
+	"fmt"
+	"go/ast"
+	"go/token"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// debugInfo is set by -g to emit the line table by .file and .loc, which GNU as assembles to .debug_line,
+// and the DWARF debug info of the functions, their parameters and local variables and the global variables
+var debugInfo bool
+
+// debugFunc is a function in the debug info
+type debugFunc struct {
+	name   string // symbol quoted for the assembler
+	decl   *ast.FuncDecl
+	end    string // the label after the last instruction
+	params []debugVar
+	locals []debugVar
+}
+
+// debugVar is a parameter or a local variable with its location like -8(%rbp) or %r13
+type debugVar struct {
+	obj      *ast.Object
+	typ      *ast.Object
+	loc      string
+	indirect bool // the location holds the pointer to the variable in the heap
+}
+
+var (
+	debugFiles     []string // the source files numbered from 1 by .file
+	debugFileIndex = map[string]int{}
+	debugFuncs     []*debugFunc
+	debugCurFunc   *debugFunc
+
+	// debugPos is the position of the last .loc, or NoPos after the instructions without a position
+	debugPos token.Pos
+
+	sourceLines = map[string][]string{} // the lines of sources by the file names
+
+	// debugUintptr is the type of the words of interfaces in the debug info
+	debugUintptr = &ast.Object{Kind: ast.Typ, Name: "uintptr"}
+)
+
+// debugFileNum returns the number of the file of pos in .file
+func debugFileNum(pos token.Position) int {
+	name, err := filepath.Abs(pos.Filename)
+	must(err)
+	if i, ok := debugFileIndex[name]; ok {
+		return i
+	}
+	debugFiles = append(debugFiles, name)
+	debugFileIndex[name] = len(debugFiles)
+	return len(debugFiles)
+}
+
+// debugLoc emits .loc for the instructions from pos unless they are at the line of the last .loc.
+// with -S=annotated, the line of the source is emitted as a comment before them
+func debugLoc(pos token.Pos) {
+	if !debugInfo && asmListing != "annotated" || !pos.IsValid() {
+		return
+	}
+	p := fileSet.Position(pos)
+	if last := fileSet.Position(debugPos); debugPos.IsValid() && last.Filename == p.Filename && last.Line == p.Line {
+		return
+	}
+	debugPos = pos
+	if asmListing == "annotated" {
+		emit("# %s:%d: %s\n", filepath.Base(p.Filename), p.Line, sourceLine(p))
+	}
+	if debugInfo {
+		emit("  .loc %d %d %d\n", debugFileNum(p), p.Line, p.Column)
+	}
+}
+
+// debugNoLoc emits .loc of line 0 for the instructions without a position in the source, like the runtime
+func debugNoLoc() {
+	if !debugPos.IsValid() {
+		return
+	}
+	if debugInfo {
+		emit("  .loc %d 0\n", debugFileNum(fileSet.Position(debugPos)))
+	}
+	debugPos = token.NoPos
+}
+
+// sourceLine returns the line of the source at p without the indentation
+func sourceLine(p token.Position) string {
+	lines, ok := sourceLines[p.Filename]
+	if !ok {
+		lines = strings.Split(string(sources[p.Filename]), "\n")
+		sourceLines[p.Filename] = lines
+	}
+	if p.Line > len(lines) {
+		return ""
+	}
+	return strings.TrimSpace(lines[p.Line-1])
+}
+
+// debugFuncStart starts the debug info of fnc emitted at the label symbol. loc returns the location of a variable
+// and whether it holds the pointer to the variable in the heap, or "" if the variable has no location
+func debugFuncStart(fnc *Func, symbol string, loc func(obj *ast.Object) (string, bool)) {
+	debugLoc(fnc.decl.Pos())
+	debugProcStart()
+	if !debugInfo || !fnc.decl.Pos().IsValid() { // the methods of embedded fields are made by the compiler
+		return
+	}
+	labelSeq++
+	f := &debugFunc{name: symbol, decl: fnc.decl, end: fmt.Sprintf(".L.debug.end.%d", labelSeq)}
+	params, locals := frameVars(fnc, loc)
+	visible := func(vars []debugVar) []debugVar {
+		var list []debugVar
+		for _, v := range vars {
+			if v.obj.Name == "_" || strings.HasPrefix(v.obj.Name, ".") { // the hidden variables of desugared statements
+				continue
+			}
+			debugFileNum(fileSet.Position(v.obj.Pos())) // numbered before the .file directives are emitted
+			list = append(list, v)
+		}
+		return list
+	}
+	f.params, f.locals = visible(params), visible(locals)
+	debugFuncs = append(debugFuncs, f)
+	debugCurFunc = f
+}
+
+// frameVars returns the parameters and the local variables of fnc at their locations returned by loc,
+// except the ones which have no location
+func frameVars(fnc *Func, loc func(obj *ast.Object) (string, bool)) (params, locals []debugVar) {
+	isParam := map[*ast.Object]bool{}
+	withTypeArgs(fnc.typeArgs, func() {
+		add := func(vars []debugVar, obj *ast.Object) []debugVar {
+			if obj == nil {
+				return vars
+			}
+			l, indirect := loc(obj)
+			if l == "" {
+				return vars
+			}
+			return append(vars, debugVar{obj: obj, typ: varType(obj), loc: l, indirect: indirect})
+		}
+		for _, list := range []*ast.FieldList{fnc.decl.Recv, fnc.decl.Type.Params} {
+			if list == nil {
+				continue
+			}
+			for _, field := range list.List {
+				for _, name := range field.Names {
+					isParam[name.Obj] = true
+					params = add(params, name.Obj)
+				}
+			}
+		}
+		for _, obj := range fnc.localvars {
+			if !isParam[obj] {
+				locals = add(locals, obj)
+			}
+		}
+	})
+	return params, locals
+}
+
+// debugFuncEnd ends the function started by debugFuncStart after its last instruction
+func debugFuncEnd() {
+	if debugCurFunc != nil {
+		emit("%s:\n", debugCurFunc.end)
+	}
+	debugProcEnd()
+	debugNoLoc()
+	debugCurFunc = nil
+}
+
+// debugProc is whether the CFI of a function is open. the CFI tells gdb and lldb where the frame of the caller is
+var debugProc bool
+
+// debugProcStart opens the CFI of the function at its label, where the CFA is rsp+8 above the return address
+func debugProcStart() {
+	if debugInfo {
+		emit("  .cfi_startproc\n")
+		debugProc = true
+	}
+}
+
+func debugProcEnd() {
+	if debugProc {
+		emit("  .cfi_endproc\n")
+		debugProc = false
+	}
+}
+
+// debugCFI emits the CFI directives like .cfi_def_cfa_register in a function opened by debugProcStart.
+// the registers are the numbers of dwarfRegs, which GNU as takes in both syntaxes
+func debugCFI(format string, a ...any) {
+	if debugProc {
+		emit("  "+format+"\n", a...)
+	}
+}
+
+// the DWARF constants used by emitDebugInfo
+const (
+	dwTagFormalParameter = 0x05
+	dwTagMember          = 0x0d
+	dwTagPointerType     = 0x0f
+	dwTagCompileUnit     = 0x11
+	dwTagStructureType   = 0x13
+	dwTagBaseType        = 0x24
+	dwTagSubprogram      = 0x2e
+	dwTagVariable        = 0x34
+
+	dwAtLocation           = 0x02
+	dwAtName               = 0x03
+	dwAtByteSize           = 0x0b
+	dwAtStmtList           = 0x10
+	dwAtLowPc              = 0x11
+	dwAtHighPc             = 0x12
+	dwAtLanguage           = 0x13
+	dwAtCompDir            = 0x1b
+	dwAtProducer           = 0x25
+	dwAtDataMemberLocation = 0x38
+	dwAtDeclFile           = 0x3a
+	dwAtDeclLine           = 0x3b
+	dwAtEncoding           = 0x3e
+	dwAtExternal           = 0x3f
+	dwAtFrameBase          = 0x40
+	dwAtType               = 0x49
+
+	dwFormAddr        = 0x01
+	dwFormData1       = 0x0b
+	dwFormData8       = 0x07
+	dwFormString      = 0x08
+	dwFormUdata       = 0x0f
+	dwFormRef4        = 0x13
+	dwFormSecOffset   = 0x17
+	dwFormExprloc     = 0x18
+	dwFormFlagPresent = 0x19
+
+	dwAteBoolean      = 0x02
+	dwAteSigned       = 0x05
+	dwAteUnsigned     = 0x07
+	dwAteUnsignedChar = 0x08
+
+	dwLangGo = 0x16
+
+	dwOpAddr  = 0x03
+	dwOpDeref = 0x06
+	dwOpReg0  = 0x50
+	dwOpBreg6 = 0x76 // rbp
+	dwOpFbreg = 0x91
+)
+
+// the abbreviations of the entries in .debug_info
+const (
+	abbrevCompileUnit = iota + 1
+	abbrevSubprogram
+	abbrevLeafSubprogram // without parameters and variables
+	abbrevParam
+	abbrevVariable
+	abbrevGlobal
+	abbrevBaseType
+	abbrevPointerType
+	abbrevStructureType
+	abbrevMember
+)
+
+var debugSubprogramAttrs = [][2]int{{dwAtName, dwFormString}, {dwAtDeclFile, dwFormUdata}, {dwAtDeclLine, dwFormUdata},
+	{dwAtLowPc, dwFormAddr}, {dwAtHighPc, dwFormData8}, {dwAtFrameBase, dwFormExprloc}, {dwAtExternal, dwFormFlagPresent}}
+
+var debugAbbrevs = []struct {
+	code, tag int
+	children  bool
+	attrs     [][2]int // name, form
+}{
+	{abbrevCompileUnit, dwTagCompileUnit, true, [][2]int{{dwAtProducer, dwFormString}, {dwAtLanguage, dwFormData1}, {dwAtName, dwFormString},
+		{dwAtCompDir, dwFormString}, {dwAtLowPc, dwFormAddr}, {dwAtHighPc, dwFormData8}, {dwAtStmtList, dwFormSecOffset}}},
+	{abbrevSubprogram, dwTagSubprogram, true, debugSubprogramAttrs},
+	{abbrevLeafSubprogram, dwTagSubprogram, false, debugSubprogramAttrs},
+	{abbrevParam, dwTagFormalParameter, false, [][2]int{{dwAtName, dwFormString}, {dwAtDeclFile, dwFormUdata}, {dwAtDeclLine, dwFormUdata},
+		{dwAtType, dwFormRef4}, {dwAtLocation, dwFormExprloc}}},
+	{abbrevVariable, dwTagVariable, false, [][2]int{{dwAtName, dwFormString}, {dwAtDeclFile, dwFormUdata}, {dwAtDeclLine, dwFormUdata},
+		{dwAtType, dwFormRef4}, {dwAtLocation, dwFormExprloc}}},
+	{abbrevGlobal, dwTagVariable, false, [][2]int{{dwAtName, dwFormString}, {dwAtType, dwFormRef4}, {dwAtLocation, dwFormExprloc},
+		{dwAtExternal, dwFormFlagPresent}}},
+	{abbrevBaseType, dwTagBaseType, false, [][2]int{{dwAtName, dwFormString}, {dwAtEncoding, dwFormData1}, {dwAtByteSize, dwFormData1}}},
+	{abbrevPointerType, dwTagPointerType, false, [][2]int{{dwAtByteSize, dwFormData1}, {dwAtType, dwFormRef4}}},
+	{abbrevStructureType, dwTagStructureType, true, [][2]int{{dwAtName, dwFormString}, {dwAtByteSize, dwFormUdata}}},
+	{abbrevMember, dwTagMember, false, [][2]int{{dwAtName, dwFormString}, {dwAtType, dwFormRef4}, {dwAtDataMemberLocation, dwFormUdata}}},
+}
+
+// dwarfRegs are the numbers of the registers in DWARF for x86-64
+var dwarfRegs = map[string]int{
+	"rax": 0, "rdx": 1, "rcx": 2, "rbx": 3, "rsi": 4, "rdi": 5, "rbp": 6, "rsp": 7,
+	"r8": 8, "r9": 9, "r10": 10, "r11": 11, "r12": 12, "r13": 13, "r14": 14, "r15": 15,
+}
+
+// emitDebugInfo emits the .file directives before the output, and .debug_abbrev and .debug_info after it.
+// .debug_line is made by GNU as from the .loc directives
+func emitDebugInfo() {
+	if !debugInfo {
+		return
+	}
+	body := asmLines
+	asmLines = nil
+	emit(".cfi_sections .debug_frame\n")
+	for i, name := range debugFiles {
+		emit(".file %d %s\n", i+1, gasString(strconv.Quote(name)))
+	}
+	emit(".text\n")
+	emit(".Ltext0:\n")
+	asmLines = append(asmLines, body...)
+	emit(".text\n")
+	emit(".Letext0:\n")
+
+	emit(".section .debug_abbrev,\"\",@progbits\n")
+	emit(".Ldebug_abbrev0:\n")
+	for _, a := range debugAbbrevs {
+		children := 0
+		if a.children {
+			children = 1
+		}
+		emit("  .uleb128 %d, %d\n  .byte %d\n", a.code, a.tag, children)
+		for _, attr := range a.attrs {
+			emit("  .uleb128 %d, %d\n", attr[0], attr[1])
+		}
+		emit("  .uleb128 0, 0\n")
+	}
+	emit("  .byte 0\n")
+
+	// the .debug_line section is started here to label its start, and GNU as appends the line table to it
+	emit(".section .debug_line,\"\",@progbits\n")
+	emit(".Ldebug_line0:\n")
+
+	wd, err := os.Getwd()
+	must(err)
+	w := &dwarfWriter{types: map[string]string{}}
+	emit(".section .debug_info,\"\",@progbits\n")
+	emit(".Ldebug_info0:\n")
+	emit("  .long .Ldebug_info_end - .Ldebug_info_start\n")
+	emit(".Ldebug_info_start:\n")
+	emit("  .short 4\n") // DWARF 4
+	emit("  .long .Ldebug_abbrev0\n")
+	emit("  .byte 8\n") // the size of addresses
+	// the compile unit is named by the file or the directory of the main package
+	name, err := filepath.Abs(mainPackage.dir)
+	must(err)
+	if len(mainPackage.files) == 1 {
+		name, err = filepath.Abs(fileSet.Position(mainPackage.files[0].Pos()).Filename)
+		must(err)
+	}
+	emit("  .uleb128 %d\n", abbrevCompileUnit)
+	w.string("gompiler")
+	emit("  .byte %d\n", dwLangGo)
+	w.string(name)
+	w.string(wd)
+	emit("  .quad .Ltext0\n")
+	emit("  .quad .Letext0 - .Ltext0\n")
+	emit("  .long .Ldebug_line0\n")
+
+	for _, f := range debugFuncs {
+		leaf := len(f.params)+len(f.locals) == 0
+		if leaf {
+			emit("  .uleb128 %d\n", abbrevLeafSubprogram)
+		} else {
+			emit("  .uleb128 %d\n", abbrevSubprogram)
+		}
+		w.string(asmUnquote(f.name))
+		w.pos(f.decl.Pos())
+		emit("  .quad %s\n", f.name)
+		emit("  .quad %s - %s\n", f.end, f.name)
+		w.exprloc(dwOpBreg6, 0) // the frame base is rbp
+		for _, v := range f.params {
+			w.variable(abbrevParam, v)
+		}
+		for _, v := range f.locals {
+			w.variable(abbrevVariable, v)
+		}
+		if !leaf {
+			emit("  .byte 0\n")
+		}
+	}
+	for _, g := range globalVariables {
+		emit("  .uleb128 %d\n", abbrevGlobal)
+		w.string(asmUnquote(g.tag))
+		w.ref(g.typ)
+		emit("  .uleb128 9\n  .byte %d\n  .quad %s\n", dwOpAddr, g.tag)
+	}
+	// the types are emitted after the entries referring to them, and may refer to more types
+	for len(w.queue) > 0 {
+		typ := w.queue[0]
+		w.queue = w.queue[1:]
+		w.typ(typ)
+	}
+	emit("  .byte 0\n")
+	emit(".Ldebug_info_end:\n")
+}
+
+// dwarfWriter emits the entries of .debug_info
+type dwarfWriter struct {
+	types map[string]string // the labels of the type entries by the names of the types
+	queue []*ast.Object     // the types referred to but not emitted yet
+}
+
+func (w *dwarfWriter) string(s string) {
+	emit("  .string %s\n", gasString(strconv.Quote(s)))
+}
+
+// pos emits the file and the line of a declaration
+func (w *dwarfWriter) pos(pos token.Pos) {
+	p := fileSet.Position(pos)
+	emit("  .uleb128 %d, %d\n", debugFileNum(p), p.Line)
+}
+
+// exprloc emits a location expression of an operation and a signed operand
+func (w *dwarfWriter) exprloc(op byte, operand int) {
+	b := appendSleb128([]byte{op}, operand)
+	emit("  .uleb128 %d\n", len(b))
+	w.bytes(b)
+}
+
+func (w *dwarfWriter) bytes(b []byte) {
+	var values []string
+	for _, c := range b {
+		values = append(values, strconv.Itoa(int(c)))
+	}
+	emit("  .byte %s\n", strings.Join(values, ", "))
+}
+
+func (w *dwarfWriter) variable(abbrev int, v debugVar) {
+	emit("  .uleb128 %d\n", abbrev)
+	w.string(v.obj.Name)
+	w.pos(v.obj.Pos())
+	w.ref(v.typ)
+	var b []byte
+	if reg, ok := strings.CutPrefix(v.loc, "%"); ok {
+		b = []byte{byte(dwOpReg0 + dwarfRegs[reg])}
+	} else {
+		op := parseX86Operand(v.loc) // offset(%rbp)
+		b = appendSleb128([]byte{dwOpFbreg}, int(op.disp))
+		if v.indirect {
+			b = append(b, dwOpDeref)
+		}
+	}
+	emit("  .uleb128 %d\n", len(b))
+	w.bytes(b)
+}
+
+// ref emits the reference to the entry of typ
+func (w *dwarfWriter) ref(typ *ast.Object) {
+	name := typeString(typ)
+	label, ok := w.types[name]
+	if !ok {
+		label = fmt.Sprintf(".L.debug.type.%d", len(w.types))
+		w.types[name] = label
+		w.queue = append(w.queue, typ)
+	}
+	emit("  .long %s - .Ldebug_info0\n", label)
+}
+
+// typ emits the entry of typ. strings, slices and interfaces are structures of their words like the ones of gc,
+// which gdb prints as Go values
+func (w *dwarfWriter) typ(typ *ast.Object) {
+	emit("%s:\n", w.types[typeString(typ)])
+	name := typeString(typ)
+	base := func(encoding, size int) {
+		emit("  .uleb128 %d\n", abbrevBaseType)
+		w.string(name)
+		emit("  .byte %d, %d\n", encoding, size)
+	}
+	type member struct {
+		name   string
+		typ    *ast.Object
+		offset int
+	}
+	structure := func(members ...member) {
+		emit("  .uleb128 %d\n", abbrevStructureType)
+		w.string(name)
+		emit("  .uleb128 %d\n", sizeOf(typ))
+		for _, m := range members {
+			emit("  .uleb128 %d\n", abbrevMember)
+			w.string(m.name)
+			w.ref(m.typ)
+			emit("  .uleb128 %d\n", m.offset)
+		}
+		emit("  .byte 0\n")
+	}
+	if typ == debugUintptr {
+		base(dwAteUnsigned, 8)
+		return
+	}
+	switch u := underlying(typ); {
+	case u == globalInt:
+		base(dwAteSigned, 8)
+	case u == globalBool:
+		base(dwAteBoolean, 8)
+	case u == globalByte: // bytes take a word in variables, but they are packed in strings and slices
+		base(dwAteUnsignedChar, 1)
+	case u == globalString:
+		structure(member{"str", pointerTo(globalByte), 0}, member{"len", globalInt, 8})
+	case isPointer(u):
+		emit("  .uleb128 %d\n  .byte 8\n", abbrevPointerType)
+		w.ref(elemType(u))
+	case isSlice(u):
+		structure(member{"array", pointerTo(sliceElem(u)), 0}, member{"len", globalInt, 8}, member{"cap", globalInt, 16})
+	case isInterface(u):
+		structure(member{"tab", debugUintptr, 0}, member{"data", debugUintptr, 8})
+	case isStruct(u):
+		var members []member
+		for _, field := range structFields(u) {
+			members = append(members, member{field.name, field.typ, field.offset})
+		}
+		structure(members...)
+	default:
+		must(fmt.Errorf("unexpected type %s in the debug info", name))
+	}
+}
+
+// appendSleb128 appends v in the signed LEB128 encoding
+func appendSleb128(b []byte, v int) []byte {
+	for {
+		c := byte(v & 0x7f)
+		v >>= 7
+		if v == 0 && c&0x40 == 0 || v == -1 && c&0x40 != 0 {
+			return append(b, c)
+		}
+		b = append(b, c|0x80)
+	}
+}
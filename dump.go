@@ -0,0 +1,474 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"go/ast"
+	"go/scanner"
+	"go/token"
+	"go/types"
+	"io"
+	"os"
+	"reflect"
+	"strings"
+	"text/tabwriter"
+
+	"github.com/lkeix/gompiler/ir"
+)
+
+// dumps are the phases selected by -dump, whose results are written to stderr after the phases.
+// tokens, ast, types, frames and ir are of the packages of the program, and asm is the whole output
+var (
+	dumps      = map[string]bool{}
+	dumpFormat = "text"
+)
+
+// dumpPhases are the phases -dump accepts in the order they are dumped
+var dumpPhases = []string{"tokens", "ast", "types", "ir", "frames", "asm"}
+
+// setDump selects the phases to dump from a comma separated list like ast,frames
+func setDump(list string) error {
+	for _, phase := range strings.Split(list, ",") {
+		if !contains(dumpPhases, phase) {
+			return fmt.Errorf("unsupported phase %s, expected some of %s", phase, strings.Join(dumpPhases, ", "))
+		}
+		dumps[phase] = true
+	}
+	return nil
+}
+
+// setDumpFormat selects text for people or json, which writes a JSON object per phase on a line
+func setDumpFormat(name string) error {
+	switch name {
+	case "text", "json":
+		dumpFormat = name
+		return nil
+	}
+	return fmt.Errorf("unsupported dump format %s, expected text or json", name)
+}
+
+func contains(list []string, s string) bool {
+	for _, t := range list {
+		if t == s {
+			return true
+		}
+	}
+	return false
+}
+
+// writeDump writes the result of phase. fields are the members of the JSON object besides "phase",
+// and text writes the result for people to a tabwriter, whose cells are separated by tabs
+func writeDump(phase string, fields map[string]any, text func(w io.Writer)) {
+	if dumpFormat == "json" {
+		fields["phase"] = phase
+		must(json.NewEncoder(os.Stderr).Encode(fields))
+		return
+	}
+	fmt.Fprintf(os.Stderr, "# dump %s\n", phase)
+	w := tabwriter.NewWriter(os.Stderr, 0, 8, 2, ' ', 0)
+	text(w)
+	must(w.Flush())
+}
+
+// dumpPackages returns the packages of the program without the ones in lib provided by the compiler
+func dumpPackages() []*Package {
+	var pkgs []*Package
+	for _, pkg := range pkgOrder {
+		if !libPackages[pkg.path] {
+			pkgs = append(pkgs, pkg)
+		}
+	}
+	return pkgs
+}
+
+// dumpFile is the name of file in fileSet
+func dumpFile(file *ast.File) string {
+	return fileSet.File(file.Pos()).Name()
+}
+
+// dumpPos formats pos like main.go:3:5, or - for the nodes made by the compiler
+func dumpPos(pos token.Pos) string {
+	if !pos.IsValid() {
+		return "-"
+	}
+	return fileSet.Position(pos).String()
+}
+
+type dumpToken struct {
+	Pos string `json:"pos"`
+	Tok string `json:"tok"`
+	Lit string `json:"lit,omitempty"`
+}
+
+// dumpTokens dumps the tokens of the files of the program. the semicolons inserted by the scanner have the literal "\n"
+func dumpTokens() {
+	if !dumps["tokens"] {
+		return
+	}
+	type tokenFile struct {
+		File   string      `json:"file"`
+		Tokens []dumpToken `json:"tokens"`
+	}
+	files := []tokenFile{}
+	for _, pkg := range dumpPackages() {
+		for _, file := range pkg.files {
+			name := dumpFile(file)
+			src := sources[name]
+			fset := token.NewFileSet()
+			var s scanner.Scanner
+			s.Init(fset.AddFile(name, -1, len(src)), src, nil, 0)
+			f := tokenFile{File: name}
+			for {
+				pos, tok, lit := s.Scan()
+				if tok == token.EOF {
+					break
+				}
+				f.Tokens = append(f.Tokens, dumpToken{Pos: fset.Position(pos).String(), Tok: tok.String(), Lit: lit})
+			}
+			files = append(files, f)
+		}
+	}
+	writeDump("tokens", map[string]any{"files": files}, func(w io.Writer) {
+		for _, f := range files {
+			for _, t := range f.Tokens {
+				switch t.Lit {
+				case "":
+					fmt.Fprintf(w, "%s\t%s\n", t.Pos, t.Tok)
+				case "\n":
+					fmt.Fprintf(w, "%s\t%s\t%q\n", t.Pos, t.Tok, t.Lit)
+				default:
+					fmt.Fprintf(w, "%s\t%s\t%s\n", t.Pos, t.Tok, t.Lit)
+				}
+			}
+		}
+	})
+}
+
+// dumpAST dumps the syntax trees of the files of the program as parsed. the text is written by ast.Fprint,
+// and the JSON has an object per node with its type in "node" and its fields which are not zero.
+// the objects and the scopes of the identifiers are left out
+func dumpAST() {
+	if !dumps["ast"] {
+		return
+	}
+	type astFile struct {
+		File string `json:"file"`
+		AST  any    `json:"ast"`
+	}
+	files := []astFile{}
+	for _, pkg := range dumpPackages() {
+		for _, file := range pkg.files {
+			files = append(files, astFile{File: dumpFile(file), AST: astValue(reflect.ValueOf(file))})
+		}
+	}
+	writeDump("ast", map[string]any{"files": files}, func(w io.Writer) {
+		for _, pkg := range dumpPackages() {
+			for _, file := range pkg.files {
+				fmt.Fprintf(w, "# %s\n", dumpFile(file))
+				must(ast.Fprint(w, fileSet, file, ast.NotNilFilter))
+			}
+		}
+	})
+}
+
+var (
+	posType    = reflect.TypeOf(token.NoPos)
+	tokenType  = reflect.TypeOf(token.ILLEGAL)
+	objectType = reflect.TypeOf((*ast.Object)(nil))
+	scopeType  = reflect.TypeOf((*ast.Scope)(nil))
+)
+
+// astValue converts the node or the field of a node v to the values encoded by encoding/json
+func astValue(v reflect.Value) any {
+	switch v.Kind() {
+	case reflect.Interface, reflect.Pointer:
+		if v.IsNil() {
+			return nil
+		}
+		if v.Kind() == reflect.Pointer && v.Elem().Kind() == reflect.Struct {
+			node := astValue(v.Elem()).(map[string]any)
+			node["node"] = v.Type().String()
+			return node
+		}
+		return astValue(v.Elem())
+	case reflect.Struct:
+		node := map[string]any{}
+		for i := 0; i < v.NumField(); i++ {
+			field, value := v.Type().Field(i), v.Field(i)
+			if !field.IsExported() || field.Type == objectType || field.Type == scopeType || value.IsZero() {
+				continue
+			}
+			switch field.Type {
+			case posType:
+				node[field.Name] = dumpPos(value.Interface().(token.Pos))
+			case tokenType:
+				node[field.Name] = value.Interface().(token.Token).String()
+			default:
+				node[field.Name] = astValue(value)
+			}
+		}
+		return node
+	case reflect.Slice:
+		list := make([]any, v.Len())
+		for i := range list {
+			list[i] = astValue(v.Index(i))
+		}
+		return list
+	}
+	return v.Interface()
+}
+
+type dumpExpr struct {
+	Pos  string `json:"pos"`
+	Expr string `json:"expr"`
+	Type string `json:"type"`
+}
+
+type typedFunc struct {
+	Func  string     `json:"func"`
+	Exprs []dumpExpr `json:"exprs"`
+}
+
+// dumpTypes dumps the types of the expressions in the functions of the program after the semantic analysis.
+// the instances of generic functions have the types of their type arguments
+func dumpTypes() {
+	if !dumps["types"] {
+		return
+	}
+	outer := curPkg
+	defer func() { curPkg = outer }()
+	list := []typedFunc{}
+	for _, fnc := range funcs {
+		if libPackages[fnc.pkg.path] {
+			continue
+		}
+		f := typedFunc{Func: funcSymbol(fnc.pkg.path, fnc.name), Exprs: []dumpExpr{}}
+		curPkg = fnc.pkg
+		withTypeArgs(fnc.typeArgs, func() {
+			ast.Inspect(fnc.decl.Body, func(node ast.Node) bool {
+				switch node := node.(type) {
+				case *ast.ArrayType, *ast.MapType, *ast.ChanType, *ast.FuncType, *ast.InterfaceType, *ast.StructType, *ast.Ellipsis:
+					return false // types
+				case *ast.Ident:
+					if node.Obj == nil || node.Obj.Kind == ast.Typ || node.Obj.Kind == ast.Pkg {
+						return false
+					}
+				case *ast.KeyValueExpr:
+					return true
+				}
+				if expr, ok := node.(ast.Expr); ok {
+					if typ := exprTypeString(expr); typ != "" {
+						f.Exprs = append(f.Exprs, dumpExpr{Pos: dumpPos(expr.Pos()), Expr: types.ExprString(expr), Type: typ})
+					}
+				}
+				return true
+			})
+		})
+		list = append(list, f)
+	}
+	writeDump("types", map[string]any{"funcs": list}, func(w io.Writer) {
+		for _, f := range list {
+			fmt.Fprintf(w, "func %s\n", f.Func)
+			for _, e := range f.Exprs {
+				fmt.Fprintf(w, "  %s\t%s\t%s\n", e.Pos, e.Expr, e.Type)
+			}
+		}
+	})
+}
+
+// exprTypeString returns the type of expr, or "" if expr has no type like a package function or a builtin
+func exprTypeString(expr ast.Expr) (s string) {
+	defer func() {
+		if recover() != nil {
+			s = ""
+		}
+	}()
+	if typ := getType(expr); typ != nil {
+		return typeString(typ)
+	}
+	return ""
+}
+
+type dumpVar struct {
+	Name   string `json:"name"`
+	Pos    string `json:"pos"`
+	Type   string `json:"type"`
+	Loc    string `json:"loc"`              // like -8(%rbp) or %r13
+	Offset int    `json:"offset,omitempty"` // from rbp, 0 in registers
+	Size   int    `json:"size"`             // of the location
+	Heap   bool   `json:"heap,omitempty"`   // the location holds the pointer to the variable in the heap
+}
+
+type dumpFrame struct {
+	Func      string    `json:"func"`
+	IR        bool      `json:"ir"` // compiled through the IR. the variables are in the registers of the IR
+	ArgsArea  int       `json:"argsarea"`
+	LocalArea int       `json:"localarea"`
+	Params    []dumpVar `json:"params"`
+	Locals    []dumpVar `json:"locals"`
+}
+
+// dumpFrames are the frames of the functions of the program recorded by recordFrame while they are emitted
+var dumpFrames []dumpFrame
+
+// recordFrame records the frame of fnc emitted at the label symbol for -dump=frames. loc is the one of debugFuncStart.
+// the frames of the functions compiled through the IR have the slots of the IR instead of the layout of funcWalk
+func recordFrame(fnc *Func, symbol string, localarea int, isIR bool, loc func(obj *ast.Object) (string, bool)) {
+	if !dumps["frames"] || libPackages[fnc.pkg.path] {
+		return
+	}
+	frame := dumpFrame{Func: symbol, IR: isIR, ArgsArea: fnc.argsarea, LocalArea: localarea}
+	params, locals := frameVars(fnc, loc)
+	withTypeArgs(fnc.typeArgs, func() {
+		vars := func(list []debugVar) []dumpVar {
+			vars := []dumpVar{}
+			for _, v := range list {
+				dv := dumpVar{Name: v.obj.Name, Pos: dumpPos(v.obj.Pos()), Type: typeString(v.typ), Loc: v.loc, Size: sizeOf(v.typ), Heap: v.indirect}
+				if v.indirect {
+					dv.Size = 8
+				}
+				fmt.Sscanf(v.loc, "%d(%%rbp)", &dv.Offset)
+				vars = append(vars, dv)
+			}
+			return vars
+		}
+		frame.Params, frame.Locals = vars(params), vars(locals)
+	})
+	dumpFrames = append(dumpFrames, frame)
+}
+
+// dumpFrameLayout dumps the frames of the functions, the string literals by their labels and the global variables
+func dumpFrameLayout() {
+	if !dumps["frames"] {
+		return
+	}
+	type dumpString struct {
+		Tag   string `json:"tag"`
+		Value string `json:"value"` // the literal in the source
+	}
+	type dumpGlobal struct {
+		Tag   string `json:"tag"`
+		Type  string `json:"type"`
+		Size  int    `json:"size"`
+		Value string `json:"value,omitempty"`
+	}
+	strs := []dumpString{}
+	for _, sl := range stringLiterals {
+		strs = append(strs, dumpString{Tag: sl.tag, Value: sl.value})
+	}
+	globals := []dumpGlobal{}
+	for _, g := range globalVariables {
+		globals = append(globals, dumpGlobal{Tag: g.tag, Type: typeString(g.typ), Size: sizeOf(underlying(g.typ)), Value: g.value})
+	}
+	fields := map[string]any{"funcs": dumpFrames, "strings": strs, "globals": globals}
+	writeDump("frames", fields, func(w io.Writer) {
+		for _, f := range dumpFrames {
+			through := ""
+			if f.IR {
+				through = " ir"
+			}
+			fmt.Fprintf(w, "func %s: args %d, locals %d%s\n", f.Func, f.ArgsArea, f.LocalArea, through)
+			for _, list := range []struct {
+				kind string
+				vars []dumpVar
+			}{{"param", f.Params}, {"local", f.Locals}} {
+				for _, v := range list.vars {
+					heap := ""
+					if v.Heap {
+						heap = "\theap"
+					}
+					fmt.Fprintf(w, "  %s\t%s\t%s\t%d\t%s\t%s%s\n", list.kind, v.Name, v.Loc, v.Size, v.Type, v.Pos, heap)
+				}
+			}
+		}
+		fmt.Fprintf(w, "string literals\n")
+		for _, s := range strs {
+			fmt.Fprintf(w, "  %s\t%s\n", s.Tag, s.Value)
+		}
+		fmt.Fprintf(w, "global variables\n")
+		for _, g := range globals {
+			value := ""
+			if g.Value != "" {
+				value = "\t" + g.Value
+			}
+			fmt.Fprintf(w, "  %s\t%d\t%s%s\n", g.Tag, g.Size, g.Type, value)
+		}
+	})
+}
+
+// dumpIR dumps the functions of the program lowered to the IR after the tail calls and the inlining
+func dumpIR(funcs []*Func, lowered map[*Func]*ir.Func) {
+	if !dumps["ir"] {
+		return
+	}
+	type irBlock struct {
+		ID      int      `json:"id"`
+		Preds   []int    `json:"preds,omitempty"`
+		Instrs  []string `json:"instrs"`
+		Control string   `json:"control"`
+	}
+	type irFunc struct {
+		Name       string    `json:"name"`
+		Params     []string  `json:"params"`
+		Results    []string  `json:"results,omitempty"`
+		MemResults bool      `json:"mem,omitempty"`
+		RegABI     bool      `json:"regabi,omitempty"`
+		Blocks     []irBlock `json:"blocks"`
+	}
+	var fs []*ir.Func
+	list := []irFunc{}
+	for _, fnc := range funcs {
+		f := lowered[fnc]
+		if libPackages[fnc.pkg.path] {
+			continue
+		}
+		fs = append(fs, f)
+		jf := irFunc{Name: f.Name, Params: []string{}, MemResults: f.MemResults, RegABI: f.RegABI}
+		for _, p := range f.Params {
+			jf.Params = append(jf.Params, p.String())
+		}
+		for _, r := range f.Results {
+			jf.Results = append(jf.Results, r.String())
+		}
+		for _, b := range f.Blocks {
+			jb := irBlock{ID: b.ID, Instrs: []string{}, Control: b.ControlString()}
+			for _, p := range b.Preds {
+				jb.Preds = append(jb.Preds, p.ID)
+			}
+			for _, instr := range b.Instrs {
+				jb.Instrs = append(jb.Instrs, f.InstrString(instr))
+			}
+			jf.Blocks = append(jf.Blocks, jb)
+		}
+		list = append(list, jf)
+	}
+	writeDump("ir", map[string]any{"funcs": list}, func(w io.Writer) {
+		for _, f := range fs {
+			ir.Fprint(w, f)
+		}
+	})
+}
+
+// dumpAsm dumps the assembly for the target before it is rendered in the syntax of -asm-syntax or assembled
+func dumpAsm() {
+	if !dumps["asm"] {
+		return
+	}
+	type asmDump struct {
+		Text    string   `json:"text"`
+		Op      string   `json:"op,omitempty"`
+		Args    []string `json:"args,omitempty"`
+		Comment string   `json:"comment,omitempty"`
+	}
+	lines := []asmDump{}
+	for _, line := range asmLines {
+		if !line.removed {
+			lines = append(lines, asmDump{Text: line.text, Op: line.op, Args: line.args, Comment: line.comment})
+		}
+	}
+	writeDump("asm", map[string]any{"lines": lines}, func(w io.Writer) {
+		for _, line := range lines {
+			fmt.Fprintln(w, line.Text)
+		}
+	})
+}
@@ -0,0 +1,236 @@
+package main
+
+import (
+	"encoding/binary"
+)
+
+// object is a relocatable object made by the built-in assembler. writeELF writes it as an ELF64 file
+type object struct {
+	sections []*objSection
+	symbols  []*objSymbol // the local symbols first
+}
+
+type objSection struct {
+	name   string
+	typ    uint32
+	flags  uint64
+	data   []byte
+	relocs []objReloc
+}
+
+type objReloc struct {
+	offset int64
+	typ    uint32
+	sym    *objSymbol
+	addend int64
+}
+
+type objSymbol struct {
+	name      string
+	section   *objSection // nil if undefined
+	value     int64
+	global    bool
+	isSection bool
+}
+
+const (
+	shtProgbits = 1
+	shtSymtab   = 2
+	shtStrtab   = 3
+	shtRela     = 4
+	shtNobits   = 8
+
+	shfWrite     = 1
+	shfAlloc     = 2
+	shfExecinstr = 4
+	shfInfoLink  = 0x40
+
+	sttNotype  = 0
+	sttSection = 3
+	stbLocal   = 0
+	stbGlobal  = 1
+
+	elfHeaderSize  = 64
+	elfSectionSize = 64
+	elfSymSize     = 24
+	elfRelaSize    = 24
+)
+
+// elfStrtab is a string table, which starts with the empty string
+type elfStrtab struct {
+	b     []byte
+	index map[string]uint32
+}
+
+func newElfStrtab() *elfStrtab {
+	return &elfStrtab{b: []byte{0}, index: map[string]uint32{"": 0}}
+}
+
+func (t *elfStrtab) add(s string) uint32 {
+	if i, ok := t.index[s]; ok {
+		return i
+	}
+	i := uint32(len(t.b))
+	t.b = append(append(t.b, s...), 0)
+	t.index[s] = i
+	return i
+}
+
+// elfSection is a section header and the contents of the section
+type elfSection struct {
+	name      string
+	typ       uint32
+	flags     uint64
+	addr      uint64
+	data      []byte
+	size      uint64 // of NOBITS sections
+	link      uint32
+	info      uint32
+	addralign uint64
+	entsize   uint64
+	offset    uint64
+}
+
+func elfHeader(typ uint16, entry uint64, phnum int, shoff uint64, shnum, shstrndx int) []byte {
+	le := binary.LittleEndian
+	b := []byte{0x7f, 'E', 'L', 'F', 2, 1, 1, 0, 0, 0, 0, 0, 0, 0, 0, 0} // 64-bit, little endian, System V
+	b = le.AppendUint16(b, typ)
+	b = le.AppendUint16(b, 62) // x86-64
+	b = le.AppendUint32(b, 1)
+	b = le.AppendUint64(b, entry)
+	phoff := uint64(0)
+	if phnum > 0 {
+		phoff = elfHeaderSize
+	}
+	b = le.AppendUint64(b, phoff)
+	b = le.AppendUint64(b, shoff)
+	b = le.AppendUint32(b, 0) // flags
+	b = le.AppendUint16(b, elfHeaderSize)
+	b = le.AppendUint16(b, 56) // the size of a program header
+	b = le.AppendUint16(b, uint16(phnum))
+	b = le.AppendUint16(b, elfSectionSize)
+	b = le.AppendUint16(b, uint16(shnum))
+	return le.AppendUint16(b, uint16(shstrndx))
+}
+
+// writeSections writes the contents of sections after b, aligned to their addralign unless their offsets are set,
+// and then the section headers with .shstrtab. it returns the file and the offset of the headers
+func writeSections(b []byte, sections []*elfSection) ([]byte, uint64) {
+	le := binary.LittleEndian
+	shstrtab := newElfStrtab()
+	names := make([]uint32, len(sections))
+	for i, s := range sections {
+		names[i] = shstrtab.add(s.name)
+	}
+	shstrndx := shstrtab.add(".shstrtab")
+	sections = append(sections, &elfSection{name: ".shstrtab", typ: shtStrtab, addralign: 1, data: shstrtab.b})
+	names = append(names, shstrndx)
+	for _, s := range sections[1:] {
+		if s.offset == 0 {
+			for s.addralign > 1 && uint64(len(b))%s.addralign != 0 {
+				b = append(b, 0)
+			}
+			s.offset = uint64(len(b))
+		}
+		for uint64(len(b)) < s.offset {
+			b = append(b, 0)
+		}
+		b = append(b, s.data...)
+	}
+	for len(b)%8 != 0 {
+		b = append(b, 0)
+	}
+	shoff := uint64(len(b))
+	for i, s := range sections {
+		size := uint64(len(s.data))
+		if s.typ == shtNobits {
+			size = s.size
+		}
+		b = le.AppendUint32(b, names[i])
+		b = le.AppendUint32(b, s.typ)
+		b = le.AppendUint64(b, s.flags)
+		b = le.AppendUint64(b, s.addr)
+		b = le.AppendUint64(b, s.offset)
+		b = le.AppendUint64(b, size)
+		b = le.AppendUint32(b, s.link)
+		b = le.AppendUint32(b, s.info)
+		b = le.AppendUint64(b, s.addralign)
+		b = le.AppendUint64(b, s.entsize)
+	}
+	return b, shoff
+}
+
+// appendElfSym appends an entry of a symbol table with the size 0
+func appendElfSym(symtab []byte, name uint32, info byte, shndx uint16, value uint64) []byte {
+	le := binary.LittleEndian
+	symtab = le.AppendUint32(symtab, name)
+	symtab = append(symtab, info, 0)
+	symtab = le.AppendUint16(symtab, shndx)
+	symtab = le.AppendUint64(symtab, value)
+	return le.AppendUint64(symtab, 0)
+}
+
+// writeELF writes obj as an ELF64 relocatable object. each section with relocations is followed by its .rela section
+func writeELF(obj *object) []byte {
+	le := binary.LittleEndian
+	sections := []*elfSection{{}} // the null section
+	index := map[*objSection]uint32{}
+	var relas []*elfSection
+	for _, s := range obj.sections {
+		index[s] = uint32(len(sections))
+		sections = append(sections, &elfSection{name: s.name, typ: s.typ, flags: s.flags, data: s.data, size: uint64(len(s.data)), addralign: 1})
+		if len(s.relocs) > 0 {
+			rela := &elfSection{name: ".rela" + s.name, typ: shtRela, flags: shfInfoLink, info: index[s], addralign: 8, entsize: elfRelaSize}
+			sections = append(sections, rela)
+			relas = append(relas, rela)
+		}
+	}
+	symtabIndex := uint32(len(sections))
+	strtab := newElfStrtab()
+	symtab := make([]byte, elfSymSize) // the null symbol
+	symIndex := map[*objSymbol]uint64{}
+	firstGlobal := 0
+	for i, sym := range obj.symbols {
+		symIndex[sym] = uint64(i + 1)
+		info, shndx := byte(stbLocal<<4|sttNotype), uint16(0)
+		if sym.isSection {
+			info = stbLocal<<4 | sttSection
+		}
+		if sym.global {
+			info = stbGlobal<<4 | sttNotype
+		} else {
+			firstGlobal = i + 2
+		}
+		if sym.section != nil {
+			shndx = uint16(index[sym.section])
+		}
+		name := uint32(0)
+		if !sym.isSection {
+			name = strtab.add(sym.name)
+		}
+		symtab = appendElfSym(symtab, name, info, shndx, uint64(sym.value))
+	}
+	if firstGlobal == 0 {
+		firstGlobal = 1
+	}
+	i := 0
+	for _, s := range obj.sections {
+		if len(s.relocs) == 0 {
+			continue
+		}
+		rela := relas[i]
+		i++
+		rela.link = symtabIndex
+		for _, r := range s.relocs {
+			rela.data = le.AppendUint64(rela.data, uint64(r.offset))
+			rela.data = le.AppendUint64(rela.data, symIndex[r.sym]<<32|uint64(r.typ))
+			rela.data = le.AppendUint64(rela.data, uint64(r.addend))
+		}
+	}
+	sections = append(sections,
+		&elfSection{name: ".symtab", typ: shtSymtab, data: symtab, link: symtabIndex + 1, info: uint32(firstGlobal), addralign: 8, entsize: elfSymSize},
+		&elfSection{name: ".strtab", typ: shtStrtab, data: strtab.b, addralign: 1})
+	b, shoff := writeSections(make([]byte, elfHeaderSize), sections)
+	copy(b, elfHeader(1, 0, 0, shoff, len(sections)+1, len(sections)))
+	return b
+}
@@ -0,0 +1,411 @@
+package main
+
+import (
+	"fmt"
+	"go/ast"
+	"go/token"
+)
+
+// escapes has why each variable moved to the heap escapes, by its object.
+// a variable whose address may be used after its function returns is allocated by runtime.alloc when it is declared,
+// and its slot in the frame holds the pointer to it
+var escapes = map[*ast.Object]string{}
+
+// heapVarFuncs are the functions analyzed by analyzeEscapes, true if they have variables in the heap
+var heapVarFuncs = map[*ast.FuncDecl]bool{}
+
+func isHeapVar(obj *ast.Object) bool {
+	return escapes[obj] != ""
+}
+
+// heapParam is a parameter moved to the heap, whose value is passed at offset from rbp
+type heapParam struct {
+	obj    *ast.Object
+	offset int
+}
+
+// paramOffset returns the offset of the value passed to the parameter obj of fnc
+func paramOffset(fnc *Func, obj *ast.Object) int {
+	for _, param := range fnc.heapParams {
+		if param.obj == obj {
+			return param.offset
+		}
+	}
+	return getObjectData(obj)
+}
+
+// emitNewHeapVar allocates the variable obj in the heap, and stores the pointer to its slot. rax has the pointer
+func emitNewHeapVar(obj *ast.Object) {
+	emit("  pushq $%d\n", sizeOf(varType(obj)))
+	emit("  callq runtime.alloc\n")
+	emit("  addq $8, %%rsp\n")
+	emit("  movq %%rax, %d(%%rbp) # &%s in the heap\n", getObjectData(obj), obj.Name)
+}
+
+// emitCopy copies the value at offset from rbp to the variable obj in the heap pointed by rax
+func emitCopy(offset int, obj *ast.Object) {
+	for i := 0; i < sizeOf(varType(obj)); i += 8 {
+		emit("  movq %d(%%rbp), %%rcx\n", offset+i)
+		emit("  movq %%rcx, %d(%%rax)\n", i)
+	}
+}
+
+// emitNewHeapVars allocates the variables in the heap declared by stmt, each time it runs
+func emitNewHeapVars(stmt ast.Stmt) {
+	for _, name := range declaredNames(stmt) {
+		if isHeapVar(name.Obj) {
+			emitNewHeapVar(name.Obj)
+		}
+	}
+}
+
+// emitRenewHeapVars allocates new copies of the variables in the heap declared by the init statement of a for loop,
+// before its post statement runs. each iteration has its own variables like Go 1.22,
+// which start with the values at the end of the previous iteration
+func emitRenewHeapVars(init ast.Stmt) {
+	for _, name := range declaredNames(init) {
+		if !isHeapVar(name.Obj) {
+			continue
+		}
+		emit("  pushq %d(%%rbp) # the previous &%s\n", getObjectData(name.Obj), name.Name)
+		emitNewHeapVar(name.Obj)
+		emit("  popq %%rcx\n")
+		for i := 0; i < sizeOf(varType(name.Obj)); i += 8 {
+			emit("  movq %d(%%rcx), %%rdx\n", i)
+			emit("  movq %%rdx, %d(%%rax)\n", i)
+		}
+	}
+}
+
+// declaredNames returns the local variables declared by stmt
+func declaredNames(stmt ast.Stmt) []*ast.Ident {
+	var names []*ast.Ident
+	switch s := stmt.(type) {
+	case *ast.DeclStmt:
+		if decl, ok := s.Decl.(*ast.GenDecl); ok && decl.Tok == token.VAR {
+			for _, spec := range decl.Specs {
+				names = append(names, spec.(*ast.ValueSpec).Names...)
+			}
+		}
+	case *ast.AssignStmt:
+		for _, lhs := range s.Lhs {
+			if ident, ok := lhs.(*ast.Ident); ok && ident.Obj != nil && (ident.Obj.Decl == s && s.Tok == token.DEFINE || rangeDefines[s]) {
+				names = append(names, ident)
+			}
+		}
+	}
+	return names
+}
+
+// escapeNode is the address of a variable, or the value held by a variable
+type escapeNode struct {
+	obj  *ast.Object
+	addr bool
+}
+
+type escapeAnalysis struct {
+	locals    []*ast.Object
+	isLocal   map[*ast.Object]bool
+	loopDepth map[*ast.Object]int          // how many loops the variable is declared in
+	addressed map[*ast.Object]bool         // &x appears somewhere
+	flows     map[*ast.Object][]escapeNode // the nodes assigned to each variable
+	escaped   map[escapeNode]string        // how the node escapes, e.g. "is returned"
+}
+
+// analyzeEscapes decides which variables of decl move to the heap. the analysis is flow-insensitive and doesn't look into callees:
+// the address of a variable escapes when it is returned, passed to a function, stored in a global variable or through a pointer,
+// captured by a closure, or assigned to a variable whose value escapes or which is declared outside the loop it is declared in,
+// because each iteration has its own variable and the ones of the previous iterations are still reachable.
+// the value of a variable in the heap escapes as well, because it is reachable from the heap
+func analyzeEscapes(fnc *Func) {
+	decl := fnc.decl
+	if _, ok := heapVarFuncs[decl]; ok || decl.Body == nil {
+		return
+	}
+	a := &escapeAnalysis{
+		isLocal:   map[*ast.Object]bool{},
+		loopDepth: map[*ast.Object]int{},
+		addressed: map[*ast.Object]bool{},
+		flows:     map[*ast.Object][]escapeNode{},
+		escaped:   map[escapeNode]string{},
+	}
+	a.collectLocals(decl)
+	for _, name := range namedResults(decl) {
+		a.escape(escapeNode{obj: name.Obj}, "is returned")
+	}
+	ast.Inspect(decl.Body, a.visit)
+
+	heapVarFuncs[decl] = false
+	for _, obj := range a.locals {
+		pos := obj.Pos()
+		if reason, ok := a.escaped[escapeNode{obj: obj, addr: true}]; ok {
+			escapes[obj] = fmt.Sprintf("&%s %s", obj.Name, reason)
+			heapVarFuncs[decl] = true
+			reportOpt(fnc.pkg, pos, "moved to heap: %s (%s)", obj.Name, escapes[obj])
+		} else if a.addressed[obj] {
+			reportOpt(fnc.pkg, pos, "%s does not escape", obj.Name)
+		}
+	}
+}
+
+// collectLocals lists the receiver, the parameters, the named results and the variables declared in the body of decl
+func (a *escapeAnalysis) collectLocals(decl *ast.FuncDecl) {
+	depth := 0
+	add := func(ident *ast.Ident) {
+		if ident != nil && ident.Obj != nil && ident.Obj.Kind == ast.Var && !a.isLocal[ident.Obj] && ident.Name != "_" {
+			a.isLocal[ident.Obj] = true
+			a.locals = append(a.locals, ident.Obj)
+			a.loopDepth[ident.Obj] = depth
+		}
+	}
+	for _, list := range []*ast.FieldList{decl.Recv, decl.Type.Params, decl.Type.Results} {
+		if list == nil {
+			continue
+		}
+		for _, field := range list.List {
+			for _, name := range field.Names {
+				add(name)
+			}
+		}
+	}
+	var stack []ast.Node
+	ast.Inspect(decl.Body, func(n ast.Node) bool {
+		if n == nil {
+			if isLoop(stack[len(stack)-1]) {
+				depth--
+			}
+			stack = stack[:len(stack)-1]
+			return true
+		}
+		stack = append(stack, n)
+		if isLoop(n) { // the variables declared by the loop itself are in it too
+			depth++
+		}
+		switch s := n.(type) {
+		case *ast.ValueSpec:
+			for _, name := range s.Names {
+				add(name)
+			}
+		case *ast.AssignStmt:
+			if s.Tok == token.DEFINE {
+				for _, lhs := range s.Lhs {
+					if ident, ok := lhs.(*ast.Ident); ok && ident.Obj != nil && ident.Obj.Decl == s {
+						add(ident)
+					}
+				}
+			}
+		case *ast.RangeStmt:
+			if s.Tok == token.DEFINE {
+				key, _ := s.Key.(*ast.Ident)
+				value, _ := s.Value.(*ast.Ident)
+				add(key)
+				add(value)
+			}
+		}
+		return true
+	})
+}
+
+func isLoop(n ast.Node) bool {
+	switch n.(type) {
+	case *ast.ForStmt, *ast.RangeStmt:
+		return true
+	}
+	return false
+}
+
+func (a *escapeAnalysis) visit(n ast.Node) bool {
+	switch s := n.(type) {
+	case *ast.ReturnStmt:
+		for _, result := range s.Results {
+			a.escapeAll(a.sources(result), "is returned")
+		}
+	case *ast.AssignStmt:
+		if len(s.Lhs) == len(s.Rhs) && (s.Tok == token.ASSIGN || s.Tok == token.DEFINE) {
+			for i, lhs := range s.Lhs {
+				a.assign(lhs, a.sources(s.Rhs[i]))
+			}
+		}
+	case *ast.ValueSpec:
+		if len(s.Names) == len(s.Values) {
+			for i, name := range s.Names {
+				a.assign(name, a.sources(s.Values[i]))
+			}
+		}
+	case *ast.RangeStmt:
+		if s.Value != nil {
+			a.assign(s.Value, a.sources(s.X))
+		}
+	case *ast.CallExpr:
+		a.call(s)
+	case *ast.FuncLit:
+		ast.Inspect(s.Body, func(n ast.Node) bool {
+			if ident, ok := n.(*ast.Ident); ok && a.isLocal[ident.Obj] {
+				a.addressed[ident.Obj] = true
+				a.escape(escapeNode{obj: ident.Obj, addr: true}, "is captured by a closure")
+			}
+			return true
+		})
+		return false
+	}
+	return true
+}
+
+// call makes the arguments of a call escape, except for the builtins which don't keep them
+func (a *escapeAnalysis) call(expr *ast.CallExpr) {
+	if conversionType(expr) != nil {
+		return
+	}
+	name := "a function"
+	if ident := funcIdent(expr.Fun); ident != nil {
+		name = ident.Name
+		if isBuiltin(ident, ident.Name) {
+			if ident.Name != "append" {
+				return
+			}
+			for _, arg := range expr.Args[1:] {
+				a.escapeAll(a.sources(arg), "is appended to a slice")
+			}
+			return
+		}
+	}
+	if sel := methodSelector(expr); sel != nil {
+		name = sel.Sel.Name
+		method := lookupMethod(getType(sel.X), sel.Sel.Name)
+		if method.decl.Recv != nil && hasPointerRecv(method.decl) && !isPointer(getType(sel.X)) {
+			a.escapeAll(a.addrSources(sel.X), fmt.Sprintf("is the receiver of %s", name))
+		} else {
+			a.escapeAll(a.sources(sel.X), fmt.Sprintf("is the receiver of %s", name))
+		}
+	}
+	for _, arg := range expr.Args {
+		a.escapeAll(a.sources(arg), fmt.Sprintf("is passed to %s", name))
+	}
+}
+
+// assign records that the nodes are assigned to lhs
+func (a *escapeAnalysis) assign(lhs ast.Expr, nodes []escapeNode) {
+	switch l := lhs.(type) {
+	case *ast.Ident:
+		switch {
+		case l.Name == "_":
+		case a.isLocal[l.Obj]:
+			a.flows[l.Obj] = append(a.flows[l.Obj], nodes...)
+			for _, node := range nodes {
+				if a.loopDepth[node.obj] > a.loopDepth[l.Obj] {
+					a.escape(node, fmt.Sprintf("is assigned to %s, which is declared outside the loop", l.Name))
+				}
+			}
+			if reason, ok := a.escaped[escapeNode{obj: l.Obj}]; ok { // assigned after its value escapes
+				a.escapeAll(nodes, fmt.Sprintf("is assigned to %s, which %s", l.Name, reason))
+			}
+		default:
+			a.escapeAll(nodes, fmt.Sprintf("is assigned to the global variable %s", l.Name))
+		}
+	case *ast.ParenExpr:
+		a.assign(l.X, nodes)
+	case *ast.SelectorExpr:
+		if ident, ok := l.X.(*ast.Ident); ok && a.isLocal[ident.Obj] && !isPointer(varType(ident.Obj)) { // a field of a struct variable
+			a.assign(ident, nodes)
+			return
+		}
+		a.escapeAll(nodes, "is stored through a pointer")
+	default:
+		a.escapeAll(nodes, "is stored through a pointer")
+	}
+}
+
+// sources returns the nodes which escape when the value of expr escapes
+func (a *escapeAnalysis) sources(expr ast.Expr) []escapeNode {
+	switch e := expr.(type) {
+	case *ast.Ident:
+		if a.isLocal[e.Obj] {
+			return []escapeNode{{obj: e.Obj}}
+		}
+	case *ast.UnaryExpr:
+		if e.Op == token.AND {
+			return a.addrSources(e.X)
+		}
+	case *ast.ParenExpr:
+		return a.sources(e.X)
+	case *ast.SelectorExpr:
+		if qualifiedIdent(e) == nil {
+			return a.sources(e.X)
+		}
+	case *ast.StarExpr: // the value p points to, which only carries the addresses in p when it holds pointers
+		if hasPointers(elemType(getType(e.X))) {
+			return a.sources(e.X)
+		}
+	case *ast.IndexExpr:
+		return a.sources(e.X)
+	case *ast.SliceExpr:
+		return a.sources(e.X)
+	case *ast.TypeAssertExpr:
+		return a.sources(e.X)
+	case *ast.CompositeLit:
+		var nodes []escapeNode
+		for _, elt := range e.Elts {
+			if kv, ok := elt.(*ast.KeyValueExpr); ok {
+				elt = kv.Value
+			}
+			nodes = append(nodes, a.sources(elt)...)
+		}
+		return nodes
+	case *ast.CallExpr:
+		if conversionType(e) != nil {
+			return a.sources(e.Args[0])
+		}
+		if ident := funcIdent(e.Fun); ident != nil && isBuiltin(ident, "append") {
+			return a.sources(e.Args[0])
+		}
+	}
+	return nil
+}
+
+// addrSources returns the nodes which escape when &expr escapes. &x and &x.f are the address of the variable x,
+// while &p.f and &s[i] are in the memory p and s point to
+func (a *escapeAnalysis) addrSources(expr ast.Expr) []escapeNode {
+	switch e := expr.(type) {
+	case *ast.Ident:
+		if a.isLocal[e.Obj] {
+			a.addressed[e.Obj] = true
+			return []escapeNode{{obj: e.Obj, addr: true}}
+		}
+	case *ast.ParenExpr:
+		return a.addrSources(e.X)
+	case *ast.SelectorExpr:
+		if qualifiedIdent(e) != nil {
+			return nil
+		}
+		if isPointer(getType(e.X)) {
+			return a.sources(e.X)
+		}
+		return a.addrSources(e.X)
+	case *ast.CompositeLit: // allocated in the heap
+		return a.sources(e)
+	default:
+		return a.sources(expr)
+	}
+	return nil
+}
+
+func (a *escapeAnalysis) escapeAll(nodes []escapeNode, reason string) {
+	for _, node := range nodes {
+		a.escape(node, reason)
+	}
+}
+
+// escape marks node escaping by reason, and the nodes assigned to it
+func (a *escapeAnalysis) escape(node escapeNode, reason string) {
+	if _, ok := a.escaped[node]; ok {
+		return
+	}
+	a.escaped[node] = reason
+	if node.addr { // the value is in the heap
+		a.escape(escapeNode{obj: node.obj}, "is moved to the heap")
+		return
+	}
+	for _, from := range a.flows[node.obj] {
+		a.escape(from, fmt.Sprintf("is assigned to %s, which %s", node.obj.Name, reason))
+	}
+}
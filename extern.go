@@ -0,0 +1,222 @@
+package main
+
+import (
+	"fmt"
+	"go/ast"
+	"strings"
+)
+
+// foreign functions are declared without body and bound to a C symbol by //extern, like gccgo
+//
+//	//extern strlen
+//	func strlen(s string) int
+//
+// they are called with the System V ABI. the arguments are words passed in rdi, rsi, rdx, rcx, r8 and r9 and then on the stack,
+// which is aligned to 16 bytes at the call. strings are passed as NUL terminated copies and slices as the pointer to the elements.
+// ints are 64 bits like long in C. a string result is copied from the NUL terminated char * returned in rax
+//
+// the objects of the C functions are linked by ld. with -libc the program is linked with the C library:
+// main is called by the C runtime instead of _start, the heap is allocated by calloc and os.Exit calls exit to flush stdio
+
+var (
+	// libc is set by -libc
+	libc bool
+
+	externRegs = []string{"rdi", "rsi", "rdx", "rcx", "r8", "r9"}
+)
+
+// externName returns the C symbol of //extern name in the doc comment of decl, or ""
+func externName(decl *ast.FuncDecl) string {
+	if decl.Doc == nil {
+		return ""
+	}
+	for _, comment := range decl.Doc.List {
+		fields := strings.Fields(comment.Text)
+		if len(fields) == 2 && fields[0] == "//extern" {
+			return fields[1]
+		}
+	}
+	return ""
+}
+
+func isExtern(fnc *Func) bool {
+	return fnc.decl.Body == nil && fnc.decl.Recv == nil && externName(fnc.decl) != ""
+}
+
+// emitExternCall calls the C function fnc and pushes the result.
+// r12 keeps the stack pointer across the call because the callee saves it
+func emitExternCall(fnc *Func, expr *ast.CallExpr) {
+	if isVariadic(fnc.decl) {
+		must(fmt.Errorf("%s: variadic extern functions are not supported", fnc.name))
+	}
+	var types []*ast.Object
+	for _, t := range paramTypes(fnc.decl) {
+		types = append(types, getType(t))
+	}
+	if len(expr.Args) != len(types) {
+		must(fmt.Errorf("%s: got %d arguments but %d parameters", fnc.name, len(expr.Args), len(types)))
+	}
+	retType := resultType(fnc)
+	if retType != nil && !isCWord(retType) && underlying(retType) != globalString {
+		must(fmt.Errorf("%s: cannot return %s from C", fnc.name, retType.Name))
+	}
+
+	sizes := make([]int, len(types))
+	operands := make([]func(), len(types))
+	for i, typ := range types {
+		i, typ := i, typ
+		sizes[i] = 8
+		operands[i] = func() {
+			emitExprAs(expr.Args[i], typ)
+			emitCArg(fnc, typ)
+		}
+	}
+	if argsHaveCalls(expr.Args, 2) {
+		emitOperandsInOrder(sizes, operands)
+	} else {
+		for i := len(operands) - 1; i >= 0; i-- {
+			operands[i]()
+		}
+	}
+	for i := 0; i < len(types) && i < len(externRegs); i++ {
+		emit("  popq %%%s\n", externRegs[i])
+	}
+	stackArgs := 0
+	if len(types) > len(externRegs) {
+		stackArgs = len(types) - len(externRegs)
+	}
+	emit("  movq %%rsp, %%r12\n")
+	emit("  andq $-16, %%rsp\n")
+	if stackArgs > 0 {
+		// copy the rest of the arguments below the aligned stack pointer
+		emit("  subq $%d, %%rsp\n", (stackArgs*8+15)&^15)
+		for i := 0; i < stackArgs; i++ {
+			emit("  movq %d(%%r12), %%rax\n", i*8)
+			emit("  movq %%rax, %d(%%rsp)\n", i*8)
+		}
+	}
+	emit("  movq $0, %%rax # no vector registers for variadic functions\n")
+	emit("  callq %s\n", symbol(externName(fnc.decl)))
+	emit("  leaq %d(%%r12), %%rsp\n", stackArgs*8)
+
+	switch {
+	case retType == nil:
+	case underlying(retType) == globalString:
+		emit("  pushq %%rax\n")
+		emit("  callq runtime.gostring\n")
+		emit("  addq $8, %%rsp\n")
+		emit("  pushq %%rsi # len\n")
+		emit("  pushq %%rax # ptr\n")
+	case underlying(retType) == globalBool, underlying(retType) == globalByte:
+		emit("  movzbq %%al, %%rax\n")
+		emit("  pushq %%rax\n")
+	default:
+		emit("  pushq %%rax\n")
+	}
+}
+
+// emitCArg converts the argument of typ on the stack to a word
+func emitCArg(fnc *Func, typ *ast.Object) {
+	switch {
+	case isCWord(typ):
+	case underlying(typ) == globalString:
+		emit("  callq runtime.cstring\n")
+		emit("  addq $16, %%rsp\n")
+		emit("  pushq %%rax\n")
+	case isSlice(typ):
+		emit("  popq %%rax # ptr\n")
+		emit("  addq $16, %%rsp\n")
+		emit("  pushq %%rax\n")
+	default:
+		must(fmt.Errorf("%s: cannot pass %s to C", fnc.name, typ.Name))
+	}
+}
+
+// isCWord reports whether values of typ are passed to C as they are
+func isCWord(typ *ast.Object) bool {
+	u := underlying(typ)
+	return u == globalInt || u == globalBool || u == globalByte || isPointer(typ)
+}
+
+// cstrings emits the conversions of strings between Go and C
+func cstrings() {
+	emit("# cstrings\n")
+	emit(".text\n")
+	// cstring copies the string at 8(rsp) to a NUL terminated string and returns the pointer in rax
+	emit("runtime.cstring:\n")
+	emit("  movq 16(%%rsp), %%rax\n")
+	emit("  incq %%rax\n")
+	emit("  pushq %%rax\n")
+	emit("  callq runtime.alloc\n")
+	emit("  addq $8, %%rsp\n")
+	emit("  movq %%rax, %%rdi\n")
+	emit("  movq 8(%%rsp), %%rsi\n")
+	emit("  movq 16(%%rsp), %%rcx\n")
+	emit("  rep movsb\n")
+	emit("  movb $0, (%%rdi)\n")
+	emit("  ret\n\n")
+
+	// gostring copies the NUL terminated string at 8(rsp) and returns the string in rax (ptr) and rsi (len)
+	emit("runtime.gostring:\n")
+	emit("  movq 8(%%rsp), %%rsi\n")
+	emit("  xorq %%rcx, %%rcx\n")
+	emit("  testq %%rsi, %%rsi\n")
+	emit("  je runtime.gostring.copy\n") // NULL is ""
+	emit("runtime.gostring.len:\n")
+	emit("  cmpb $0, (%%rsi,%%rcx)\n")
+	emit("  je runtime.gostring.copy\n")
+	emit("  incq %%rcx\n")
+	emit("  jmp runtime.gostring.len\n")
+	emit("runtime.gostring.copy:\n")
+	emit("  pushq %%rcx\n")
+	emit("  pushq %%rcx\n")
+	emit("  callq runtime.alloc\n")
+	emit("  addq $8, %%rsp\n")
+	emit("  popq %%rcx\n")
+	emit("  pushq %%rcx\n")
+	emit("  movq %%rax, %%rdi\n")
+	emit("  movq 16(%%rsp), %%rsi\n")
+	emit("  rep movsb\n")
+	emit("  popq %%rsi\n")
+	emit("  ret\n\n")
+}
+
+// libcRuntime emits the entry point and the routines replaced when the program is linked with the C library
+func libcRuntime() {
+	emit("# libc\n")
+	emit(".text\n")
+	// main(argc, argv, envp) saves rbx of the register ABI and r12 for the C runtime
+	emit(".global main\n")
+	emit("main:\n")
+	emit("  pushq %%rbx\n")
+	emit("  pushq %%r12\n")
+	emit("  movq %%rsi, runtime.argv(%%rip)\n")
+	emit("  movq %%rdx, runtime.envp(%%rip)\n")
+	emit("  callq main.init\n")
+	emit("  callq main.main\n")
+	emit("  popq %%r12\n")
+	emit("  popq %%rbx\n")
+	emit("  movq $0, %%rax\n")
+	emit("  ret\n\n")
+
+	emit("runtime.exit:\n")
+	emit("  movq 8(%%rsp), %%rdi\n")
+	emit("  andq $-16, %%rsp\n")
+	emit("  callq exit\n\n")
+
+	// alloc returns calloc(1, size) in rax. the other registers are saved because the runtime routines don't expect calls to clobber them
+	emit("runtime.alloc:\n")
+	for _, reg := range []string{"rcx", "rdx", "rsi", "rdi", "r8", "r9", "r10", "r11", "r12"} {
+		emit("  pushq %%%s\n", reg)
+	}
+	emit("  movq 80(%%rsp), %%rsi\n")
+	emit("  movq $1, %%rdi\n")
+	emit("  movq %%rsp, %%r12\n")
+	emit("  andq $-16, %%rsp\n")
+	emit("  callq calloc\n")
+	emit("  movq %%r12, %%rsp\n")
+	for _, reg := range []string{"r12", "r11", "r10", "r9", "r8", "rdi", "rsi", "rdx", "rcx"} {
+		emit("  popq %%%s\n", reg)
+	}
+	emit("  ret\n\n")
+}
@@ -0,0 +1,411 @@
+package main
+
+import (
+	"fmt"
+	"go/ast"
+	"go/constant"
+	"go/token"
+	"math"
+	"strconv"
+	"strings"
+)
+
+// fold rewrites the files of pkg before they are walked. untyped constant expressions like 1 + 2, "a" + "b" or limit > 0
+// are replaced by literals and true or false. integers are computed exactly like the Go compiler does, so 1 << 70 >> 68
+// is 4, and a literal left out of the range of int is an error when it is compiled.
+// statements after return, break and continue are dropped, statements after return, break and continue are dropped,
+// and if statements and for loops with constant conditions are replaced by the branch taken.
+// typed constants are left to the code generation because their values wrap around like the type
+func fold(pkg *Package) {
+	for _, file := range pkg.files {
+		for _, decl := range file.Decls {
+			switch d := decl.(type) {
+			case *ast.GenDecl:
+				for _, spec := range d.Specs {
+					if valSpec, ok := spec.(*ast.ValueSpec); ok {
+						foldExprs(valSpec.Values)
+					}
+				}
+			case *ast.FuncDecl:
+				if d.Body != nil {
+					d.Body.List = foldStmts(d.Body.List)
+				}
+			}
+		}
+	}
+}
+
+// foldStmts folds the statements of a block and drops the ones never executed
+func foldStmts(stmts []ast.Stmt) []ast.Stmt {
+	var list []ast.Stmt
+	for _, stmt := range stmts {
+		stmt = foldStmt(stmt)
+		if stmt == nil {
+			continue
+		}
+		list = append(list, stmt)
+		switch s := stmt.(type) {
+		case *ast.ReturnStmt:
+			return list
+		case *ast.BranchStmt:
+			if s.Tok == token.BREAK || s.Tok == token.CONTINUE {
+				return list
+			}
+		}
+	}
+	return list
+}
+
+// foldStmt folds stmt and returns the statement replacing it, or nil if it is removed
+func foldStmt(stmt ast.Stmt) ast.Stmt {
+	switch s := stmt.(type) {
+	case *ast.ExprStmt:
+		s.X = foldExpr(s.X)
+	case *ast.DeclStmt:
+		if d, ok := s.Decl.(*ast.GenDecl); ok {
+			for _, spec := range d.Specs {
+				if valSpec, ok := spec.(*ast.ValueSpec); ok {
+					foldExprs(valSpec.Values)
+				}
+			}
+		}
+	case *ast.AssignStmt:
+		foldExprs(s.Rhs)
+	case *ast.ReturnStmt:
+		foldExprs(s.Results)
+	case *ast.BlockStmt:
+		s.List = foldStmts(s.List)
+	case *ast.IfStmt:
+		s.Cond = foldExpr(s.Cond)
+		s.Body.List = foldStmts(s.Body.List)
+		if s.Else != nil {
+			s.Else = foldStmt(s.Else)
+		}
+		cond, ok := constBool(s.Cond)
+		if !ok || s.Init != nil {
+			break
+		}
+		if cond {
+			return s.Body
+		}
+		if s.Else == nil {
+			return nil
+		}
+		return s.Else
+	case *ast.ForStmt:
+		if s.Cond != nil {
+			s.Cond = foldExpr(s.Cond)
+		}
+		s.Body.List = foldStmts(s.Body.List)
+		if cond, ok := constBool(s.Cond); ok && !cond { // the body is never executed
+			if s.Init == nil {
+				return nil
+			}
+			return &ast.BlockStmt{List: []ast.Stmt{s.Init}}
+		}
+	case *ast.RangeStmt:
+		s.X = foldExpr(s.X)
+		s.Body.List = foldStmts(s.Body.List)
+	case *ast.SwitchStmt:
+		if s.Tag != nil {
+			s.Tag = foldExpr(s.Tag)
+		}
+		for _, clause := range s.Body.List {
+			cc := clause.(*ast.CaseClause)
+			foldExprs(cc.List)
+			cc.Body = foldStmts(cc.Body)
+		}
+	}
+	return stmt
+}
+
+func foldExprs(exprs []ast.Expr) {
+	for i := range exprs {
+		exprs[i] = foldExpr(exprs[i])
+	}
+}
+
+// foldExpr returns expr with its untyped constant subexpressions replaced by their values
+func foldExpr(expr ast.Expr) ast.Expr {
+	switch e := expr.(type) {
+	case *ast.ParenExpr:
+		e.X = foldExpr(e.X)
+		if isFoldedConst(e.X) {
+			return e.X
+		}
+	case *ast.UnaryExpr:
+		e.X = foldExpr(e.X)
+		if !isFoldedConst(e.X) {
+			break
+		}
+		if x, ok := constInt(e.X); ok {
+			switch e.Op {
+			case token.SUB, token.XOR:
+				return intLit(constant.UnaryOp(e.Op, x, 0))
+			case token.ADD:
+				return e.X
+			}
+		}
+		if x, ok := constBool(e.X); ok && e.Op == token.NOT {
+			return boolIdent(!x)
+		}
+	case *ast.BinaryExpr:
+		e.X = foldExpr(e.X)
+		e.Y = foldExpr(e.Y)
+		if !isFoldedConst(e.X) || !isFoldedConst(e.Y) {
+			break
+		}
+		if folded := foldBinary(e); folded != nil {
+			return folded
+		}
+	case *ast.CallExpr:
+		foldExprs(e.Args)
+	case *ast.CompositeLit:
+		for i, elt := range e.Elts {
+			if kv, ok := elt.(*ast.KeyValueExpr); ok {
+				kv.Value = foldExpr(kv.Value)
+			} else {
+				e.Elts[i] = foldExpr(elt)
+			}
+		}
+	case *ast.IndexExpr:
+		e.X = foldExpr(e.X)
+		e.Index = foldExpr(e.Index)
+	case *ast.SliceExpr:
+		e.X = foldExpr(e.X)
+		if e.Low != nil {
+			e.Low = foldExpr(e.Low)
+		}
+		if e.High != nil {
+			e.High = foldExpr(e.High)
+		}
+	case *ast.SelectorExpr:
+		e.X = foldExpr(e.X)
+	case *ast.StarExpr:
+		e.X = foldExpr(e.X)
+	}
+	return expr
+}
+
+// foldBinary returns the value of x op y of untyped constants, or nil if it isn't computed at compile time
+func foldBinary(e *ast.BinaryExpr) ast.Expr {
+	if x, ok := constInt(e.X); ok {
+		y, ok := constInt(e.Y)
+		if !ok {
+			return nil
+		}
+		switch e.Op {
+		case token.ADD, token.SUB, token.MUL, token.AND, token.OR, token.XOR, token.AND_NOT:
+			return intLit(constant.BinaryOp(x, e.Op, y))
+		case token.QUO, token.REM:
+			if constant.Sign(y) == 0 { // left to the division by zero at run time
+				return nil
+			}
+			if e.Op == token.QUO {
+				return intLit(constant.BinaryOp(x, token.QUO_ASSIGN, y)) // the integer division
+			}
+			return intLit(constant.BinaryOp(x, token.REM, y))
+		case token.SHL, token.SHR:
+			count, exact := constant.Uint64Val(y)
+			if !exact || count > maxConstShift {
+				return nil
+			}
+			return intLit(constant.Shift(x, e.Op, uint(count)))
+		}
+		return compareConst(e.Op, x, y)
+	}
+	if x, ok := constString(e.X); ok {
+		y, ok := constString(e.Y)
+		if !ok {
+			return nil
+		}
+		if e.Op == token.ADD {
+			return &ast.BasicLit{Kind: token.STRING, Value: strconv.Quote(x + y)}
+		}
+		return compareConst(e.Op, constant.MakeString(x), constant.MakeString(y))
+	}
+	if x, ok := constBool(e.X); ok {
+		y, ok := constBool(e.Y)
+		if !ok {
+			return nil
+		}
+		switch e.Op {
+		case token.LAND:
+			return boolIdent(x && y)
+		case token.LOR:
+			return boolIdent(x || y)
+		case token.EQL:
+			return boolIdent(x == y)
+		case token.NEQ:
+			return boolIdent(x != y)
+		}
+	}
+	return nil
+}
+
+// compareConst returns the result of the comparison x op y of constants
+func compareConst(op token.Token, x, y constant.Value) ast.Expr {
+	switch op {
+	case token.EQL, token.NEQ, token.LSS, token.LEQ, token.GTR, token.GEQ:
+		return boolIdent(constant.Compare(x, op, y))
+	}
+	return nil
+}
+
+// isFoldedConst reports whether expr is an untyped constant whose value is known
+func isFoldedConst(expr ast.Expr) bool {
+	if !isUntypedConst(expr) && !isBoolConst(expr) {
+		return false
+	}
+	_, isInt := constInt(expr)
+	_, isString := constString(expr)
+	_, isBool := constBool(expr)
+	return isInt || isString || isBool
+}
+
+func isBoolConst(expr ast.Expr) bool {
+	ident, ok := expr.(*ast.Ident)
+	return ok && ident.Obj != nil && ident.Obj.Kind == ast.Con && ident.Obj.Decl == nil // true or false
+}
+
+// maxConstShift is the largest count of the shifts of constants computed at compile time
+const maxConstShift = 10000
+
+// constInt returns the exact value of an integer or rune literal, or of a constant declared by one
+func constInt(expr ast.Expr) (constant.Value, bool) {
+	switch e := expr.(type) {
+	case *ast.BasicLit:
+		if e.Kind == token.INT || e.Kind == token.CHAR {
+			return litValue(e), true
+		}
+	case *ast.UnaryExpr: // negative values are folded to -n
+		if lit, ok := e.X.(*ast.BasicLit); ok && e.Op == token.SUB && lit.Kind == token.INT {
+			return constant.UnaryOp(token.SUB, litValue(lit), 0), true
+		}
+	case *ast.Ident:
+		if value := untypedConstValue(e); value != nil {
+			return constInt(value)
+		}
+	}
+	return nil, false
+}
+
+// litValue returns the value of an integer or rune literal. the literal of the smallest int made by intLit has the sign
+func litValue(lit *ast.BasicLit) constant.Value {
+	if digits, ok := strings.CutPrefix(lit.Value, "-"); ok {
+		return constant.UnaryOp(token.SUB, constant.MakeFromLiteral(digits, lit.Kind, 0), 0)
+	}
+	return constant.MakeFromLiteral(lit.Value, lit.Kind, 0)
+}
+
+func constString(expr ast.Expr) (string, bool) {
+	switch e := expr.(type) {
+	case *ast.BasicLit:
+		if e.Kind == token.STRING {
+			s, err := strconv.Unquote(e.Value)
+			must(err)
+			return s, true
+		}
+	case *ast.Ident:
+		if value := untypedConstValue(e); value != nil {
+			return constString(value)
+		}
+	}
+	return "", false
+}
+
+func constBool(expr ast.Expr) (bool, bool) {
+	if isBoolConst(expr) {
+		return expr.(*ast.Ident).Name == "true", true
+	}
+	if ident, ok := expr.(*ast.Ident); ok {
+		if value := untypedConstValue(ident); value != nil {
+			return constBool(value)
+		}
+	}
+	return false, false
+}
+
+// untypedConstValue returns the folded value of const x = value, or nil if ident isn't an untyped constant
+func untypedConstValue(ident *ast.Ident) ast.Expr {
+	if ident.Obj == nil || ident.Obj.Kind != ast.Con || !isUntypedConst(ident) {
+		return nil
+	}
+	spec := ident.Obj.Decl.(*ast.ValueSpec)
+	for i, name := range spec.Names {
+		if name.Obj == ident.Obj && i < len(spec.Values) {
+			spec.Values[i] = foldExpr(spec.Values[i])
+			return spec.Values[i]
+		}
+	}
+	return nil
+}
+
+// intLit returns the literal of value, which keeps every digit even if value doesn't fit in int
+func intLit(value constant.Value) ast.Expr {
+	if value.Kind() != constant.Int {
+		must(fmt.Errorf("unexpected constant %s", value))
+	}
+	if v, exact := constant.Int64Val(value); exact && v == math.MinInt64 { // a literal has no sign, but the negation of MinInt overflows
+		return &ast.BasicLit{Kind: token.INT, Value: value.ExactString()}
+	}
+	if constant.Sign(value) < 0 {
+		return &ast.UnaryExpr{Op: token.SUB, X: intLit(constant.UnaryOp(token.SUB, value, 0))}
+	}
+	return &ast.BasicLit{Kind: token.INT, Value: value.ExactString()}
+}
+
+func boolIdent(value bool) ast.Expr {
+	name := "false"
+	if value {
+		name = "true"
+	}
+	return &ast.Ident{Name: name, Obj: universe.Lookup(name)}
+}
+
+// liveFuncs returns funcs without the unexported functions never referred to from main, init, exported functions,
+// methods and package level variables
+func liveFuncs(funcs []*Func) []*Func {
+	live := map[*ast.FuncDecl]bool{}
+	var queue []ast.Node
+	mark := func(decl *ast.FuncDecl) {
+		if !live[decl] && decl.Body != nil {
+			live[decl] = true
+			queue = append(queue, decl.Body)
+		}
+	}
+	for _, pkg := range pkgOrder {
+		for _, file := range pkg.files {
+			for _, decl := range file.Decls {
+				switch d := decl.(type) {
+				case *ast.FuncDecl:
+					if d.Recv != nil || ast.IsExported(d.Name.Name) || d.Name.Name == MAIN || d.Name.Name == "init" {
+						mark(d)
+					}
+				case *ast.GenDecl:
+					queue = append(queue, d)
+				}
+			}
+		}
+	}
+	for len(queue) > 0 {
+		node := queue[0]
+		queue = queue[1:]
+		ast.Inspect(node, func(n ast.Node) bool {
+			if ident, ok := n.(*ast.Ident); ok && ident.Obj != nil {
+				if decl, ok := ident.Obj.Decl.(*ast.FuncDecl); ok {
+					mark(decl)
+				}
+			}
+			return true
+		})
+	}
+
+	var list []*Func
+	for _, fnc := range funcs {
+		if live[fnc.decl] {
+			list = append(list, fnc)
+		}
+	}
+	return list
+}
@@ -0,0 +1,346 @@
+package main
+
+// the heap is a reservation of the heapMax bytes of the target tiled by blocks. a block has a header of its size and flags,
+// and the link of the free list while it is free. a bitmap with a bit for every 16 bytes marks where the blocks start,
+// which finds the block of a pointer into the middle of it like a slice of an array
+const (
+	heapMinimum = 4 << 20 // the smallest heap collected with GOGC=100
+
+	blockMarked = 1
+	blockFree   = 2
+)
+
+// emitGCRoots emits the table of the address and the size of the global variables which may hold pointers.
+// the collector scans them and the stack for pointers to the heap
+func emitGCRoots() {
+	emit(".data\n")
+	emit("runtime.gcRoots:\n")
+	for _, gv := range globalVariables {
+		if hasPointers(gv.typ) {
+			emit("  .quad %s, %d\n", gv.tag, sizeOf(gv.typ))
+		}
+	}
+	emit("runtime.gcRootsEnd:\n\n")
+}
+
+// alloc emits the allocator and the mark-sweep collector. runtime.alloc returns size(8(rsp)) bytes of zeroed memory in rax,
+// and keeps the other registers. it collects the heap first when the heap in use would grow over the goal set by GOGC
+func alloc() {
+	if libc {
+		return // calloc
+	}
+	emit("# alloc\n")
+	emit(".data\n")
+	for _, name := range []string{"heapStart", "heapTop", "heapLimit", "bitmap", "markStack", "freeList", "heapLive", "nextGC", "stackTop"} {
+		emit("runtime.%s:\n", name)
+		emit("  .quad 0\n")
+	}
+	emit("runtime.gcPercent:\n")
+	emit("  .quad 100\n")
+	emit("runtime.oommsg:\n")
+	emit("  .ascii \"fatal error: runtime: out of memory\\n\"\n")
+	emit(".text\n")
+
+	gcinit()
+
+	emit("runtime.alloc:\n")
+	// the registers are saved on the stack, which the collector scans for the pointers they hold
+	for _, reg := range gcSavedRegs {
+		emit("  pushq %%%s\n", reg)
+	}
+	emit("  movq %d(%%rsp), %%rbx\n", 8+8*len(gcSavedRegs))
+	emit("  addq $31, %%rbx\n") // the header and the size aligned to 16 bytes
+	emit("  andq $-16, %%rbx\n")
+	emit("  cmpq $32, %%rbx\n") // a block holds a link at least
+	emit("  jae runtime.alloc.collect\n")
+	emit("  movq $32, %%rbx\n")
+	emit("runtime.alloc.collect:\n")
+	emit("  movq runtime.heapLive(%%rip), %%rax\n")
+	emit("  addq %%rbx, %%rax\n")
+	emit("  cmpq runtime.nextGC(%%rip), %%rax\n")
+	emit("  jbe runtime.alloc.find\n")
+	emit("  callq runtime.gc\n")
+	// the first block in the free list large enough. rdi has the address of the link to it
+	emit("runtime.alloc.find:\n")
+	emit("  leaq runtime.freeList(%%rip), %%rdi\n")
+	emit("runtime.alloc.next:\n")
+	emit("  movq (%%rdi), %%rax\n")
+	emit("  testq %%rax, %%rax\n")
+	emit("  je runtime.alloc.bump\n")
+	emit("  movq (%%rax), %%rcx\n")
+	emit("  andq $-16, %%rcx\n")
+	emit("  cmpq %%rbx, %%rcx\n")
+	emit("  jae runtime.alloc.found\n")
+	emit("  leaq 8(%%rax), %%rdi\n")
+	emit("  jmp runtime.alloc.next\n")
+	emit("runtime.alloc.found:\n")
+	emit("  movq 8(%%rax), %%rdx\n")
+	emit("  movq %%rcx, %%rsi\n")
+	emit("  subq %%rbx, %%rsi\n")
+	emit("  cmpq $32, %%rsi\n")
+	emit("  jb runtime.alloc.whole\n")
+	// the rest of the block takes its place in the free list
+	emit("  leaq (%%rax,%%rbx), %%r8\n")
+	emit("  movq %%rsi, %%r9\n")
+	emit("  orq $%d, %%r9\n", blockFree)
+	emit("  movq %%r9, (%%r8)\n")
+	emit("  movq %%rdx, 8(%%r8)\n")
+	emit("  movq %%r8, (%%rdi)\n")
+	emitBlockBit("bts", "r8")
+	emit("  movq %%rbx, %%rcx\n")
+	emit("  jmp runtime.alloc.zero\n")
+	emit("runtime.alloc.whole:\n")
+	emit("  movq %%rdx, (%%rdi)\n")
+	emit("runtime.alloc.zero:\n") // reused memory is cleared
+	emit("  movq %%rcx, (%%rax)\n")
+	emit("  addq %%rcx, runtime.heapLive(%%rip)\n")
+	emit("  leaq 16(%%rax), %%rdi\n")
+	emit("  subq $16, %%rcx\n")
+	emit("  movq %%rax, %%rdx\n")
+	emit("  xorl %%eax, %%eax\n")
+	emit("  rep stosb\n")
+	emit("  movq %%rdx, %%rax\n")
+	emit("  jmp runtime.alloc.done\n")
+	// a new block at the top of the heap. the reservation is zero
+	emit("runtime.alloc.bump:\n")
+	emit("  movq runtime.heapTop(%%rip), %%rax\n")
+	emit("  leaq (%%rax,%%rbx), %%rcx\n")
+	emit("  cmpq runtime.heapLimit(%%rip), %%rcx\n")
+	emit("  ja runtime.outOfMemory\n")
+	emit("  movq %%rcx, runtime.heapTop(%%rip)\n")
+	emit("  movq %%rbx, (%%rax)\n")
+	emit("  addq %%rbx, runtime.heapLive(%%rip)\n")
+	emitBlockBit("bts", "rax")
+	emit("runtime.alloc.done:\n")
+	emit("  addq $16, %%rax\n")
+	for i := len(gcSavedRegs) - 1; i >= 0; i-- {
+		emit("  popq %%%s\n", gcSavedRegs[i])
+	}
+	emit("  ret\n\n")
+
+	emit("runtime.outOfMemory:\n")
+	emit("  leaq runtime.oommsg(%%rip), %%rsi\n")
+	emit("  movq $36, %%rdx\n")
+	emit("  jmp runtime.panicmsg\n\n")
+
+	collector()
+}
+
+// gcSavedRegs are the registers runtime.alloc saves, all but rax and rsp
+var gcSavedRegs = []string{"rbx", "rcx", "rdx", "rsi", "rdi", "rbp", "r8", "r9", "r10", "r11", "r12", "r13", "r14", "r15"}
+
+// emitBlockBit sets (bts) or clears (btr) the bit of the block at reg in the bitmap. it clobbers r10 and r11
+func emitBlockBit(op, reg string) {
+	emit("  movq %%%s, %%r10\n", reg)
+	emit("  subq runtime.heapStart(%%rip), %%r10\n")
+	emit("  shrq $4, %%r10\n")
+	emit("  movq runtime.bitmap(%%rip), %%r11\n")
+	emit("  %sq %%r10, (%%r11)\n", op)
+}
+
+// gcinit reserves the heap, the bitmap and the mark stack with mmap, and reads GOGC from the environment.
+// GOGC=off disables the collector, and GOGC=n collects when the heap in use grows by n% since the last collection
+func gcinit() {
+	heapMax := curTarget.heapMax
+	emit("runtime.gcinit:\n")
+	emit("  movq $0, %%rdi\n")
+	emit("  movq $%d, %%rsi\n", heapMax+heapMax/128+heapMax/4)
+	emit("  movq $3, %%rdx\n")      // PROT_READ|PROT_WRITE
+	emit("  movq $0x4022, %%r10\n") // MAP_PRIVATE|MAP_ANONYMOUS|MAP_NORESERVE
+	emit("  movq $-1, %%r8\n")
+	emit("  movq $0, %%r9\n")
+	emit("  movq $9, %%rax\n")
+	emit("  syscall\n")
+	emit("  cmpq $-4096, %%rax\n") // -errno
+	emit("  ja runtime.outOfMemory\n")
+	emit("  movq %%rax, runtime.heapStart(%%rip)\n")
+	emit("  movq %%rax, runtime.heapTop(%%rip)\n")
+	emit("  movq $%d, %%rcx\n", heapMax)
+	emit("  addq %%rcx, %%rax\n")
+	emit("  movq %%rax, runtime.heapLimit(%%rip)\n")
+	emit("  movq %%rax, runtime.bitmap(%%rip)\n")
+	emit("  addq $%d, %%rax\n", heapMax/128)
+	emit("  movq %%rax, runtime.markStack(%%rip)\n")
+
+	emit("  movq runtime.envp(%%rip), %%rsi\n")
+	emit("runtime.gcinit.env:\n")
+	emit("  movq (%%rsi), %%rdi\n")
+	emit("  testq %%rdi, %%rdi\n")
+	emit("  je runtime.gcinit.goal\n")
+	emit("  addq $8, %%rsi\n")
+	emit("  cmpl $0x43474f47, (%%rdi)\n") // GOGC
+	emit("  jne runtime.gcinit.env\n")
+	emit("  cmpb $0x3d, 4(%%rdi)\n") // =
+	emit("  jne runtime.gcinit.env\n")
+	emit("  cmpl $0x0066666f, 5(%%rdi)\n") // off
+	emit("  jne runtime.gcinit.percent\n")
+	emit("  movq $-1, runtime.gcPercent(%%rip)\n")
+	emit("  jmp runtime.gcinit.goal\n")
+	emit("runtime.gcinit.percent:\n") // the value is ignored unless it is a number
+	emit("  movzbq 5(%%rdi), %%rcx\n")
+	emit("  subq $0x30, %%rcx\n") // 0
+	emit("  cmpq $9, %%rcx\n")
+	emit("  ja runtime.gcinit.goal\n")
+	emit("  addq $5, %%rdi\n")
+	emit("  xorq %%rax, %%rax\n")
+	emit("runtime.gcinit.digit:\n")
+	emit("  movzbq (%%rdi), %%rcx\n")
+	emit("  subq $0x30, %%rcx\n")
+	emit("  cmpq $9, %%rcx\n")
+	emit("  ja runtime.gcinit.set\n")
+	emit("  imulq $10, %%rax\n")
+	emit("  addq %%rcx, %%rax\n")
+	emit("  incq %%rdi\n")
+	emit("  jmp runtime.gcinit.digit\n")
+	emit("runtime.gcinit.set:\n")
+	emit("  movq %%rax, runtime.gcPercent(%%rip)\n")
+	emit("runtime.gcinit.goal:\n")
+	emit("  movq $0, %%rax\n")
+	emit("  jmp runtime.gcgoal\n\n")
+
+	// gcgoal sets the goal of the next collection from the heap in use rax, live + max(live, heapMinimum) * GOGC / 100
+	emit("runtime.gcgoal:\n")
+	emit("  movq runtime.gcPercent(%%rip), %%rcx\n")
+	emit("  testq %%rcx, %%rcx\n")
+	emit("  js runtime.gcgoal.off\n")
+	emit("  pushq %%rax\n")
+	emit("  cmpq $%d, %%rax\n", heapMinimum)
+	emit("  jae runtime.gcgoal.grow\n")
+	emit("  movq $%d, %%rax\n", heapMinimum)
+	emit("runtime.gcgoal.grow:\n")
+	emit("  imulq %%rcx, %%rax\n")
+	emit("  xorq %%rdx, %%rdx\n")
+	emit("  movq $100, %%rcx\n")
+	emit("  divq %%rcx\n")
+	emit("  popq %%rcx\n")
+	emit("  addq %%rcx, %%rax\n")
+	emit("  movq %%rax, runtime.nextGC(%%rip)\n")
+	emit("  ret\n")
+	emit("runtime.gcgoal.off:\n")
+	emit("  movq $-1, runtime.nextGC(%%rip)\n")
+	emit("  ret\n\n")
+}
+
+// collector emits runtime.gc, which marks the blocks reachable from the roots and sweeps the others to the free list.
+// the stack is scanned conservatively: every word between rsp and the stack at the entry, which has the frames chained by rbp
+// and the values pushed while evaluating expressions, keeps the block it points into
+func collector() {
+	emit("runtime.gc:\n")
+	emit("  movq runtime.markStack(%%rip), %%r12\n") // r12 is the top of the mark stack, which has the blocks to scan
+	emit("  movq %%rsp, %%rsi\n")
+	emit("  movq runtime.stackTop(%%rip), %%rdi\n")
+	emit("  callq runtime.gc.scan\n")
+	emit("  leaq runtime.gcRoots(%%rip), %%r14\n")
+	emit("runtime.gc.globals:\n")
+	emit("  leaq runtime.gcRootsEnd(%%rip), %%rax\n")
+	emit("  cmpq %%rax, %%r14\n")
+	emit("  jae runtime.gc.drain\n")
+	emit("  movq (%%r14), %%rsi\n")
+	emit("  movq 8(%%r14), %%rdi\n")
+	emit("  addq %%rsi, %%rdi\n")
+	emit("  callq runtime.gc.scan\n")
+	emit("  addq $16, %%r14\n")
+	emit("  jmp runtime.gc.globals\n")
+	emit("runtime.gc.drain:\n")
+	emit("  cmpq runtime.markStack(%%rip), %%r12\n")
+	emit("  je runtime.gc.sweep\n")
+	emit("  subq $8, %%r12\n")
+	emit("  movq (%%r12), %%rsi\n")
+	emit("  movq (%%rsi), %%rdi\n")
+	emit("  andq $-16, %%rdi\n")
+	emit("  addq %%rsi, %%rdi\n")
+	emit("  addq $16, %%rsi\n")
+	emit("  callq runtime.gc.scan\n")
+	emit("  jmp runtime.gc.drain\n")
+
+	// the sweep walks the blocks in order. consecutive unmarked blocks are joined into a free block (r10),
+	// and the free block at the top of the heap is returned to the top
+	emit("runtime.gc.sweep:\n")
+	emit("  movq runtime.heapStart(%%rip), %%rsi\n")
+	emit("  leaq runtime.freeList(%%rip), %%r9\n") // the link to the next free block
+	emit("  xorq %%r8, %%r8\n")                    // the size of the marked blocks
+	emit("  xorq %%r13, %%r13\n")                  // the first block of the free run, 0 if none
+	emit("runtime.gc.block:\n")
+	emit("  cmpq runtime.heapTop(%%rip), %%rsi\n")
+	emit("  jae runtime.gc.top\n")
+	emit("  movq (%%rsi), %%rax\n")
+	emit("  movq %%rax, %%rcx\n")
+	emit("  andq $-16, %%rcx\n")
+	emit("  testq $%d, %%rax\n", blockMarked)
+	emit("  je runtime.gc.dead\n")
+	emit("  movq %%rcx, (%%rsi)\n")
+	emit("  addq %%rcx, %%r8\n")
+	emit("  testq %%r13, %%r13\n")
+	emit("  je runtime.gc.next\n")
+	emit("  movq %%rsi, %%rax\n") // the free run ends here
+	emit("  subq %%r13, %%rax\n")
+	emit("  orq $%d, %%rax\n", blockFree)
+	emit("  movq %%rax, (%%r13)\n")
+	emit("  movq %%r13, (%%r9)\n")
+	emit("  leaq 8(%%r13), %%r9\n")
+	emit("  xorq %%r13, %%r13\n")
+	emit("  jmp runtime.gc.next\n")
+	emit("runtime.gc.dead:\n")
+	emit("  testq %%r13, %%r13\n")
+	emit("  jne runtime.gc.join\n")
+	emit("  movq %%rsi, %%r13\n")
+	emit("  jmp runtime.gc.next\n")
+	emit("runtime.gc.join:\n")
+	emitBlockBit("btr", "rsi")
+	emit("runtime.gc.next:\n")
+	emit("  addq %%rcx, %%rsi\n")
+	emit("  jmp runtime.gc.block\n")
+	emit("runtime.gc.top:\n")
+	emit("  testq %%r13, %%r13\n")
+	emit("  je runtime.gc.end\n")
+	emit("  movq %%r13, runtime.heapTop(%%rip)\n")
+	emitBlockBit("btr", "r13")
+	emit("runtime.gc.end:\n")
+	emit("  movq $0, (%%r9)\n")
+	emit("  movq %%r8, runtime.heapLive(%%rip)\n")
+	emit("  movq %%r8, %%rax\n")
+	emit("  jmp runtime.gcgoal\n\n")
+
+	// scan marks the blocks pointed by the words from rsi to rdi, and pushes them to the mark stack.
+	// the block of an address is the last block starting at or before it in the bitmap
+	emit("runtime.gc.scan:\n")
+	emit("  cmpq %%rdi, %%rsi\n")
+	emit("  jae runtime.gc.scan.end\n")
+	emit("  movq (%%rsi), %%rax\n")
+	emit("  addq $8, %%rsi\n")
+	emit("  cmpq runtime.heapStart(%%rip), %%rax\n")
+	emit("  jb runtime.gc.scan\n")
+	emit("  cmpq runtime.heapTop(%%rip), %%rax\n")
+	emit("  jae runtime.gc.scan\n")
+	emit("  subq runtime.heapStart(%%rip), %%rax\n")
+	emit("  shrq $4, %%rax\n")
+	emit("  movq %%rax, %%r8\n")
+	emit("  shrq $6, %%r8\n") // the word of the bitmap
+	emit("  movl %%eax, %%ecx\n")
+	emit("  andl $63, %%ecx\n")
+	emit("  movq $2, %%r9\n") // the bits at or before the address
+	emit("  shlq %%cl, %%r9\n")
+	emit("  decq %%r9\n")
+	emit("  movq runtime.bitmap(%%rip), %%rdx\n")
+	emit("  andq (%%rdx,%%r8,8), %%r9\n")
+	emit("runtime.gc.scan.find:\n")
+	emit("  jne runtime.gc.scan.block\n")
+	emit("  decq %%r8\n")
+	emit("  movq (%%rdx,%%r8,8), %%r9\n")
+	emit("  testq %%r9, %%r9\n")
+	emit("  jmp runtime.gc.scan.find\n")
+	emit("runtime.gc.scan.block:\n")
+	emit("  bsrq %%r9, %%r9\n")
+	emit("  shlq $6, %%r8\n")
+	emit("  addq %%r9, %%r8\n")
+	emit("  shlq $4, %%r8\n")
+	emit("  addq runtime.heapStart(%%rip), %%r8\n")
+	emit("  testq $%d, (%%r8)\n", blockMarked|blockFree)
+	emit("  jne runtime.gc.scan\n")
+	emit("  orq $%d, (%%r8)\n", blockMarked)
+	emit("  movq %%r8, (%%r12)\n")
+	emit("  addq $8, %%r12\n")
+	emit("  jmp runtime.gc.scan\n")
+	emit("runtime.gc.scan.end:\n")
+	emit("  ret\n\n")
+}
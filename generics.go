@@ -0,0 +1,368 @@
+package main
+
+import (
+	"fmt"
+	"go/ast"
+	"go/token"
+	"go/types"
+	"strings"
+)
+
+// generic functions and types are compiled by monomorphization.
+// every instantiation gets its own Func (or type object) whose type parameters are substituted by curTypeArgs while walking and emitting it.
+
+type typeInstance struct {
+	generic  *ast.Object                 // the generic type declaration. e.g. Pair
+	args     []*ast.Object               // type arguments in order of the type parameters
+	typeArgs map[*ast.Object]*ast.Object // type parameter -> type argument
+}
+
+var (
+	globalAny = &ast.Object{
+		Kind: ast.Typ,
+		Name: "any",
+		Decl: nil,
+		Data: nil,
+		Type: nil,
+	}
+
+	globalComparable = &ast.Object{
+		Kind: ast.Typ,
+		Name: "comparable",
+		Decl: nil,
+		Data: nil,
+		Type: nil,
+	}
+
+	// curTypeArgs substitutes the type parameters of the instance being walked or emitted
+	curTypeArgs map[*ast.Object]*ast.Object
+
+	instances     = map[string]*Func{}       // instances of generic functions by symbol. e.g. main.Max[int]
+	typeInstances = map[string]*ast.Object{} // instances of generic types by name. e.g. main.Pair[int,string]
+)
+
+func withTypeArgs(typeArgs map[*ast.Object]*ast.Object, fn func()) {
+	outer := curTypeArgs
+	curTypeArgs = typeArgs
+	defer func() { curTypeArgs = outer }()
+	fn()
+}
+
+// instanceTypeArgs returns the substitution of a generic type instance, or nil for other types
+func instanceTypeArgs(typ *ast.Object) map[*ast.Object]*ast.Object {
+	inst, ok := typ.Data.(*typeInstance)
+	if !ok {
+		return nil
+	}
+	return inst.typeArgs
+}
+
+// genericFuncDecl returns the declaration of the generic function called by expr, or nil
+func genericFuncDecl(expr *ast.CallExpr) *ast.FuncDecl {
+	ident := funcIdent(expr.Fun)
+	if ident == nil || ident.Obj == nil {
+		return nil
+	}
+	decl, ok := ident.Obj.Decl.(*ast.FuncDecl)
+	if !ok || decl.Type.TypeParams == nil {
+		return nil
+	}
+	return decl
+}
+
+// instantiateFunc returns the instance of the generic function called by expr.
+// a new instance is walked immediately so that instances it calls are created too.
+func instantiateFunc(expr *ast.CallExpr) *Func {
+	decl := genericFuncDecl(expr)
+	args := inferTypeArgs(expr, decl)
+	pkg := pkgOf(decl.Name.Obj)
+	name := instanceName(decl.Name.Name, args)
+	if fnc, ok := instances[funcSymbol(pkg.path, name)]; ok {
+		return fnc
+	}
+
+	fnc := &Func{
+		decl:     decl,
+		pkg:      pkg,
+		name:     name,
+		typeArgs: bindTypeParams(decl.Type.TypeParams, args, name),
+	}
+	instances[funcSymbol(pkg.path, name)] = fnc
+	funcWalk(fnc)
+	funcs = append(funcs, fnc)
+	return fnc
+}
+
+// instantiateType returns the instance of a generic type like Pair[int, string]
+func instantiateType(expr ast.Expr) *ast.Object {
+	var x ast.Expr
+	var indices []ast.Expr
+	switch e := expr.(type) {
+	case *ast.IndexExpr:
+		x, indices = e.X, []ast.Expr{e.Index}
+	case *ast.IndexListExpr:
+		x, indices = e.X, e.Indices
+	}
+	generic := getType(x)
+	spec, ok := generic.Decl.(*ast.TypeSpec)
+	if !ok || spec.TypeParams == nil {
+		must(fmt.Errorf("%s is not a generic type", generic.Name))
+	}
+
+	var args []*ast.Object
+	for _, index := range indices {
+		args = append(args, getType(index))
+	}
+	name := instanceName(typeString(generic), args)
+	if typ, ok := typeInstances[name]; ok {
+		return typ
+	}
+
+	typ := &ast.Object{
+		Kind: ast.Typ,
+		Name: name,
+		Decl: spec,
+		Data: &typeInstance{
+			generic:  generic,
+			args:     args,
+			typeArgs: bindTypeParams(spec.TypeParams, args, name),
+		},
+	}
+	typeInstances[name] = typ
+	return typ
+}
+
+// instanceName returns the name of an instance. e.g. Max[int], main.Pair[int,main.MyInt]
+func instanceName(name string, args []*ast.Object) string {
+	var names []string
+	for _, arg := range args {
+		names = append(names, typeString(arg))
+	}
+	return fmt.Sprintf("%s[%s]", name, strings.Join(names, ","))
+}
+
+func typeParams(list *ast.FieldList) []*ast.Object {
+	var params []*ast.Object
+	for _, field := range list.List {
+		for _, name := range field.Names {
+			params = append(params, name.Obj)
+		}
+	}
+	return params
+}
+
+// bindTypeParams maps the type parameters to the type arguments and checks that every argument satisfies its constraint
+func bindTypeParams(list *ast.FieldList, args []*ast.Object, name string) map[*ast.Object]*ast.Object {
+	params := typeParams(list)
+	if len(params) != len(args) {
+		must(fmt.Errorf("%s: got %d type arguments but %d type parameters", name, len(args), len(params)))
+	}
+	typeArgs := make(map[*ast.Object]*ast.Object)
+	for i, param := range params {
+		typeArgs[param] = args[i]
+	}
+
+	// constraints can refer to the type parameters. e.g. [S ~[]E, E any]
+	withTypeArgs(typeArgs, func() {
+		for i, param := range params {
+			constraint := param.Decl.(*ast.Field).Type
+			if !satisfies(args[i], constraint) {
+				must(fmt.Errorf("%s: %s does not satisfy %s", name, args[i].Name, exprString(constraint)))
+			}
+		}
+	})
+	return typeArgs
+}
+
+// inferTypeArgs returns the type arguments of a generic function call.
+// explicit type arguments f[int](x) come first, the rest are inferred from the types of the arguments
+func inferTypeArgs(expr *ast.CallExpr, decl *ast.FuncDecl) []*ast.Object {
+	params := typeParams(decl.Type.TypeParams)
+	bound := make(map[*ast.Object]*ast.Object)
+
+	var explicit []ast.Expr
+	switch fn := expr.Fun.(type) {
+	case *ast.IndexExpr:
+		explicit = []ast.Expr{fn.Index}
+	case *ast.IndexListExpr:
+		explicit = fn.Indices
+	}
+	if len(explicit) > len(params) {
+		must(fmt.Errorf("%s: got %d type arguments but %d type parameters", decl.Name.Name, len(explicit), len(params)))
+	}
+	for i, arg := range explicit {
+		bound[params[i]] = getType(arg)
+	}
+
+	// typed arguments are unified first so that untyped constants get the inferred type. e.g. Max(x, 1) where x is MyInt
+	types := paramTypes(decl)
+	var untyped []int
+	for i, paramType := range types {
+		if isUntypedConst(expr.Args[i]) {
+			untyped = append(untyped, i)
+			continue
+		}
+		unify(paramType, getType(expr.Args[i]), bound)
+	}
+	for _, i := range untyped {
+		if ident, ok := types[i].(*ast.Ident); ok && isTypeParam(ident.Obj) && bound[ident.Obj] == nil {
+			bound[ident.Obj] = getType(expr.Args[i]) // default type of the constant
+		}
+	}
+
+	var args []*ast.Object
+	for _, param := range params {
+		if bound[param] == nil {
+			must(fmt.Errorf("%s: cannot infer %s", decl.Name.Name, param.Name))
+		}
+		args = append(args, bound[param])
+	}
+	return args
+}
+
+// paramTypes returns the type expression of each parameter of decl
+func paramTypes(decl *ast.FuncDecl) []ast.Expr {
+	var types []ast.Expr
+	for _, field := range decl.Type.Params.List {
+		types = append(types, field.Type)
+		for i := 1; i < len(field.Names); i++ {
+			types = append(types, field.Type)
+		}
+	}
+	return types
+}
+
+// unify binds the type parameters in paramType so that it is identical to argType
+func unify(paramType ast.Expr, argType *ast.Object, bound map[*ast.Object]*ast.Object) {
+	switch p := paramType.(type) {
+	case *ast.Ident:
+		if !isTypeParam(p.Obj) {
+			return
+		}
+		if typ, ok := bound[p.Obj]; ok && typ != argType {
+			must(fmt.Errorf("type %s of argument does not match inferred type %s for %s", argType.Name, typ.Name, p.Name))
+		}
+		bound[p.Obj] = argType
+	case *ast.StarExpr:
+		if isPointer(underlying(argType)) {
+			unify(p.X, elemType(underlying(argType)), bound)
+		}
+	case *ast.ArrayType:
+		if p.Len == nil && isSlice(underlying(argType)) {
+			unify(p.Elt, sliceElem(underlying(argType)), bound)
+		}
+	case *ast.FuncType: // func values, which the type would be inferred from, are not supported
+		must(fmt.Errorf("%s: function types are not supported: %s", fileSet.Position(p.Pos()), exprString(p)))
+	case *ast.IndexExpr, *ast.IndexListExpr:
+		// Pair[K, V] is unified with the type arguments of an instance of Pair
+		inst, ok := argType.Data.(*typeInstance)
+		if !ok {
+			return
+		}
+		var indices []ast.Expr
+		if e, ok := p.(*ast.IndexExpr); ok {
+			indices = []ast.Expr{e.Index}
+		} else {
+			indices = p.(*ast.IndexListExpr).Indices
+		}
+		for i, index := range indices {
+			unify(index, inst.args[i], bound)
+		}
+	}
+}
+
+func isTypeParam(obj *ast.Object) bool {
+	if obj == nil || obj.Kind != ast.Typ {
+		return false
+	}
+	_, ok := obj.Decl.(*ast.Field)
+	return ok
+}
+
+func isUntypedConst(expr ast.Expr) bool {
+	switch e := expr.(type) {
+	case *ast.BasicLit:
+		return true
+	case *ast.Ident: // const x = 1
+		if e.Obj == nil || e.Obj.Kind != ast.Con {
+			return false
+		}
+		spec, ok := e.Obj.Decl.(*ast.ValueSpec)
+		return ok && spec.Type == nil
+	case *ast.ParenExpr:
+		return isUntypedConst(e.X)
+	case *ast.UnaryExpr:
+		return isUntypedConst(e.X)
+	case *ast.BinaryExpr:
+		return isUntypedConst(e.X) && isUntypedConst(e.Y)
+	}
+	return false
+}
+
+// satisfies reports whether typ is in the type set of constraint.
+// constraint is any, comparable, an interface of type elements or a union like ~int | ~string
+func satisfies(typ *ast.Object, constraint ast.Expr) bool {
+	switch c := constraint.(type) {
+	case *ast.Ident:
+		switch c.Obj {
+		case globalAny:
+			return true
+		case globalComparable:
+			return isComparable(typ)
+		}
+		if spec, ok := c.Obj.Decl.(*ast.TypeSpec); ok {
+			if it, ok := spec.Type.(*ast.InterfaceType); ok {
+				return satisfiesInterface(typ, it)
+			}
+		}
+		return typ == getType(c)
+	case *ast.InterfaceType:
+		return satisfiesInterface(typ, c)
+	case *ast.ParenExpr:
+		return satisfies(typ, c.X)
+	case *ast.BinaryExpr: // union
+		if c.Op != token.OR {
+			must(fmt.Errorf("unexpected constraint operator %s", c.Op))
+		}
+		return satisfies(typ, c.X) || satisfies(typ, c.Y)
+	case *ast.UnaryExpr: // ~T
+		if c.Op != token.TILDE {
+			must(fmt.Errorf("unexpected constraint operator %s", c.Op))
+		}
+		return underlying(typ) == underlying(getType(c.X))
+	case *ast.IndexExpr, *ast.IndexListExpr:
+		return typ == getType(c)
+	}
+	must(fmt.Errorf("unexpected constraint %T", constraint))
+	return false
+}
+
+// satisfiesInterface reports whether typ satisfies every element of a constraint interface
+func satisfiesInterface(typ *ast.Object, it *ast.InterfaceType) bool {
+	for _, elem := range it.Methods.List {
+		if len(elem.Names) > 0 {
+			must(fmt.Errorf("methods in constraint interfaces are not supported: %s", elem.Names[0].Name))
+		}
+		if !satisfies(typ, elem.Type) {
+			return false
+		}
+	}
+	return true
+}
+
+// exprString formats a type or constraint expression for error messages
+func exprString(expr ast.Expr) string {
+	switch e := expr.(type) {
+	case *ast.Ident:
+		return e.Name
+	case *ast.ParenExpr:
+		return "(" + exprString(e.X) + ")"
+	case *ast.BinaryExpr:
+		return exprString(e.X) + " " + e.Op.String() + " " + exprString(e.Y)
+	case *ast.UnaryExpr:
+		return e.Op.String() + exprString(e.X)
+	case *ast.InterfaceType:
+		return "interface{...}"
+	}
+	return types.ExprString(expr)
+}
@@ -0,0 +1,3 @@
+module github.com/lkeix/gompiler
+
+go 1.18
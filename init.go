@@ -0,0 +1,164 @@
+package main
+
+import (
+	"fmt"
+	"go/ast"
+	"strings"
+)
+
+// varInit is package level variables initialized by an expression at run time
+type varInit struct {
+	names []*ast.Ident // nil for var _ = x, and several for var a, b = f()
+	value ast.Expr
+	deps  map[*ast.Object]bool // package level variables value refers to directly or through functions
+}
+
+// declInitFunc registers func init(). a package can have several of them, so they are named like main.init.0
+func declInitFunc(decl *ast.FuncDecl) {
+	if decl.Type.Params.NumFields() > 0 || decl.Type.Results != nil {
+		must(fmt.Errorf("func init must have no arguments and no return values"))
+	}
+	fnc := &Func{
+		decl: decl,
+		pkg:  curPkg,
+		name: fmt.Sprintf("init.%d", len(curPkg.initFuncs)),
+	}
+	funcWalk(fnc)
+	funcs = append(funcs, fnc)
+	curPkg.initFuncs = append(curPkg.initFuncs, fnc)
+}
+
+// initOrder sorts the variable initializers of pkg like the Go spec:
+// the earliest variable in declaration order that doesn't depend on uninitialized variables is initialized next
+func initOrder(pkg *Package) []*varInit {
+	pending := make(map[*ast.Object]bool)
+	for _, vi := range pkg.varInits {
+		vi.deps = make(map[*ast.Object]bool)
+		collectDeps(vi.value, pkg, vi.deps, make(map[*ast.Object]bool))
+		for _, name := range vi.names {
+			pending[name.Obj] = true
+		}
+	}
+
+	var ordered []*varInit
+	rest := pkg.varInits
+	for len(rest) > 0 {
+		next := -1
+		for i, vi := range rest {
+			if isReady(vi, pending) {
+				next = i
+				break
+			}
+		}
+		if next < 0 {
+			var names []string
+			for _, vi := range rest {
+				for _, name := range vi.names {
+					names = append(names, name.Name)
+				}
+			}
+			must(fmt.Errorf("initialization cycle: %s", strings.Join(names, ", ")))
+		}
+		vi := rest[next]
+		for _, name := range vi.names {
+			delete(pending, name.Obj)
+		}
+		ordered = append(ordered, vi)
+		rest = append(rest[:next:next], rest[next+1:]...)
+	}
+	return ordered
+}
+
+func isReady(vi *varInit, pending map[*ast.Object]bool) bool {
+	for dep := range vi.deps {
+		if pending[dep] {
+			return false
+		}
+	}
+	return true
+}
+
+// collectDeps adds the package level variables of pkg referred by node to deps.
+// the bodies of the functions node refers to are followed, visited prevents walking a function twice
+func collectDeps(node ast.Node, pkg *Package, deps map[*ast.Object]bool, visited map[*ast.Object]bool) {
+	ast.Inspect(node, func(n ast.Node) bool {
+		ident, ok := n.(*ast.Ident)
+		if !ok || ident.Obj == nil || objPkgs[ident.Obj] != pkg {
+			return true
+		}
+		switch ident.Obj.Kind {
+		case ast.Var:
+			deps[ident.Obj] = true
+		case ast.Fun:
+			if decl, ok := ident.Obj.Decl.(*ast.FuncDecl); ok && decl.Body != nil && !visited[ident.Obj] {
+				visited[ident.Obj] = true
+				collectDeps(decl.Body, pkg, deps, visited)
+			}
+		}
+		return true
+	})
+}
+
+// emitPackageInit emits pkg.init, which initializes the imported packages, the package level variables
+// and calls func init() in this order. every package is initialized once
+func emitPackageInit(pkg *Package) {
+	curPkg = pkg
+	initName := symbol(pkg.path + ".init")
+	done := symbol(pkg.path + ".initdone")
+	emit("# %s initialization\n", pkg.path)
+	emit(".data\n")
+	emit("%s:\n", done)
+	emit("  .quad 0\n")
+	if useIR {
+		fnc := &Func{
+			decl: &ast.FuncDecl{Name: ast.NewIdent("init"), Type: &ast.FuncType{Params: &ast.FieldList{}}, Body: &ast.BlockStmt{}},
+			pkg:  pkg,
+			name: "init",
+		}
+		emitIRFunc(fnc, lowerPackageInit(pkg, fnc))
+		return
+	}
+	emit(".text\n")
+	emit("%s:\n", initName)
+	debugProcStart()
+	emit("  cmpq $0, %s(%%rip)\n", done)
+	emit("  je %s\n", symbol(pkg.path+".init.start"))
+	emit("  ret\n")
+	emit("%s:\n", symbol(pkg.path+".init.start"))
+	emit("  movq $1, %s(%%rip)\n", done)
+	emitPrologue()
+	for _, imported := range pkg.imports {
+		emit("  callq %s\n", symbol(imported.path+".init"))
+	}
+	for _, vi := range pkg.varInits {
+		value := vi.value
+		switch len(vi.names) {
+		case 0:
+			emitExpr(value)
+			emit("  addq $%d, %%rsp # discard\n", getExprSize(&value))
+		case 1:
+			name := vi.names[0]
+			emitExprAs(value, varType(name.Obj))
+			emit("  # %s = %s\n", name.Name, exprString(value))
+			emitVariableAddr(name.Obj)
+			emitStore(sizeOf(varType(name.Obj)), name.Name)
+		default: // the results are pushed with the first one on the top
+			emitExpr(value)
+			for i, typ := range tupleTypes(getType(value)) {
+				name := vi.names[i]
+				if name.Name == "_" {
+					emit("  addq $%d, %%rsp # discard\n", sizeOf(typ))
+					continue
+				}
+				emitVariableAddr(name.Obj)
+				emitStore(sizeOf(typ), name.Name)
+			}
+		}
+	}
+	for _, fnc := range pkg.initFuncs {
+		emit("  callq %s\n", funcSymbol(pkg.path, fnc.name))
+	}
+	emitEpilogue()
+	debugProcEnd()
+	emit("\n")
+}
@@ -0,0 +1,83 @@
+package main
+
+import (
+	"fmt"
+	"go/ast"
+	"go/token"
+	"os"
+	"strings"
+
+	"github.com/lkeix/gompiler/ir"
+)
+
+// inlineBudget is the largest cost of a function inlined at its calls
+const inlineBudget = 20
+
+// optReport prints the decisions of the inliner and the tail calls optimized in the main package to stderr like go build -gcflags=-m
+var optReport bool
+
+// inlinable has the leaf functions lowered to the IR within the budget by their symbol
+var inlinable = map[string]inlinee{}
+
+type inlinee struct {
+	fnc *Func
+	f   *ir.Func
+}
+
+// inlineFuncs decides which of the lowered functions are inlined and inlines their calls from the other functions
+func inlineFuncs(funcs []*Func, lowered map[*Func]*ir.Func) {
+	for _, fnc := range funcs {
+		f := lowered[fnc]
+		switch {
+		case hasDirective(fnc.decl, "//go:noinline"):
+			reportOpt(fnc.pkg, fnc.decl.Name.Pos(), "cannot inline %s: marked go:noinline", fnc.name)
+		case !f.IsLeaf():
+			reportOpt(fnc.pkg, fnc.decl.Name.Pos(), "cannot inline %s: calls other functions", fnc.name)
+		case f.Cost() > inlineBudget:
+			reportOpt(fnc.pkg, fnc.decl.Name.Pos(), "cannot inline %s: cost %d exceeds budget %d", fnc.name, f.Cost(), inlineBudget)
+		default:
+			reportOpt(fnc.pkg, fnc.decl.Name.Pos(), "can inline %s with cost %d", fnc.name, f.Cost())
+			inlinable[f.Name] = inlinee{fnc, f}
+		}
+	}
+
+	for _, fnc := range funcs {
+		f := lowered[fnc]
+		inlined := false
+		// the inlined blocks make no calls, and the instructions after a call move to a later block
+		for bi := 0; bi < len(f.Blocks); bi++ {
+			b := f.Blocks[bi]
+			for i, instr := range b.Instrs {
+				if callee, ok := inlinable[instr.Sym]; ok && instr.Op == ir.Call {
+					reportOpt(fnc.pkg, instr.Pos, "inlining call to %s", callee.fnc.name)
+					f.InlineCall(b, i, callee.f)
+					inlined = true
+					break
+				}
+			}
+		}
+		if inlined {
+			must(ir.Verify(f))
+		}
+	}
+}
+
+// reportOpt prints a decision of an optimization at pos in pkg if pkg is the main package
+func reportOpt(pkg *Package, pos token.Pos, format string, a ...any) {
+	if optReport && pkg == mainPackage {
+		fmt.Fprintf(os.Stderr, "%s: %s\n", fileSet.Position(pos), fmt.Sprintf(format, a...))
+	}
+}
+
+// hasDirective reports whether the doc comment of decl has the directive like //go:noinline
+func hasDirective(decl *ast.FuncDecl, directive string) bool {
+	if decl.Doc == nil {
+		return false
+	}
+	for _, comment := range decl.Doc.List {
+		if strings.TrimSpace(comment.Text) == directive {
+			return true
+		}
+	}
+	return false
+}
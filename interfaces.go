@@ -0,0 +1,337 @@
+package main
+
+import (
+	"fmt"
+	"go/ast"
+	"go/token"
+	"strconv"
+)
+
+// an interface value is 2 words, the type descriptor and the data. values of a word are stored in the data,
+// larger values are copied to the heap and the data points them. nil has no type descriptor.
+//
+// a type descriptor is
+//
+//	.quad size
+//	.quad kind
+//	.quad name, len(name) // e.g. main.Point
+//	.quad elem            // descriptor of the element of a slice
+//	.quad len(fields), fields // pairs of the descriptor and the offset of struct fields
+//	.quad len(methods), methods // pairs of the method name and the function, see methodTable
+
+const (
+	kindBool    = 1
+	kindInt     = 2
+	kindString  = 3
+	kindPointer = 4
+	kindSlice   = 5
+	kindStruct  = 6
+	kindByte    = 7
+	kindAny     = 8
+)
+
+var (
+	typeDescs    []*ast.Object // types whose descriptors are emitted
+	typeDescSeen = map[*ast.Object]bool{}
+)
+
+// emitBox replaces the value of typ on the top of the stack with an interface value
+func emitBox(typ *ast.Object) {
+	if size := sizeOf(typ); size != 8 {
+		emit("  pushq $%d\n", size)
+		emit("  callq runtime.alloc\n")
+		emit("  addq $8, %%rsp\n")
+		emit("  pushq %%rax\n")
+		emitStore(size, typ.Name)
+		emit("  pushq %%rax # data\n")
+	}
+	emit("  leaq %s(%%rip), %%rax\n", typeDesc(typ))
+	emit("  pushq %%rax # type\n")
+}
+
+// typeDesc returns the symbol of the type descriptor of typ. the descriptor is emitted by emitTypeDescs
+func typeDesc(typ *ast.Object) string {
+	if !typeDescSeen[typ] {
+		typeDescSeen[typ] = true
+		typeDescs = append(typeDescs, typ)
+	}
+	return symbol("type." + typeString(typ))
+}
+
+func typeKind(typ *ast.Object) int {
+	switch u := underlying(typ); {
+	case u == globalBool:
+		return kindBool
+	case u == globalInt:
+		return kindInt
+	case u == globalString:
+		return kindString
+	case u == globalByte:
+		return kindByte
+	case isInterface(u):
+		return kindAny
+	case isPointer(u):
+		return kindPointer
+	case isSlice(u):
+		return kindSlice
+	case isStruct(u):
+		return kindStruct
+	}
+	must(fmt.Errorf("unexpected type %s in interface", typ.Name))
+	return 0
+}
+
+func emitTypeDescs() {
+	emit("# type descriptors\n")
+	emit(".data\n")
+	// the descriptors of elements and fields are added while emitting
+	for i := 0; i < len(typeDescs); i++ {
+		typ := typeDescs[i]
+		name := typeString(typ)
+		elem := "0"
+		if isSlice(underlying(typ)) {
+			elem = typeDesc(sliceElem(typ))
+		}
+		var fields []structField
+		if isStruct(underlying(typ)) {
+			fields = structFields(underlying(typ))
+		}
+		var methods []methodEntry
+		if !isInterface(typ) {
+			methods = methodTable(typ)
+		}
+
+		emit("%s:\n", typeDesc(typ))
+		emit("  .quad %d # size\n", sizeOf(typ))
+		emit("  .quad %d # kind\n", typeKind(typ))
+		emit("  .quad %s\n", symbol("type."+name+".name"))
+		emit("  .quad %d\n", len(name))
+		emit("  .quad %s # elem\n", elem)
+		emit("  .quad %d # fields\n", len(fields))
+		if len(fields) > 0 {
+			emit("  .quad %s\n", symbol("type."+name+".fields"))
+		} else {
+			emit("  .quad 0\n")
+		}
+		emit("  .quad %d # methods\n", len(methods))
+		if len(methods) > 0 {
+			emit("  .quad %s\n", symbol("type."+name+".methods"))
+		} else {
+			emit("  .quad 0\n")
+		}
+		if len(fields) > 0 {
+			emit("%s:\n", symbol("type."+name+".fields"))
+			for _, field := range fields {
+				emit("  .quad %s, %d # %s\n", typeDesc(field.typ), field.offset, field.name)
+			}
+		}
+		if len(methods) > 0 {
+			emit("%s:\n", symbol("type."+name+".methods"))
+			for _, method := range methods {
+				emit("  .quad %s, %s\n", method.label, method.symbol)
+			}
+		}
+		emit("%s:\n", symbol("type."+name+".name"))
+		emit("  .ascii %s\n", gasString(strconv.Quote(name)))
+	}
+	emit("\n")
+
+	emitWrappers()
+
+	// method names are identified by the addresses of their symbols
+	methodLabel(nil, "Error") // runtime.efaceerror
+	emit("# method names\n")
+	emit(".data\n")
+	for _, label := range methodLabelList {
+		emit("%s:\n", label)
+		emit("  .ascii %s\n", gasString(strconv.Quote(methodLabels[label])))
+	}
+	emit("\n")
+}
+
+// emitIfaceCompare pushes the result of x == y or x != y of interface values. a concrete operand is converted to the interface type.
+// values of a word are compared by the data word, strings by their bytes and other values by their memory
+func emitIfaceCompare(expr *ast.BinaryExpr) {
+	typ := getType(expr.X)
+	if !isInterface(typ) {
+		typ = getType(expr.Y)
+	}
+	emitExprAs(expr.X, typ)
+	emitExprAs(expr.Y, typ)
+	emit("  callq runtime.efaceeq\n")
+	emit("  addq $32, %%rsp\n")
+	emit("  cmpq $0, %%rax\n")
+	if expr.Op == token.EQL {
+		emitSetcc(token.NEQ)
+	} else {
+		emitSetcc(token.EQL)
+	}
+}
+
+// efaces emits the runtime routines which take an interface value at 8(rsp) (type) and 16(rsp) (data).
+// the fmt package binds them by go:linkname, compiled code calls efaceeq and findmethod
+func efaces() {
+	emit("# interfaces\n")
+	emit(".text\n")
+	emit("runtime.efacekind:\n")
+	emit("  movq 8(%%rsp), %%rax\n")
+	emit("  testq %%rax, %%rax\n")
+	emit("  je runtime.efacekind.nil\n")
+	emit("  movq 8(%%rax), %%rax\n")
+	emit("runtime.efacekind.nil:\n")
+	emit("  ret\n\n")
+
+	emit("runtime.efacetype:\n")
+	emit("  movq 8(%%rsp), %%rdx\n")
+	emit("  movq 16(%%rdx), %%rax\n")
+	emit("  movq 24(%%rdx), %%rsi\n")
+	emit("  ret\n\n")
+
+	emit("runtime.efaceword:\n")
+	emit("  movq 16(%%rsp), %%rax\n")
+	emit("  ret\n\n")
+
+	emit("runtime.efacestring:\n")
+	emit("  movq 16(%%rsp), %%rdx\n")
+	emit("  movq (%%rdx), %%rax\n")
+	emit("  movq 8(%%rdx), %%rsi\n")
+	emit("  ret\n\n")
+
+	// efacelen returns the length of a slice or the number of fields of a struct
+	emit("runtime.efacelen:\n")
+	emit("  movq 8(%%rsp), %%rdx\n")
+	emit("  movq 40(%%rdx), %%rax\n")
+	emit("  cmpq $%d, 8(%%rdx)\n", kindStruct)
+	emit("  je runtime.efacelen.end\n")
+	emit("  movq 16(%%rsp), %%rax\n")
+	emit("  movq 8(%%rax), %%rax\n")
+	emit("runtime.efacelen.end:\n")
+	emit("  ret\n\n")
+
+	// efaceindex returns the element 24(rsp) of a slice or the field of a struct as an interface value.
+	// values larger than a word refer to the memory of the slice or the struct
+	emit("runtime.efaceindex:\n")
+	emit("  movq 8(%%rsp), %%rdx\n")
+	emit("  movq 24(%%rsp), %%rcx\n")
+	emit("  cmpq $%d, 8(%%rdx)\n", kindStruct)
+	emit("  je runtime.efaceindex.field\n")
+	emit("  movq 16(%%rsp), %%rax\n")
+	emit("  movq (%%rax), %%rax\n") // ptr of the slice
+	emit("  movq 32(%%rdx), %%rdx\n")
+	emit("  cmpq $%d, 8(%%rdx)\n", kindByte)
+	emit("  jne runtime.efaceindex.elem\n")
+	emit("  movzbq (%%rax,%%rcx), %%rsi\n") // bytes are packed
+	emit("  movq %%rdx, %%rax\n")
+	emit("  ret\n")
+	emit("runtime.efaceindex.elem:\n")
+	emit("  imulq (%%rdx), %%rcx\n")
+	emit("  addq %%rcx, %%rax\n")
+	emit("  jmp runtime.efaceindex.load\n")
+	emit("runtime.efaceindex.field:\n")
+	emit("  leaq 16(%%rsp), %%rax\n") // a struct of a word is in the data
+	emit("  cmpq $8, (%%rdx)\n")
+	emit("  je runtime.efaceindex.offset\n")
+	emit("  movq 16(%%rsp), %%rax\n")
+	emit("runtime.efaceindex.offset:\n")
+	emit("  shlq $4, %%rcx\n")
+	emit("  addq 48(%%rdx), %%rcx\n")
+	emit("  addq 8(%%rcx), %%rax\n")
+	emit("  movq (%%rcx), %%rdx\n")
+	emit("runtime.efaceindex.load:\n") // rax is the address of a value of the type rdx
+	emit("  cmpq $%d, 8(%%rdx)\n", kindAny)
+	emit("  je runtime.efaceindex.any\n")
+	emit("  movq %%rax, %%rsi\n")
+	emit("  cmpq $8, (%%rdx)\n")
+	emit("  jne runtime.efaceindex.end\n")
+	emit("  movq (%%rax), %%rsi\n")
+	emit("runtime.efaceindex.end:\n")
+	emit("  movq %%rdx, %%rax\n")
+	emit("  ret\n")
+	emit("runtime.efaceindex.any:\n")
+	emit("  movq 8(%%rax), %%rsi\n")
+	emit("  movq (%%rax), %%rax\n")
+	emit("  ret\n\n")
+
+	// efaceeq compares the interface values at 24(rsp) (left) and 8(rsp) (right) and returns 1 if they are equal
+	emit("runtime.efaceeq:\n")
+	emit("  movq 8(%%rsp), %%rdx\n")
+	emit("  cmpq 24(%%rsp), %%rdx\n")
+	emit("  jne runtime.efaceeq.false\n")
+	emit("  testq %%rdx, %%rdx\n")
+	emit("  je runtime.efaceeq.true\n") // both are nil
+	emit("  movq 16(%%rsp), %%rsi\n")
+	emit("  movq 32(%%rsp), %%rdi\n")
+	emit("  cmpq $8, (%%rdx)\n")
+	emit("  je runtime.efaceeq.word\n")
+	emit("  movq (%%rdx), %%rcx\n")
+	emit("  cmpq $%d, 8(%%rdx)\n", kindString)
+	emit("  jne runtime.efaceeq.memory\n")
+	emit("  movq 8(%%rsi), %%rcx\n")
+	emit("  cmpq 8(%%rdi), %%rcx\n")
+	emit("  jne runtime.efaceeq.false\n")
+	emit("  movq (%%rsi), %%rsi\n")
+	emit("  movq (%%rdi), %%rdi\n")
+	emit("runtime.efaceeq.memory:\n") // compare rcx bytes at rsi and rdi
+	emit("  testq %%rcx, %%rcx\n")
+	emit("  repe cmpsb\n")
+	emit("  jne runtime.efaceeq.false\n")
+	emit("  jmp runtime.efaceeq.true\n")
+	emit("runtime.efaceeq.word:\n")
+	emit("  cmpq %%rsi, %%rdi\n")
+	emit("  jne runtime.efaceeq.false\n")
+	emit("runtime.efaceeq.true:\n")
+	emit("  movq $1, %%rax\n")
+	emit("  ret\n")
+	emit("runtime.efaceeq.false:\n")
+	emit("  movq $0, %%rax\n")
+	emit("  ret\n\n")
+
+	// findmethod returns the method 8(rsp) (name) of the dynamic type of the interface value at 16(rsp) in rax
+	emit("runtime.findmethod:\n")
+	emit("  movq 16(%%rsp), %%rdx\n")
+	emit("  testq %%rdx, %%rdx\n")
+	emit("  je runtime.panicnil\n")
+	emit("  movq 8(%%rsp), %%rax\n")
+	emit("  jmp runtime.lookupmethod\n")
+
+	// lookupmethod returns the method rax (name) of the type descriptor rdx in rax, or 0 if the type doesn't have it
+	emit("runtime.lookupmethod:\n")
+	emit("  testq %%rdx, %%rdx\n")
+	emit("  je runtime.lookupmethod.none\n")
+	emit("  movq 56(%%rdx), %%rcx\n")
+	emit("  movq 64(%%rdx), %%rdx\n")
+	emit("runtime.lookupmethod.loop:\n")
+	emit("  testq %%rcx, %%rcx\n")
+	emit("  je runtime.lookupmethod.none\n")
+	emit("  cmpq (%%rdx), %%rax\n")
+	emit("  je runtime.lookupmethod.found\n")
+	emit("  addq $16, %%rdx\n")
+	emit("  decq %%rcx\n")
+	emit("  jmp runtime.lookupmethod.loop\n")
+	emit("runtime.lookupmethod.found:\n")
+	emit("  movq 8(%%rdx), %%rax\n")
+	emit("  ret\n")
+	emit("runtime.lookupmethod.none:\n")
+	emit("  movq $0, %%rax\n")
+	emit("  ret\n\n")
+
+	// efaceiserror reports whether the dynamic type of the interface value at 8(rsp) has the method Error
+	emit("runtime.efaceiserror:\n")
+	emit("  movq 8(%%rsp), %%rdx\n")
+	emit("  leaq go.name.Error(%%rip), %%rax\n")
+	emit("  callq runtime.lookupmethod\n")
+	emit("  testq %%rax, %%rax\n")
+	emit("  setne %%al\n")
+	emit("  movzbq %%al, %%rax\n")
+	emit("  ret\n\n")
+
+	// efaceerror returns the result of the method Error of the interface value at 8(rsp)
+	emit("runtime.efaceerror:\n")
+	emit("  movq 8(%%rsp), %%rdx\n")
+	emit("  leaq go.name.Error(%%rip), %%rax\n")
+	emit("  callq runtime.lookupmethod\n")
+	emit("  pushq 16(%%rsp) # the data is the receiver\n")
+	emit("  callq *%%rax\n")
+	emit("  addq $8, %%rsp\n")
+	emit("  ret\n\n")
+}
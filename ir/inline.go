@@ -0,0 +1,109 @@
+package ir
+
+// Cost is the number of instructions and blocks of f, which the inliner compares with its budget
+func (f *Func) Cost() int {
+	n := 0
+	for _, b := range f.Blocks {
+		n += len(b.Instrs) + 1
+	}
+	return n
+}
+
+// IsLeaf reports whether f calls no function, including the runtime
+func (f *Func) IsLeaf() bool {
+	for _, b := range f.Blocks {
+		for _, instr := range b.Instrs {
+			if instr.Op.IsCall() {
+				return false
+			}
+		}
+	}
+	return true
+}
+
+// InlineCall replaces the call at b.Instrs[i] by a copy of the blocks of callee.
+// the registers of callee are appended to the registers of f, the parameters are copied from the arguments,
+// the frame slots of callee are appended to the slots of f,
+// and the returns copy the results to the results of the call and jump to a block with the instructions after the call
+func (f *Func) InlineCall(b *Block, i int, callee *Func) {
+	call := b.Instrs[i]
+	cont := &Block{Instrs: append([]*Instr(nil), b.Instrs[i+1:]...), Kind: b.Kind, Ctrl: b.Ctrl, Rets: b.Rets, Succs: b.Succs, Done: true}
+	for _, s := range b.Succs {
+		for j, p := range s.Preds {
+			if p == b {
+				s.Preds[j] = cont
+			}
+		}
+	}
+	b.Instrs = b.Instrs[:i]
+
+	base := Reg(len(f.Regs))
+	for r, typ := range callee.Regs {
+		f.NewReg(typ, callee.RegNames[r])
+	}
+	reg := func(r Reg) Reg {
+		if r == NoReg {
+			return NoReg
+		}
+		return base + r
+	}
+	regs := func(rs []Reg) []Reg {
+		var list []Reg
+		for _, r := range rs {
+			list = append(list, reg(r))
+		}
+		return list
+	}
+	slots := len(f.Slots)
+	f.Slots = append(f.Slots, callee.Slots...)
+	clones := map[*Block]*Block{}
+	var blocks []*Block
+	for _, cb := range callee.Blocks {
+		clones[cb] = &Block{Ctrl: NoReg}
+		blocks = append(blocks, clones[cb])
+	}
+	for _, cb := range callee.Blocks {
+		nb := clones[cb]
+		for _, instr := range cb.Instrs {
+			if instr.Op == Param {
+				nb.Add(&Instr{Op: Copy, Dst: reg(instr.Dst), Args: []Reg{call.Args[instr.Imm]}})
+				continue
+			}
+			clone := *instr
+			clone.Dst = reg(instr.Dst)
+			clone.Args = regs(instr.Args)
+			clone.Rets = regs(instr.Rets)
+			if instr.Op == Addr {
+				clone.Imm += slots
+			}
+			nb.Add(&clone)
+		}
+		if cb.Kind == Return {
+			for j, r := range cb.Rets {
+				nb.Add(&Instr{Op: Copy, Dst: call.Rets[j], Args: []Reg{reg(r)}})
+			}
+			nb.End(Jump, NoReg, cont)
+			continue
+		}
+		var succs []*Block
+		for _, s := range cb.Succs {
+			succs = append(succs, clones[s])
+		}
+		nb.End(cb.Kind, reg(cb.Ctrl), succs...)
+	}
+	b.Succs = nil
+	b.End(Jump, NoReg, blocks[0])
+
+	// the inlined blocks and the continuation follow b
+	var list []*Block
+	for _, x := range f.Blocks {
+		list = append(list, x)
+		if x == b {
+			list = append(append(list, blocks...), cont)
+		}
+	}
+	for id, x := range list {
+		x.ID = id
+	}
+	f.Blocks = list
+}
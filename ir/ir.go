@@ -0,0 +1,250 @@
+// Package ir is the intermediate representation between the AST and assembly.
+//
+// a function is a list of basic blocks of three-address instructions on virtual registers.
+// every register holds a word of a type, and local variables are registers assigned by Copy, so the IR is not SSA.
+// a value larger than a word is the registers of its words in the order of memory, e.g. a string is a pointer and an int.
+// a block ends with a jump, a branch or a return to its successors
+package ir
+
+import "go/token"
+
+// Type is the type of the word held by a register
+type Type int
+
+const (
+	Void Type = iota // no value, e.g. the result of a function returning nothing
+	Int
+	Bool
+	Byte // wraps around after arithmetic
+	Ptr  // an address, which the collector follows
+)
+
+func (t Type) String() string {
+	return [...]string{"void", "int", "bool", "byte", "ptr"}[t]
+}
+
+// Reg is a virtual register
+type Reg int
+
+// NoReg is the destination of instructions without result
+const NoReg Reg = -1
+
+// Op is the operation of an instruction
+type Op int
+
+const (
+	Const    Op = iota // Dst = Imm
+	Copy               // Dst = Args[0]
+	Convert            // Dst = Type(Args[0])
+	Param              // Dst = the word Imm of the parameters
+	Load               // Dst = the word at Sym+Imm
+	Store              // the word at Sym+Imm = Args[0]
+	Addr               // Dst = the address of the frame slot Imm
+	SymAddr            // Dst = the address Sym+Imm
+	LoadMem            // Dst = the Size bytes at Args[0]+Imm
+	StoreMem           // the Size bytes at Args[0]+Imm = Args[1]
+	Neg                // Dst = -Args[0]
+	Not                // Dst = !Args[0]
+	Com                // Dst = ^Args[0]
+	Add                // Dst = Args[0] + Args[1]
+	Sub                // Dst = Args[0] - Args[1]
+	Mul                // Dst = Args[0] * Args[1]
+	Div                // Dst = Args[0] / Args[1]
+	Rem                // Dst = Args[0] % Args[1]
+	And                // Dst = Args[0] & Args[1]
+	Or                 // Dst = Args[0] | Args[1]
+	Xor                // Dst = Args[0] ^ Args[1]
+	AndNot             // Dst = Args[0] &^ Args[1]
+	Shl                // Dst = Args[0] << Args[1]
+	Shr                // Dst = Args[0] >> Args[1]
+	Eq                 // Dst = Args[0] == Args[1]
+	Ne                 // Dst = Args[0] != Args[1]
+	Lt                 // Dst = Args[0] < Args[1]
+	Le                 // Dst = Args[0] <= Args[1]
+	Gt                 // Dst = Args[0] > Args[1]
+	Ge                 // Dst = Args[0] >= Args[1]
+	LtU                // Dst = Args[0] < Args[1] as unsigned, e.g. an index below the length
+	LeU                // Dst = Args[0] <= Args[1] as unsigned
+	Call               // Rets = Sym(Args...). Args are the words of the arguments and Rets the words of the results
+	CallInd            // Rets = Args[0](Args[1:]...) calls the function at the address Args[0]
+	Check              // jumps to the runtime routine Sym, which panics, unless Args[0] is true
+)
+
+var opNames = [...]string{
+	"const", "copy", "convert", "param", "load", "store", "addr", "symaddr", "loadmem", "storemem", "neg", "not", "com",
+	"add", "sub", "mul", "div", "rem", "and", "or", "xor", "andnot", "shl", "shr",
+	"eq", "ne", "lt", "le", "gt", "ge", "ltu", "leu", "call", "callind", "check",
+}
+
+func (op Op) String() string {
+	return opNames[op]
+}
+
+// IsCompare reports whether op compares its arguments and results in a bool
+func (op Op) IsCompare() bool {
+	return op >= Eq && op <= LeU
+}
+
+// IsBinary reports whether op is an arithmetic or comparison operation of 2 arguments
+func (op Op) IsBinary() bool {
+	return op >= Add && op <= LeU
+}
+
+// IsUnary reports whether op is an operation of 1 argument
+func (op Op) IsUnary() bool {
+	return op >= Neg && op <= Com
+}
+
+// IsCall reports whether op calls a function
+func (op Op) IsCall() bool {
+	return op == Call || op == CallInd
+}
+
+// Instr is a three-address instruction
+type Instr struct {
+	Op         Op
+	Dst        Reg
+	Args       []Reg
+	Rets       []Reg // results of a call
+	Imm        int
+	Size       int       // bytes of LoadMem and StoreMem, 1 for the bytes packed in slices and strings, otherwise 8
+	Sym        string    // symbol of a global variable, a function or a string literal quoted for the assembler
+	RegABI     bool      // the callee of a Call takes its arguments in registers
+	MemResults bool      // the callee returns the results in the area reserved by the caller instead of registers
+	Extern     bool      // the callee is a C function called with the System V ABI
+	Pos        token.Pos // position in the source of the statement, or of the call for a Call, for the line table and the diagnostics of the inliner
+}
+
+// Defs returns the registers instr assigns
+func (instr *Instr) Defs() []Reg {
+	if instr.Op.IsCall() {
+		return instr.Rets
+	}
+	if instr.Dst == NoReg {
+		return nil
+	}
+	return []Reg{instr.Dst}
+}
+
+// BlockKind is how a block leaves
+type BlockKind int
+
+const (
+	Jump   BlockKind = iota // to Succs[0]
+	If                      // to Succs[0] if Ctrl is true, otherwise to Succs[1]
+	Return                  // returns Rets
+)
+
+// Block is a basic block
+type Block struct {
+	ID     int
+	Instrs []*Instr
+	Kind   BlockKind
+	Ctrl   Reg
+	Rets   []Reg // the words of the results of a return
+	Succs  []*Block
+	Preds  []*Block
+	Done   bool      // the block ends with Kind
+	Pos    token.Pos // position in the source of the statement ending the block, for the line table
+}
+
+// Uses returns the registers the control of b reads
+func (b *Block) Uses() []Reg {
+	switch b.Kind {
+	case If:
+		return []Reg{b.Ctrl}
+	case Return:
+		return b.Rets
+	}
+	return nil
+}
+
+// Func is a function compiled through the IR
+type Func struct {
+	Name       string   // symbol quoted for the assembler. e.g. main.add, "main.Max[int]"
+	Params     []Type   // the words of the receiver and the parameters
+	Results    []Type   // the words of the results
+	MemResults bool     // the results are returned in the area reserved by the caller
+	RegABI     bool     // the arguments are passed in registers
+	Regs       []Type   // types of the registers
+	RegNames   []string // variable names of the registers, "" for temporaries
+	Slots      []int    // sizes of the frame slots of the variables whose addresses are taken
+	Blocks     []*Block // the entry block is the first
+}
+
+// NewFunc returns a function with an empty entry block
+func NewFunc(name string, params []Type, results []Type) *Func {
+	f := &Func{Name: name, Params: params, Results: results}
+	f.NewBlock()
+	return f
+}
+
+// NewReg allocates a register of typ. name is the variable held by the register or ""
+func (f *Func) NewReg(typ Type, name string) Reg {
+	f.Regs = append(f.Regs, typ)
+	f.RegNames = append(f.RegNames, name)
+	return Reg(len(f.Regs) - 1)
+}
+
+// NewSlot allocates a frame slot of size bytes and returns its index
+func (f *Func) NewSlot(size int) int {
+	f.Slots = append(f.Slots, size)
+	return len(f.Slots) - 1
+}
+
+// NewBlock appends an empty block
+func (f *Func) NewBlock() *Block {
+	b := &Block{ID: len(f.Blocks), Ctrl: NoReg}
+	f.Blocks = append(f.Blocks, b)
+	return b
+}
+
+// Add appends an instruction to b
+func (b *Block) Add(instr *Instr) *Instr {
+	b.Instrs = append(b.Instrs, instr)
+	return instr
+}
+
+// End terminates b by kind with the control value ctrl and the successors
+func (b *Block) End(kind BlockKind, ctrl Reg, succs ...*Block) {
+	b.Kind = kind
+	b.Ctrl = ctrl
+	b.Succs = succs
+	b.Done = true
+	for _, s := range succs {
+		s.Preds = append(s.Preds, b)
+	}
+}
+
+// RemoveUnreachable drops the blocks not reachable from the entry block and renumbers the rest
+func (f *Func) RemoveUnreachable() {
+	reachable := map[*Block]bool{}
+	var visit func(b *Block)
+	visit = func(b *Block) {
+		if reachable[b] {
+			return
+		}
+		reachable[b] = true
+		for _, s := range b.Succs {
+			visit(s)
+		}
+	}
+	visit(f.Blocks[0])
+
+	var blocks []*Block
+	for _, b := range f.Blocks {
+		if !reachable[b] {
+			continue
+		}
+		var preds []*Block
+		for _, p := range b.Preds {
+			if reachable[p] {
+				preds = append(preds, p)
+			}
+		}
+		b.Preds = preds
+		b.ID = len(blocks)
+		blocks = append(blocks, b)
+	}
+	f.Blocks = blocks
+}
@@ -0,0 +1,138 @@
+package ir
+
+import (
+	"fmt"
+	"io"
+	"strings"
+)
+
+// Fprint writes f in text. e.g.
+//
+//	func main.add(int, int) int
+//	b0:
+//	  v0 = param 0 // a
+//	  v1 = param 1 // b
+//	  v2 = add v0, v1
+//	  ret v2
+func Fprint(w io.Writer, f *Func) {
+	var params []string
+	for _, p := range f.Params {
+		params = append(params, p.String())
+	}
+	fmt.Fprintf(w, "func %s(%s)", f.Name, strings.Join(params, ", "))
+	var results []string
+	for _, r := range f.Results {
+		results = append(results, r.String())
+	}
+	switch len(results) {
+	case 0:
+	case 1:
+		fmt.Fprintf(w, " %s", results[0])
+	default:
+		fmt.Fprintf(w, " (%s)", strings.Join(results, ", "))
+	}
+	if f.MemResults {
+		fmt.Fprintf(w, " mem")
+	}
+	if f.RegABI {
+		fmt.Fprintf(w, " regabi")
+	}
+	fmt.Fprintf(w, "\n")
+	for _, b := range f.Blocks {
+		fmt.Fprintf(w, "b%d:", b.ID)
+		if len(b.Preds) > 0 {
+			var preds []string
+			for _, p := range b.Preds {
+				preds = append(preds, fmt.Sprintf("b%d", p.ID))
+			}
+			fmt.Fprintf(w, " // preds %s", strings.Join(preds, " "))
+		}
+		fmt.Fprintf(w, "\n")
+		for _, instr := range b.Instrs {
+			fmt.Fprintf(w, "  %s\n", f.InstrString(instr))
+		}
+		fmt.Fprintf(w, "  %s\n", b.ControlString())
+	}
+}
+
+// InstrString returns instr in text with the name of the variable it assigns
+func (f *Func) InstrString(instr *Instr) string {
+	var s strings.Builder
+	if instr.Dst != NoReg {
+		fmt.Fprintf(&s, "%s = ", instr.Dst)
+	}
+	if len(instr.Rets) > 0 {
+		fmt.Fprintf(&s, "%s = ", regsString(instr.Rets))
+	}
+	s.WriteString(instr.Op.String())
+	var operands []string
+	switch instr.Op {
+	case Const, Param:
+		operands = append(operands, fmt.Sprint(instr.Imm))
+	case Convert:
+		operands = append(operands, f.Regs[instr.Dst].String())
+	case Load, Store:
+		operands = append(operands, fmt.Sprintf("%s+%d", instr.Sym, instr.Imm))
+	case Addr:
+		operands = append(operands, fmt.Sprintf("s%d", instr.Imm))
+	case SymAddr:
+		if instr.Imm != 0 {
+			operands = append(operands, fmt.Sprintf("%s+%d", instr.Sym, instr.Imm))
+		} else {
+			operands = append(operands, instr.Sym)
+		}
+	case Call, Check:
+		operands = append(operands, instr.Sym)
+	}
+	for _, arg := range instr.Args {
+		operands = append(operands, arg.String())
+	}
+	if instr.Op == LoadMem || instr.Op == StoreMem {
+		operands = append(operands[:1], append([]string{fmt.Sprint(instr.Imm)}, operands[1:]...)...)
+	}
+	if len(operands) > 0 {
+		s.WriteString(" " + strings.Join(operands, ", "))
+	}
+	if (instr.Op == LoadMem || instr.Op == StoreMem) && instr.Size == 1 {
+		s.WriteString(" byte")
+	}
+	if instr.RegABI {
+		s.WriteString(" regabi")
+	}
+	if instr.Extern {
+		s.WriteString(" extern")
+	}
+	if instr.MemResults {
+		s.WriteString(" mem")
+	}
+	if instr.Dst != NoReg && f.RegNames[instr.Dst] != "" {
+		fmt.Fprintf(&s, " // %s", f.RegNames[instr.Dst])
+	}
+	return s.String()
+}
+
+// ControlString returns how b leaves in text
+func (b *Block) ControlString() string {
+	switch b.Kind {
+	case Jump:
+		return fmt.Sprintf("jump b%d", b.Succs[0].ID)
+	case If:
+		return fmt.Sprintf("if %s b%d b%d", b.Ctrl, b.Succs[0].ID, b.Succs[1].ID)
+	}
+	if len(b.Rets) == 0 {
+		return "ret"
+	}
+	return fmt.Sprintf("ret %s", regsString(b.Rets))
+}
+
+func regsString(regs []Reg) string {
+	var s []string
+	for _, r := range regs {
+		s = append(s, r.String())
+	}
+	return strings.Join(s, ", ")
+}
+
+func (r Reg) String() string {
+	return fmt.Sprintf("v%d", int(r))
+}
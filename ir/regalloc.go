@@ -0,0 +1,226 @@
+package ir
+
+import "sort"
+
+// Allocation maps the virtual registers of a function to machine registers or to spill slots in the frame
+type Allocation struct {
+	Regs     []string // machine register of each virtual register, "" if it is spilled
+	Slots    []int    // spill slot of each virtual register, -1 if it is in a register
+	NumSlots int
+	Saved    []string // callee-saved registers assigned to some virtual register, which the function saves and restores
+}
+
+// Interval is the range of positions where a virtual register is live.
+// instructions are numbered in the order of the blocks, and the control of a block is after its last instruction
+type Interval struct {
+	Reg        Reg
+	Start, End int
+	AcrossCall bool // a call is between the start and the end, so the value is kept in a callee-saved register
+}
+
+// Liveness returns the registers live at the entry and at the exit of each block
+func Liveness(f *Func) (in, out [][]bool) {
+	n := len(f.Regs)
+	uses := make([][]bool, len(f.Blocks)) // used before they are defined in the block
+	defs := make([][]bool, len(f.Blocks))
+	for _, b := range f.Blocks {
+		use, def := make([]bool, n), make([]bool, n)
+		for _, instr := range b.Instrs {
+			for _, arg := range instr.Args {
+				if !def[arg] {
+					use[arg] = true
+				}
+			}
+			for _, r := range instr.Defs() {
+				def[r] = true
+			}
+		}
+		for _, r := range b.Uses() {
+			if !def[r] {
+				use[r] = true
+			}
+		}
+		uses[b.ID], defs[b.ID] = use, def
+	}
+
+	in = make([][]bool, len(f.Blocks))
+	out = make([][]bool, len(f.Blocks))
+	for i := range f.Blocks {
+		in[i], out[i] = make([]bool, n), make([]bool, n)
+	}
+	for changed := true; changed; {
+		changed = false
+		for i := len(f.Blocks) - 1; i >= 0; i-- {
+			b := f.Blocks[i]
+			for r := 0; r < n; r++ {
+				live := false
+				for _, s := range b.Succs {
+					live = live || in[s.ID][r]
+				}
+				out[i][r] = live
+				live = uses[i][r] || live && !defs[i][r]
+				if live != in[i][r] {
+					in[i][r] = live
+					changed = true
+				}
+			}
+		}
+	}
+	return in, out
+}
+
+// Intervals returns the live intervals of the registers of f sorted by their start.
+// a register used in a loop is live through the whole loop, because the blocks of the loop are between its entry and its back edge
+func Intervals(f *Func) []*Interval {
+	in, out := Liveness(f)
+	intervals := make([]*Interval, len(f.Regs))
+	extend := func(r Reg, pos int) {
+		if intervals[r] == nil {
+			intervals[r] = &Interval{Reg: r, Start: pos, End: pos}
+		}
+		if pos < intervals[r].Start {
+			intervals[r].Start = pos
+		}
+		if pos > intervals[r].End {
+			intervals[r].End = pos
+		}
+	}
+
+	var calls []int
+	pos := 0
+	for _, b := range f.Blocks {
+		start := pos
+		for _, instr := range b.Instrs {
+			for _, arg := range instr.Args {
+				extend(arg, pos)
+			}
+			for _, r := range instr.Defs() {
+				extend(r, pos)
+			}
+			if instr.Op.IsCall() {
+				calls = append(calls, pos)
+			}
+			pos++
+		}
+		for _, r := range b.Uses() {
+			extend(r, pos)
+		}
+		for r := range f.Regs {
+			if in[b.ID][r] {
+				extend(Reg(r), start)
+			}
+			if out[b.ID][r] {
+				extend(Reg(r), pos)
+			}
+		}
+		pos++
+	}
+
+	var list []*Interval
+	for _, it := range intervals {
+		if it == nil { // never used
+			continue
+		}
+		for _, call := range calls {
+			if it.Start < call && call < it.End {
+				it.AcrossCall = true
+			}
+		}
+		list = append(list, it)
+	}
+	sort.SliceStable(list, func(i, j int) bool { return list[i].Start < list[j].Start })
+	return list
+}
+
+// Allocate assigns the registers of f by linear scan over their intervals.
+// callerSaved registers are given to values not live across calls, and calleeSaved registers to any value.
+// when every register is taken, the interval ending last is spilled
+func Allocate(f *Func, callerSaved, calleeSaved []string) *Allocation {
+	a := &Allocation{Regs: make([]string, len(f.Regs)), Slots: make([]int, len(f.Regs))}
+	for i := range a.Slots {
+		a.Slots[i] = -1
+	}
+	free := map[string]bool{}
+	for _, r := range append(append([]string(nil), callerSaved...), calleeSaved...) {
+		free[r] = true
+	}
+	callee := map[string]bool{}
+	for _, r := range calleeSaved {
+		callee[r] = true
+	}
+	saved := map[string]bool{}
+	allowed := func(it *Interval, r string) bool {
+		return !it.AcrossCall || callee[r]
+	}
+	spill := func(r Reg) {
+		a.Regs[r] = ""
+		a.Slots[r] = a.NumSlots
+		a.NumSlots++
+	}
+
+	var active []*Interval // sorted by their end
+	for _, it := range Intervals(f) {
+		// expire the intervals ending before it. an instruction reads its arguments before it writes its destination
+		for len(active) > 0 && active[0].End <= it.Start {
+			free[a.Regs[active[0].Reg]] = true
+			active = active[1:]
+		}
+
+		reg := ""
+		for _, r := range append(callerSaved[:len(callerSaved):len(callerSaved)], calleeSaved...) {
+			if free[r] && allowed(it, r) {
+				reg = r
+				break
+			}
+		}
+		if reg == "" {
+			victim := -1
+			for i := len(active) - 1; i >= 0; i-- {
+				if allowed(it, a.Regs[active[i].Reg]) {
+					victim = i
+					break
+				}
+			}
+			if victim < 0 || active[victim].End <= it.End {
+				spill(it.Reg)
+				continue
+			}
+			reg = a.Regs[active[victim].Reg]
+			spill(active[victim].Reg)
+			active = append(active[:victim], active[victim+1:]...)
+		}
+
+		free[reg] = false
+		a.Regs[it.Reg] = reg
+		if callee[reg] {
+			saved[reg] = true
+		}
+		i := sort.Search(len(active), func(i int) bool { return active[i].End > it.End })
+		active = append(active[:i], append([]*Interval{it}, active[i:]...)...)
+	}
+
+	for _, r := range calleeSaved {
+		if saved[r] {
+			a.Saved = append(a.Saved, r)
+		}
+	}
+	return a
+}
+
+// Unassign takes the machine register from r, whose location is given by the caller instead.
+// a callee-saved register no longer assigned to any register is not saved
+func (a *Allocation) Unassign(r Reg) {
+	reg := a.Regs[r]
+	a.Regs[r] = ""
+	for _, other := range a.Regs {
+		if other == reg {
+			return
+		}
+	}
+	for i, saved := range a.Saved {
+		if saved == reg {
+			a.Saved = append(a.Saved[:i], a.Saved[i+1:]...)
+			return
+		}
+	}
+}
@@ -0,0 +1,78 @@
+package ir
+
+// TailCalls replaces the calls of f to itself whose result is returned right away by jumps to the start of f.
+// the arguments are copied to the registers of the parameters, so the call reuses the frame. it returns the calls replaced
+func (f *Func) TailCalls() []*Instr {
+	var calls []*Instr
+	var params []Reg // registers of the parameters by their index
+	var body *Block
+	for _, b := range append([]*Block(nil), f.Blocks...) {
+		n := len(b.Instrs)
+		if b.Kind != Return || n == 0 {
+			continue
+		}
+		call := b.Instrs[n-1]
+		if call.Op != Call || call.Sym != f.Name || !sameRegs(call.Rets, b.Rets) {
+			continue
+		}
+		if body == nil {
+			params, body = f.splitParams()
+		}
+		// the arguments are copied to temporaries first because they may be computed from the parameters
+		b.Instrs = b.Instrs[:n-1]
+		var temps []Reg
+		for _, arg := range call.Args {
+			t := f.NewReg(f.Regs[arg], "")
+			b.Add(&Instr{Op: Copy, Dst: t, Args: []Reg{arg}})
+			temps = append(temps, t)
+		}
+		for i, t := range temps {
+			b.Add(&Instr{Op: Copy, Dst: params[i], Args: []Reg{t}})
+		}
+		b.End(Jump, NoReg, body)
+		calls = append(calls, call)
+	}
+	return calls
+}
+
+// splitParams moves the instructions after the parameters of the entry block to a new block,
+// and returns the registers of the parameters and the new block
+func (f *Func) splitParams() ([]Reg, *Block) {
+	entry := f.Blocks[0]
+	params := make([]Reg, len(f.Params))
+	n := 0
+	for n < len(entry.Instrs) && entry.Instrs[n].Op == Param {
+		params[entry.Instrs[n].Imm] = entry.Instrs[n].Dst
+		n++
+	}
+
+	body := &Block{Instrs: append([]*Instr(nil), entry.Instrs[n:]...), Kind: entry.Kind, Ctrl: entry.Ctrl, Rets: entry.Rets, Succs: entry.Succs, Done: true}
+	for _, s := range entry.Succs {
+		for j, p := range s.Preds {
+			if p == entry {
+				s.Preds[j] = body
+			}
+		}
+	}
+	entry.Instrs = entry.Instrs[:n]
+	entry.Succs = nil
+	entry.End(Jump, NoReg, body)
+
+	f.Blocks = append([]*Block{entry, body}, f.Blocks[1:]...)
+	for id, b := range f.Blocks {
+		b.ID = id
+	}
+	return params, body
+}
+
+func sameRegs(a, b []Reg) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,207 @@
+package ir
+
+import "fmt"
+
+// Verify checks that the blocks of f are terminated and linked both ways,
+// that the registers are defined before they are used on every path, and that the operands have the types of their operations
+func Verify(f *Func) error {
+	if len(f.Blocks) == 0 {
+		return fmt.Errorf("%s: no blocks", f.Name)
+	}
+	for i, b := range f.Blocks {
+		if b.ID != i {
+			return fmt.Errorf("%s: b%d is at %d", f.Name, b.ID, i)
+		}
+		if !b.Done {
+			return fmt.Errorf("%s: b%d is not terminated", f.Name, b.ID)
+		}
+		for _, s := range b.Succs {
+			if !contains(s.Preds, b) {
+				return fmt.Errorf("%s: b%d is not a predecessor of b%d", f.Name, b.ID, s.ID)
+			}
+		}
+		for _, p := range b.Preds {
+			if !contains(p.Succs, b) {
+				return fmt.Errorf("%s: b%d is not a successor of b%d", f.Name, b.ID, p.ID)
+			}
+		}
+		want := map[BlockKind]int{Jump: 1, If: 2, Return: 0}[b.Kind]
+		if len(b.Succs) != want {
+			return fmt.Errorf("%s: b%d has %d successors", f.Name, b.ID, len(b.Succs))
+		}
+		for _, instr := range b.Instrs {
+			if err := verifyInstr(f, instr); err != nil {
+				return fmt.Errorf("%s: b%d: %s: %w", f.Name, b.ID, f.InstrString(instr), err)
+			}
+		}
+		switch {
+		case b.Kind == If && !f.isReg(b.Ctrl, Bool):
+			return fmt.Errorf("%s: b%d: the condition %s is not bool", f.Name, b.ID, b.Ctrl)
+		case b.Kind == Return && len(b.Rets) != len(f.Results):
+			return fmt.Errorf("%s: b%d returns %d words", f.Name, b.ID, len(b.Rets))
+		case b.Kind == Return:
+			for i, r := range b.Rets {
+				if !f.isReg(r, f.Results[i]) {
+					return fmt.Errorf("%s: b%d doesn't return %s", f.Name, b.ID, f.Results[i])
+				}
+			}
+		}
+	}
+	return verifyDefs(f)
+}
+
+func verifyInstr(f *Func, instr *Instr) error {
+	for _, r := range append(append([]Reg(nil), instr.Args...), instr.Rets...) {
+		if r < 0 || int(r) >= len(f.Regs) {
+			return fmt.Errorf("undefined register %s", r)
+		}
+	}
+	if instr.Dst != NoReg && (instr.Dst < 0 || int(instr.Dst) >= len(f.Regs)) {
+		return fmt.Errorf("undefined register %s", instr.Dst)
+	}
+	args := map[Op]int{Const: 0, Copy: 1, Convert: 1, Param: 0, Load: 0, Store: 1, Addr: 0, SymAddr: 0, LoadMem: 1, StoreMem: 2, Check: 1}
+	switch {
+	case instr.Op.IsUnary():
+		args[instr.Op] = 1
+	case instr.Op.IsBinary():
+		args[instr.Op] = 2
+	}
+	if n, ok := args[instr.Op]; ok && len(instr.Args) != n {
+		return fmt.Errorf("got %d arguments", len(instr.Args))
+	}
+	hasDst := instr.Op != Store && instr.Op != StoreMem && instr.Op != Check && !instr.Op.IsCall()
+	if hasDst && instr.Dst == NoReg {
+		return fmt.Errorf("no destination")
+	}
+	if !hasDst && instr.Dst != NoReg {
+		return fmt.Errorf("unexpected destination")
+	}
+	if !instr.Op.IsCall() && len(instr.Rets) > 0 {
+		return fmt.Errorf("unexpected results")
+	}
+
+	typ := func(i int) Type {
+		return f.Regs[instr.Args[i]]
+	}
+	switch op := instr.Op; {
+	case op == Param && (instr.Imm < 0 || instr.Imm >= len(f.Params) || f.Params[instr.Imm] != f.Regs[instr.Dst]):
+		return fmt.Errorf("no parameter %d of %s", instr.Imm, f.Regs[instr.Dst])
+	case op == Copy && typ(0) != f.Regs[instr.Dst]:
+		return fmt.Errorf("copy %s to %s", typ(0), f.Regs[instr.Dst])
+	case op == Addr && (instr.Imm < 0 || instr.Imm >= len(f.Slots) || !f.isReg(instr.Dst, Ptr)):
+		return fmt.Errorf("no slot %d", instr.Imm)
+	case (op == Load || op == Store || op == SymAddr || op == Check || op == Call) && instr.Sym == "":
+		return fmt.Errorf("no symbol")
+	case op == SymAddr && !f.isReg(instr.Dst, Ptr):
+		return fmt.Errorf("address of %s", f.Regs[instr.Dst])
+	case op == LoadMem || op == StoreMem:
+		if typ(0) != Ptr {
+			return fmt.Errorf("%s through %s", op, typ(0))
+		}
+		var value Type
+		if op == StoreMem {
+			value = typ(1)
+		} else {
+			value = f.Regs[instr.Dst]
+		}
+		if instr.Size != 8 && (instr.Size != 1 || value != Byte) {
+			return fmt.Errorf("%s of %d bytes to %s", op, instr.Size, value)
+		}
+	case op == Check && typ(0) != Bool:
+		return fmt.Errorf("check of %s", typ(0))
+	case op == CallInd && (len(instr.Args) == 0 || typ(0) != Ptr):
+		return fmt.Errorf("call of no function")
+	case op == Not && (!f.isReg(instr.Dst, Bool) || typ(0) != Bool):
+		return fmt.Errorf("not of non bool")
+	case (op == Neg || op == Com) && (typ(0) != f.Regs[instr.Dst] || typ(0) == Bool || typ(0) == Ptr):
+		return fmt.Errorf("%s of %s", op, typ(0))
+	case op.IsCompare():
+		if !f.isReg(instr.Dst, Bool) || typ(0) != typ(1) {
+			return fmt.Errorf("compare %s and %s", typ(0), typ(1))
+		}
+	case (op == Add || op == Sub) && f.Regs[instr.Dst] == Ptr: // pointer arithmetic
+		if typ(0) != Ptr || typ(1) != Int {
+			return fmt.Errorf("%s of %s and %s", op, typ(0), typ(1))
+		}
+	case op.IsBinary() && op != Shl && op != Shr:
+		if typ(0) != f.Regs[instr.Dst] || typ(1) != f.Regs[instr.Dst] || f.Regs[instr.Dst] == Bool && op != And || f.Regs[instr.Dst] == Ptr {
+			return fmt.Errorf("%s of %s and %s", op, typ(0), typ(1))
+		}
+	case op == Shl || op == Shr:
+		if typ(0) != f.Regs[instr.Dst] || f.Regs[instr.Dst] == Bool || f.Regs[instr.Dst] == Ptr || typ(1) == Bool || typ(1) == Ptr {
+			return fmt.Errorf("shift of %s by %s", typ(0), typ(1))
+		}
+	}
+	return nil
+}
+
+// verifyDefs checks that every register is assigned on all paths to its uses
+func verifyDefs(f *Func) error {
+	n := len(f.Regs)
+	in := make([][]bool, len(f.Blocks))  // registers defined at the entry of each block
+	out := make([][]bool, len(f.Blocks)) // and at the exit
+	for i := range f.Blocks {
+		out[i] = make([]bool, n)
+		for r := range out[i] {
+			out[i][r] = i != 0 // unknown blocks define everything until they are visited
+		}
+	}
+	for changed := true; changed; {
+		changed = false
+		for _, b := range f.Blocks {
+			defs := make([]bool, n)
+			if b.ID != 0 {
+				for r := range defs {
+					defs[r] = true
+					for _, p := range b.Preds {
+						defs[r] = defs[r] && out[p.ID][r]
+					}
+				}
+			}
+			in[b.ID] = append([]bool(nil), defs...)
+			for _, instr := range b.Instrs {
+				for _, r := range instr.Defs() {
+					defs[r] = true
+				}
+			}
+			for r := range defs {
+				if defs[r] != out[b.ID][r] {
+					out[b.ID][r] = defs[r]
+					changed = true
+				}
+			}
+		}
+	}
+	for _, b := range f.Blocks {
+		defs := in[b.ID]
+		for _, instr := range b.Instrs {
+			for _, arg := range instr.Args {
+				if !defs[arg] {
+					return fmt.Errorf("%s: b%d: %s is used before it is defined", f.Name, b.ID, arg)
+				}
+			}
+			for _, r := range instr.Defs() {
+				defs[r] = true
+			}
+		}
+		for _, r := range b.Uses() {
+			if !defs[r] {
+				return fmt.Errorf("%s: b%d: %s is used before it is defined", f.Name, b.ID, r)
+			}
+		}
+	}
+	return nil
+}
+
+func (f *Func) isReg(r Reg, typ Type) bool {
+	return r >= 0 && int(r) < len(f.Regs) && f.Regs[r] == typ
+}
+
+func contains(blocks []*Block, b *Block) bool {
+	for _, x := range blocks {
+		if x == b {
+			return true
+		}
+	}
+	return false
+}
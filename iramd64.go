@@ -0,0 +1,284 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/lkeix/gompiler/ir"
+)
+
+// amd64IR emits the IR in x86-64. an instruction loads its arguments to rax and rdi, computes in rax
+// and stores it to the location of the destination. rax, rcx, rdx and rdi are left as scratch registers,
+// rbx is an argument register of the register ABI, and r12 is changed by the calls to C
+type amd64IR struct{}
+
+func (amd64IR) registers() ([]string, []string) {
+	return []string{"rsi", "r8", "r9", "r10", "r11"}, []string{"r13", "r14", "r15"}
+}
+
+func (amd64IR) argRegs() []string {
+	return abiRegs
+}
+
+func (amd64IR) resultRegs() []string {
+	return []string{"rax", "rsi", "rdx"}
+}
+
+func (amd64IR) loc(l irLoc) string {
+	if l.reg != "" {
+		return "%" + l.reg
+	}
+	return fmt.Sprintf("%d(%%rbp)", l.off)
+}
+
+func (a amd64IR) prologue(size int) {
+	emitPrologue()
+	if size > 0 {
+		emit("  subq $%d, %%rsp\n", size)
+	}
+}
+
+func (amd64IR) epilogue() {
+	emitEpilogue()
+}
+
+func (a amd64IR) move(dst, src irLoc) {
+	switch {
+	case dst == src:
+	case dst.reg == "" && src.reg == "":
+		emit("  movq %s, %%rax\n", a.loc(src))
+		emit("  movq %%rax, %s\n", a.loc(dst))
+	default:
+		emit("  movq %s, %s\n", a.loc(src), a.loc(dst))
+	}
+}
+
+func (amd64IR) label(name string) {
+	emit("%s:\n", name)
+}
+
+func (amd64IR) jump(label string) {
+	emit("  jmp %s\n", label)
+}
+
+func (a amd64IR) jumpIfZero(x irLoc, label string) {
+	emit("  cmpq $0, %s\n", a.loc(x))
+	emit("  je %s\n", label)
+}
+
+var irSetcc = map[ir.Op]string{
+	ir.Eq:  "sete",
+	ir.Ne:  "setne",
+	ir.Lt:  "setl",
+	ir.Le:  "setle",
+	ir.Gt:  "setg",
+	ir.Ge:  "setge",
+	ir.LtU: "setb",
+	ir.LeU: "setbe",
+}
+
+func (a amd64IR) instr(fr *irFrame, instr *ir.Instr) {
+	f := fr.f
+	slot := func(r ir.Reg) string {
+		return a.loc(fr.loc(r))
+	}
+	arg := func(i int) string {
+		return slot(instr.Args[i])
+	}
+	switch op := instr.Op; {
+	case op == ir.Const:
+		if dst := slot(instr.Dst); strings.HasPrefix(dst, "%") || fitsInt32(int64(instr.Imm)) {
+			emit("  movq $%d, %s\n", instr.Imm, dst)
+			return
+		}
+		emit("  movq $%d, %%rax\n", instr.Imm) // only registers take 64-bit immediates
+	case op == ir.Convert:
+		emit("  movq %s, %%rax\n", arg(0))
+	case op == ir.Load:
+		emit("  movq %s(%%rip), %%rax\n", symOff(instr.Sym, instr.Imm))
+	case op == ir.Store:
+		emit("  movq %s, %%rax\n", arg(0))
+		emit("  movq %%rax, %s(%%rip)\n", symOff(instr.Sym, instr.Imm))
+		return
+	case op == ir.Addr:
+		emit("  leaq %s, %%rax\n", a.loc(fr.slots[instr.Imm]))
+	case op == ir.SymAddr:
+		emit("  leaq %s(%%rip), %%rax\n", symOff(instr.Sym, instr.Imm))
+	case op == ir.LoadMem:
+		emit("  movq %s, %%rax\n", arg(0))
+		if instr.Size == 1 {
+			emit("  movzbq %d(%%rax), %%rax\n", instr.Imm)
+		} else {
+			emit("  movq %d(%%rax), %%rax\n", instr.Imm)
+		}
+	case op == ir.StoreMem:
+		emit("  movq %s, %%rax\n", arg(0))
+		emit("  movq %s, %%rcx\n", arg(1))
+		if instr.Size == 1 {
+			emit("  movb %%cl, %d(%%rax)\n", instr.Imm)
+		} else {
+			emit("  movq %%rcx, %d(%%rax)\n", instr.Imm)
+		}
+		return
+	case op == ir.Neg:
+		emit("  movq %s, %%rax\n", arg(0))
+		emit("  negq %%rax\n")
+	case op == ir.Not:
+		emit("  movq %s, %%rax\n", arg(0))
+		emit("  xorq $1, %%rax\n")
+	case op == ir.Com:
+		emit("  movq %s, %%rax\n", arg(0))
+		emit("  notq %%rax\n")
+	case op.IsBinary():
+		emit("  movq %s, %%rax\n", arg(0))
+		emit("  movq %s, %%rdi\n", arg(1))
+		a.binary(f, instr)
+	default:
+		must(fmt.Errorf("unexpected IR operation %s", op))
+	}
+	if f.Regs[instr.Dst] == ir.Byte {
+		emit("  movzbq %%al, %%rax\n")
+	}
+	emit("  movq %%rax, %s\n", slot(instr.Dst))
+}
+
+// binary computes rax op rdi in rax
+func (amd64IR) binary(f *ir.Func, instr *ir.Instr) {
+	switch instr.Op {
+	case ir.Add:
+		emit("  addq %%rdi, %%rax\n")
+	case ir.Sub:
+		emit("  subq %%rdi, %%rax\n")
+	case ir.Mul:
+		emit("  imulq %%rdi, %%rax\n")
+	case ir.Div, ir.Rem:
+		emitDivide(instr.Op == ir.Rem, false)
+	case ir.And:
+		emit("  andq %%rdi, %%rax\n")
+	case ir.Or:
+		emit("  orq %%rdi, %%rax\n")
+	case ir.Xor:
+		emit("  xorq %%rdi, %%rax\n")
+	case ir.AndNot:
+		emit("  notq %%rdi\n")
+		emit("  andq %%rdi, %%rax\n")
+	case ir.Shl:
+		emitShift("salq")
+	case ir.Shr:
+		if f.Regs[instr.Dst] == ir.Byte {
+			emitShift("shrq")
+		} else {
+			emitShift("sarq")
+		}
+	default: // comparison
+		emit("  cmpq %%rdi, %%rax\n")
+		emit("  %s %%al\n", irSetcc[instr.Op])
+		emit("  movzbq %%al, %%rax\n")
+	}
+}
+
+// call calls the function with the arguments in registers or pushed in reverse order.
+// the results come back in rax, rsi and rdx, or in the area reserved below the arguments.
+// allocated arguments may be in the argument registers, so they are pushed and popped to their registers
+func (a amd64IR) call(fr *irFrame, instr *ir.Instr) {
+	if instr.Extern {
+		a.externCall(fr, instr)
+		return
+	}
+	slot := func(r ir.Reg) string {
+		return a.loc(fr.loc(r))
+	}
+	args := instr.Args
+	if instr.Op == ir.CallInd {
+		args = args[1:]
+	}
+	if instr.MemResults {
+		emit("  subq $%d, %%rsp # results\n", 8*len(instr.Rets))
+	}
+	switch {
+	case instr.RegABI && fr.allocated:
+		for i := len(args) - 1; i >= 0; i-- {
+			emit("  pushq %s\n", slot(args[i]))
+		}
+		for i := range args {
+			emit("  popq %%%s\n", abiRegs[i])
+		}
+		emit("  callq %s\n", instr.Sym)
+	case instr.RegABI:
+		for i, arg := range args {
+			emit("  movq %s, %%%s\n", slot(arg), abiRegs[i])
+		}
+		emit("  callq %s\n", instr.Sym)
+	default:
+		for i := len(args) - 1; i >= 0; i-- {
+			emit("  pushq %s\n", slot(args[i]))
+		}
+		if instr.Op == ir.CallInd {
+			emit("  movq %s, %%rax\n", slot(instr.Args[0]))
+			emit("  callq *%%rax\n")
+		} else {
+			emit("  callq %s\n", instr.Sym)
+		}
+		if len(args) > 0 {
+			emit("  addq $%d, %%rsp\n", 8*len(args))
+		}
+	}
+	if instr.MemResults {
+		for i, r := range instr.Rets {
+			emit("  movq %d(%%rsp), %%rax\n", 8*i)
+			emit("  movq %%rax, %s\n", slot(r))
+		}
+		emit("  addq $%d, %%rsp\n", 8*len(instr.Rets))
+		return
+	}
+	// rsi may be allocated to the first result, so the others move to scratch registers first
+	results := []string{"rax", "rcx", "rdi"}
+	if len(instr.Rets) > 1 {
+		emit("  movq %%rsi, %%rcx\n")
+	}
+	if len(instr.Rets) > 2 {
+		emit("  movq %%rdx, %%rdi\n")
+	}
+	for i, r := range instr.Rets {
+		emit("  movq %%%s, %s\n", results[i], slot(r))
+	}
+}
+
+// externCall calls a C function like emitExternCall. the arguments past the registers are copied
+// below the aligned stack pointer first, because the argument registers may be allocated to the arguments
+func (a amd64IR) externCall(fr *irFrame, instr *ir.Instr) {
+	slot := func(r ir.Reg) string {
+		return a.loc(fr.loc(r))
+	}
+	regArgs := instr.Args
+	stackArgs := 0
+	if len(regArgs) > len(externRegs) {
+		stackArgs = len(regArgs) - len(externRegs)
+		regArgs = regArgs[:len(externRegs)]
+	}
+	emit("  movq %%rsp, %%r12\n")
+	emit("  andq $-16, %%rsp\n")
+	if stackArgs > 0 {
+		emit("  subq $%d, %%rsp\n", (stackArgs*8+15)&^15)
+		for i := 0; i < stackArgs; i++ {
+			emit("  movq %s, %%rax\n", slot(instr.Args[len(externRegs)+i]))
+			emit("  movq %%rax, %d(%%rsp)\n", i*8)
+		}
+	}
+	for i := len(regArgs) - 1; i >= 0; i-- {
+		emit("  pushq %s\n", slot(regArgs[i]))
+	}
+	for i := range regArgs {
+		emit("  popq %%%s\n", externRegs[i])
+	}
+	emit("  movq $0, %%rax # no vector registers for variadic functions\n")
+	emit("  callq %s\n", instr.Sym)
+	emit("  movq %%r12, %%rsp\n")
+	if len(instr.Rets) == 0 {
+		return
+	}
+	if typ := fr.f.Regs[instr.Rets[0]]; typ == ir.Bool || typ == ir.Byte {
+		emit("  movzbq %%al, %%rax\n")
+	}
+	emit("  movq %%rax, %s\n", slot(instr.Rets[0]))
+}
@@ -0,0 +1,312 @@
+package main
+
+import (
+	"fmt"
+
+	"github.com/lkeix/gompiler/ir"
+)
+
+// arm64IR emits the IR in AArch64. the stack of the compiled code is x28 and the frame pointer is x29 like the lowering
+// of the runtime, so the frames keep the layout of the stack ABI and the collector scans them from x28.
+// an instruction loads its arguments to x0 and x1, computes in x0 unless the destination is a register,
+// and stores it to the frame slot of the destination. x16 and x17 are scratch registers for the immediates
+// and the addresses, and x30 holds the return address while it is pushed.
+// the registers allocated to the IR and the result registers are the ones of amd64 in the lowering, which
+// the runtime keeps like on amd64: x4 and x8-x11 are changed by the calls, and x13-x15 are kept
+type arm64IR struct{}
+
+func (arm64IR) registers() ([]string, []string) {
+	return []string{"x4", "x8", "x9", "x10", "x11"}, []string{"x13", "x14", "x15"}
+}
+
+func (arm64IR) argRegs() []string {
+	return []string{"x0", "x1", "x2", "x3", "x4", "x5", "x6", "x7", "x8"}
+}
+
+func (arm64IR) resultRegs() []string {
+	return []string{"x0", "x4", "x3"}
+}
+
+func (arm64IR) loc(l irLoc) string {
+	if l.reg != "" {
+		return l.reg
+	}
+	return fmt.Sprintf("[x29, #%d]", l.off)
+}
+
+// addImm computes x = y + v, through x16 when v is out of the range of the immediates of add and sub
+func (arm64IR) addImm(x, y string, v int) {
+	switch {
+	case v >= 0 && v < 4096:
+		emitNative("add %s, %s, #%d", x, y, v)
+	case v < 0 && v > -4096:
+		emitNative("sub %s, %s, #%d", x, y, -v)
+	default:
+		for _, ins := range arm64Imm("x16", int64(v)) {
+			emitNative("%s", ins)
+		}
+		emitNative("add %s, %s, x16", x, y)
+	}
+}
+
+// mem returns the memory operand of the word or the byte at off from the register base.
+// the address is computed to x17 when off is out of the range of ldr and str
+func (a arm64IR) mem(base string, off, size int) string {
+	if off >= -256 && off < 256 || off > 0 && off%size == 0 && off/size < 4096 {
+		return fmt.Sprintf("[%s, #%d]", base, off)
+	}
+	a.addImm("x17", base, off)
+	return "[x17]"
+}
+
+// use returns the register holding the value at l, which is loaded to scratch if l is a frame slot
+func (a arm64IR) use(l irLoc, scratch string) string {
+	if l.reg != "" {
+		return l.reg
+	}
+	emitNative("ldr %s, %s", scratch, a.mem("x29", l.off, 8))
+	return scratch
+}
+
+// def returns the register to compute the value of l in, which is scratch if l is a frame slot
+func (arm64IR) def(l irLoc, scratch string) string {
+	if l.reg != "" {
+		return l.reg
+	}
+	return scratch
+}
+
+// put stores the register x computed for l by def to l
+func (a arm64IR) put(l irLoc, x string) {
+	if l.reg == "" {
+		emitNative("str %s, %s", x, a.mem("x29", l.off, 8))
+	}
+}
+
+// symAddr computes the address sym+off to x
+func (arm64IR) symAddr(x, sym string, off int) {
+	emitNative("adrp %s, %s", x, symOff(sym, off))
+	emitNative("add %s, %s, :lo12:%s", x, x, symOff(sym, off))
+}
+
+func (a arm64IR) prologue(size int) {
+	emitNative("str x29, [x28, #-8]!")
+	emitNative("mov x29, x28")
+	if size > 0 {
+		a.addImm("x28", "x28", -size)
+	}
+}
+
+func (arm64IR) epilogue() {
+	emitNative("mov x28, x29")
+	emitNative("ldr x29, [x28], #8")
+	emitNative("ldr x30, [x28], #8")
+	emitNative("ret")
+}
+
+func (a arm64IR) move(dst, src irLoc) {
+	switch {
+	case dst == src:
+	case dst.reg != "" && src.reg != "":
+		emitNative("mov %s, %s", dst.reg, src.reg)
+	default:
+		a.put(dst, a.use(src, a.def(dst, "x16")))
+	}
+}
+
+func (arm64IR) label(name string) {
+	emit("%s:\n", name)
+}
+
+func (arm64IR) jump(label string) {
+	emitNative("b %s", label)
+}
+
+// jumpIfZero branches by cbz to the labels of the function. cbz reaches 1MB, so it branches over b to the runtime
+func (a arm64IR) jumpIfZero(x irLoc, label string) {
+	r := a.use(x, "x16")
+	if label[0] == '.' {
+		emitNative("cbz %s, %s", r, label)
+		return
+	}
+	labelSeq++
+	skip := fmt.Sprintf(".L.ir.skip.%d", labelSeq)
+	emitNative("cbnz %s, %s", r, skip)
+	emitNative("b %s", label)
+	emit("%s:\n", skip)
+}
+
+var arm64IRConds = map[ir.Op]string{
+	ir.Eq: "eq", ir.Ne: "ne", ir.Lt: "lt", ir.Le: "le", ir.Gt: "gt", ir.Ge: "ge", ir.LtU: "lo", ir.LeU: "ls",
+}
+
+func (a arm64IR) instr(fr *irFrame, instr *ir.Instr) {
+	f := fr.f
+	arg := func(i int, scratch string) string {
+		return a.use(fr.loc(instr.Args[i]), scratch)
+	}
+	switch op := instr.Op; {
+	case op == ir.Store:
+		x := arg(0, "x0")
+		a.symAddr("x17", instr.Sym, instr.Imm)
+		emitNative("str %s, [x17]", x)
+		return
+	case op == ir.StoreMem:
+		p, x := arg(0, "x0"), arg(1, "x1")
+		if instr.Size == 1 {
+			emitNative("strb w%s, %s", x[1:], a.mem(p, instr.Imm, 1))
+		} else {
+			emitNative("str %s, %s", x, a.mem(p, instr.Imm, 8))
+		}
+		return
+	}
+	dst := fr.loc(instr.Dst)
+	d := a.def(dst, "x0")
+	switch op := instr.Op; {
+	case op == ir.Const:
+		for _, ins := range arm64Imm(d, int64(instr.Imm)) {
+			emitNative("%s", ins)
+		}
+	case op == ir.Convert:
+		if x := arg(0, "x0"); x != d {
+			emitNative("mov %s, %s", d, x)
+		}
+	case op == ir.Load:
+		a.symAddr("x17", instr.Sym, instr.Imm)
+		emitNative("ldr %s, [x17]", d)
+	case op == ir.Addr:
+		a.addImm(d, "x29", fr.slots[instr.Imm].off)
+	case op == ir.SymAddr:
+		a.symAddr(d, instr.Sym, instr.Imm)
+	case op == ir.LoadMem:
+		p := arg(0, "x0")
+		if instr.Size == 1 {
+			emitNative("ldrb w%s, %s", d[1:], a.mem(p, instr.Imm, 1))
+		} else {
+			emitNative("ldr %s, %s", d, a.mem(p, instr.Imm, 8))
+		}
+	case op == ir.Neg:
+		emitNative("neg %s, %s", d, arg(0, "x0"))
+	case op == ir.Not:
+		emitNative("eor %s, %s, #1", d, arg(0, "x0"))
+	case op == ir.Com:
+		emitNative("mvn %s, %s", d, arg(0, "x0"))
+	case op.IsBinary():
+		a.binary(f, instr, d, arg(0, "x0"), arg(1, "x1"))
+	default:
+		must(fmt.Errorf("unexpected IR operation %s", op))
+	}
+	if f.Regs[instr.Dst] == ir.Byte {
+		emitNative("and %s, %s, #0xff", d, d)
+	}
+	a.put(dst, d)
+}
+
+// binary computes x op y in d. the shifts by 64 or more make 0, or the sign for the arithmetic shift,
+// and the division by 0 panics, while sdiv makes the minimum int divided by -1 the minimum int like Go
+func (a arm64IR) binary(f *ir.Func, instr *ir.Instr, d, x, y string) {
+	switch instr.Op {
+	case ir.Add:
+		emitNative("add %s, %s, %s", d, x, y)
+	case ir.Sub:
+		emitNative("sub %s, %s, %s", d, x, y)
+	case ir.Mul:
+		emitNative("mul %s, %s, %s", d, x, y)
+	case ir.Div:
+		a.jumpIfZero(irLoc{reg: y}, "runtime.panicdivide")
+		emitNative("sdiv %s, %s, %s", d, x, y)
+	case ir.Rem:
+		a.jumpIfZero(irLoc{reg: y}, "runtime.panicdivide")
+		emitNative("sdiv x16, %s, %s", x, y)
+		emitNative("msub %s, x16, %s, %s", d, y, x)
+	case ir.And:
+		emitNative("and %s, %s, %s", d, x, y)
+	case ir.Or:
+		emitNative("orr %s, %s, %s", d, x, y)
+	case ir.Xor:
+		emitNative("eor %s, %s, %s", d, x, y)
+	case ir.AndNot:
+		emitNative("bic %s, %s, %s", d, x, y)
+	case ir.Shl, ir.Shr:
+		if instr.Op == ir.Shr && f.Regs[instr.Dst] != ir.Byte {
+			emitNative("mov x16, #63")
+			emitNative("cmp %s, #63", y)
+			emitNative("csel x16, %s, x16, ls", y)
+			emitNative("asr %s, %s, x16", d, x)
+			return
+		}
+		shift := map[ir.Op]string{ir.Shl: "lsl", ir.Shr: "lsr"}[instr.Op]
+		emitNative("%s x16, %s, %s", shift, x, y)
+		emitNative("cmp %s, #64", y)
+		emitNative("csel %s, x16, xzr, lo", d)
+	default: // comparison
+		emitNative("cmp %s, %s", x, y)
+		emitNative("cset %s, %s", d, arm64IRConds[instr.Op])
+	}
+}
+
+// call pushes the arguments and the return address to x28 and branches to the function.
+// the arguments of the register ABI go through the stack to their registers,
+// because allocated arguments may be in the argument registers
+func (a arm64IR) call(fr *irFrame, instr *ir.Instr) {
+	if instr.Extern {
+		must(fmt.Errorf("calls to C are only for linux/amd64"))
+	}
+	args := instr.Args
+	if instr.Op == ir.CallInd {
+		args = args[1:]
+	}
+	if instr.MemResults {
+		a.addImm("x28", "x28", -8*len(instr.Rets))
+	}
+	if len(args) > 0 {
+		a.addImm("x28", "x28", -8*len(args))
+		for i, arg := range args {
+			emitNative("str %s, %s", a.use(fr.loc(arg), "x16"), a.mem("x28", 8*i, 8))
+		}
+	}
+	if instr.RegABI {
+		for i := range args {
+			emitNative("ldr %s, %s", a.argRegs()[i], a.mem("x28", 8*i, 8))
+		}
+		a.addImm("x28", "x28", 8*len(args))
+	}
+	target := ""
+	if instr.Op == ir.CallInd {
+		target = a.use(fr.loc(instr.Args[0]), "x17")
+	}
+	labelSeq++
+	ret := fmt.Sprintf(".L.ir.ret.%d", labelSeq)
+	emitNative("adr x30, %s", ret)
+	emitNative("str x30, [x28, #-8]!")
+	if target != "" {
+		emitNative("br %s", target)
+	} else {
+		emitNative("b %s", instr.Sym)
+	}
+	emit("%s:\n", ret)
+	if !instr.RegABI && len(args) > 0 {
+		a.addImm("x28", "x28", 8*len(args))
+	}
+	if instr.MemResults {
+		for i, r := range instr.Rets {
+			dst := fr.loc(r)
+			x := a.def(dst, "x16")
+			emitNative("ldr %s, %s", x, a.mem("x28", 8*i, 8))
+			a.put(dst, x)
+		}
+		a.addImm("x28", "x28", 8*len(instr.Rets))
+		return
+	}
+	// x4 may be allocated to the first result, so the others move to scratch registers first
+	results := []string{"x0", "x1", "x2"}
+	if len(instr.Rets) > 1 {
+		emitNative("mov x1, x4")
+	}
+	if len(instr.Rets) > 2 {
+		emitNative("mov x2, x3")
+	}
+	for i, r := range instr.Rets {
+		a.move(fr.loc(r), irLoc{reg: results[i]})
+	}
+}
@@ -0,0 +1,283 @@
+package main
+
+import (
+	"fmt"
+	"go/ast"
+	"sort"
+
+	"github.com/lkeix/gompiler/ir"
+)
+
+// optLevel is the optimization level. 0 keeps every register of the IR in a frame slot, and 1 allocates machine registers
+var optLevel = 1
+
+// irArch emits the functions lowered to the IR for the architecture of a target. emitIRFunc lays out the frame
+// and walks the blocks, and the architecture emits the frame, the operations, the branches and the calls
+// in its instructions. the calls follow the stack ABI of the runtime: the caller pushes the arguments
+// in reverse order and the return address, the callee returns the results in the result registers,
+// or in the area the caller reserved above the arguments, and the frame pointer points at the saved one
+type irArch interface {
+	// registers returns the machine registers allocated to the IR. the calls may change callerSaved,
+	// and the function using one of calleeSaved saves it in its frame
+	registers() (callerSaved, calleeSaved []string)
+	// argRegs are the registers passing the arguments of the register ABI, and resultRegs the results
+	argRegs() []string
+	resultRegs() []string
+	// loc formats a location for the comments and the debugger
+	loc(l irLoc) string
+
+	prologue(size int) // saves the frame pointer and reserves size bytes of the frame
+	epilogue()         // frees the frame and returns
+	move(dst, src irLoc)
+	instr(fr *irFrame, instr *ir.Instr) // an operation other than a move, a check and a call
+	label(name string)
+	jump(label string)
+	jumpIfZero(x irLoc, label string)
+	call(fr *irFrame, instr *ir.Instr)
+}
+
+// irLoc is where a register of the IR is: a machine register, or the word of the frame at off from the frame pointer
+type irLoc struct {
+	reg string
+	off int
+}
+
+// frameWord returns the location of the word at off from the frame pointer
+func frameWord(off int) irLoc {
+	return irLoc{off: off}
+}
+
+// irFrame is where the registers, the parameters and the frame slots of a function lowered to the IR are
+type irFrame struct {
+	f         *ir.Func
+	loc       func(ir.Reg) irLoc
+	param     func(int) irLoc // the argument i, or irLoc{} if the parameters are read from the registers they are passed in
+	slots     []irLoc         // the frame slots of the variables whose addresses are taken
+	allocated bool            // the registers are allocated to machine registers
+}
+
+// emitIRFunc emits a function lowered to the IR for curTarget. at -O0 every register has a slot in the frame,
+// otherwise registers are allocated by ir.Allocate and only the spilled ones have slots.
+// with -g, the registers of the variables stay at homes in the frame, because a machine register holds a variable
+// only while it is live and is reused after that. the parameters are at the arguments, and the rest get slots.
+// the words of a variable are in the order of memory at -O0 and at the homes, so the debugger sees the whole value
+func emitIRFunc(fnc *Func, f *ir.Func) {
+	arch := curTarget.ir
+	frameSlot := func(i int) irLoc {
+		return frameWord(-8 * (i + 1))
+	}
+	fr := &irFrame{f: f, param: func(i int) irLoc {
+		return frameWord(16 + 8*i)
+	}}
+	slots := len(f.Regs)
+	params := 0 // slots of the arguments passed in registers, followed by the slots of the callee-saved registers
+	fr.loc = func(r ir.Reg) irLoc {
+		return frameSlot(len(f.Regs) - 1 - int(r))
+	}
+
+	var alloc *ir.Allocation
+	homes := map[ir.Reg]irLoc{}
+	if optLevel > 0 {
+		// the frame has the arguments passed in registers, the callee-saved registers, the spilled registers and the homes
+		callerSaved, calleeSaved := arch.registers()
+		alloc = ir.Allocate(f, callerSaved, calleeSaved)
+		fr.allocated = true
+		if f.RegABI {
+			params = len(f.Params)
+			fr.param = func(i int) irLoc {
+				return frameSlot(params - 1 - i)
+			}
+		}
+		var vars [][]ir.Reg
+		if debugInfo {
+			for _, v := range irVars[f] {
+				used := false
+				for _, r := range v.regs {
+					used = used || alloc.Regs[r] != "" || alloc.Slots[r] >= 0
+				}
+				if used {
+					for _, r := range v.regs {
+						alloc.Unassign(r)
+					}
+					vars = append(vars, v.regs)
+				}
+			}
+			sort.Slice(vars, func(i, j int) bool { return vars[i][0] < vars[j][0] })
+		}
+		slots = params + len(alloc.Saved) + alloc.NumSlots
+		for _, regs := range vars {
+			for i, r := range regs {
+				if int(r) < len(f.Params) { // the parameters are the first registers
+					homes[r] = fr.param(int(r))
+				} else {
+					homes[r] = frameSlot(slots + len(regs) - 1 - i)
+				}
+			}
+			if int(regs[0]) >= len(f.Params) {
+				slots += len(regs)
+			}
+		}
+		fr.loc = func(r ir.Reg) irLoc {
+			if home, ok := homes[r]; ok {
+				return home
+			}
+			if alloc.Regs[r] != "" {
+				return irLoc{reg: alloc.Regs[r]}
+			}
+			return frameSlot(params + len(alloc.Saved) + alloc.Slots[r])
+		}
+	} else if f.RegABI {
+		fr.param = nil
+	}
+	for _, size := range f.Slots {
+		words := (size + 7) / 8
+		fr.slots = append(fr.slots, frameSlot(slots+words-1))
+		slots += words
+	}
+	unused := func(r ir.Reg) bool {
+		_, home := homes[r]
+		return alloc != nil && !home && alloc.Regs[r] == "" && alloc.Slots[r] < 0
+	}
+
+	emit("# ir\n")
+	emit(".text\n")
+	emit("%s: # regs %d, blocks %d\n", f.Name, len(f.Regs), len(f.Blocks))
+	varLoc := func(obj *ast.Object) (string, bool) {
+		v, ok := irVars[f][obj]
+		switch {
+		case !ok:
+			return "", false
+		case v.slot >= 0:
+			return arch.loc(fr.slots[v.slot]), false
+		case len(v.regs) == 0 || unused(v.regs[0]): // never used
+			return "", false
+		case len(v.regs) == 1 || alloc == nil:
+			return arch.loc(fr.loc(v.regs[0])), v.heap
+		}
+		if _, ok := homes[v.regs[0]]; ok {
+			return arch.loc(fr.loc(v.regs[0])), false
+		}
+		return "", false // the words are in separate registers
+	}
+	debugFuncStart(fnc, f.Name, varLoc)
+	recordFrame(fnc, f.Name, 8*slots, true, varLoc)
+	if alloc != nil {
+		for r, name := range f.RegNames {
+			if unused(ir.Reg(r)) { // never used
+				continue
+			}
+			if name != "" {
+				name = " " + name
+			}
+			emit("# %s %s%s: %s\n", ir.Reg(r), f.Regs[r], name, arch.loc(fr.loc(ir.Reg(r))))
+		}
+	}
+	arch.prologue(8 * slots)
+	if alloc != nil {
+		if f.RegABI { // the arguments are stored before the registers are reused
+			for i := range f.Params {
+				arch.move(fr.param(i), irLoc{reg: arch.argRegs()[i]})
+			}
+		}
+		for i, r := range alloc.Saved {
+			arch.move(frameSlot(params+i), irLoc{reg: r})
+			debugCFI(".cfi_offset %d, %d", dwarfRegs[r], -16-8*(params+i+1)) // the CFA is rbp+16
+		}
+	}
+	emitIRBlocks(fr, func(b *ir.Block) {
+		emitIRReturn(fr, b)
+		if alloc != nil {
+			for i, r := range alloc.Saved {
+				arch.move(irLoc{reg: r}, frameSlot(params+i))
+			}
+		}
+		arch.epilogue()
+	})
+	debugFuncEnd()
+	emit("\n")
+}
+
+// emitIRReturn moves the results of the return b to the result registers, or to the area reserved by the caller above the arguments
+func emitIRReturn(fr *irFrame, b *ir.Block) {
+	arch := curTarget.ir
+	if fr.f.MemResults {
+		off := 16 + 8*len(fr.f.Params)
+		if fr.f.RegABI {
+			off = 16
+		}
+		for i, r := range b.Rets {
+			arch.move(frameWord(off+8*i), fr.loc(r))
+		}
+		return
+	}
+	for i, r := range b.Rets {
+		arch.move(irLoc{reg: arch.resultRegs()[i]}, fr.loc(r))
+	}
+}
+
+// emitIRBlocks emits the blocks of the function of fr. ret emits a return
+func emitIRBlocks(fr *irFrame, ret func(b *ir.Block)) {
+	arch := curTarget.ir
+	f := fr.f
+	labelSeq++
+	seq := labelSeq
+	label := func(b *ir.Block) string {
+		return fmt.Sprintf(".L.ir.%d.%d", seq, b.ID)
+	}
+	for i, b := range f.Blocks {
+		arch.label(label(b))
+		for _, instr := range b.Instrs {
+			emit("  # %s\n", f.InstrString(instr))
+			debugLoc(instr.Pos)
+			emitIRInstr(fr, instr)
+		}
+		emit("  # %s\n", b.ControlString())
+		debugLoc(b.Pos)
+		var next *ir.Block
+		if i+1 < len(f.Blocks) {
+			next = f.Blocks[i+1]
+		}
+		switch b.Kind {
+		case ir.Jump:
+			if b.Succs[0] != next {
+				arch.jump(label(b.Succs[0]))
+			}
+		case ir.If:
+			arch.jumpIfZero(fr.loc(b.Ctrl), label(b.Succs[1]))
+			if b.Succs[0] != next {
+				arch.jump(label(b.Succs[0]))
+			}
+		case ir.Return:
+			ret(b)
+		}
+	}
+}
+
+// emitIRInstr emits instr in the frame fr
+func emitIRInstr(fr *irFrame, instr *ir.Instr) {
+	arch := curTarget.ir
+	switch op := instr.Op; {
+	case op == ir.Param && fr.param == nil:
+		arch.move(fr.loc(instr.Dst), irLoc{reg: arch.argRegs()[instr.Imm]})
+	case op == ir.Param:
+		if fr.param(instr.Imm) != fr.loc(instr.Dst) { // kept at the argument for the debugger
+			arch.move(fr.loc(instr.Dst), fr.param(instr.Imm))
+		}
+	case op == ir.Copy:
+		arch.move(fr.loc(instr.Dst), fr.loc(instr.Args[0]))
+	case op == ir.Check:
+		arch.jumpIfZero(fr.loc(instr.Args[0]), instr.Sym)
+	case op.IsCall():
+		arch.call(fr, instr)
+	default:
+		arch.instr(fr, instr)
+	}
+}
+
+// symOff returns the address sym+off for the assembler
+func symOff(sym string, off int) string {
+	if off == 0 {
+		return sym
+	}
+	return fmt.Sprintf("%s+%d", sym, off)
+}
@@ -0,0 +1,300 @@
+package main
+
+import (
+	"fmt"
+
+	"github.com/lkeix/gompiler/ir"
+)
+
+// riscv64IR emits the IR in RV64GC. the stack of the compiled code is sp and the frame pointer is s0 like the lowering
+// of the runtime, so the frames keep the layout of the stack ABI. an instruction loads its arguments to t1 and t2,
+// computes in t1 unless the destination is a register, and stores it to the frame slot of the destination.
+// t0 is the scratch register for the addresses, t3 and t4 for the intermediate values, and ra holds the return address
+// while it is pushed. the registers allocated to the IR and the result registers are the ones of amd64 in the lowering,
+// which the runtime keeps like on amd64: a4, a6, a7, s2 and s3 are changed by the calls, and s5-s7 are kept
+type riscv64IR struct{}
+
+func (riscv64IR) registers() ([]string, []string) {
+	return []string{"a4", "a6", "a7", "s2", "s3"}, []string{"s5", "s6", "s7"}
+}
+
+func (riscv64IR) argRegs() []string {
+	return []string{"a0", "a1", "a2", "a3", "a4", "a5", "a6", "a7", "s1"}
+}
+
+func (riscv64IR) resultRegs() []string {
+	return []string{"a0", "a4", "a3"}
+}
+
+func (riscv64IR) loc(l irLoc) string {
+	if l.reg != "" {
+		return l.reg
+	}
+	return fmt.Sprintf("%d(s0)", l.off)
+}
+
+// addImm computes x = y + v, through t0 when v is out of the range of 12 bits
+func (riscv64IR) addImm(x, y string, v int) {
+	if isImm12(int64(v)) {
+		emitNative("addi %s, %s, %d", x, y, v)
+		return
+	}
+	emitNative("li t0, %d", v)
+	emitNative("add %s, %s, t0", x, y)
+}
+
+// mem returns the memory operand at off from the register base. the address is computed to t0 when off is out of 12 bits
+func (r riscv64IR) mem(base string, off int) string {
+	if isImm12(int64(off)) {
+		return fmt.Sprintf("%d(%s)", off, base)
+	}
+	r.addImm("t0", base, off)
+	return "0(t0)"
+}
+
+// use returns the register holding the value at l, which is loaded to scratch if l is a frame slot
+func (r riscv64IR) use(l irLoc, scratch string) string {
+	if l.reg != "" {
+		return l.reg
+	}
+	emitNative("ld %s, %s", scratch, r.mem("s0", l.off))
+	return scratch
+}
+
+// def returns the register to compute the value of l in, which is scratch if l is a frame slot
+func (riscv64IR) def(l irLoc, scratch string) string {
+	if l.reg != "" {
+		return l.reg
+	}
+	return scratch
+}
+
+// put stores the register x computed for l by def to l
+func (r riscv64IR) put(l irLoc, x string) {
+	if l.reg == "" {
+		emitNative("sd %s, %s", x, r.mem("s0", l.off))
+	}
+}
+
+func (r riscv64IR) prologue(size int) {
+	emitNative("addi sp, sp, -8")
+	emitNative("sd s0, 0(sp)")
+	emitNative("mv s0, sp")
+	if size > 0 {
+		r.addImm("sp", "sp", -size)
+	}
+}
+
+func (riscv64IR) epilogue() {
+	emitNative("mv sp, s0")
+	emitNative("ld s0, 0(sp)")
+	emitNative("ld ra, 8(sp)")
+	emitNative("addi sp, sp, 16")
+	emitNative("ret")
+}
+
+func (r riscv64IR) move(dst, src irLoc) {
+	switch {
+	case dst == src:
+	case dst.reg != "" && src.reg != "":
+		emitNative("mv %s, %s", dst.reg, src.reg)
+	default:
+		r.put(dst, r.use(src, r.def(dst, "t1")))
+	}
+}
+
+func (riscv64IR) label(name string) {
+	emit("%s:\n", name)
+}
+
+func (riscv64IR) jump(label string) {
+	emitNative("j %s", label)
+}
+
+// jumpIfZero branches over j, because the conditional branches reach 4KB while j reaches 1MB
+func (r riscv64IR) jumpIfZero(x irLoc, label string) {
+	v := r.use(x, "t1")
+	labelSeq++
+	skip := fmt.Sprintf(".L.ir.skip.%d", labelSeq)
+	emitNative("bnez %s, %s", v, skip)
+	emitNative("j %s", label)
+	emit("%s:\n", skip)
+}
+
+func (r riscv64IR) instr(fr *irFrame, instr *ir.Instr) {
+	f := fr.f
+	arg := func(i int, scratch string) string {
+		return r.use(fr.loc(instr.Args[i]), scratch)
+	}
+	switch op := instr.Op; {
+	case op == ir.Store:
+		x := arg(0, "t1")
+		emitNative("lla t0, %s", symOff(instr.Sym, instr.Imm))
+		emitNative("sd %s, 0(t0)", x)
+		return
+	case op == ir.StoreMem:
+		p, x := arg(0, "t1"), arg(1, "t2")
+		insn := map[int]string{1: "sb", 8: "sd"}[instr.Size]
+		emitNative("%s %s, %s", insn, x, r.mem(p, instr.Imm))
+		return
+	}
+	dst := fr.loc(instr.Dst)
+	d := r.def(dst, "t1")
+	switch op := instr.Op; {
+	case op == ir.Const:
+		emitNative("li %s, %d", d, instr.Imm)
+	case op == ir.Convert:
+		if x := arg(0, "t1"); x != d {
+			emitNative("mv %s, %s", d, x)
+		}
+	case op == ir.Load:
+		emitNative("lla t0, %s", symOff(instr.Sym, instr.Imm))
+		emitNative("ld %s, 0(t0)", d)
+	case op == ir.Addr:
+		r.addImm(d, "s0", fr.slots[instr.Imm].off)
+	case op == ir.SymAddr:
+		emitNative("lla %s, %s", d, symOff(instr.Sym, instr.Imm))
+	case op == ir.LoadMem:
+		p := arg(0, "t1")
+		insn := map[int]string{1: "lbu", 8: "ld"}[instr.Size]
+		emitNative("%s %s, %s", insn, d, r.mem(p, instr.Imm))
+	case op == ir.Neg:
+		emitNative("neg %s, %s", d, arg(0, "t1"))
+	case op == ir.Not:
+		emitNative("xori %s, %s, 1", d, arg(0, "t1"))
+	case op == ir.Com:
+		emitNative("not %s, %s", d, arg(0, "t1"))
+	case op.IsBinary():
+		r.binary(f, instr, d, arg(0, "t1"), arg(1, "t2"))
+	default:
+		must(fmt.Errorf("unexpected IR operation %s", op))
+	}
+	if f.Regs[instr.Dst] == ir.Byte {
+		emitNative("andi %s, %s, 255", d, d)
+	}
+	r.put(dst, d)
+}
+
+// binary computes x op y in d. the shifts by 64 or more make 0, or the sign for the arithmetic shift,
+// and the division by 0 panics, while div and rem make the minimum int divided by -1 the minimum int
+// with the remainder 0 like Go
+func (r riscv64IR) binary(f *ir.Func, instr *ir.Instr, d, x, y string) {
+	switch instr.Op {
+	case ir.Add:
+		emitNative("add %s, %s, %s", d, x, y)
+	case ir.Sub:
+		emitNative("sub %s, %s, %s", d, x, y)
+	case ir.Mul:
+		emitNative("mul %s, %s, %s", d, x, y)
+	case ir.Div, ir.Rem:
+		r.jumpIfZero(irLoc{reg: y}, "runtime.panicdivide")
+		emitNative("%s %s, %s, %s", map[ir.Op]string{ir.Div: "div", ir.Rem: "rem"}[instr.Op], d, x, y)
+	case ir.And:
+		emitNative("and %s, %s, %s", d, x, y)
+	case ir.Or:
+		emitNative("or %s, %s, %s", d, x, y)
+	case ir.Xor:
+		emitNative("xor %s, %s, %s", d, x, y)
+	case ir.AndNot:
+		emitNative("not t3, %s", y)
+		emitNative("and %s, %s, t3", d, x)
+	case ir.Shl, ir.Shr:
+		if instr.Op == ir.Shr && f.Regs[instr.Dst] != ir.Byte {
+			// the count is y if it is below 63, and 63 otherwise
+			emitNative("sltiu t4, %s, 63", y)
+			emitNative("neg t4, t4")
+			emitNative("xori t3, %s, 63", y)
+			emitNative("and t3, t3, t4")
+			emitNative("xori t3, t3, 63")
+			emitNative("sra %s, %s, t3", d, x)
+			return
+		}
+		shift := map[ir.Op]string{ir.Shl: "sll", ir.Shr: "srl"}[instr.Op]
+		emitNative("%s t3, %s, %s", shift, x, y)
+		emitNative("sltiu t4, %s, 64", y)
+		emitNative("neg t4, t4")
+		emitNative("and %s, t3, t4", d)
+	case ir.Eq, ir.Ne:
+		emitNative("sub t3, %s, %s", x, y)
+		emitNative("%s %s, t3", map[ir.Op]string{ir.Eq: "seqz", ir.Ne: "snez"}[instr.Op], d)
+	default: // the other comparisons are slt or sltu, with the operands swapped for > and <=, and negated for >= and <=
+		insn := "slt"
+		if instr.Op == ir.LtU || instr.Op == ir.LeU {
+			insn = "sltu"
+		}
+		if instr.Op == ir.Gt || instr.Op == ir.Le || instr.Op == ir.LeU {
+			x, y = y, x
+		}
+		emitNative("%s %s, %s, %s", insn, d, x, y)
+		if instr.Op == ir.Ge || instr.Op == ir.Le || instr.Op == ir.LeU {
+			emitNative("xori %s, %s, 1", d, d)
+		}
+	}
+}
+
+// call pushes the arguments and the return address to sp and jumps to the function.
+// the arguments of the register ABI go through the stack to their registers,
+// because allocated arguments may be in the argument registers
+func (r riscv64IR) call(fr *irFrame, instr *ir.Instr) {
+	if instr.Extern {
+		must(fmt.Errorf("calls to C are only for linux/amd64"))
+	}
+	args := instr.Args
+	if instr.Op == ir.CallInd {
+		args = args[1:]
+	}
+	if instr.MemResults {
+		r.addImm("sp", "sp", -8*len(instr.Rets))
+	}
+	if len(args) > 0 {
+		r.addImm("sp", "sp", -8*len(args))
+		for i, arg := range args {
+			emitNative("sd %s, %s", r.use(fr.loc(arg), "t1"), r.mem("sp", 8*i))
+		}
+	}
+	if instr.RegABI {
+		for i := range args {
+			emitNative("ld %s, %s", r.argRegs()[i], r.mem("sp", 8*i))
+		}
+		r.addImm("sp", "sp", 8*len(args))
+	}
+	target := ""
+	if instr.Op == ir.CallInd {
+		target = r.use(fr.loc(instr.Args[0]), "t2")
+	}
+	labelSeq++
+	ret := fmt.Sprintf(".L.ir.ret.%d", labelSeq)
+	emitNative("lla ra, %s", ret)
+	emitNative("addi sp, sp, -8")
+	emitNative("sd ra, 0(sp)")
+	if target != "" {
+		emitNative("jr %s", target)
+	} else {
+		emitNative("j %s", instr.Sym)
+	}
+	emit("%s:\n", ret)
+	if !instr.RegABI && len(args) > 0 {
+		r.addImm("sp", "sp", 8*len(args))
+	}
+	if instr.MemResults {
+		for i, reg := range instr.Rets {
+			dst := fr.loc(reg)
+			x := r.def(dst, "t1")
+			emitNative("ld %s, %s", x, r.mem("sp", 8*i))
+			r.put(dst, x)
+		}
+		r.addImm("sp", "sp", 8*len(instr.Rets))
+		return
+	}
+	// a4 may be allocated to the first result, so the others move to scratch registers first
+	results := []string{"a0", "t1", "t2"}
+	if len(instr.Rets) > 1 {
+		emitNative("mv t1, a4")
+	}
+	if len(instr.Rets) > 2 {
+		emitNative("mv t2, a3")
+	}
+	for i, reg := range instr.Rets {
+		r.move(fr.loc(reg), irLoc{reg: results[i]})
+	}
+}
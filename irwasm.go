@@ -0,0 +1,351 @@
+package main
+
+import (
+	"fmt"
+
+	"github.com/lkeix/gompiler/ir"
+)
+
+// wasmIR emits the IR in the instructions of wasm in the text format, which wasm.go assembles with the runtime.
+// the registers of the IR are the i64 locals v0, v1 and so on, and an instruction pushes its arguments to the operand
+// stack of wasm and pops its result to the destination. the frame is in the linear memory at the local fp, which is rbp
+// of the runtime too, so the calls keep the stack ABI of amd64. the collector scans the linear memory and doesn't see
+// the locals, so the locals are given to the allocator as registers the calls change and the values live across calls
+// are spilled to the frame. the arguments and the results of the register ABI are in the globals of the registers
+type wasmIR struct{}
+
+func (wasmIR) registers() ([]string, []string) {
+	regs := make([]string, wasmVars)
+	for i := range regs {
+		regs[i] = fmt.Sprintf("v%d", i)
+	}
+	return regs, nil
+}
+
+func (wasmIR) argRegs() []string {
+	return abiRegs
+}
+
+func (wasmIR) resultRegs() []string {
+	return []string{"rax", "rsi", "rdx"}
+}
+
+func (wasmIR) loc(l irLoc) string {
+	if l.reg != "" {
+		return l.reg
+	}
+	return fmt.Sprintf("%d(fp)", l.off)
+}
+
+// access emits local.op of a register of the IR, or global.op of a register of x86-64
+func (wasmIR) access(op, reg string) {
+	if reg[0] == 'v' {
+		emitNative("local.%s %s", op, reg)
+	} else {
+		emitNative("global.%s %s", op, reg)
+	}
+}
+
+// frameAddr pushes the address of the word at off from the frame pointer as i32
+func (wasmIR) frameAddr(off int) {
+	emitNative("local.get fp")
+	emitNative("i64.const %d", off)
+	emitNative("i64.add")
+	emitNative("i32.wrap_i64")
+}
+
+// stackAddr pushes the address of the word at off from rsp as i32
+func (wasmIR) stackAddr(off int) {
+	emitNative("global.get rsp")
+	if off != 0 {
+		emitNative("i64.const %d", off)
+		emitNative("i64.add")
+	}
+	emitNative("i32.wrap_i64")
+}
+
+// addStack adds n to rsp
+func (wasmIR) addStack(n int) {
+	emitNative("global.get rsp")
+	emitNative("i64.const %d", n)
+	emitNative("i64.add")
+	emitNative("global.set rsp")
+}
+
+// push pushes the value at l
+func (w wasmIR) push(l irLoc) {
+	if l.reg != "" {
+		w.access("get", l.reg)
+		return
+	}
+	w.frameAddr(l.off)
+	emitNative("i64.load")
+}
+
+// begin pushes the address of l if it is a frame slot, which comes before the value stored there by end
+func (w wasmIR) begin(l irLoc) {
+	if l.reg == "" {
+		w.frameAddr(l.off)
+	}
+}
+
+// end pops the value on the stack to l
+func (w wasmIR) end(l irLoc) {
+	if l.reg == "" {
+		emitNative("i64.store")
+		return
+	}
+	w.access("set", l.reg)
+}
+
+func (w wasmIR) prologue(size int) {
+	w.addStack(-8)
+	w.stackAddr(0)
+	emitNative("global.get rbp")
+	emitNative("i64.store")
+	emitNative("global.get rsp")
+	emitNative("local.tee fp")
+	emitNative("global.set rbp")
+	if size > 0 {
+		w.addStack(-size)
+	}
+}
+
+// epilogue restores rbp and pops it and the return address
+func (w wasmIR) epilogue() {
+	w.frameAddr(0)
+	emitNative("i64.load")
+	emitNative("global.set rbp")
+	emitNative("local.get fp")
+	emitNative("i64.const 16")
+	emitNative("i64.add")
+	emitNative("global.set rsp")
+	emitNative("return")
+}
+
+func (w wasmIR) move(dst, src irLoc) {
+	if dst == src {
+		return
+	}
+	w.begin(dst)
+	w.push(src)
+	w.end(dst)
+}
+
+func (wasmIR) label(name string) {
+	emit("%s:\n", name)
+}
+
+// jump branches to the label in the function, or tail calls the function of the label like the jumps of the runtime
+func (wasmIR) jump(label string) {
+	emitNative("jump %s", label)
+}
+
+func (w wasmIR) jumpIfZero(x irLoc, label string) {
+	w.push(x)
+	emitNative("i64.eqz")
+	emitNative("if")
+	w.jump(label)
+	emitNative("end")
+}
+
+var wasmIRCompares = map[ir.Op]string{
+	ir.Eq: "i64.eq", ir.Ne: "i64.ne", ir.Lt: "i64.lt_s", ir.Le: "i64.le_s", ir.Gt: "i64.gt_s", ir.Ge: "i64.ge_s",
+	ir.LtU: "i64.lt_u", ir.LeU: "i64.le_u",
+}
+
+func (w wasmIR) instr(fr *irFrame, instr *ir.Instr) {
+	f := fr.f
+	arg := func(i int) {
+		w.push(fr.loc(instr.Args[i]))
+	}
+	// the address of a memory operand at the pointer of the argument i
+	memAddr := func(i int) {
+		arg(i)
+		if instr.Imm != 0 {
+			emitNative("i64.const %d", instr.Imm)
+			emitNative("i64.add")
+		}
+		emitNative("i32.wrap_i64")
+	}
+	switch op := instr.Op; {
+	case op == ir.Store:
+		emitNative("i64.const %s", symOff(instr.Sym, instr.Imm))
+		emitNative("i32.wrap_i64")
+		arg(0)
+		emitNative("i64.store")
+		return
+	case op == ir.StoreMem:
+		memAddr(0)
+		arg(1)
+		emitNative(map[int]string{1: "i64.store8", 8: "i64.store"}[instr.Size])
+		return
+	case op == ir.Div || op == ir.Rem:
+		w.jumpIfZero(fr.loc(instr.Args[1]), "runtime.panicdivide")
+	}
+	dst := fr.loc(instr.Dst)
+	w.begin(dst)
+	switch op := instr.Op; {
+	case op == ir.Const:
+		emitNative("i64.const %d", instr.Imm)
+	case op == ir.Convert:
+		arg(0)
+	case op == ir.Load:
+		emitNative("i64.const %s", symOff(instr.Sym, instr.Imm))
+		emitNative("i32.wrap_i64")
+		emitNative("i64.load")
+	case op == ir.Addr:
+		emitNative("local.get fp")
+		emitNative("i64.const %d", fr.slots[instr.Imm].off)
+		emitNative("i64.add")
+	case op == ir.SymAddr:
+		emitNative("i64.const %s", symOff(instr.Sym, instr.Imm))
+	case op == ir.LoadMem:
+		memAddr(0)
+		emitNative(map[int]string{1: "i64.load8_u", 8: "i64.load"}[instr.Size])
+	case op == ir.Neg:
+		emitNative("i64.const 0")
+		arg(0)
+		emitNative("i64.sub")
+	case op == ir.Not:
+		arg(0)
+		emitNative("i64.const 1")
+		emitNative("i64.xor")
+	case op == ir.Com:
+		arg(0)
+		emitNative("i64.const -1")
+		emitNative("i64.xor")
+	case op.IsBinary():
+		w.binary(f, instr, func() { arg(0) }, func() { arg(1) })
+	default:
+		must(fmt.Errorf("unexpected IR operation %s", op))
+	}
+	if f.Regs[instr.Dst] == ir.Byte {
+		emitNative("i64.const 255")
+		emitNative("i64.and")
+	}
+	w.end(dst)
+}
+
+// binary pushes x op y, which are pushed by x and y. the division by 0 is checked before, and the division
+// by -1 is a negation because i64.div_s traps on the minimum int. the shifts by 64 or more make 0,
+// or the sign for the arithmetic shift, while the shifts of wasm take the count modulo 64
+func (wasmIR) binary(f *ir.Func, instr *ir.Instr, x, y func()) {
+	isMinusOne := func() {
+		y()
+		emitNative("i64.const -1")
+		emitNative("i64.eq")
+	}
+	switch instr.Op {
+	case ir.Add, ir.Sub, ir.Mul, ir.And, ir.Or, ir.Xor:
+		x()
+		y()
+		emitNative(map[ir.Op]string{
+			ir.Add: "i64.add", ir.Sub: "i64.sub", ir.Mul: "i64.mul", ir.And: "i64.and", ir.Or: "i64.or", ir.Xor: "i64.xor",
+		}[instr.Op])
+	case ir.AndNot:
+		x()
+		y()
+		emitNative("i64.const -1")
+		emitNative("i64.xor")
+		emitNative("i64.and")
+	case ir.Div:
+		x()
+		emitNative("i64.const 1")
+		y()
+		isMinusOne()
+		emitNative("select")
+		emitNative("i64.div_s")
+		emitNative("i64.const -1")
+		emitNative("i64.const 1")
+		isMinusOne()
+		emitNative("select")
+		emitNative("i64.mul")
+	case ir.Rem:
+		x()
+		y()
+		emitNative("i64.rem_s")
+	case ir.Shl, ir.Shr:
+		x()
+		if instr.Op == ir.Shr && f.Regs[instr.Dst] != ir.Byte {
+			y()
+			emitNative("i64.const 63")
+			y()
+			emitNative("i64.const 63")
+			emitNative("i64.lt_u")
+			emitNative("select")
+			emitNative("i64.shr_s")
+			return
+		}
+		y()
+		emitNative(map[ir.Op]string{ir.Shl: "i64.shl", ir.Shr: "i64.shr_u"}[instr.Op])
+		emitNative("i64.const 0")
+		y()
+		emitNative("i64.const 64")
+		emitNative("i64.lt_u")
+		emitNative("select")
+	default: // comparison
+		x()
+		y()
+		emitNative(wasmIRCompares[instr.Op])
+		emitNative("i64.extend_i32_u")
+	}
+}
+
+// call pushes the arguments and a word for the return address to the stack of the linear memory, or sets the globals
+// of the argument registers, and calls the function. the results come back in the globals of the result registers,
+// or in the area reserved above the arguments
+func (w wasmIR) call(fr *irFrame, instr *ir.Instr) {
+	if instr.Extern {
+		must(fmt.Errorf("calls to C are only for linux/amd64"))
+	}
+	args := instr.Args
+	if instr.Op == ir.CallInd {
+		args = args[1:]
+	}
+	if instr.MemResults {
+		w.addStack(-8 * len(instr.Rets))
+	}
+	switch {
+	case instr.RegABI:
+		for i, arg := range args {
+			w.push(fr.loc(arg))
+			w.access("set", abiRegs[i])
+		}
+	case len(args) > 0:
+		w.addStack(-8 * len(args))
+		for i, arg := range args {
+			w.stackAddr(8 * i)
+			w.push(fr.loc(arg))
+			emitNative("i64.store")
+		}
+	}
+	w.addStack(-8)
+	w.stackAddr(0)
+	emitNative("i64.const 0")
+	emitNative("i64.store")
+	if instr.Op == ir.CallInd {
+		w.push(fr.loc(instr.Args[0]))
+		emitNative("i32.wrap_i64")
+		emitNative("call_indirect")
+	} else {
+		emitNative("call %s", instr.Sym)
+	}
+	if !instr.RegABI && len(args) > 0 {
+		w.addStack(8 * len(args))
+	}
+	if instr.MemResults {
+		for i, r := range instr.Rets {
+			dst := fr.loc(r)
+			w.begin(dst)
+			w.stackAddr(8 * i)
+			emitNative("i64.load")
+			w.end(dst)
+		}
+		w.addStack(8 * len(instr.Rets))
+		return
+	}
+	for i, r := range instr.Rets {
+		w.move(fr.loc(r), irLoc{reg: w.resultRegs()[i]})
+	}
+}
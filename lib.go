@@ -0,0 +1,10 @@
+package main
+
+import "embed"
+
+// the packages of the standard library which are not provided by the runtime are compiled from the go sources in lib
+//
+//go:embed lib
+var libFS embed.FS
+
+var libPackages = map[string]bool{"errors": true, "fmt": true, "io": true, "os": true}
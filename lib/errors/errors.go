@@ -0,0 +1,17 @@
+//go:build gompiler
+
+// Package errors is the subset of the errors package provided by gompiler.
+package errors
+
+type errorString struct {
+	s string
+}
+
+func (e *errorString) Error() string {
+	return e.s
+}
+
+// New returns an error whose Error method returns text. every call returns a distinct error
+func New(text string) error {
+	return &errorString{s: text}
+}
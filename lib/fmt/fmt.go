@@ -0,0 +1,378 @@
+//go:build gompiler
+
+// Package fmt is the subset of the fmt package provided by gompiler.
+// it is compiled from this source together with the program, and formats the values with the type descriptors of the runtime.
+package fmt
+
+import (
+	"io"
+	_ "unsafe" // for go:linkname
+)
+
+// kinds of the type descriptors
+const (
+	kindNil     = 0
+	kindBool    = 1
+	kindInt     = 2
+	kindString  = 3
+	kindPointer = 4
+	kindSlice   = 5
+	kindStruct  = 6
+	kindByte    = 7
+	kindAny     = 8
+)
+
+//go:linkname write runtime.write
+func write(fd int, b []byte) int
+
+//go:linkname kind runtime.efacekind
+func kind(a any) int
+
+//go:linkname typeName runtime.efacetype
+func typeName(a any) string
+
+//go:linkname word runtime.efaceword
+func word(a any) int
+
+//go:linkname stringValue runtime.efacestring
+func stringValue(a any) string
+
+//go:linkname length runtime.efacelen
+func length(a any) int
+
+//go:linkname index runtime.efaceindex
+func index(a any, i int) any
+
+//go:linkname isError runtime.efaceiserror
+func isError(a any) bool
+
+//go:linkname errorString runtime.efaceerror
+func errorString(a any) string
+
+// Print formats the operands like %v and writes them to the standard output.
+// spaces are added between operands when neither is a string
+func Print(a ...any) {
+	write(1, appendPrint(nil, a))
+}
+
+// Println formats the operands like %v and writes them to the standard output.
+// spaces are always added between operands and a newline is appended
+func Println(a ...any) {
+	write(1, appendPrintln(nil, a))
+}
+
+// Printf formats the operands according to the format and writes them to the standard output
+func Printf(format string, a ...any) {
+	write(1, appendPrintf(nil, format, a))
+}
+
+// Fprint formats like Print and writes to w
+func Fprint(w io.Writer, a ...any) (int, error) {
+	return w.Write(appendPrint(nil, a))
+}
+
+// Fprintln formats like Println and writes to w
+func Fprintln(w io.Writer, a ...any) (int, error) {
+	return w.Write(appendPrintln(nil, a))
+}
+
+// Fprintf formats like Printf and writes to w
+func Fprintf(w io.Writer, format string, a ...any) (int, error) {
+	return w.Write(appendPrintf(nil, format, a))
+}
+
+func Sprint(a ...any) string {
+	return string(appendPrint(nil, a))
+}
+
+func Sprintln(a ...any) string {
+	return string(appendPrintln(nil, a))
+}
+
+func Sprintf(format string, a ...any) string {
+	return string(appendPrintf(nil, format, a))
+}
+
+func appendPrint(b []byte, a []any) []byte {
+	for i, arg := range a {
+		if i > 0 && kind(arg) != kindString && kind(a[i-1]) != kindString {
+			b = append(b, ' ')
+		}
+		b = appendValue(b, arg, 'v')
+	}
+	return b
+}
+
+func appendPrintln(b []byte, a []any) []byte {
+	for i, arg := range a {
+		if i > 0 {
+			b = append(b, ' ')
+		}
+		b = appendValue(b, arg, 'v')
+	}
+	return append(b, '\n')
+}
+
+// appendPrintf supports the verbs %d %s %v %x %X %q %t %c %%, the flags '-' and '0' and the width
+func appendPrintf(b []byte, format string, a []any) []byte {
+	argNum := 0
+	for i := 0; i < len(format); i++ {
+		c := format[i]
+		if c != '%' {
+			b = append(b, c)
+			continue
+		}
+		i++
+		minus := false
+		zero := false
+		for ; i < len(format); i++ {
+			if format[i] == '-' {
+				minus = true
+			} else if format[i] == '0' {
+				zero = true
+			} else {
+				break
+			}
+		}
+		width := 0
+		for ; i < len(format) && format[i] >= '0' && format[i] <= '9'; i++ {
+			width = width*10 + int(format[i]-'0')
+		}
+		if i >= len(format) {
+			b = append(b, "%!(NOVERB)"...)
+			break
+		}
+		verb := format[i]
+		if verb == '%' {
+			b = append(b, '%')
+			continue
+		}
+		if argNum >= len(a) {
+			b = append(b, '%', '!', verb)
+			b = append(b, "(MISSING)"...)
+			continue
+		}
+		b = appendPadded(b, appendValue(nil, a[argNum], verb), width, minus, zero)
+		argNum++
+	}
+	if argNum < len(a) {
+		b = append(b, "%!(EXTRA "...)
+		for i := argNum; i < len(a); i++ {
+			if i > argNum {
+				b = append(b, ", "...)
+			}
+			b = appendTyped(b, a[i])
+		}
+		b = append(b, ')')
+	}
+	return b
+}
+
+// appendPadded appends s padded to width runes. zeros are put after the sign
+func appendPadded(b []byte, s []byte, width int, minus bool, zero bool) []byte {
+	n := width
+	for _, c := range s {
+		if c&0xc0 != 0x80 {
+			n--
+		}
+	}
+	if n <= 0 {
+		return append(b, s...)
+	}
+	if minus {
+		b = append(b, s...)
+		return appendRepeat(b, ' ', n)
+	}
+	if zero {
+		if len(s) > 0 && s[0] == '-' {
+			b = append(b, '-')
+			s = s[1:]
+		}
+		b = appendRepeat(b, '0', n)
+		return append(b, s...)
+	}
+	b = appendRepeat(b, ' ', n)
+	return append(b, s...)
+}
+
+func appendRepeat(b []byte, c byte, n int) []byte {
+	for i := 0; i < n; i++ {
+		b = append(b, c)
+	}
+	return b
+}
+
+// appendValue formats arg. errors are formatted by their Error method with the verbs for strings
+func appendValue(b []byte, arg any, verb byte) []byte {
+	if isError(arg) && (verb == 'v' || verb == 's' || verb == 'q' || verb == 'x' || verb == 'X') {
+		return appendValue(b, errorString(arg), verb)
+	}
+	switch kind(arg) {
+	case kindNil:
+		if verb == 'v' {
+			return append(b, "<nil>"...)
+		}
+		b = append(b, '%', '!', verb)
+		return append(b, "(<nil>)"...)
+	case kindBool:
+		if verb == 'v' || verb == 't' {
+			if word(arg) != 0 {
+				return append(b, "true"...)
+			}
+			return append(b, "false"...)
+		}
+	case kindInt, kindByte:
+		switch verb {
+		case 'd', 'v':
+			return appendInt(b, word(arg), 10, false)
+		case 'x':
+			return appendInt(b, word(arg), 16, false)
+		case 'X':
+			return appendInt(b, word(arg), 16, true)
+		case 'c':
+			return appendRune(b, word(arg))
+		case 'q':
+			b = append(b, '\'')
+			b = appendRune(b, word(arg))
+			return append(b, '\'')
+		}
+	case kindString:
+		switch verb {
+		case 's', 'v':
+			return append(b, stringValue(arg)...)
+		case 'q':
+			return appendQuote(b, stringValue(arg))
+		case 'x', 'X':
+			return appendHex(b, stringValue(arg), verb == 'X')
+		}
+	case kindPointer:
+		if verb == 'v' || verb == 'p' {
+			if word(arg) == 0 {
+				return append(b, "<nil>"...)
+			}
+			b = append(b, '0', 'x')
+			return appendInt(b, word(arg), 16, false)
+		}
+	case kindSlice:
+		if typeName(arg) == "[]uint8" && verb != 'v' && verb != 'd' {
+			s := []byte{}
+			for i := 0; i < length(arg); i++ {
+				s = append(s, byte(word(index(arg, i))))
+			}
+			return appendValue(b, string(s), verb)
+		}
+		b = append(b, '[')
+		for i := 0; i < length(arg); i++ {
+			if i > 0 {
+				b = append(b, ' ')
+			}
+			b = appendValue(b, index(arg, i), verb)
+		}
+		return append(b, ']')
+	case kindStruct:
+		b = append(b, '{')
+		for i := 0; i < length(arg); i++ {
+			if i > 0 {
+				b = append(b, ' ')
+			}
+			b = appendValue(b, index(arg, i), verb)
+		}
+		return append(b, '}')
+	}
+	b = append(b, '%', '!', verb, '(')
+	b = appendTyped(b, arg)
+	return append(b, ')')
+}
+
+// appendTyped appends type=value like the arguments of bad verbs
+func appendTyped(b []byte, arg any) []byte {
+	if kind(arg) == kindNil {
+		return append(b, "<nil>"...)
+	}
+	b = append(b, typeName(arg)...)
+	b = append(b, '=')
+	return appendValue(b, arg, 'v')
+}
+
+func appendInt(b []byte, v int, base int, upper bool) []byte {
+	if v < 0 {
+		b = append(b, '-')
+		// -v overflows for the min int, so the last digit is taken from the negative value
+		if v/base != 0 {
+			b = appendUint(b, -(v / base), base, upper)
+		}
+		return append(b, digit(-(v%base), upper))
+	}
+	return appendUint(b, v, base, upper)
+}
+
+func appendUint(b []byte, v int, base int, upper bool) []byte {
+	if v >= base {
+		b = appendUint(b, v/base, base, upper)
+	}
+	return append(b, digit(v%base, upper))
+}
+
+func digit(d int, upper bool) byte {
+	if d < 10 {
+		return byte('0' + d)
+	}
+	if upper {
+		return byte('A' + d - 10)
+	}
+	return byte('a' + d - 10)
+}
+
+func appendHex(b []byte, s string, upper bool) []byte {
+	for i := 0; i < len(s); i++ {
+		b = append(b, digit(int(s[i]>>4), upper), digit(int(s[i]&0xf), upper))
+	}
+	return b
+}
+
+// appendRune appends the UTF-8 encoding of r
+func appendRune(b []byte, r int) []byte {
+	if r < 0x80 {
+		return append(b, byte(r))
+	}
+	if r < 0x800 {
+		return append(b, byte(0xc0|r>>6), byte(0x80|r&0x3f))
+	}
+	if r < 0x10000 {
+		return append(b, byte(0xe0|r>>12), byte(0x80|r>>6&0x3f), byte(0x80|r&0x3f))
+	}
+	return append(b, byte(0xf0|r>>18), byte(0x80|r>>12&0x3f), byte(0x80|r>>6&0x3f), byte(0x80|r&0x3f))
+}
+
+// appendQuote appends s as a double quoted Go string literal. bytes except ASCII control characters are kept
+func appendQuote(b []byte, s string) []byte {
+	b = append(b, '"')
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		switch c {
+		case '"', '\\':
+			b = append(b, '\\', c)
+		case '\a':
+			b = append(b, '\\', 'a')
+		case '\b':
+			b = append(b, '\\', 'b')
+		case '\f':
+			b = append(b, '\\', 'f')
+		case '\n':
+			b = append(b, '\\', 'n')
+		case '\r':
+			b = append(b, '\\', 'r')
+		case '\t':
+			b = append(b, '\\', 't')
+		case '\v':
+			b = append(b, '\\', 'v')
+		default:
+			if c < ' ' || c == 0x7f {
+				b = append(b, '\\', 'x', digit(int(c>>4), false), digit(int(c&0xf), false))
+			} else {
+				b = append(b, c)
+			}
+		}
+	}
+	return append(b, '"')
+}
@@ -0,0 +1,17 @@
+//go:build gompiler
+
+// Package io is the subset of the io package provided by gompiler.
+package io
+
+import "errors"
+
+// EOF is returned by Read when no more input is available
+var EOF = errors.New("EOF")
+
+type Reader interface {
+	Read(p []byte) (n int, err error)
+}
+
+type Writer interface {
+	Write(p []byte) (n int, err error)
+}
@@ -0,0 +1,257 @@
+//go:build gompiler
+
+// Package os is the subset of the os package provided by gompiler.
+// files are accessed by the Linux syscalls of the runtime, which return -errno on failure.
+package os
+
+import (
+	"errors"
+	"io"
+	_ "unsafe" // for go:linkname
+)
+
+// flags of OpenFile
+const (
+	O_RDONLY = 0x0
+	O_WRONLY = 0x1
+	O_RDWR   = 0x2
+	O_CREATE = 0x40
+	O_EXCL   = 0x80
+	O_TRUNC  = 0x200
+	O_APPEND = 0x400
+
+	oCloexec = 0x80000
+)
+
+var (
+	ErrInvalid = errors.New("invalid argument")
+	ErrClosed  = errors.New("file already closed")
+)
+
+// File is an open file descriptor
+type File struct {
+	fd   int
+	name string
+}
+
+var (
+	Stdin  = &File{fd: 0, name: "/dev/stdin"}
+	Stdout = &File{fd: 1, name: "/dev/stdout"}
+	Stderr = &File{fd: 2, name: "/dev/stderr"}
+)
+
+// Args hold the command-line arguments, starting with the program name
+var Args = args()
+
+//go:linkname Exit runtime.exit
+func Exit(code int)
+
+//go:linkname args runtime.args
+func args() []string
+
+//go:linkname envs runtime.envs
+func envs() []string
+
+//go:linkname read runtime.read
+func read(fd int, b []byte) int
+
+//go:linkname write runtime.write
+func write(fd int, b []byte) int
+
+//go:linkname open runtime.open
+func open(path []byte, flag int, perm int) int
+
+//go:linkname closeFd runtime.close
+func closeFd(fd int) int
+
+// PathError records an error and the operation and file path that caused it
+type PathError struct {
+	Op   string
+	Path string
+	Err  error
+}
+
+func (e *PathError) Error() string {
+	return e.Op + " " + e.Path + ": " + e.Err.Error()
+}
+
+// errno is an error number of Linux returned by a syscall
+type errno int
+
+func (e errno) Error() string {
+	switch e {
+	case 1:
+		return "operation not permitted"
+	case 2:
+		return "no such file or directory"
+	case 5:
+		return "input/output error"
+	case 9:
+		return "bad file descriptor"
+	case 13:
+		return "permission denied"
+	case 17:
+		return "file exists"
+	case 20:
+		return "not a directory"
+	case 21:
+		return "is a directory"
+	case 22:
+		return "invalid argument"
+	case 24:
+		return "too many open files"
+	case 28:
+		return "no space left on device"
+	case 30:
+		return "read-only file system"
+	case 36:
+		return "file name too long"
+	}
+	return "errno " + itoa(int(e))
+}
+
+func itoa(n int) string {
+	if n < 0 {
+		return "-" + itoa(-n)
+	}
+	if n < 10 {
+		return string([]byte{byte('0' + n)})
+	}
+	return itoa(n/10) + itoa(n%10)
+}
+
+// Getenv returns the value of the environment variable key, or "" if it is not set
+func Getenv(key string) string {
+	value, _ := LookupEnv(key)
+	return value
+}
+
+// LookupEnv returns the value of the environment variable key and whether it is set
+func LookupEnv(key string) (string, bool) {
+	for _, kv := range envs() {
+		if len(kv) > len(key) && kv[len(key)] == '=' && kv[:len(key)] == key {
+			return kv[len(key)+1:], true
+		}
+	}
+	return "", false
+}
+
+// Open opens the named file for reading
+func Open(name string) (*File, error) {
+	return OpenFile(name, O_RDONLY, 0)
+}
+
+// Create creates or truncates the named file with the permission 0666
+func Create(name string) (*File, error) {
+	return OpenFile(name, O_RDWR|O_CREATE|O_TRUNC, 0666)
+}
+
+// OpenFile opens the named file with the flags like O_RDONLY and the permission used when the file is created
+func OpenFile(name string, flag int, perm int) (*File, error) {
+	fd := open(append([]byte(name), 0), flag|oCloexec, perm)
+	if fd < 0 {
+		return nil, &PathError{Op: "open", Path: name, Err: errno(-fd)}
+	}
+	return &File{fd: fd, name: name}, nil
+}
+
+// Name returns the name of the file as presented to Open
+func (f *File) Name() string {
+	return f.name
+}
+
+// Read reads up to len(b) bytes. it returns 0, io.EOF at the end of the file
+func (f *File) Read(b []byte) (n int, err error) {
+	err = f.checkValid("read")
+	if err != nil {
+		return 0, err
+	}
+	n = read(f.fd, b)
+	if n < 0 {
+		return 0, &PathError{Op: "read", Path: f.name, Err: errno(-n)}
+	}
+	if n == 0 && len(b) > 0 {
+		return 0, io.EOF
+	}
+	return n, nil
+}
+
+// Write writes all the bytes of b unless an error occurs
+func (f *File) Write(b []byte) (n int, err error) {
+	err = f.checkValid("write")
+	if err != nil {
+		return 0, err
+	}
+	for n < len(b) {
+		m := write(f.fd, b[n:])
+		if m < 0 {
+			return n, &PathError{Op: "write", Path: f.name, Err: errno(-m)}
+		}
+		n += m
+	}
+	return n, nil
+}
+
+func (f *File) WriteString(s string) (n int, err error) {
+	return f.Write([]byte(s))
+}
+
+// Close closes the file. closing it again returns ErrClosed
+func (f *File) Close() error {
+	err := f.checkValid("close")
+	if err != nil {
+		return err
+	}
+	ret := closeFd(f.fd)
+	f.fd = -1
+	if ret < 0 {
+		return &PathError{Op: "close", Path: f.name, Err: errno(-ret)}
+	}
+	return nil
+}
+
+func (f *File) checkValid(op string) error {
+	if f == nil {
+		return ErrInvalid
+	}
+	if f.fd < 0 {
+		return &PathError{Op: op, Path: f.name, Err: ErrClosed}
+	}
+	return nil
+}
+
+// ReadFile reads the whole named file
+func ReadFile(name string) ([]byte, error) {
+	f, err := Open(name)
+	if err != nil {
+		return nil, err
+	}
+	data := make([]byte, 0, 512)
+	buf := make([]byte, 512)
+	for {
+		n, err := f.Read(buf)
+		data = append(data, buf[:n]...)
+		if err == io.EOF {
+			f.Close()
+			return data, nil
+		}
+		if err != nil {
+			f.Close()
+			return nil, err
+		}
+	}
+}
+
+// WriteFile writes data to the named file, creating it with perm if necessary
+func WriteFile(name string, data []byte, perm int) error {
+	f, err := OpenFile(name, O_WRONLY|O_CREATE|O_TRUNC, perm)
+	if err != nil {
+		return err
+	}
+	_, err = f.Write(data)
+	cerr := f.Close()
+	if err == nil {
+		err = cerr
+	}
+	return err
+}
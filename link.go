@@ -0,0 +1,174 @@
+package main
+
+import (
+	"encoding/binary"
+	"fmt"
+)
+
+// the executable is loaded at linkBase like the ones of ld
+const (
+	linkBase     = 0x400000
+	linkPageSize = 0x1000
+	linkAlign    = 16 // the alignment of the sections in the executable
+
+	ptLoad      = 1
+	ptGnuStack  = 0x6474e551
+	pfX         = 1
+	pfW         = 2
+	pfR         = 4
+	elfProgSize = 56
+)
+
+// linkSegment is a loadable segment of the sections with the same permissions
+type linkSegment struct {
+	flags        uint32
+	sections     []*objSection
+	offset, addr uint64
+	size         uint64
+}
+
+// linkELF links obj, which has the runtime as well as the program, into a static executable starting at _start.
+// the sections are put into the segments of the code, the read-only data and the writable data by their flags.
+// every segment starts at a page so that its address and its offset in the file are the same modulo the page size
+func linkELF(obj *object) []byte {
+	le := binary.LittleEndian
+	segments := []*linkSegment{{flags: pfR | pfX}, {flags: pfR}, {flags: pfR | pfW}}
+	for _, s := range obj.sections {
+		if len(s.data) == 0 { // the empty sections are dropped like ld. .bss is always empty
+			continue
+		}
+		seg := segments[1]
+		switch {
+		case s.flags&shfExecinstr != 0:
+			seg = segments[0]
+		case s.flags&shfWrite != 0:
+			seg = segments[2]
+		}
+		seg.sections = append(seg.sections, s)
+	}
+	loads := segments[:1] // the first segment has the headers even without code
+	for _, seg := range segments[1:] {
+		if len(seg.sections) > 0 {
+			loads = append(loads, seg)
+		}
+	}
+	phnum := len(loads) + 1 // and PT_GNU_STACK
+
+	// lay the sections out and give them addresses
+	addrs := map[*objSection]uint64{}
+	offsets := map[*objSection]uint64{}
+	offset := uint64(elfHeaderSize + phnum*elfProgSize)
+	for i, seg := range loads {
+		if i > 0 {
+			offset = alignUp(offset, linkPageSize)
+			seg.offset = offset
+		}
+		seg.addr = linkBase + seg.offset
+		for _, s := range seg.sections {
+			offset = alignUp(offset, linkAlign)
+			offsets[s] = offset
+			addrs[s] = linkBase + offset
+			offset += uint64(len(s.data))
+			seg.size = offset - seg.offset
+		}
+	}
+
+	address := func(sym *objSymbol) uint64 {
+		if sym.section == nil {
+			must(fmt.Errorf("ld: undefined reference to %s", sym.name))
+		}
+		return addrs[sym.section] + uint64(sym.value)
+	}
+	var entry *objSymbol
+	for _, sym := range obj.symbols {
+		if sym.name == "_start" && sym.section != nil {
+			entry = sym
+		}
+	}
+	if entry == nil {
+		must(fmt.Errorf("ld: _start is not defined"))
+	}
+
+	// apply the relocations to copies of the sections
+	data := map[*objSection][]byte{}
+	for _, s := range obj.sections {
+		b := append([]byte(nil), s.data...)
+		for _, r := range s.relocs {
+			v := int64(address(r.sym)) + r.addend
+			switch r.typ {
+			case rX86_64_64:
+				le.PutUint64(b[r.offset:], uint64(v))
+				continue
+			case rX86_64_PC32, rX86_64_PLT32:
+				v -= int64(addrs[s]) + r.offset
+			case rX86_64_32S:
+			default:
+				must(fmt.Errorf("ld: unsupported relocation %d in %s", r.typ, s.name))
+			}
+			if !fitsInt32(v) {
+				must(fmt.Errorf("ld: relocation to %s in %s is out of range", r.sym.name, s.name))
+			}
+			le.PutUint32(b[r.offset:], uint32(v))
+		}
+		data[s] = b
+	}
+
+	// the section headers and the symbol table are kept for objdump and the debuggers
+	sections := []*elfSection{{}}
+	index := map[*objSection]uint16{}
+	for _, seg := range loads {
+		for _, s := range seg.sections {
+			index[s] = uint16(len(sections))
+			sections = append(sections, &elfSection{name: s.name, typ: s.typ, flags: s.flags, addr: addrs[s], data: data[s],
+				offset: offsets[s], addralign: linkAlign})
+		}
+	}
+	strtab := newElfStrtab()
+	symtab := make([]byte, elfSymSize)
+	var globals []byte
+	firstGlobal := 1
+	for _, sym := range obj.symbols {
+		if sym.isSection || index[sym.section] == 0 {
+			continue
+		}
+		if sym.global {
+			globals = appendElfSym(globals, strtab.add(sym.name), stbGlobal<<4|sttNotype, index[sym.section], address(sym))
+			continue
+		}
+		symtab = appendElfSym(symtab, strtab.add(sym.name), stbLocal<<4|sttNotype, index[sym.section], address(sym))
+		firstGlobal++
+	}
+	symtabIndex := uint32(len(sections))
+	sections = append(sections,
+		&elfSection{name: ".symtab", typ: shtSymtab, data: append(symtab, globals...), link: symtabIndex + 1, info: uint32(firstGlobal), addralign: 8, entsize: elfSymSize},
+		&elfSection{name: ".strtab", typ: shtStrtab, data: strtab.b, addralign: 1})
+
+	var phdrs []byte
+	for _, seg := range loads {
+		phdrs = appendElfProg(phdrs, ptLoad, seg.flags, seg.offset, seg.addr, seg.size, seg.size, linkPageSize)
+	}
+	phdrs = appendElfProg(phdrs, ptGnuStack, pfR|pfW, 0, 0, 0, 0, linkAlign) // the stack is not executable
+
+	b := make([]byte, elfHeaderSize, offset)
+	b = append(b, phdrs...)
+	b, shoff := writeSections(b, sections)
+	copy(b, elfHeader(2, address(entry), phnum, shoff, len(sections)+1, len(sections)))
+	return b
+}
+
+// appendElfProg appends a program header
+func appendElfProg(b []byte, typ, flags uint32, offset, addr, filesz, memsz, align uint64) []byte {
+	le := binary.LittleEndian
+	b = le.AppendUint32(b, typ)
+	b = le.AppendUint32(b, flags)
+	b = le.AppendUint64(b, offset)
+	b = le.AppendUint64(b, addr)
+	b = le.AppendUint64(b, addr) // the physical address
+	b = le.AppendUint64(b, filesz)
+	b = le.AppendUint64(b, memsz)
+	return le.AppendUint64(b, align)
+}
+
+func alignUp(n, align uint64) uint64 {
+	return (n + align - 1) &^ (align - 1)
+}
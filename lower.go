@@ -0,0 +1,1395 @@
+package main
+
+import (
+	"fmt"
+	"go/ast"
+	"go/token"
+
+	"github.com/lkeix/gompiler/ir"
+)
+
+// every function is lowered to the IR and compiled by emitIRFunc. a value is the registers of its words like in memory,
+// so a string is a pointer and an int, and a struct is the words of its fields. a local variable is held by registers
+// unless its address is taken: a variable in the heap is a register pointing to it, and the other ones whose address
+// is taken live in a frame slot. -ir=false compiles every function from the AST by emitDeclFunc
+
+var (
+	// useIR is set by -ir
+	useIR = true
+)
+
+// irVar is the location of a local variable or a parameter
+type irVar struct {
+	regs []ir.Reg // the words of the variable, or the pointer to the variable in the heap
+	heap bool
+	slot int // the frame slot of the variable whose address is taken, or -1
+}
+
+type lowerer struct {
+	fnc       *Func
+	fn        *ir.Func
+	cur       *ir.Block
+	vars      map[*ast.Object]*irVar
+	addressed map[*ast.Object]bool // local variables whose address is taken by &x
+	breaks    []*ir.Block
+	continues []*ir.Block
+	pos       token.Pos // of the statement being lowered
+}
+
+// irVars are the locations of the local variables and the parameters of the lowered functions, for the debug info
+var irVars = map[*ir.Func]map[*ast.Object]*irVar{}
+
+// lowerFunc lowers fnc to the IR
+func lowerFunc(fnc *Func) *ir.Func {
+	curPkg = fnc.pkg
+	curTypeArgs = fnc.typeArgs
+	defer func() { curTypeArgs = nil }()
+
+	var fields []*ast.Field // the receiver is the first parameter
+	if fnc.decl.Recv != nil {
+		fields = append(fields, fnc.decl.Recv.List...)
+	}
+	fields = append(fields, fnc.decl.Type.Params.List...)
+	var params, results []ir.Type
+	for _, field := range fields {
+		for i := 0; i < len(field.Names) || i == 0; i++ {
+			params = append(params, irTypes(getType(field.Type))...)
+		}
+	}
+	retType := resultType(fnc)
+	if retType != nil {
+		results = irTypes(retType)
+	}
+	l := newLowerer(fnc, ir.NewFunc(funcSymbol(fnc.pkg.path, fnc.name), params, results))
+	l.fn.RegABI = usesRegABI(fnc)
+	l.fn.MemResults = retType != nil && isResultInMemory(retType)
+	l.addressed = addressedVars(fnc.decl.Body)
+
+	// the words of the parameters are the first registers, and the ones moved to memory are copied after all are read
+	type paramVar struct {
+		obj  *ast.Object
+		regs []ir.Reg
+	}
+	var moved []paramVar
+	for _, field := range fields {
+		names := field.Names
+		if len(names) == 0 { // unnamed parameter
+			names = []*ast.Ident{ast.NewIdent("_")}
+		}
+		for _, name := range names {
+			var regs []ir.Reg
+			for _, typ := range irTypes(getType(field.Type)) {
+				r := l.fn.NewReg(typ, name.Name)
+				l.cur.Add(&ir.Instr{Op: ir.Param, Dst: r, Imm: int(r)})
+				regs = append(regs, r)
+			}
+			switch {
+			case name.Obj == nil || name.Name == "_":
+			case isHeapVar(name.Obj) || l.addressed[name.Obj]:
+				moved = append(moved, paramVar{name.Obj, regs})
+			default:
+				l.vars[name.Obj] = &irVar{regs: regs, slot: -1}
+			}
+		}
+	}
+	for _, p := range moved {
+		l.declare(p.obj)
+		l.store(l.varLvalue(p.obj), p.regs)
+	}
+	for _, name := range namedResults(fnc.decl) {
+		if name.Name != "_" {
+			l.declare(name.Obj)
+			l.store(l.varLvalue(name.Obj), l.zero(varType(name.Obj)))
+		}
+	}
+
+	l.stmts(fnc.decl.Body.List)
+	if !l.cur.Done {
+		// a function with results ends with a return statement, so the last block is unreachable unless the function returns nothing
+		l.pos = fnc.decl.Body.Rbrace
+		l.returnStmt(&ast.ReturnStmt{})
+	}
+	return l.finish()
+}
+
+// lowerPackageInit lowers pkg.init, which initializes the imported packages, the package level variables
+// and calls func init() in this order. every package is initialized once
+func lowerPackageInit(pkg *Package, fnc *Func) *ir.Func {
+	curPkg = pkg
+	l := newLowerer(fnc, ir.NewFunc(symbol(pkg.path+".init"), nil, nil))
+	done := l.newReg(ir.Int)
+	l.add(&ir.Instr{Op: ir.Load, Dst: done, Sym: symbol(pkg.path + ".initdone")})
+	start, ret := l.fn.NewBlock(), l.fn.NewBlock()
+	l.end(ir.If, l.binop(ir.Ne, ir.Bool, done, l.constant(ir.Int, 0)), ret, start)
+	l.startBlock(ret)
+	l.end(ir.Return, ir.NoReg)
+
+	l.startBlock(start)
+	l.add(&ir.Instr{Op: ir.Store, Dst: ir.NoReg, Args: []ir.Reg{l.constant(ir.Int, 1)}, Sym: symbol(pkg.path + ".initdone")})
+	for _, imported := range pkg.imports {
+		l.callSym(symbol(imported.path+".init"), nil)
+	}
+	for _, vi := range pkg.varInits {
+		switch len(vi.names) {
+		case 0:
+			l.expr(vi.value)
+		case 1:
+			name := vi.names[0]
+			l.store(l.varLvalue(name.Obj), l.exprAs(vi.value, varType(name.Obj)))
+		default:
+			values := l.splitTuple(getType(vi.value), l.expr(vi.value))
+			for i, name := range vi.names {
+				if name.Name != "_" {
+					l.store(l.varLvalue(name.Obj), values[i])
+				}
+			}
+		}
+	}
+	for _, initFunc := range pkg.initFuncs {
+		l.callSym(funcSymbol(pkg.path, initFunc.name), nil)
+	}
+	l.end(ir.Return, ir.NoReg)
+	return l.finish()
+}
+
+func newLowerer(fnc *Func, f *ir.Func) *lowerer {
+	return &lowerer{fnc: fnc, fn: f, cur: f.Blocks[0], vars: map[*ast.Object]*irVar{}, addressed: map[*ast.Object]bool{}}
+}
+
+// finish drops the unreachable blocks and verifies the function lowered
+func (l *lowerer) finish() *ir.Func {
+	l.fn.RemoveUnreachable()
+	must(ir.Verify(l.fn))
+	irVars[l.fn] = l.vars
+	return l.fn
+}
+
+// addressedVars returns the variables x whose address is taken by &x, &x.f or a method call with a pointer receiver on x
+func addressedVars(body *ast.BlockStmt) map[*ast.Object]bool {
+	addressed := map[*ast.Object]bool{}
+	var root func(expr ast.Expr)
+	root = func(expr ast.Expr) {
+		switch e := expr.(type) {
+		case *ast.Ident:
+			if e.Obj != nil && e.Obj.Kind == ast.Var {
+				addressed[e.Obj] = true
+			}
+		case *ast.ParenExpr:
+			root(e.X)
+		case *ast.SelectorExpr:
+			if qualifiedIdent(e) == nil && !isPointer(getType(e.X)) {
+				root(e.X)
+			}
+		}
+	}
+	ast.Inspect(body, func(n ast.Node) bool {
+		switch e := n.(type) {
+		case *ast.UnaryExpr:
+			if e.Op == token.AND {
+				root(e.X)
+			}
+		case *ast.CallExpr:
+			if sel := methodSelector(e); sel != nil && !isInterface(getType(sel.X)) && !isPointer(getType(sel.X)) {
+				if method := lookupMethod(getType(sel.X), sel.Sel.Name); hasPointerRecv(method.decl) {
+					root(sel.X)
+				}
+			}
+		}
+		return true
+	})
+	return addressed
+}
+
+// irTypes returns the IR types of the words of the values of typ
+func irTypes(typ *ast.Object) []ir.Type {
+	if typ == untypedNil || isPointer(typ) {
+		return []ir.Type{ir.Ptr}
+	}
+	u := underlying(typ)
+	switch {
+	case u == globalInt:
+		return []ir.Type{ir.Int}
+	case u == globalBool:
+		return []ir.Type{ir.Bool}
+	case u == globalByte:
+		return []ir.Type{ir.Byte}
+	case u == globalString:
+		return []ir.Type{ir.Ptr, ir.Int}
+	case isSlice(u):
+		return []ir.Type{ir.Ptr, ir.Int, ir.Int}
+	case isInterface(u): // the type descriptor and the data
+		return []ir.Type{ir.Ptr, ir.Ptr}
+	case isTuple(u):
+		var types []ir.Type
+		for _, t := range tupleTypes(u) {
+			types = append(types, irTypes(t)...)
+		}
+		return types
+	case isStruct(u):
+		var types []ir.Type
+		for _, field := range structFields(u) {
+			types = append(types, irTypes(field.typ)...)
+		}
+		return types
+	}
+	must(fmt.Errorf("type %s has no representation in the IR", typeString(typ)))
+	return nil
+}
+
+// startBlock continues lowering in b
+func (l *lowerer) startBlock(b *ir.Block) {
+	l.cur = b
+}
+
+// end ends the current block at the statement being lowered
+func (l *lowerer) end(kind ir.BlockKind, ctrl ir.Reg, succs ...*ir.Block) {
+	l.cur.End(kind, ctrl, succs...)
+	l.cur.Pos = l.pos
+}
+
+// jump ends the current block by a jump to b unless it is terminated already
+func (l *lowerer) jump(b *ir.Block) {
+	if !l.cur.Done {
+		l.end(ir.Jump, ir.NoReg, b)
+	}
+}
+
+func (l *lowerer) add(instr *ir.Instr) {
+	if l.cur.Done { // unreachable code after return, break or continue
+		l.startBlock(l.fn.NewBlock())
+	}
+	if instr.Pos == token.NoPos {
+		instr.Pos = l.pos
+	}
+	l.cur.Add(instr)
+}
+
+func (l *lowerer) newReg(typ ir.Type) ir.Reg {
+	return l.fn.NewReg(typ, "")
+}
+
+func (l *lowerer) constant(typ ir.Type, value int) ir.Reg {
+	r := l.newReg(typ)
+	l.add(&ir.Instr{Op: ir.Const, Dst: r, Imm: value})
+	return r
+}
+
+// zero returns the zero value of typ
+func (l *lowerer) zero(typ *ast.Object) []ir.Reg {
+	var words []ir.Reg
+	for _, t := range irTypes(typ) {
+		words = append(words, l.constant(t, 0))
+	}
+	return words
+}
+
+// binop returns x op y in a new register of typ
+func (l *lowerer) binop(op ir.Op, typ ir.Type, x, y ir.Reg) ir.Reg {
+	r := l.newReg(typ)
+	l.add(&ir.Instr{Op: op, Dst: r, Args: []ir.Reg{x, y}})
+	return r
+}
+
+// convert returns the word r as typ
+func (l *lowerer) convert(r ir.Reg, typ ir.Type) ir.Reg {
+	if l.fn.Regs[r] == typ {
+		return r
+	}
+	dst := l.newReg(typ)
+	l.add(&ir.Instr{Op: ir.Convert, Dst: dst, Args: []ir.Reg{r}})
+	return dst
+}
+
+// copyRegs copies the words to new registers
+func (l *lowerer) copyRegs(words []ir.Reg) []ir.Reg {
+	var copies []ir.Reg
+	for _, r := range words {
+		c := l.newReg(l.fn.Regs[r])
+		l.add(&ir.Instr{Op: ir.Copy, Dst: c, Args: []ir.Reg{r}})
+		copies = append(copies, c)
+	}
+	return copies
+}
+
+// callSym calls the function sym with the stack ABI and returns the words of the results of the types
+func (l *lowerer) callSym(sym string, args []ir.Reg, results ...ir.Type) []ir.Reg {
+	rets := make([]ir.Reg, len(results))
+	for i, typ := range results {
+		rets[i] = l.newReg(typ)
+	}
+	l.add(&ir.Instr{Op: ir.Call, Dst: ir.NoReg, Args: args, Rets: rets, Sym: sym})
+	return rets
+}
+
+// alloc allocates size bytes in the heap
+func (l *lowerer) alloc(size int) ir.Reg {
+	return l.callSym("runtime.alloc", []ir.Reg{l.constant(ir.Int, size)}, ir.Ptr)[0]
+}
+
+// check jumps to the runtime routine sym, which panics, unless x op y
+func (l *lowerer) check(op ir.Op, x, y ir.Reg, sym string) {
+	l.add(&ir.Instr{Op: ir.Check, Dst: ir.NoReg, Args: []ir.Reg{l.binop(op, ir.Bool, x, y)}, Sym: sym})
+}
+
+// splitTuple splits the words of a value of the tuple typ into the ones of its elements
+func (l *lowerer) splitTuple(typ *ast.Object, words []ir.Reg) [][]ir.Reg {
+	var values [][]ir.Reg
+	for _, t := range tupleTypes(typ) {
+		n := len(irTypes(t))
+		values = append(values, words[:n])
+		words = words[n:]
+	}
+	return values
+}
+
+// lvalue is where a value is assigned: the registers of a variable, the memory at base+off, or a package level variable at sym+off.
+// packed is for the bytes of slices and strings, which take a byte instead of a word
+type lvalue struct {
+	regs   []ir.Reg
+	base   ir.Reg
+	sym    string
+	off    int
+	types  []ir.Type
+	packed bool
+}
+
+func (lv lvalue) size() int {
+	if lv.packed {
+		return 1
+	}
+	return 8
+}
+
+// load returns the words of the value at lv. the registers of a variable are returned as they are
+func (l *lowerer) load(lv lvalue) []ir.Reg {
+	if lv.regs != nil {
+		return lv.regs
+	}
+	var words []ir.Reg
+	for i, typ := range lv.types {
+		r := l.newReg(typ)
+		if lv.sym != "" {
+			l.add(&ir.Instr{Op: ir.Load, Dst: r, Sym: lv.sym, Imm: lv.off + 8*i})
+		} else {
+			l.add(&ir.Instr{Op: ir.LoadMem, Dst: r, Args: []ir.Reg{lv.base}, Imm: lv.off + 8*i, Size: lv.size()})
+		}
+		words = append(words, r)
+	}
+	return words
+}
+
+// store assigns the words of a value to lv
+func (l *lowerer) store(lv lvalue, value []ir.Reg) {
+	if lv.regs != nil {
+		// a value made of the registers of the variable like s = S{a: s.b, b: s.a} is copied before any of them is assigned
+		for i, src := range value {
+			for j, dst := range lv.regs {
+				if src == dst && i != j {
+					value = l.copyRegs(value)
+				}
+			}
+		}
+		for i, r := range lv.regs {
+			if r != value[i] {
+				l.add(&ir.Instr{Op: ir.Copy, Dst: r, Args: []ir.Reg{value[i]}})
+			}
+		}
+		return
+	}
+	for i, v := range value {
+		if lv.sym != "" {
+			l.add(&ir.Instr{Op: ir.Store, Dst: ir.NoReg, Args: []ir.Reg{v}, Sym: lv.sym, Imm: lv.off + 8*i})
+		} else {
+			l.add(&ir.Instr{Op: ir.StoreMem, Dst: ir.NoReg, Args: []ir.Reg{lv.base, v}, Imm: lv.off + 8*i, Size: lv.size()})
+		}
+	}
+}
+
+// declare makes the location of the local variable obj. a variable in the heap is allocated each time it is declared
+func (l *lowerer) declare(obj *ast.Object) {
+	typ := varType(obj)
+	v, ok := l.vars[obj]
+	if !ok {
+		v = &irVar{slot: -1}
+		switch {
+		case isHeapVar(obj):
+			v.heap = true
+			v.regs = []ir.Reg{l.fn.NewReg(ir.Ptr, obj.Name)}
+		case l.addressed[obj]:
+			v.slot = l.fn.NewSlot(sizeOf(typ))
+		default:
+			for _, t := range irTypes(typ) {
+				v.regs = append(v.regs, l.fn.NewReg(t, obj.Name))
+			}
+		}
+		l.vars[obj] = v
+	}
+	if v.heap {
+		l.add(&ir.Instr{Op: ir.Call, Dst: ir.NoReg, Args: []ir.Reg{l.constant(ir.Int, sizeOf(typ))}, Rets: v.regs, Sym: "runtime.alloc"})
+	}
+}
+
+// renewHeapVars allocates new copies of the variables in the heap declared by the init statement of a for loop,
+// before its post statement runs, like emitRenewHeapVars
+func (l *lowerer) renewHeapVars(init ast.Stmt) {
+	for _, name := range declaredNames(init) {
+		v := l.vars[name.Obj]
+		if v == nil || !v.heap {
+			continue
+		}
+		prev := l.copyRegs(v.regs)[0]
+		l.declare(name.Obj)
+		types := irTypes(varType(name.Obj))
+		l.store(lvalue{base: v.regs[0], types: types}, l.load(lvalue{base: prev, types: types}))
+	}
+}
+
+// varLvalue returns the location of the variable obj. variables which are not local are package level variables
+func (l *lowerer) varLvalue(obj *ast.Object) lvalue {
+	types := irTypes(varType(obj))
+	v, ok := l.vars[obj]
+	switch {
+	case !ok:
+		if getObjectData(obj) != -1 {
+			must(fmt.Errorf("%s: the variable %s is used before its declaration", l.fn.Name, obj.Name))
+		}
+		return lvalue{sym: globalSymbol(obj), types: types}
+	case v.heap:
+		return lvalue{base: v.regs[0], types: types}
+	case v.slot >= 0:
+		addr := l.newReg(ir.Ptr)
+		l.add(&ir.Instr{Op: ir.Addr, Dst: addr, Imm: v.slot})
+		return lvalue{base: addr, types: types}
+	}
+	return lvalue{regs: v.regs, types: types}
+}
+
+// lvalue returns the location of the addressable expression or the variable expr
+func (l *lowerer) lvalue(expr ast.Expr) lvalue {
+	switch e := expr.(type) {
+	case *ast.Ident:
+		return l.varLvalue(e.Obj)
+	case *ast.ParenExpr:
+		return l.lvalue(e.X)
+	case *ast.StarExpr:
+		return lvalue{base: l.expr(e.X)[0], types: irTypes(getType(e))}
+	case *ast.SelectorExpr:
+		if ident := qualifiedIdent(e); ident != nil {
+			return l.varLvalue(ident.Obj)
+		}
+		field := lookupField(getType(e.X), e.Sel.Name)
+		types := irTypes(field.typ)
+		if isPointer(getType(e.X)) { // p.x is (*p).x
+			return lvalue{base: l.expr(e.X)[0], off: field.offset, types: types}
+		}
+		lv := l.lvalue(e.X)
+		if lv.regs != nil {
+			lv.regs = lv.regs[field.offset/8 : field.offset/8+len(types)]
+		} else {
+			lv.off += field.offset
+		}
+		lv.types = types
+		return lv
+	case *ast.IndexExpr:
+		return l.indexLvalue(e)
+	}
+	must(fmt.Errorf("cannot assign to %s", exprString(expr)))
+	return lvalue{}
+}
+
+// indexLvalue returns the element s[i] of a slice or the byte of a string after the bounds check
+func (l *lowerer) indexLvalue(e *ast.IndexExpr) lvalue {
+	typ := underlying(getType(e.X))
+	x := l.expr(e.X)
+	i := l.exprAs(e.Index, globalInt)[0]
+	l.check(ir.LtU, i, x[1], "runtime.panicindex")
+	elem := globalByte
+	if typ != globalString {
+		elem = sliceElem(typ)
+	}
+	size := elemSize(elem)
+	return lvalue{base: l.elemAddr(x[0], i, size), types: irTypes(elem), packed: size == 1}
+}
+
+// elemAddr returns the address of the element i of size bytes from ptr
+func (l *lowerer) elemAddr(ptr, i ir.Reg, size int) ir.Reg {
+	if size != 1 {
+		i = l.binop(ir.Mul, ir.Int, i, l.constant(ir.Int, size))
+	}
+	return l.binop(ir.Add, ir.Ptr, ptr, i)
+}
+
+// addrOf returns &expr. composite literals are allocated in the heap
+func (l *lowerer) addrOf(expr ast.Expr) ir.Reg {
+	if lit, ok := expr.(*ast.CompositeLit); ok {
+		typ := getType(lit.Type)
+		value := l.compositeLit(lit)
+		ptr := l.alloc(sizeOf(typ))
+		l.store(lvalue{base: ptr, types: irTypes(typ)}, value)
+		return ptr
+	}
+	lv := l.lvalue(expr)
+	switch {
+	case lv.regs != nil:
+		must(fmt.Errorf("cannot take the address of %s in registers", exprString(expr)))
+	case lv.sym != "":
+		r := l.newReg(ir.Ptr)
+		l.add(&ir.Instr{Op: ir.SymAddr, Dst: r, Sym: lv.sym, Imm: lv.off})
+		return r
+	case lv.off != 0:
+		return l.binop(ir.Add, ir.Ptr, lv.base, l.constant(ir.Int, lv.off))
+	}
+	return lv.base
+}
+
+func (l *lowerer) stmts(list []ast.Stmt) {
+	for _, stmt := range list {
+		l.stmt(stmt)
+	}
+}
+
+func (l *lowerer) stmt(stmt ast.Stmt) {
+	if l.cur.Done {
+		l.startBlock(l.fn.NewBlock())
+	}
+	if stmt.Pos().IsValid() { // the statements made by desugaring have no position
+		defer func(pos token.Pos) { l.pos = pos }(l.pos)
+		l.pos = stmt.Pos()
+	}
+	switch s := stmt.(type) {
+	case *ast.ExprStmt:
+		l.expr(s.X)
+	case *ast.DeclStmt:
+		l.localDecl(s)
+	case *ast.AssignStmt:
+		l.assign(s)
+	case *ast.IncDecStmt: // x++ is x += 1
+		op := token.ADD
+		if s.Tok == token.DEC {
+			op = token.SUB
+		}
+		l.opAssign(s.X, op, &ast.BasicLit{Kind: token.INT, Value: "1"})
+	case *ast.ReturnStmt:
+		l.returnStmt(s)
+	case *ast.BlockStmt:
+		l.stmts(s.List)
+	case *ast.IfStmt:
+		l.ifStmt(s)
+	case *ast.ForStmt:
+		l.forStmt(s)
+	case *ast.RangeStmt:
+		l.stmt(desugared[s])
+	case *ast.SwitchStmt:
+		if d := desugared[s]; d != nil {
+			l.stmt(d)
+		} else {
+			l.switchStmt(s)
+		}
+	case *ast.BranchStmt:
+		switch s.Tok {
+		case token.BREAK:
+			l.end(ir.Jump, ir.NoReg, l.breaks[len(l.breaks)-1])
+		case token.CONTINUE:
+			l.end(ir.Jump, ir.NoReg, l.continues[len(l.continues)-1])
+		case token.FALLTHROUGH: // the next clause follows
+		default:
+			must(fmt.Errorf("unexpected branch %s", s.Tok))
+		}
+	default:
+		must(fmt.Errorf("unexpected stmt type %T", stmt))
+	}
+}
+
+// returnStmt returns the results. return without results returns the named results
+func (l *lowerer) returnStmt(stmt *ast.ReturnStmt) {
+	retType := resultType(l.fnc)
+	var rets []ir.Reg
+	switch {
+	case retType == nil:
+	case len(stmt.Results) == 0:
+		names := namedResults(l.fnc.decl)
+		if names == nil { // the end of a function with results, which is unreachable
+			rets = l.zero(retType)
+			break
+		}
+		for _, name := range names {
+			if name.Name == "_" {
+				rets = append(rets, l.zero(varType(name.Obj))...)
+				continue
+			}
+			rets = append(rets, l.load(l.varLvalue(name.Obj))...)
+		}
+	case len(stmt.Results) == 1: // a value, or the results of a call like return f()
+		rets = l.exprAs(stmt.Results[0], retType)
+	default:
+		for i, typ := range tupleTypes(retType) {
+			rets = append(rets, l.exprAs(stmt.Results[i], typ)...)
+		}
+	}
+	l.cur.Rets = rets
+	l.end(ir.Return, ir.NoReg)
+}
+
+// localDecl declares the local variables of var x T = v, or with the zero value
+func (l *lowerer) localDecl(stmt *ast.DeclStmt) {
+	decl := stmt.Decl.(*ast.GenDecl)
+	if decl.Tok != token.VAR {
+		return
+	}
+	for _, spec := range decl.Specs {
+		valSpec := spec.(*ast.ValueSpec)
+		var values [][]ir.Reg
+		if len(valSpec.Names) > 1 && len(valSpec.Values) == 1 { // var a, b = f()
+			values = l.splitTuple(getType(valSpec.Values[0]), l.expr(valSpec.Values[0]))
+		} else {
+			for i, name := range valSpec.Names {
+				switch {
+				case i >= len(valSpec.Values):
+					values = append(values, l.zero(varType(name.Obj)))
+				case name.Name == "_":
+					values = append(values, l.expr(valSpec.Values[i]))
+				default:
+					values = append(values, l.exprAs(valSpec.Values[i], varType(name.Obj)))
+				}
+			}
+		}
+		for i, name := range valSpec.Names {
+			if name.Name != "_" {
+				l.declare(name.Obj)
+				l.store(l.varLvalue(name.Obj), values[i])
+			}
+		}
+	}
+}
+
+// assign lowers x = y, x op= y, x := y, a, b = x, y and a, b = f(). all values are evaluated before they are assigned
+func (l *lowerer) assign(stmt *ast.AssignStmt) {
+	if op, ok := assignOps[stmt.Tok]; ok {
+		l.opAssign(stmt.Lhs[0], op, stmt.Rhs[0])
+		return
+	}
+	var values [][]ir.Reg
+	if len(stmt.Lhs) > 1 && len(stmt.Rhs) == 1 {
+		values = l.splitTuple(getType(stmt.Rhs[0]), l.expr(stmt.Rhs[0]))
+	} else {
+		for i, rhs := range stmt.Rhs {
+			var value []ir.Reg
+			if isBlank(stmt.Lhs[i]) {
+				value = l.expr(rhs)
+			} else {
+				value = l.exprAs(rhs, getType(stmt.Lhs[i]))
+			}
+			if len(stmt.Lhs) > 1 { // the registers of a variable in a, b = b, a are copied before a is assigned
+				value = l.copyRegs(value)
+			}
+			values = append(values, value)
+		}
+	}
+	declared := map[*ast.Object]bool{}
+	for _, name := range declaredNames(stmt) {
+		declared[name.Obj] = true
+	}
+	for i, lhs := range stmt.Lhs {
+		if isBlank(lhs) {
+			continue
+		}
+		if ident, ok := lhs.(*ast.Ident); ok && declared[ident.Obj] {
+			l.declare(ident.Obj)
+		}
+		l.store(l.lvalue(lhs), values[i])
+	}
+}
+
+// opAssign lowers x op= y, which evaluates the location of x once
+func (l *lowerer) opAssign(lhs ast.Expr, op token.Token, rhs ast.Expr) {
+	typ := getType(lhs)
+	lv := l.lvalue(lhs)
+	var y []ir.Reg
+	if op == token.SHL || op == token.SHR {
+		y = l.expr(rhs)
+	} else {
+		y = l.exprAs(rhs, typ)
+	}
+	l.store(lv, l.binaryOp(op, typ, l.load(lv), y))
+}
+
+func (l *lowerer) ifStmt(stmt *ast.IfStmt) {
+	if stmt.Init != nil {
+		l.stmt(stmt.Init)
+	}
+	then, els, end := l.fn.NewBlock(), l.fn.NewBlock(), l.fn.NewBlock()
+	l.cond(stmt.Cond, then, els)
+	l.startBlock(then)
+	l.stmts(stmt.Body.List)
+	l.jump(end)
+	l.startBlock(els)
+	if stmt.Else != nil {
+		l.stmt(stmt.Else)
+	}
+	l.jump(end)
+	l.startBlock(end)
+}
+
+func (l *lowerer) forStmt(stmt *ast.ForStmt) {
+	if stmt.Init != nil {
+		l.stmt(stmt.Init)
+	}
+	head, body, post, end := l.fn.NewBlock(), l.fn.NewBlock(), l.fn.NewBlock(), l.fn.NewBlock()
+	l.jump(head)
+	l.startBlock(head)
+	if stmt.Cond != nil {
+		l.cond(stmt.Cond, body, end)
+	} else {
+		l.jump(body)
+	}
+	l.startBlock(body)
+	l.breaks = append(l.breaks, end)
+	l.continues = append(l.continues, post)
+	l.stmts(stmt.Body.List)
+	l.breaks = l.breaks[:len(l.breaks)-1]
+	l.continues = l.continues[:len(l.continues)-1]
+	l.jump(post)
+	l.startBlock(post)
+	if stmt.Init != nil {
+		l.renewHeapVars(stmt.Init)
+	}
+	if stmt.Post != nil {
+		l.stmt(stmt.Post)
+	}
+	l.jump(head)
+	l.startBlock(end)
+}
+
+// switchStmt lowers a switch without tag like emitSwitchStmt. a clause ending with fallthrough jumps to the body of the next one
+func (l *lowerer) switchStmt(stmt *ast.SwitchStmt) {
+	end := l.fn.NewBlock()
+	bodies := make([]*ir.Block, len(stmt.Body.List))
+	for i := range bodies {
+		bodies[i] = l.fn.NewBlock()
+	}
+	deflt := end
+	for i, clause := range stmt.Body.List {
+		cc := clause.(*ast.CaseClause)
+		if cc.List == nil {
+			deflt = bodies[i]
+			continue
+		}
+		for _, cond := range cc.List {
+			next := l.fn.NewBlock()
+			l.cond(cond, bodies[i], next)
+			l.startBlock(next)
+		}
+	}
+	l.jump(deflt)
+
+	l.breaks = append(l.breaks, end)
+	for i, clause := range stmt.Body.List {
+		cc := clause.(*ast.CaseClause)
+		l.startBlock(bodies[i])
+		l.stmts(cc.Body)
+		if n := len(cc.Body); n > 0 && i+1 < len(bodies) {
+			if branch, ok := cc.Body[n-1].(*ast.BranchStmt); ok && branch.Tok == token.FALLTHROUGH {
+				l.jump(bodies[i+1])
+				continue
+			}
+		}
+		l.jump(end)
+	}
+	l.breaks = l.breaks[:len(l.breaks)-1]
+	l.startBlock(end)
+}
+
+// cond branches to then if the condition is true, otherwise to els
+func (l *lowerer) cond(expr ast.Expr, then *ir.Block, els *ir.Block) {
+	r := l.expr(expr)[0]
+	if l.cur.Done {
+		l.startBlock(l.fn.NewBlock())
+	}
+	l.end(ir.If, r, then, els)
+}
+
+// exprAs lowers expr assigned to a variable of typ like emitExprAs. values assigned to interfaces are boxed,
+// nil is the zero value of typ, and untyped constants take the type of their context
+func (l *lowerer) exprAs(expr ast.Expr, typ *ast.Object) []ir.Reg {
+	src := getType(expr)
+	switch {
+	case src == untypedNil:
+		return l.zero(typ)
+	case isInterface(typ) && !isInterface(src):
+		checkImplements(src, typ)
+		return l.box(l.expr(expr), src)
+	}
+	words := l.expr(expr)
+	types := irTypes(typ)
+	if len(words) != len(types) {
+		must(fmt.Errorf("cannot use %s as %s", exprString(expr), typeString(typ)))
+	}
+	var converted []ir.Reg
+	for i, r := range words {
+		converted = append(converted, l.convert(r, types[i]))
+	}
+	return converted
+}
+
+// box returns the interface value of the value of typ. a word is the data itself, and larger values are copied to the heap
+func (l *lowerer) box(value []ir.Reg, typ *ast.Object) []ir.Reg {
+	var data ir.Reg
+	if sizeOf(typ) == 8 {
+		data = l.convert(value[0], ir.Ptr)
+	} else {
+		data = l.alloc(sizeOf(typ))
+		l.store(lvalue{base: data, types: irTypes(typ)}, value)
+	}
+	desc := l.newReg(ir.Ptr)
+	l.add(&ir.Instr{Op: ir.SymAddr, Dst: desc, Sym: typeDesc(typ)})
+	return []ir.Reg{desc, data}
+}
+
+var irOps = map[token.Token]ir.Op{
+	token.ADD:     ir.Add,
+	token.SUB:     ir.Sub,
+	token.MUL:     ir.Mul,
+	token.QUO:     ir.Div,
+	token.REM:     ir.Rem,
+	token.AND:     ir.And,
+	token.OR:      ir.Or,
+	token.XOR:     ir.Xor,
+	token.AND_NOT: ir.AndNot,
+	token.SHL:     ir.Shl,
+	token.SHR:     ir.Shr,
+	token.EQL:     ir.Eq,
+	token.NEQ:     ir.Ne,
+	token.LSS:     ir.Lt,
+	token.LEQ:     ir.Le,
+	token.GTR:     ir.Gt,
+	token.GEQ:     ir.Ge,
+}
+
+// expr lowers expr and returns the registers of its words
+func (l *lowerer) expr(expr ast.Expr) []ir.Reg {
+	switch e := expr.(type) {
+	case *ast.BasicLit:
+		switch e.Kind {
+		case token.INT, token.CHAR:
+			return []ir.Reg{l.constant(ir.Int, intValue(e))}
+		case token.STRING:
+			ptr := l.newReg(ir.Ptr)
+			l.add(&ir.Instr{Op: ir.SymAddr, Dst: ptr, Sym: searchTag(e.Value)})
+			return []ir.Reg{ptr, l.constant(ir.Int, stringLen(e.Value))}
+		}
+		must(fmt.Errorf("unexpected basic literal %s", e.Value))
+	case *ast.Ident:
+		return l.ident(e)
+	case *ast.ParenExpr:
+		return l.expr(e.X)
+	case *ast.UnaryExpr:
+		return l.unary(e)
+	case *ast.BinaryExpr:
+		return l.binary(e)
+	case *ast.StarExpr: // *p
+		return l.load(l.lvalue(e))
+	case *ast.SelectorExpr:
+		return l.selector(e)
+	case *ast.CompositeLit:
+		return l.compositeLit(e)
+	case *ast.IndexExpr:
+		return l.load(l.indexLvalue(e))
+	case *ast.SliceExpr:
+		return l.sliceExpr(e)
+	case *ast.CallExpr:
+		return l.call(e)
+	default:
+		must(fmt.Errorf("unexpected expr type %T", expr))
+	}
+	return nil
+}
+
+func (l *lowerer) ident(e *ast.Ident) []ir.Reg {
+	obj := e.Obj
+	switch {
+	case obj == globalNil:
+		return []ir.Reg{l.constant(ir.Ptr, 0)}
+	case obj.Kind == ast.Con:
+		if value := constValue(obj); value != nil {
+			return l.exprAs(value, getType(e))
+		}
+		return []ir.Reg{l.constant(ir.Bool, boolValue(obj))} // true or false
+	case obj.Kind != ast.Var:
+		must(fmt.Errorf("ident kind should be ast.Var"))
+	}
+	return l.load(l.varLvalue(obj))
+}
+
+func (l *lowerer) unary(e *ast.UnaryExpr) []ir.Reg {
+	if e.Op == token.AND {
+		return []ir.Reg{l.addrOf(e.X)}
+	}
+	ops := map[token.Token]ir.Op{token.SUB: ir.Neg, token.NOT: ir.Not, token.XOR: ir.Com}
+	x := l.expr(e.X)
+	if e.Op == token.ADD {
+		return x
+	}
+	op, ok := ops[e.Op]
+	if !ok {
+		must(fmt.Errorf("unexpected unary operator: %s", e.Op))
+	}
+	r := l.newReg(l.fn.Regs[x[0]])
+	l.add(&ir.Instr{Op: op, Dst: r, Args: []ir.Reg{x[0]}})
+	return []ir.Reg{r}
+}
+
+// selector lowers the struct field x.f or the package level variable pkg.x
+func (l *lowerer) selector(e *ast.SelectorExpr) []ir.Reg {
+	if ident := qualifiedIdent(e); ident != nil {
+		return l.ident(ident)
+	}
+	if isAddressable(e) {
+		return l.load(l.lvalue(e))
+	}
+	// the struct is a temporary value like f().x
+	field := lookupField(getType(e.X), e.Sel.Name)
+	x := l.expr(e.X)
+	return x[field.offset/8 : field.offset/8+len(irTypes(field.typ))]
+}
+
+// compositeLit lowers a struct literal, whose fields without element are zero, or a slice literal
+func (l *lowerer) compositeLit(e *ast.CompositeLit) []ir.Reg {
+	typ := getType(e.Type)
+	if isSlice(underlying(typ)) {
+		return l.sliceLit(e.Elts, sliceElem(typ))
+	}
+	fields := structFields(typ)
+	values := make([]ast.Expr, len(fields))
+	for i, elt := range e.Elts {
+		kv, ok := elt.(*ast.KeyValueExpr)
+		if !ok {
+			values[i] = elt
+			continue
+		}
+		key := kv.Key.(*ast.Ident)
+		for j, field := range fields {
+			if field.name == key.Name {
+				values[j] = kv.Value
+			}
+		}
+	}
+	operands := make([]operand, len(fields))
+	for i, field := range fields {
+		i, field := i, field
+		operands[i] = operand{calls: values[i] != nil && hasCall(values[i]), eval: func() []ir.Reg {
+			if values[i] == nil {
+				return l.zero(field.typ)
+			}
+			return l.exprAs(values[i], field.typ)
+		}}
+	}
+	return l.operands(operands)
+}
+
+// sliceLit allocates the elements of a slice literal in the heap
+func (l *lowerer) sliceLit(elems []ast.Expr, elem *ast.Object) []ir.Reg {
+	size := elemSize(elem)
+	values := make([][]ir.Reg, len(elems))
+	for i, e := range elems {
+		values[i] = l.exprAs(e, elem)
+	}
+	ptr := l.alloc(len(elems) * size)
+	for i, value := range values {
+		l.store(lvalue{base: ptr, off: i * size, types: irTypes(elem), packed: size == 1}, value)
+	}
+	n := l.constant(ir.Int, len(elems))
+	return []ir.Reg{ptr, n, n}
+}
+
+// sliceExpr lowers s[low:high] of a slice or a string after checking low <= high <= cap, or the length for a string
+func (l *lowerer) sliceExpr(e *ast.SliceExpr) []ir.Reg {
+	if e.Slice3 {
+		must(fmt.Errorf("3-index slices are not supported"))
+	}
+	typ := underlying(getType(e.X))
+	x := l.expr(e.X)
+	var low, high ir.Reg
+	if e.Low != nil {
+		low = l.exprAs(e.Low, globalInt)[0]
+	} else {
+		low = l.constant(ir.Int, 0)
+	}
+	if e.High != nil {
+		high = l.exprAs(e.High, globalInt)[0]
+	} else {
+		high = x[1]
+	}
+	limit := x[1]
+	elem := globalByte
+	if typ != globalString {
+		limit = x[2]
+		elem = sliceElem(typ)
+	}
+	l.check(ir.LeU, low, high, "runtime.panicslice")
+	l.check(ir.LeU, high, limit, "runtime.panicslice")
+	ptr := l.elemAddr(x[0], low, elemSize(elem))
+	length := l.binop(ir.Sub, ir.Int, high, low)
+	if typ == globalString {
+		return []ir.Reg{ptr, length}
+	}
+	return []ir.Reg{ptr, length, l.binop(ir.Sub, ir.Int, x[2], low)}
+}
+
+// binary lowers x op y. && and || evaluate y in another block only if x doesn't decide the result
+func (l *lowerer) binary(e *ast.BinaryExpr) []ir.Reg {
+	if e.Op == token.LAND || e.Op == token.LOR {
+		r := l.newReg(ir.Bool)
+		l.add(&ir.Instr{Op: ir.Copy, Dst: r, Args: []ir.Reg{l.expr(e.X)[0]}})
+		right, end := l.fn.NewBlock(), l.fn.NewBlock()
+		if e.Op == token.LAND {
+			l.end(ir.If, r, right, end)
+		} else {
+			l.end(ir.If, r, end, right)
+		}
+		l.startBlock(right)
+		l.add(&ir.Instr{Op: ir.Copy, Dst: r, Args: []ir.Reg{l.expr(e.Y)[0]}})
+		l.jump(end)
+		l.startBlock(end)
+		return []ir.Reg{r}
+	}
+	xType, yType := getType(e.X), getType(e.Y)
+	if xType == untypedNil || yType == untypedNil {
+		// a pointer, a slice or an interface is nil by its first word
+		x := e.X
+		if xType == untypedNil {
+			x = e.Y
+		}
+		return []ir.Reg{l.binop(irOps[e.Op], ir.Bool, l.expr(x)[0], l.constant(ir.Ptr, 0))}
+	}
+	typ := xType
+	switch {
+	case isInterface(xType) || isInterface(yType):
+		if !isInterface(typ) {
+			typ = yType
+		}
+	case e.Op == token.SHL || e.Op == token.SHR:
+	case isUntypedConst(e.X) && !isUntypedConst(e.Y): // the operands have the type of the typed one
+		typ = yType
+	}
+	x := l.exprAs(e.X, typ)
+	var y []ir.Reg
+	if e.Op == token.SHL || e.Op == token.SHR {
+		y = l.expr(e.Y)
+	} else {
+		y = l.exprAs(e.Y, typ)
+	}
+	return l.binaryOp(e.Op, typ, x, y)
+}
+
+// binaryOp returns x op y of the operands of typ. strings are concatenated and compared by the runtime,
+// and interfaces and structs are compared by equal
+func (l *lowerer) binaryOp(op token.Token, typ *ast.Object, x, y []ir.Reg) []ir.Reg {
+	u := underlying(typ)
+	switch {
+	case u == globalString && op == token.ADD:
+		return l.callSym("runtime.concatstring", []ir.Reg{y[0], y[1], x[0], x[1]}, ir.Ptr, ir.Int)
+	case u == globalString && op != token.EQL && op != token.NEQ:
+		r := l.callSym("runtime.cmpstring", []ir.Reg{y[0], y[1], x[0], x[1]}, ir.Int)[0]
+		return []ir.Reg{l.binop(irOps[op], ir.Bool, r, l.constant(ir.Int, 0))}
+	case len(x) > 1 || isInterface(typ):
+		eq := l.equal(typ, x, y)
+		if op == token.NEQ {
+			ne := l.newReg(ir.Bool)
+			l.add(&ir.Instr{Op: ir.Not, Dst: ne, Args: []ir.Reg{eq}})
+			eq = ne
+		}
+		return []ir.Reg{eq}
+	}
+	op2, ok := irOps[op]
+	if !ok {
+		must(fmt.Errorf("unexpected binary operator: %s", op))
+	}
+	dst := l.fn.Regs[x[0]]
+	if op2.IsCompare() {
+		dst = ir.Bool
+	}
+	return []ir.Reg{l.binop(op2, dst, x[0], y[0])}
+}
+
+// equal returns x == y of typ
+func (l *lowerer) equal(typ *ast.Object, x, y []ir.Reg) ir.Reg {
+	u := underlying(typ)
+	switch {
+	case u == globalString:
+		r := l.callSym("runtime.cmpstring", []ir.Reg{y[0], y[1], x[0], x[1]}, ir.Int)[0]
+		return l.binop(ir.Eq, ir.Bool, r, l.constant(ir.Int, 0))
+	case isInterface(u):
+		r := l.callSym("runtime.efaceeq", []ir.Reg{y[0], y[1], x[0], x[1]}, ir.Int)[0]
+		return l.binop(ir.Ne, ir.Bool, r, l.constant(ir.Int, 0))
+	case isStruct(u): // field by field
+		eq := l.constant(ir.Bool, 1)
+		for _, field := range structFields(u) {
+			n := len(irTypes(field.typ))
+			i := field.offset / 8
+			eq = l.binop(ir.And, ir.Bool, eq, l.equal(field.typ, x[i:i+n], y[i:i+n]))
+		}
+		return eq
+	case len(x) > 1:
+		must(fmt.Errorf("cannot compare %s", typeString(typ)))
+	}
+	return l.binop(ir.Eq, ir.Bool, x[0], y[0])
+}
+
+// operand is an operand of a call or a composite literal evaluated by operands
+type operand struct {
+	calls bool // evaluating the operand calls a function
+	eval  func() []ir.Reg
+}
+
+// operands evaluates the operands calling functions first in order, and then the rest, like the compiler of Go.
+// it returns the words of all in order
+func (l *lowerer) operands(list []operand) []ir.Reg {
+	values := make([][]ir.Reg, len(list))
+	for _, calls := range []bool{true, false} {
+		for i, op := range list {
+			if op.calls == calls {
+				values[i] = op.eval()
+			}
+		}
+	}
+	var words []ir.Reg
+	for _, value := range values {
+		words = append(words, value...)
+	}
+	return words
+}
+
+// call lowers a call of a function or a method, a conversion or a builtin, and returns the words of the results
+func (l *lowerer) call(e *ast.CallExpr) []ir.Reg {
+	if typ := conversionType(e); typ != nil {
+		return l.conversion(e.Args[0], typ)
+	}
+	ident := funcIdent(e.Fun)
+	if ident != nil && isBuiltin(ident, ident.Name) {
+		return l.builtin(ident.Name, e)
+	}
+	fnc := calleeFunc(e)
+	if isExtern(fnc) {
+		return l.externCall(fnc, e)
+	}
+	var types []*ast.Object
+	withTypeArgs(fnc.typeArgs, func() {
+		for _, t := range paramTypes(fnc.decl) {
+			types = append(types, getType(t))
+		}
+	})
+	variadic := len(types) > 0 && isVariadic(fnc.decl) && !e.Ellipsis.IsValid()
+	if !variadic && len(e.Args) != len(types) {
+		must(fmt.Errorf("%s: got %d arguments but %d parameters", fnc.name, len(e.Args), len(types)))
+	}
+
+	var list []operand // the receiver is the first argument
+	sel := methodSelector(e)
+	iface := sel != nil && isInterface(getType(sel.X))
+	if sel != nil {
+		list = append(list, operand{calls: hasCall(sel.X), eval: func() []ir.Reg {
+			if iface {
+				return l.expr(sel.X)
+			}
+			return l.recv(fnc, sel.X)
+		}})
+	}
+	for i, typ := range types {
+		i, typ := i, typ
+		if variadic && i == len(types)-1 {
+			list = append(list, operand{calls: argsHaveCalls(e.Args[i:], 1), eval: func() []ir.Reg { return l.sliceLit(e.Args[i:], sliceElem(typ)) }})
+			break
+		}
+		list = append(list, operand{calls: hasCall(e.Args[i]), eval: func() []ir.Reg { return l.exprAs(e.Args[i], typ) }})
+	}
+	args := l.operands(list)
+
+	retType := resultType(fnc)
+	var rets []ir.Reg
+	if retType != nil {
+		for _, typ := range irTypes(retType) {
+			rets = append(rets, l.newReg(typ))
+		}
+	}
+	memResults := retType != nil && isResultInMemory(retType)
+	if iface {
+		// the method is looked up in the method table of the dynamic type, and the data is the receiver
+		label := l.newReg(ir.Ptr)
+		l.add(&ir.Instr{Op: ir.SymAddr, Dst: label, Sym: methodLabel(objPkgs[underlying(getType(sel.X))], sel.Sel.Name)})
+		method := l.callSym("runtime.findmethod", []ir.Reg{label, args[0]}, ir.Ptr)[0]
+		l.add(&ir.Instr{Op: ir.CallInd, Dst: ir.NoReg, Args: append([]ir.Reg{method}, args[1:]...), Rets: rets, MemResults: memResults, Pos: e.Pos()})
+		return rets
+	}
+	l.add(&ir.Instr{Op: ir.Call, Dst: ir.NoReg, Args: args, Rets: rets, Sym: calleeSymbol(fnc), RegABI: usesRegABI(fnc), MemResults: memResults, Pos: e.Pos()})
+	return rets
+}
+
+// recv lowers the receiver x of a method call like emitRecv.
+// x is addressed or dereferenced when it is T for a receiver *T or *T for a receiver T
+func (l *lowerer) recv(fnc *Func, x ast.Expr) []ir.Reg {
+	var recvType *ast.Object
+	withTypeArgs(fnc.typeArgs, func() {
+		recvType = getType(fnc.decl.Recv.List[0].Type)
+	})
+	switch xType := getType(x); {
+	case isPointer(recvType) && !isPointer(xType):
+		if !isAddressable(x) {
+			must(fmt.Errorf("cannot call pointer method %s on %s", fnc.decl.Name.Name, xType.Name))
+		}
+		return []ir.Reg{l.addrOf(x)}
+	case !isPointer(recvType) && isPointer(xType):
+		return l.expr(&ast.StarExpr{X: x})
+	}
+	return l.expr(x)
+}
+
+// externCall calls the C function fnc like emitExternCall. strings are passed as C strings and slices as their pointers
+func (l *lowerer) externCall(fnc *Func, e *ast.CallExpr) []ir.Reg {
+	if isVariadic(fnc.decl) {
+		must(fmt.Errorf("%s: variadic extern functions are not supported", fnc.name))
+	}
+	var types []*ast.Object
+	for _, t := range paramTypes(fnc.decl) {
+		types = append(types, getType(t))
+	}
+	if len(e.Args) != len(types) {
+		must(fmt.Errorf("%s: got %d arguments but %d parameters", fnc.name, len(e.Args), len(types)))
+	}
+	retType := resultType(fnc)
+	if retType != nil && !isCWord(retType) && underlying(retType) != globalString {
+		must(fmt.Errorf("%s: cannot return %s from C", fnc.name, retType.Name))
+	}
+
+	list := make([]operand, len(types))
+	for i, typ := range types {
+		i, typ := i, typ
+		list[i] = operand{calls: hasCall(e.Args[i]), eval: func() []ir.Reg {
+			value := l.exprAs(e.Args[i], typ)
+			switch {
+			case isCWord(typ):
+				return value
+			case underlying(typ) == globalString:
+				return l.callSym("runtime.cstring", value, ir.Ptr)
+			case isSlice(typ):
+				return value[:1]
+			}
+			must(fmt.Errorf("%s: cannot pass %s to C", fnc.name, typ.Name))
+			return nil
+		}}
+	}
+	args := l.operands(list)
+	var rets []ir.Reg
+	switch {
+	case retType == nil:
+	case underlying(retType) == globalString:
+		rets = []ir.Reg{l.newReg(ir.Ptr)}
+	default:
+		rets = []ir.Reg{l.newReg(irTypes(retType)[0])}
+	}
+	l.add(&ir.Instr{Op: ir.Call, Dst: ir.NoReg, Args: args, Rets: rets, Sym: symbol(externName(fnc.decl)), Extern: true, Pos: e.Pos()})
+	if retType != nil && underlying(retType) == globalString {
+		return l.callSym("runtime.gostring", rets, ir.Ptr, ir.Int)
+	}
+	return rets
+}
+
+// conversion lowers T(x). the representation doesn't change except for bytes, interfaces and []byte <-> string
+func (l *lowerer) conversion(x ast.Expr, typ *ast.Object) []ir.Reg {
+	src := underlying(getType(x))
+	dst := underlying(typ)
+	switch {
+	case dst == globalString && isSlice(src):
+		return l.callSym("runtime.slicebytetostring", l.expr(x), ir.Ptr, ir.Int)
+	case isSlice(dst) && src == globalString:
+		return l.callSym("runtime.stringtoslicebyte", l.expr(x), ir.Ptr, ir.Int, ir.Int)
+	}
+	return l.exprAs(x, typ)
+}
+
+// builtin lowers a call of a builtin function
+func (l *lowerer) builtin(name string, e *ast.CallExpr) []ir.Reg {
+	switch name {
+	case "print", "println":
+		l.print(e, name == "println")
+		return nil
+	case "new":
+		return []ir.Reg{l.alloc(sizeOf(getType(e.Args[0])))}
+	case "len":
+		return l.expr(e.Args[0])[1:2]
+	case "cap":
+		return l.expr(e.Args[0])[2:3]
+	case "append":
+		return l.appendCall(e)
+	case "make":
+		typ := getType(e.Args[0])
+		if !isSlice(underlying(typ)) {
+			must(fmt.Errorf("make of %s is not supported", typ.Name))
+		}
+		length := l.exprAs(e.Args[1], globalInt)[0]
+		capacity := length
+		if len(e.Args) > 2 {
+			capacity = l.exprAs(e.Args[2], globalInt)[0]
+		}
+		return l.callSym("runtime.makeslice", []ir.Reg{l.constant(ir.Int, elemSize(sliceElem(typ))), length, capacity}, ir.Ptr, ir.Int, ir.Int)
+	}
+	must(fmt.Errorf("builtin %s is not supported", name))
+	return nil
+}
+
+// appendCall lowers append(s, x...) by runtime.appendslice, or append(s, a, b) by runtime.growslice and the stores
+// of the elements, which are evaluated before the slice grows
+func (l *lowerer) appendCall(e *ast.CallExpr) []ir.Reg {
+	typ := getType(e.Args[0])
+	elem := sliceElem(typ)
+	size := elemSize(elem)
+	s := l.expr(e.Args[0])
+	if e.Ellipsis.IsValid() {
+		t := l.expr(e.Args[1])
+		tcap := t[1] // a string has no capacity
+		if len(t) > 2 {
+			tcap = t[2]
+		}
+		return l.callSym("runtime.appendslice", []ir.Reg{l.constant(ir.Int, size), t[0], t[1], tcap, s[0], s[1], s[2]}, ir.Ptr, ir.Int, ir.Int)
+	}
+	n := len(e.Args) - 1
+	values := make([][]ir.Reg, n)
+	for i, arg := range e.Args[1:] {
+		values[i] = l.exprAs(arg, elem)
+	}
+	grown := l.callSym("runtime.growslice", []ir.Reg{l.constant(ir.Int, n), l.constant(ir.Int, size), s[0], s[1], s[2]}, ir.Ptr, ir.Int, ir.Int)
+	for i, value := range values {
+		index := l.binop(ir.Sub, ir.Int, grown[1], l.constant(ir.Int, n-i))
+		l.store(lvalue{base: l.elemAddr(grown[0], index, size), types: irTypes(elem), packed: size == 1}, value)
+	}
+	return grown
+}
+
+// print lowers the print builtins to the calls of the runtime like emitPrint
+func (l *lowerer) print(e *ast.CallExpr, newline bool) {
+	// the arguments are evaluated before anything is printed, so the ones calling print come first
+	values := make([][]ir.Reg, len(e.Args))
+	for i, arg := range e.Args {
+		values[i] = l.expr(arg)
+	}
+	for i, arg := range e.Args {
+		if newline && i > 0 {
+			l.callSym("runtime.printsp", nil)
+		}
+		typ := underlying(getType(arg))
+		switch {
+		case typ == globalString:
+			l.callSym("runtime.printstring", values[i])
+		case typ == globalInt || typ == globalByte:
+			l.callSym("runtime.printint", values[i])
+		case typ == globalBool:
+			l.callSym("runtime.printbool", values[i])
+		case isPointer(typ):
+			l.callSym("runtime.printhex", values[i])
+		default:
+			must(fmt.Errorf("illegal types for operand: print %s", typ.Name))
+		}
+	}
+	if newline {
+		l.callSym("runtime.printnl", nil)
+	}
+}
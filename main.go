@@ -1,11 +1,17 @@
 package main
 
 import (
+	"errors"
+	"flag"
 	"fmt"
 	"go/ast"
-	"go/parser"
 	"go/token"
+	"os"
+	"path/filepath"
 	"strconv"
+	"strings"
+
+	"github.com/lkeix/gompiler/ir"
 )
 
 const MAIN = "main"
@@ -17,15 +23,19 @@ type (
 	}
 	globalVariable struct {
 		tag   string
-		value string
-		typ   *ast.Ident
+		value string // literal of the initial value. zero if empty
+		typ   *ast.Object
 	}
 
 	Func struct {
-		decl      *ast.FuncDecl
-		localvars []*ast.ValueSpec
-		localarea int
-		argsarea  int
+		decl       *ast.FuncDecl
+		pkg        *Package
+		name       string                      // symbol name without package. e.g. Max[int], (*File).Read
+		typeArgs   map[*ast.Object]*ast.Object // type parameter -> type argument, nil if not generic
+		localvars  []*ast.Object
+		localarea  int
+		argsarea   int
+		heapParams []heapParam // parameters moved to the heap by the prologue
 	}
 )
 
@@ -48,84 +58,454 @@ var (
 		Data: nil,
 		Type: nil,
 	}
+
+	globalBool = &ast.Object{
+		Kind: ast.Typ,
+		Name: "bool",
+		Decl: nil,
+		Data: nil,
+		Type: nil,
+	}
+
+	// globalByte is byte and uint8. bytes take a word in variables but are packed in slices and strings
+	globalByte = &ast.Object{
+		Kind: ast.Typ,
+		Name: "uint8",
+		Decl: nil,
+		Data: nil,
+		Type: nil,
+	}
 	funcs []*Func
+
+	// funcsEmitted is set after funcs are emitted, and the functions created later are emitted with the wrappers
+	funcsEmitted bool
+
+	// curFunc is the function being emitted
+	curFunc *Func
+
+	// labelSeq numbers the local labels of branches
+	labelSeq int
+
+	// breakLabels and continueLabels are the targets of break and continue in the innermost statements
+	breakLabels    []string
+	continueLabels []string
+
+	// desugared keeps the statements rewritten by walking range and switch statements, which are emitted instead of them
+	desugared = map[ast.Stmt]ast.Stmt{}
+
+	// rangeDefines are the assignments of desugared range statements which declare k and v
+	rangeDefines = map[*ast.AssignStmt]bool{}
 )
 
 // AT&T syntax
-func osExit() {
-	fmt.Printf(".text\n")
-	fmt.Printf("os.Exit:\n")               // os.Exit label: exit
-	fmt.Printf("  movq 8(%%rsp), %%rdi\n") // rsp(stack pointer register) + 8 address  value(42(decimal) = 2a(hex)) to rdi(destination register)
-	fmt.Printf("  movq $60, %%rax\n")      // rax(accumulator register) = 60
-	fmt.Printf("  syscall\n\n")            // emit syscall
+// syscalls emits the runtime routines of the os package. they return the result of the syscall in rax, which is -errno on failure
+func syscalls() {
+	emit("# syscalls\n")
+	emit(".text\n")
+	if !libc {
+		emit("runtime.exit:\n")
+		emit("  movq 8(%%rsp), %%rdi\n") // rsp(stack pointer register) + 8 address  value(42(decimal) = 2a(hex)) to rdi(destination register)
+		emit("  movq $60, %%rax\n")      // rax(accumulator register) = 60
+		emit("  syscall\n\n")            // emit syscall
+	}
+
+	// read reads into the slice at 16(rsp) from the file descriptor 8(rsp)
+	emit("runtime.read:\n")
+	emit("  movq 8(%%rsp), %%rdi\n")
+	emit("  movq 16(%%rsp), %%rsi\n")
+	emit("  movq 24(%%rsp), %%rdx\n")
+	emit("  movq $0, %%rax\n")
+	emit("  syscall\n")
+	emit("  ret\n\n")
+
+	// open opens the NUL terminated path in the slice at 8(rsp) with the flags 32(rsp) and the permission 40(rsp)
+	emit("runtime.open:\n")
+	emit("  movq 8(%%rsp), %%rdi\n")
+	emit("  movq 32(%%rsp), %%rsi\n")
+	emit("  movq 40(%%rsp), %%rdx\n")
+	emit("  movq $2, %%rax\n")
+	emit("  syscall\n")
+	emit("  ret\n\n")
+
+	emit("runtime.close:\n")
+	emit("  movq 8(%%rsp), %%rdi\n")
+	emit("  movq $3, %%rax\n")
+	emit("  syscall\n")
+	emit("  ret\n\n")
+
+	// args and envs return the command line arguments and the environment variables as []string in the result area at 8(rsp)
+	emit("runtime.args:\n")
+	emit("  movq runtime.argv(%%rip), %%rax\n")
+	emit("  jmp runtime.cstrings\n")
+	emit("runtime.envs:\n")
+	emit("  movq runtime.envp(%%rip), %%rax\n")
+	// cstrings converts the NULL terminated array of C strings at rax
+	emit("runtime.cstrings:\n")
+	emit("  pushq %%rax\n")
+	emit("  xorq %%rcx, %%rcx\n")
+	emit("runtime.cstrings.count:\n")
+	emit("  cmpq $0, (%%rax,%%rcx,8)\n")
+	emit("  je runtime.cstrings.alloc\n")
+	emit("  incq %%rcx\n")
+	emit("  jmp runtime.cstrings.count\n")
+	emit("runtime.cstrings.alloc:\n")
+	emit("  movq %%rcx, 24(%%rsp) # len\n")
+	emit("  movq %%rcx, 32(%%rsp) # cap\n")
+	emit("  shlq $4, %%rcx\n")
+	emit("  pushq %%rcx\n")
+	emit("  callq runtime.alloc\n")
+	emit("  addq $8, %%rsp\n")
+	emit("  movq %%rax, 16(%%rsp) # ptr\n")
+	emit("  popq %%rsi\n")
+	emit("  movq %%rax, %%rdi\n")
+	emit("runtime.cstrings.next:\n")
+	emit("  movq (%%rsi), %%rdx\n")
+	emit("  testq %%rdx, %%rdx\n")
+	emit("  je runtime.cstrings.end\n")
+	emit("  movq %%rdx, (%%rdi)\n")
+	emit("  xorq %%rcx, %%rcx\n")
+	emit("runtime.cstrings.len:\n")
+	emit("  cmpb $0, (%%rdx,%%rcx)\n")
+	emit("  je runtime.cstrings.store\n")
+	emit("  incq %%rcx\n")
+	emit("  jmp runtime.cstrings.len\n")
+	emit("runtime.cstrings.store:\n")
+	emit("  movq %%rcx, 8(%%rdi)\n")
+	emit("  addq $8, %%rsi\n")
+	emit("  addq $16, %%rdi\n")
+	emit("  jmp runtime.cstrings.next\n")
+	emit("runtime.cstrings.end:\n")
+	emit("  ret\n\n")
 }
 
 func runtime() {
-	fmt.Printf("# runtime\n")
-	fmt.Printf(".global _start\n")
-	fmt.Printf("_start:\n")
-	fmt.Printf("  callq main.main\n")
-	fmt.Printf("  movq $0, %%rdi\n")
-	fmt.Printf("  movq $60, %%rax\n")
-	fmt.Printf("  syscall\n\n") // emit syscall
+	emit("# runtime\n")
+	emit(".data\n")
+	emit("runtime.argv:\n") // the NULL terminated arrays of C strings
+	emit("  .quad 0\n")
+	emit("runtime.envp:\n")
+	emit("  .quad 0\n")
+	if libc {
+		libcRuntime()
+		return
+	}
+	emit(".text\n")
+	emit(".global _start\n")
+	emit("_start:\n")
+	// the kernel puts argc, argv, NULL, envp and NULL at the initial stack pointer
+	emit("  movq %%rsp, runtime.stackTop(%%rip)\n")
+	emit("  movq (%%rsp), %%rax\n")
+	emit("  leaq 8(%%rsp), %%rcx\n")
+	emit("  movq %%rcx, runtime.argv(%%rip)\n")
+	emit("  leaq 8(%%rcx,%%rax,8), %%rcx\n")
+	emit("  movq %%rcx, runtime.envp(%%rip)\n")
+	emit("  callq runtime.gcinit\n")
+	emit("  callq main.init\n")
+	emit("  callq main.main\n")
+	emit("  movq $0, %%rdi\n")
+	emit("  movq $60, %%rax\n")
+	emit("  syscall\n\n") // emit syscall
 }
 
+// print emits the routines of the print and println builtins. they write to stderr like the Go runtime
 func print() {
-	fmt.Printf("# print\n")
-	fmt.Printf(".text\n")
-	fmt.Printf("runtime.print:\n")
-	fmt.Printf("  movq $2, %%rdi\n")        // set 2 to rdi (stderr)
-	fmt.Printf("  movq 8(%%rsp), %%rsi\n")  // set 16(rsp) to rsi (string)
-	fmt.Printf("  movq 16(%%rsp), %%rdx\n") // set 8(rsp) to rdx (length)
-	fmt.Printf("  movq $1, %%rax\n")        // set 1 to rax (syscall number)
-	fmt.Printf("  syscall\n")
-	fmt.Printf("  ret\n\n")
+	emit("# print\n")
+	emit(".data\n")
+	emit("runtime.true:\n")
+	emit("  .ascii \"true\"\n")
+	emit("runtime.false:\n")
+	emit("  .ascii \"false\"\n")
+	emit("runtime.sp:\n")
+	emit("  .ascii \" \"\n")
+	emit("runtime.nl:\n")
+	emit("  .ascii \"\\n\"\n")
+
+	emit(".text\n")
+	emit("runtime.printstring:\n")
+	emit("  movq $2, %%rdi\n")        // set 2 to rdi (stderr)
+	emit("  movq 8(%%rsp), %%rsi\n")  // set 16(rsp) to rsi (string)
+	emit("  movq 16(%%rsp), %%rdx\n") // set 8(rsp) to rdx (length)
+	emit("  movq $1, %%rax\n")        // set 1 to rax (syscall number)
+	emit("  syscall\n")
+	emit("  ret\n\n")
+
+	// printint writes the decimal digits from the end of a buffer on the stack
+	emit("runtime.printint:\n")
+	emit("  movq 8(%%rsp), %%rax\n")
+	emit("  movq %%rax, %%r8\n") // r8 keeps the sign
+	emit("  subq $32, %%rsp\n")
+	emit("  leaq 32(%%rsp), %%rsi\n")
+	emit("  testq %%rax, %%rax\n")
+	emit("  jns runtime.printint.loop\n")
+	emit("  negq %%rax\n") // the min int stays negative but is right as unsigned
+	emit("runtime.printint.loop:\n")
+	emit("  movq $10, %%rcx\n")
+	emit("  xorq %%rdx, %%rdx\n")
+	emit("  divq %%rcx\n")
+	emit("  addb $48, %%dl\n") // '0'
+	emit("  decq %%rsi\n")
+	emit("  movb %%dl, (%%rsi)\n")
+	emit("  testq %%rax, %%rax\n")
+	emit("  jne runtime.printint.loop\n")
+	emit("  testq %%r8, %%r8\n")
+	emit("  jns runtime.printint.write\n")
+	emit("  decq %%rsi\n")
+	emit("  movb $45, (%%rsi)\n") // '-'
+	emit("runtime.printint.write:\n")
+	emit("  leaq 32(%%rsp), %%rdx\n")
+	emit("  subq %%rsi, %%rdx\n")
+	emit("  movq $2, %%rdi\n")
+	emit("  movq $1, %%rax\n")
+	emit("  syscall\n")
+	emit("  addq $32, %%rsp\n")
+	emit("  ret\n\n")
+
+	// printhex writes 0x and the hex digits without leading zeros like pointers in Go. 0 is 0x0
+	emit("runtime.printhex:\n")
+	emit("  movq 8(%%rsp), %%rax\n")
+	emit("  subq $32, %%rsp\n")
+	emit("  leaq 32(%%rsp), %%rsi\n")
+	emit("runtime.printhex.loop:\n")
+	emit("  movq %%rax, %%rdx\n")
+	emit("  andq $15, %%rdx\n")
+	emit("  cmpq $10, %%rdx\n")
+	emit("  jb runtime.printhex.digit\n")
+	emit("  addq $39, %%rdx\n") // 'a' - '0' - 10
+	emit("runtime.printhex.digit:\n")
+	emit("  addq $48, %%rdx\n")
+	emit("  decq %%rsi\n")
+	emit("  movb %%dl, (%%rsi)\n")
+	emit("  shrq $4, %%rax\n")
+	emit("  jne runtime.printhex.loop\n")
+	emit("  decq %%rsi\n")
+	emit("  movb $120, (%%rsi)\n") // 'x'
+	emit("  decq %%rsi\n")
+	emit("  movb $48, (%%rsi)\n") // '0'
+	emit("  leaq 32(%%rsp), %%rdx\n")
+	emit("  subq %%rsi, %%rdx\n")
+	emit("  movq $2, %%rdi\n")
+	emit("  movq $1, %%rax\n")
+	emit("  syscall\n")
+	emit("  addq $32, %%rsp\n")
+	emit("  ret\n\n")
+
+	emit("runtime.printbool:\n")
+	emit("  cmpq $0, 8(%%rsp)\n")
+	emit("  je runtime.printbool.false\n")
+	emit("  pushq $4\n")
+	emit("  leaq runtime.true(%%rip), %%rax\n")
+	emit("  jmp runtime.printbool.write\n")
+	emit("runtime.printbool.false:\n")
+	emit("  pushq $5\n")
+	emit("  leaq runtime.false(%%rip), %%rax\n")
+	emit("runtime.printbool.write:\n")
+	emit("  pushq %%rax\n")
+	emit("  callq runtime.printstring\n")
+	emit("  addq $16, %%rsp\n")
+	emit("  ret\n\n")
+
+	// write writes the bytes of the slice at 16(rsp) to the file descriptor 8(rsp)
+	emit("runtime.write:\n")
+	emit("  movq 8(%%rsp), %%rdi\n")
+	emit("  movq 16(%%rsp), %%rsi\n")
+	emit("  movq 24(%%rsp), %%rdx\n")
+	emit("  movq $1, %%rax\n")
+	emit("  syscall\n")
+	emit("  ret\n\n")
+
+	emit("runtime.printsp:\n")
+	emit("  pushq $1\n")
+	emit("  leaq runtime.sp(%%rip), %%rax\n")
+	emit("  pushq %%rax\n")
+	emit("  callq runtime.printstring\n")
+	emit("  addq $16, %%rsp\n")
+	emit("  ret\n\n")
+
+	emit("runtime.printnl:\n")
+	emit("  pushq $1\n")
+	emit("  leaq runtime.nl(%%rip), %%rax\n")
+	emit("  pushq %%rax\n")
+	emit("  callq runtime.printstring\n")
+	emit("  addq $16, %%rsp\n")
+	emit("  ret\n\n")
+}
+
+// cmpstring compares the strings at 24(rsp) (left) and 8(rsp) (right) and returns -1, 0 or 1 in rax
+func cmpstring() {
+	emit("# cmpstring\n")
+	emit(".text\n")
+	emit("runtime.cmpstring:\n")
+	emit("  movq 24(%%rsp), %%rsi\n") // left ptr
+	emit("  movq 32(%%rsp), %%rcx\n") // left len
+	emit("  movq 8(%%rsp), %%rdi\n")  // right ptr
+	emit("  movq 16(%%rsp), %%rdx\n") // right len
+	emit("  movq %%rcx, %%r8\n")
+	emit("  cmpq %%rdx, %%r8\n")
+	emit("  cmovaq %%rdx, %%r8\n") // r8 = min(left len, right len)
+	emit("runtime.cmpstring.loop:\n")
+	emit("  testq %%r8, %%r8\n")
+	emit("  je runtime.cmpstring.len\n")
+	emit("  movzbq (%%rsi), %%rax\n")
+	emit("  movzbq (%%rdi), %%r9\n")
+	emit("  cmpq %%r9, %%rax\n")
+	emit("  jb runtime.cmpstring.lt\n")
+	emit("  ja runtime.cmpstring.gt\n")
+	emit("  incq %%rsi\n")
+	emit("  incq %%rdi\n")
+	emit("  decq %%r8\n")
+	emit("  jmp runtime.cmpstring.loop\n")
+	emit("runtime.cmpstring.len:\n") // common prefix is equal, the shorter one is less
+	emit("  cmpq %%rdx, %%rcx\n")
+	emit("  jb runtime.cmpstring.lt\n")
+	emit("  ja runtime.cmpstring.gt\n")
+	emit("  movq $0, %%rax\n")
+	emit("  ret\n")
+	emit("runtime.cmpstring.lt:\n")
+	emit("  movq $-1, %%rax\n")
+	emit("  ret\n")
+	emit("runtime.cmpstring.gt:\n")
+	emit("  movq $1, %%rax\n")
+	emit("  ret\n\n")
+}
+
+// concatstring joins the strings at 24(rsp) (left) and 8(rsp) (right) and returns the new string in rax (ptr) and rsi (len)
+func concatstring() {
+	emit("# concatstring\n")
+	emit(".text\n")
+	emit("runtime.concatstring:\n")
+	emit("  movq 32(%%rsp), %%rax\n")
+	emit("  addq 16(%%rsp), %%rax\n")
+	emit("  pushq %%rax\n")
+	emit("  callq runtime.alloc\n")
+	emit("  popq %%r9\n")         // len of the new string
+	emit("  movq %%rax, %%rdi\n") // copy left
+	emit("  movq 24(%%rsp), %%rsi\n")
+	emit("  movq 32(%%rsp), %%rcx\n")
+	emit("  rep movsb\n")
+	emit("  movq 8(%%rsp), %%rsi\n") // copy right
+	emit("  movq 16(%%rsp), %%rcx\n")
+	emit("  rep movsb\n")
+	emit("  movq %%r9, %%rsi\n")
+	emit("  ret\n\n")
 }
 
 func declWalk(decl *ast.Decl) {
-	var localvars []*ast.ValueSpec
-	localoffset := 0
 	switch (*decl).(type) {
 	case *ast.GenDecl:
 		// extract global variables before analyze declaration functions
 		parseGlobalVariables((*decl).(*ast.GenDecl))
 	case *ast.FuncDecl:
 		funcDecl := (*decl).(*ast.FuncDecl)
-		paramoffset := new(int)
-		*paramoffset = 16
-		funcParamsWalk(funcDecl.Type.Params, paramoffset)
-		localvars = bodyWalk(funcDecl.Body.List, localvars, &localoffset)
+		if funcDecl.Recv == nil && funcDecl.Name.Name == "init" {
+			declInitFunc(funcDecl)
+			return
+		}
+		if funcDecl.Type.TypeParams != nil || isGenericMethod(funcDecl) {
+			// generic functions and methods of generic types are walked per instance when a call site instantiates them
+			return
+		}
+		if funcDecl.Body == nil {
+			// implemented by the runtime or C
+			if linkname(funcDecl) == "" && externName(funcDecl) == "" {
+				must(fmt.Errorf("missing function body: %s", funcDecl.Name.Name))
+			}
+			return
+		}
 		fnc := &Func{
-			decl:      funcDecl,
-			localvars: localvars,
-			localarea: localoffset * -1,
-			argsarea:  *paramoffset,
+			decl: funcDecl,
+			pkg:  curPkg,
+			name: funcDecl.Name.Name,
 		}
+		if funcDecl.Recv != nil {
+			fnc.name = methodFuncName(funcDecl)
+		}
+		funcWalk(fnc)
 		funcs = append(funcs, fnc)
 	default:
 		must(fmt.Errorf("unexpected declaration: %T", decl))
 	}
 }
 
+// funcWalk computes the frame layout of fnc and walks its body.
+// the offsets are stored in the objects of the declaration, so instances of a generic function are walked again just before emitting them.
+func funcWalk(fnc *Func) {
+	outer := curTypeArgs
+	curTypeArgs = fnc.typeArgs
+	defer func() { curTypeArgs = outer }()
+
+	analyzeEscapes(fnc)
+	var localvars []*ast.Object
+	localoffset := 0
+	paramoffset := new(int)
+	*paramoffset = 16
+	if fnc.decl.Recv != nil { // the receiver is the first argument
+		funcParamsWalk(fnc.decl.Recv, paramoffset)
+	}
+	if usesRegABI(fnc) {
+		localvars = regParamsWalk(fnc, localvars, &localoffset)
+	} else {
+		funcParamsWalk(fnc.decl.Type.Params, paramoffset)
+	}
+	// parameters in the heap are copied by the prologue, and their slots hold the pointers
+	fnc.heapParams = nil
+	for _, list := range []*ast.FieldList{fnc.decl.Recv, fnc.decl.Type.Params} {
+		if list == nil {
+			continue
+		}
+		for _, field := range list.List {
+			for _, name := range field.Names {
+				if isHeapVar(name.Obj) {
+					fnc.heapParams = append(fnc.heapParams, heapParam{name.Obj, getObjectData(name.Obj)})
+					localvars = allocLocal(name.Obj, localvars, &localoffset)
+				}
+			}
+		}
+	}
+	// named results are local variables, which are returned by return statements
+	for _, name := range namedResults(fnc.decl) {
+		localvars = allocLocal(name.Obj, localvars, &localoffset)
+	}
+	localvars = bodyWalk(fnc.decl.Body.List, localvars, &localoffset)
+	fnc.localvars = localvars
+	fnc.localarea = localoffset * -1
+	fnc.argsarea = *paramoffset
+}
+
+// namedResults returns the names of the results of decl, or nil if they are unnamed
+func namedResults(decl *ast.FuncDecl) []*ast.Ident {
+	if decl.Type.Results == nil {
+		return nil
+	}
+	var names []*ast.Ident
+	for _, field := range decl.Type.Results.List {
+		names = append(names, field.Names...)
+	}
+	return names
+}
+
 func funcParamsWalk(params *ast.FieldList, paramoffset *int) {
 	for _, field := range params.List {
-		obj := field.Names[0].Obj
-		varSize := 8                             // default size is int variable
-		if getType(field.Type) == globalString { // object type is string
-			varSize = 16
+		varSize := sizeOf(getType(field.Type))
+		for _, name := range field.Names {
+			setObjectData(name.Obj, *paramoffset)
+			*paramoffset += varSize
+		}
+		if len(field.Names) == 0 { // unnamed parameter
+			*paramoffset += varSize
 		}
-		setObjectData(obj, *paramoffset)
-		*paramoffset += varSize
 	}
 }
 
-func bodyWalk(stmts []ast.Stmt, localvars []*ast.ValueSpec, localoffset *int) []*ast.ValueSpec {
+func bodyWalk(stmts []ast.Stmt, localvars []*ast.Object, localoffset *int) []*ast.Object {
 	for _, stmt := range stmts {
 		switch s := stmt.(type) {
 		case *ast.DeclStmt: // escape panic error
 			localvars = walkDeclField(&s.Decl, localvars, localoffset)
 		case *ast.AssignStmt: // escape panic error
 			walkAssignStmt(s)
+			if s.Tok == token.DEFINE {
+				localvars = walkDefine(s, localvars, localoffset)
+			}
 		case *ast.ExprStmt:
 			expr := s.X
 			walkExpr(&expr)
@@ -133,6 +513,51 @@ func bodyWalk(stmts []ast.Stmt, localvars []*ast.ValueSpec, localoffset *int) []
 			for _, r := range s.Results {
 				walkExpr(&r)
 			}
+		case *ast.IfStmt:
+			walkExpr(&s.Cond)
+			localvars = bodyWalk(s.Body.List, localvars, localoffset)
+			if s.Else != nil {
+				localvars = bodyWalk([]ast.Stmt{s.Else}, localvars, localoffset)
+			}
+		case *ast.BlockStmt:
+			localvars = bodyWalk(s.List, localvars, localoffset)
+		case *ast.ForStmt:
+			if s.Init != nil {
+				localvars = bodyWalk([]ast.Stmt{s.Init}, localvars, localoffset)
+			}
+			if s.Cond != nil {
+				walkExpr(&s.Cond)
+			}
+			if s.Post != nil {
+				localvars = bodyWalk([]ast.Stmt{s.Post}, localvars, localoffset)
+			}
+			localvars = bodyWalk(s.Body.List, localvars, localoffset)
+		case *ast.RangeStmt:
+			if s.Tok == token.DEFINE {
+				for _, x := range []ast.Expr{s.Key, s.Value} {
+					if ident, ok := x.(*ast.Ident); ok && ident.Obj != nil {
+						localvars = allocLocal(ident.Obj, localvars, localoffset)
+					}
+				}
+			}
+			localvars = bodyWalk([]ast.Stmt{desugarRange(s)}, localvars, localoffset)
+		case *ast.SwitchStmt:
+			if d := desugarSwitch(s); d != s {
+				localvars = bodyWalk([]ast.Stmt{d}, localvars, localoffset)
+			} else {
+				localvars = bodyWalk(s.Body.List, localvars, localoffset)
+			}
+		case *ast.CaseClause:
+			for i := range s.List {
+				walkExpr(&s.List[i])
+			}
+			localvars = bodyWalk(s.Body, localvars, localoffset)
+		case *ast.IncDecStmt:
+			walkExpr(&s.X)
+		case *ast.BranchStmt:
+			if s.Label != nil || s.Tok == token.GOTO {
+				must(fmt.Errorf("labels are not supported: %s %s", s.Tok, s.Label))
+			}
 		default:
 			must(fmt.Errorf("Unexpected stmt type: %T", stmt))
 		}
@@ -140,23 +565,22 @@ func bodyWalk(stmts []ast.Stmt, localvars []*ast.ValueSpec, localoffset *int) []
 	return localvars
 }
 
-func walkDeclField(decl *ast.Decl, localvars []*ast.ValueSpec, localoffset *int) []*ast.ValueSpec {
+func walkDeclField(decl *ast.Decl, localvars []*ast.Object, localoffset *int) []*ast.Object {
 	switch decl := (*decl).(type) {
 	case *ast.GenDecl:
 		declSpec := decl.Specs[0]
 		switch ds := declSpec.(type) {
 		case *ast.ValueSpec:
-			varSpec := ds
-			obj := varSpec.Names[0].Obj
-			varSize := 8 // default size is int variable
-			if getType(varSpec.Type) == globalString {
-				varSize = 16
+			for i := range ds.Values {
+				walkExpr(&ds.Values[i])
 			}
-
-			*localoffset -= varSize
-			setObjectData(obj, *localoffset)
-			localvars = append(localvars, ds)
-			fmt.Printf("  # localvars: %v\n", localvars)
+			if decl.Tok == token.CONST { // constants are evaluated where they are used
+				break
+			}
+			for _, name := range ds.Names {
+				localvars = allocLocal(name.Obj, localvars, localoffset)
+			}
+			emit("  # localvars: %s\n", localvarsString(localvars))
 		}
 	default:
 		must(fmt.Errorf("unexpected type of declaration: %T", decl))
@@ -164,6 +588,119 @@ func walkDeclField(decl *ast.Decl, localvars []*ast.ValueSpec, localoffset *int)
 	return localvars
 }
 
+// walkDefine allocates the variables newly declared by x := y
+func walkDefine(stmt *ast.AssignStmt, localvars []*ast.Object, localoffset *int) []*ast.Object {
+	for _, lhs := range stmt.Lhs {
+		ident, ok := lhs.(*ast.Ident)
+		if !ok || ident.Obj == nil || ident.Obj.Decl != stmt {
+			continue
+		}
+		localvars = allocLocal(ident.Obj, localvars, localoffset)
+	}
+	return localvars
+}
+
+func allocLocal(obj *ast.Object, localvars []*ast.Object, localoffset *int) []*ast.Object {
+	size := sizeOf(varType(obj))
+	if isHeapVar(obj) { // the pointer to the variable
+		size = 8
+	}
+	return allocSlot(obj, size, localvars, localoffset)
+}
+
+func allocSlot(obj *ast.Object, size int, localvars []*ast.Object, localoffset *int) []*ast.Object {
+	*localoffset -= size
+	setObjectData(obj, *localoffset)
+	return append(localvars, obj)
+}
+
+// tempVar declares a hidden local variable initialized by value. it is walked and emitted like var name = value
+func tempVar(name string, value ast.Expr) (*ast.Ident, ast.Stmt) {
+	ident := ast.NewIdent(name)
+	spec := &ast.ValueSpec{Names: []*ast.Ident{ident}, Values: []ast.Expr{value}}
+	ident.Obj = &ast.Object{Kind: ast.Var, Name: name, Decl: spec}
+	return ident, &ast.DeclStmt{Decl: &ast.GenDecl{Tok: token.VAR, Specs: []ast.Spec{spec}}}
+}
+
+// desugarRange rewrites for k, v := range x { ... } over a slice or an integer into
+//
+//	{ var .range = x; var .index = 0; for .index < len(.range); .index++ { k = .index; v = .range[.index]; ... } }
+func desugarRange(stmt *ast.RangeStmt) ast.Stmt {
+	if d, ok := desugared[stmt]; ok {
+		return d
+	}
+	typ := underlying(getType(stmt.X))
+	x, xDecl := tempVar(".range", stmt.X)
+	i, iDecl := tempVar(".index", &ast.BasicLit{Kind: token.INT, Value: "0"})
+	var limit ast.Expr = x
+	if typ != globalInt {
+		if !isSlice(typ) {
+			must(fmt.Errorf("range over %s is not supported", typ.Name))
+		}
+		limit = &ast.CallExpr{Fun: &ast.Ident{Name: "len", Obj: universe.Lookup("len")}, Args: []ast.Expr{x}}
+	}
+
+	var body []ast.Stmt
+	if stmt.Key != nil && !isBlank(stmt.Key) {
+		assign := &ast.AssignStmt{Lhs: []ast.Expr{stmt.Key}, Tok: token.ASSIGN, Rhs: []ast.Expr{i}}
+		rangeDefines[assign] = stmt.Tok == token.DEFINE
+		body = append(body, assign)
+	}
+	if stmt.Value != nil && !isBlank(stmt.Value) {
+		value := &ast.IndexExpr{X: x, Index: i}
+		assign := &ast.AssignStmt{Lhs: []ast.Expr{stmt.Value}, Tok: token.ASSIGN, Rhs: []ast.Expr{value}}
+		rangeDefines[assign] = stmt.Tok == token.DEFINE
+		body = append(body, assign)
+	}
+	body = append(body, stmt.Body)
+	loop := &ast.ForStmt{
+		Cond: &ast.BinaryExpr{X: i, Op: token.LSS, Y: limit},
+		Post: &ast.IncDecStmt{X: i, Tok: token.INC},
+		Body: &ast.BlockStmt{List: body},
+	}
+	desugared[stmt] = &ast.BlockStmt{List: []ast.Stmt{xDecl, iDecl, loop}}
+	return desugared[stmt]
+}
+
+// desugarSwitch rewrites switch init; tag { case a, b: ... } into
+//
+//	{ init; var .tag = tag; switch { case .tag == a, .tag == b: ... } }
+//
+// switch statements without init and tag are returned as they are
+func desugarSwitch(stmt *ast.SwitchStmt) ast.Stmt {
+	if stmt.Init == nil && stmt.Tag == nil {
+		return stmt
+	}
+	if d, ok := desugared[stmt]; ok {
+		return d
+	}
+	var list []ast.Stmt
+	if stmt.Init != nil {
+		list = append(list, stmt.Init)
+	}
+	body := stmt.Body
+	if stmt.Tag != nil {
+		tag, decl := tempVar(".tag", stmt.Tag)
+		list = append(list, decl)
+		body = &ast.BlockStmt{}
+		for _, clause := range stmt.Body.List {
+			cc := clause.(*ast.CaseClause)
+			var conds []ast.Expr
+			for _, value := range cc.List {
+				conds = append(conds, &ast.BinaryExpr{X: tag, Op: token.EQL, Y: value})
+			}
+			body.List = append(body.List, &ast.CaseClause{List: conds, Body: cc.Body})
+		}
+	}
+	desugared[stmt] = &ast.BlockStmt{List: append(list, &ast.SwitchStmt{Body: body})}
+	return desugared[stmt]
+}
+
+func isBlank(expr ast.Expr) bool {
+	ident, ok := expr.(*ast.Ident)
+	return ok && ident.Name == "_"
+}
+
 func walkExpr(expr *ast.Expr) {
 	switch e := (*expr).(type) {
 	case *ast.Ident:
@@ -171,9 +708,19 @@ func walkExpr(expr *ast.Expr) {
 		// add empty body this why without this statement, the program will not compile
 		break
 	case *ast.CallExpr:
-		for _, arg := range e.Args {
+		args := e.Args
+		if ident := funcIdent(e.Fun); ident != nil && (isBuiltin(ident, "new") || isBuiltin(ident, "make")) {
+			args = args[1:] // the first argument is a type
+		}
+		for _, arg := range args {
 			walkExpr(&arg)
 		}
+		if sel := methodSelector(e); sel != nil {
+			walkExpr(&sel.X)
+		}
+		if genericFuncDecl(e) != nil {
+			instantiateFunc(e)
+		}
 	case *ast.ParenExpr: // "(" or ")" expr
 		walkExpr(&e.X)
 	case *ast.BasicLit:
@@ -181,25 +728,51 @@ func walkExpr(expr *ast.Expr) {
 	case *ast.BinaryExpr:
 		walkExpr(&e.X)
 		walkExpr(&e.Y)
+	case *ast.UnaryExpr:
+		walkExpr(&e.X)
+	case *ast.StarExpr:
+		walkExpr(&e.X)
+	case *ast.SelectorExpr:
+		walkExpr(&e.X)
+	case *ast.IndexExpr:
+		walkExpr(&e.X)
+		walkExpr(&e.Index)
+	case *ast.SliceExpr:
+		for _, x := range []ast.Expr{e.X, e.Low, e.High} {
+			if x != nil {
+				walkExpr(&x)
+			}
+		}
+	case *ast.CompositeLit:
+		for _, elt := range e.Elts {
+			if kv, ok := elt.(*ast.KeyValueExpr); ok {
+				elt = kv.Value
+			}
+			walkExpr(&elt)
+		}
 	default:
 		must(fmt.Errorf("unexpected expr type %T", *expr))
 	}
 }
 
 func walkAssignStmt(stmt *ast.AssignStmt) {
-	// l := stmt.Lhs[0]
-	r := stmt.Rhs[0]
-	// walkExpr(&l)
-	walkExpr(&r)
+	if len(stmt.Lhs) != len(stmt.Rhs) && (len(stmt.Rhs) != 1 || len(tupleTypes(getType(stmt.Rhs[0]))) != len(stmt.Lhs)) {
+		must(fmt.Errorf("assignment mismatch: %d variables but %d values", len(stmt.Lhs), len(stmt.Rhs)))
+	}
+	for i := range stmt.Rhs {
+		walkExpr(&stmt.Rhs[i])
+	}
 }
 
 func parseStringLiteral(expr *ast.BasicLit) {
 	switch expr.Kind.String() {
 	// TODO INT
-	case "INT":
+	case "INT", "CHAR":
 		break
 	case "STRING":
-		stringLiterals = append(stringLiterals, stringLiteral{tag: "", value: expr.Value})
+		if !hasStringLiteral(expr.Value) {
+			stringLiterals = append(stringLiterals, stringLiteral{tag: "", value: expr.Value})
+		}
 	default:
 		must(fmt.Errorf("unexpected basic literal type %T", expr))
 	}
@@ -208,41 +781,64 @@ func parseStringLiteral(expr *ast.BasicLit) {
 func parseGlobalVariables(decl *ast.GenDecl) {
 	switch decl.Tok {
 	case token.VAR:
-		valSpec, ok := decl.Specs[0].(*ast.ValueSpec)
-		if !ok {
-			must(fmt.Errorf("unexpected value spec type %T", decl.Specs[0]))
+		for _, spec := range decl.Specs {
+			valSpec, ok := spec.(*ast.ValueSpec)
+			if !ok {
+				must(fmt.Errorf("unexpected value spec type %T", spec))
+			}
+			parseGrobalVariable(valSpec)
 		}
-		fmt.Printf("# spec.Name=%v, spec.Value=%v\n", valSpec.Names[0], valSpec.Values[0])
-		valSpec.Names[0].Obj.Data = -1
-		parseGrobalVariable(valSpec)
-		typeIdent, ok := valSpec.Type.(*ast.Ident)
-		if !ok {
-			must(fmt.Errorf("unexpected type %T", valSpec.Type))
+	case token.CONST:
+		for _, spec := range decl.Specs {
+			valSpec := spec.(*ast.ValueSpec)
+			if len(valSpec.Values) != len(valSpec.Names) {
+				must(fmt.Errorf("constants must have values: %s", valSpec.Names[0].Name))
+			}
+			for i := range valSpec.Values {
+				walkExpr(&valSpec.Values[i])
+			}
 		}
-		// object data is -1(global variable mark)
-		globalVariables = append(globalVariables, globalVariable{tag: valSpec.Names[0].Name, value: valSpec.Values[0].(*ast.BasicLit).Value, typ: typeIdent})
 	}
 }
 
+// parseGrobalVariable registers package level variables. literals are emitted as static data,
+// other initializers are evaluated by the package initialization in dependency order
 func parseGrobalVariable(valSpec *ast.ValueSpec) {
-	typeIdent, ok := valSpec.Type.(*ast.Ident)
-	if !ok {
-		must(fmt.Errorf("unexpected type ident %v", typeIdent))
+	tuple := len(valSpec.Values) == 1 && len(valSpec.Names) > 1 // var a, b = f()
+	if tuple {
+		walkExpr(&valSpec.Values[0])
+		if n := len(tupleTypes(getType(valSpec.Values[0]))); n != len(valSpec.Names) {
+			must(fmt.Errorf("assignment mismatch: %d variables but %d values", len(valSpec.Names), n))
+		}
+		curPkg.varInits = append(curPkg.varInits, &varInit{names: valSpec.Names, value: valSpec.Values[0]})
+	} else if len(valSpec.Values) > 0 && len(valSpec.Values) != len(valSpec.Names) {
+		must(fmt.Errorf("assignment mismatch: %d variables but %d values", len(valSpec.Names), len(valSpec.Values)))
 	}
-	switch typeIdent.Obj {
-	case globalInt:
-		_, ok := valSpec.Values[0].(*ast.BasicLit)
-		if !ok {
-			must(fmt.Errorf("unexpected type ident %v", typeIdent))
+	for i, name := range valSpec.Names {
+		var value ast.Expr
+		if i < len(valSpec.Values) && !tuple {
+			walkExpr(&valSpec.Values[i])
+			value = valSpec.Values[i]
 		}
-	case globalString:
-		lit, ok := valSpec.Values[0].(*ast.BasicLit)
-		if !ok {
-			must(fmt.Errorf("unexpected type ident %v", typeIdent))
+		emit("# spec.Name=%s, spec.Value=%s\n", name.Name, exprString(value))
+		if name.Name == "_" { // evaluated only for side effects
+			if value != nil {
+				curPkg.varInits = append(curPkg.varInits, &varInit{value: value})
+			}
+			continue
 		}
-		parseStringLiteral(lit)
-	default:
-		must(fmt.Errorf("Unexpected global ident"))
+
+		// object data is -1(global variable mark)
+		name.Obj.Data = -1
+		gv := globalVariable{tag: globalSymbol(name.Obj), typ: varType(name.Obj)}
+		if lit, ok := value.(*ast.BasicLit); ok && lit.Kind == token.STRING {
+			gv.value = lit.Value
+		} else if ok {
+			gv.value = strconv.Itoa(intValue(lit))
+		} else if value != nil {
+			curPkg.varInits = append(curPkg.varInits, &varInit{names: []*ast.Ident{name}, value: value})
+		}
+		globalVariables = append(globalVariables, gv)
 	}
 }
 
@@ -258,353 +854,1395 @@ func emitExpr(expr ast.Expr) {
 		emitBasicLit(e)
 	case *ast.BinaryExpr:
 		emitBinaryExpr(e)
+	case *ast.UnaryExpr:
+		emitUnaryExpr(e)
+	case *ast.StarExpr: // *p
+		emitExpr(e.X)
+		emitLoad(getExprSize(&expr), "*"+exprString(e.X))
+	case *ast.SelectorExpr:
+		emitSelectorExpr(e)
+	case *ast.CompositeLit:
+		emitCompositeLit(e)
+	case *ast.IndexExpr:
+		emitIndexAddr(e)
+		emitLoad(memSize(e), exprString(e))
+	case *ast.SliceExpr:
+		emitSliceExpr(e)
 	default:
 		must(fmt.Errorf("unexpected expr type %T", expr))
 	}
 }
 
-func getLocalOffset(obj *ast.Object) int {
-	switch obj.Decl.(type) {
-	case *ast.Field:
-		return getObjectData(obj)
-	case *ast.ValueSpec:
-		return getObjectData(obj) * -1
+// emitExprAs pushes the value of expr assigned to a variable of typ.
+// values assigned to interfaces are boxed, and nil gets the size of typ
+func emitExprAs(expr ast.Expr, typ *ast.Object) {
+	src := getType(expr)
+	switch {
+	case src == untypedNil:
+		emitZero(sizeOf(typ))
+	case isInterface(typ) && !isInterface(src):
+		checkImplements(src, typ)
+		emitExpr(expr)
+		emitBox(src)
 	default:
-		must(fmt.Errorf("unexpected obj type %T", obj))
+		emitExpr(expr)
 	}
-	return 0
 }
 
 func emitVariable(obj *ast.Object) {
+	if obj.Kind == ast.Con {
+		if value := constValue(obj); value != nil {
+			emitExpr(value)
+			return
+		}
+		emit("  pushq $%d # %s\n", boolValue(obj), obj.Name) // true or false
+		return
+	}
 	if obj.Kind != ast.Var {
 		must(fmt.Errorf("ident kind should be ast.Var"))
 	}
 
-	var typ ast.Expr
-	var localOffset int
+	emitVariableAddr(obj)
+	emitLoad(sizeOf(varType(obj)), obj.Name)
+}
 
-	switch dcl := obj.Decl.(type) {
-	case *ast.ValueSpec:
-		typ = dcl.Type
-		localOffset = getObjectData(obj) * -1
-	case *ast.Field:
-		typ = dcl.Type
-		localOffset = getObjectData(obj)
+// emitLoad replaces the address on the top of the stack with the size bytes value it points.
+// the first word is pushed last so that it lies at the lowest address like in memory.
+func emitLoad(size int, name string) {
+	emit("  popq %%rax # address of %s\n", name)
+	if size == 1 {
+		emit("  movzbq (%%rax), %%rcx\n")
+		emit("  pushq %%rcx\n")
+		return
+	}
+	for off := size - 8; off >= 0; off -= 8 {
+		emit("  pushq %d(%%rax)\n", off)
 	}
+}
 
-	switch getType(typ) {
-	case globalInt:
-		if getObjectData(obj) == -1 {
-			fmt.Printf("  movq %s+0(%%rip), %%rax\n", obj.Name) // object name(param name) address move to rax
-		} else {
-			fmt.Printf("  movq %d(%%rbp), %%rax # %s\n", localOffset, obj.Name) // emit local int variable
-		}
-		fmt.Printf("  pushq %%rax\n")
-	case globalString:
-		if getObjectData(obj) == -1 { // obj data is global variable
-			fmt.Printf("  movq %s+0(%%rip), %%rax\n", obj.Name)
-			fmt.Printf("  movq %s+8(%%rip), %%rcx\n", obj.Name)
-		} else { // obj data is local variable
-			fmt.Printf("  movq %d(%%rbp), %%rax # ptr %s \n", localOffset, obj.Name)   // emit local string variable address
-			fmt.Printf("  movq %d(%%rbp), %%rcx # len %s \n", localOffset+8, obj.Name) // emit local string variable length
-		}
-		fmt.Printf("  pushq %%rcx # len\n")
-		fmt.Printf("  pushq %%rax # ptr\n")
-	default:
-		must(fmt.Errorf("Unexpected global ident"))
+// emitStore pops the address and then the size bytes value from the stack and writes the value to the address
+func emitStore(size int, name string) {
+	emit("  popq %%rax # address of %s\n", name)
+	if size == 1 {
+		emit("  popq %%rcx\n")
+		emit("  movb %%cl, (%%rax)\n")
+		return
+	}
+	for off := 0; off < size; off += 8 {
+		emit("  popq %d(%%rax)\n", off)
 	}
 }
 
 func emitBasicLit(expr *ast.BasicLit) {
-	if expr.Kind.String() == "INT" {
-		val := expr.Value
-		ival, err := strconv.Atoi(val)
-		must(err)
-		fmt.Printf("# %T\n", expr)
-		fmt.Printf("  movq $%d, %%rax\n", ival)
-		fmt.Printf("  pushq %%rax\n")
+	if expr.Kind.String() == "INT" || expr.Kind.String() == "CHAR" {
+		emit("# %T\n", expr)
+		emit("  movq $%d, %%rax\n", intValue(expr))
+		emit("  pushq %%rax\n")
 	} else if expr.Kind.String() == "STRING" {
-		fmt.Printf("  pushq $%d\n", len(expr.Value)-1-2)
+		emit("  pushq $%d\n", stringLen(expr.Value))
 		// FIXME: searchTag function computable complexity is O(n)
-		fmt.Printf("  leaq %s, %%rax\n", searchTag(expr.Value))
-		fmt.Printf("  pushq %%rax\n")
-		stringLiterals = stringLiterals[1:]
+		emit("  leaq %s, %%rax\n", searchTag(expr.Value))
+		emit("  pushq %%rax\n")
 	} else {
 		must(fmt.Errorf("unexpected basic literal type %T", expr))
 	}
 }
 
 func emitBinaryExpr(expr *ast.BinaryExpr) {
-	fmt.Printf("# start %T\n", expr)
+	emit("# start %T\n", expr)
+	switch expr.Op {
+	case token.LAND, token.LOR:
+		emitLogicalExpr(expr)
+		return
+	}
+	if x, ok := expr.Y.(*ast.Ident); ok && x.Obj == globalNil && sizeOf(getType(expr.X)) > 8 {
+		emitNilCompare(expr.X, expr.Op)
+		return
+	}
+	if isInterface(getType(expr.X)) || isInterface(getType(expr.Y)) {
+		emitIfaceCompare(expr)
+		return
+	}
+	if underlying(getType(expr.X)) == globalString {
+		emitStringBinaryExpr(expr)
+		return
+	}
 	emitExpr(expr.X) // left
 	emitExpr(expr.Y) // right
-	fmt.Printf("  popq %%rdi # right\n")
-	fmt.Printf("  popq %%rax # left\n")
+	emit("  popq %%rdi # right\n")
+	emit("  popq %%rax # left\n")
 	switch expr.Op.String() {
 	case "+":
-		fmt.Printf("  addq %%rdi, %%rax\n")
-		fmt.Printf("  pushq %%rax\n")
+		emit("  addq %%rdi, %%rax\n")
 	case "-":
-		fmt.Printf("  subq %%rdi, %%rax\n")
-		fmt.Printf("  pushq %%rax\n")
+		emit("  subq %%rdi, %%rax\n")
 	case "*":
-		fmt.Printf("  imulq %%rdi, %%rax\n")
-		fmt.Printf("  pushq %%rax\n")
+		emit("  imulq %%rdi, %%rax\n")
+	case "/", "%":
+		emitDivide(expr.Op == token.REM, isNonZeroLit(expr.Y))
+	case "&":
+		emit("  andq %%rdi, %%rax\n")
+	case "|":
+		emit("  orq %%rdi, %%rax\n")
+	case "^":
+		emit("  xorq %%rdi, %%rax\n")
+	case "&^":
+		emit("  notq %%rdi\n")
+		emit("  andq %%rdi, %%rax\n")
+	case "<<":
+		emitShift("salq")
+	case ">>":
+		if underlying(getType(expr.X)) == globalByte {
+			emitShift("shrq")
+		} else {
+			emitShift("sarq")
+		}
+	case "==", "!=", "<", "<=", ">", ">=":
+		emit("  cmpq %%rdi, %%rax\n")
+		emitSetcc(expr.Op)
+		return
 	default:
 		panic(fmt.Errorf("unexpected binary operator: %s", expr.Op.String()))
 	}
+	emitTruncate(getType(expr))
+	emit("  pushq %%rax\n")
 }
 
-func emitFunc(expr *ast.CallExpr) {
-	fun := expr.Fun
-	fmt.Printf("  # fun = %T\n", fun)
-	switch fn := fun.(type) {
-	case *ast.Ident:
-		if fn.Name == "print" {
-			emitExpr(expr.Args[0])
-			// build in print
-			fmt.Printf("  call runtime.print\n")
-			fmt.Printf("  addq $16, %%rsp\n")
-		} else {
-			argsSize := 0
-			// push args like stack
-			for i := len(expr.Args) - 1; i >= 0; i-- {
-				emitExpr(expr.Args[i])
-				argsSize += getExprSize(&expr.Args[i])
-			}
-			// FIXME package name is main only.
-			fmt.Printf("  callq main.%s\n", fn.Name)
-			fmt.Printf("  addq $%d, %%rsp\n", argsSize)
-
-			// next!!!!
-			obj := fn.Obj
-			dclfn, ok := obj.Decl.(*ast.FuncDecl)
-			if !ok {
-				must(fmt.Errorf("unexpected obj type %T", obj))
-			}
-
-			if dclfn.Type.Results != nil {
-				// FIXME: can multi return value
-				// return length is 1
-				retval := dclfn.Type.Results.List[0]
-				switch getType(retval.Type) {
-				case globalInt:
-					fmt.Printf("  pushq %%rax\n")
+// emitShift shifts rax by the count in rdi with op, which is salq, shrq or sarq. x86-64 takes the count modulo 64,
+// but in Go the bits shifted by a count of the width or more are all gone: the result is 0, or the sign bits for sarq
+func emitShift(op string) {
+	if op == "sarq" {
+		emit("  movq $63, %%rcx\n")
+		emit("  cmpq $63, %%rdi\n")
+		emit("  cmovbeq %%rdi, %%rcx\n")
+		emit("  sarq %%cl, %%rax\n")
+		return
+	}
+	emit("  movq %%rdi, %%rcx\n")
+	emit("  %s %%cl, %%rax\n", op)
+	emit("  movq $0, %%rdx\n")
+	emit("  cmpq $64, %%rdi\n")
+	emit("  cmovaeq %%rdx, %%rax\n")
+}
 
-				case globalString:
-					fmt.Printf("  pushq %%rsi # len \n")
-					fmt.Printf("  pushq %%rax # ptr \n")
-				}
-			}
+// emitDivide divides rax by rdi, leaving the quotient or the remainder in rax. idivq traps for 0 and for the minimum int
+// divided by -1, while in Go the first panics and the second is the minimum int with the remainder 0 by wrapping around.
+// the checks are left out for a literal divisor, which is neither
+func emitDivide(rem bool, literal bool) {
+	if !literal {
+		labelSeq++
+		div, done := fmt.Sprintf(".L.div.%d", labelSeq), fmt.Sprintf(".L.div.end.%d", labelSeq)
+		emit("  testq %%rdi, %%rdi\n")
+		emit("  je runtime.panicdivide\n")
+		emit("  cmpq $-1, %%rdi\n")
+		emit("  jne %s\n", div)
+		if rem {
+			emit("  movq $0, %%rax\n")
+		} else {
+			emit("  negq %%rax\n")
 		}
-	case *ast.SelectorExpr:
-		emitExpr(expr.Args[0])
-		symbol := fmt.Sprintf("%s.%s", fn.X, fn.Sel)
-		fmt.Printf("  callq %s\n", symbol)
+		emit("  jmp %s\n", done)
+		emit("%s:\n", div)
+		defer emit("%s:\n", done)
+	}
+	emit("  cqto\n")
+	emit("  idivq %%rdi\n")
+	if rem {
+		emit("  movq %%rdx, %%rax\n")
 	}
 }
 
-// emitDeclFunc emits assembly code for a declarated function. parse func XXX(...) {...}
-func emitDeclFunc(pkg string, fnc *Func) {
-	funcDecl := fnc.decl
-	fmt.Printf("# %T\n", funcDecl)
-	fmt.Printf(".text\n")
-	fmt.Printf("%s.%s: # args %d, locals %d\n",
-		pkg,
-		funcDecl.Name,
-		fnc.argsarea,
-		fnc.localarea)
-	fmt.Printf("  pushq %%rbp\n")
-	fmt.Printf("  movq %%rsp, %%rbp\n")
-	fmt.Printf("# localvars: %v\n", fnc.localvars)
-	if len(fnc.localvars) > 0 {
-		fmt.Printf("  subq $%d, %%rsp\n", fnc.localarea)
-	}
+// isNonZeroLit reports whether expr is an integer literal other than 0
+func isNonZeroLit(expr ast.Expr) bool {
+	lit, ok := expr.(*ast.BasicLit)
+	return ok && (lit.Kind == token.INT || lit.Kind == token.CHAR) && intValue(lit) != 0
+}
 
-	// emit assembly code for function body. parse {...}
-	emitFuncBody(funcDecl.Body)
+// emitTruncate wraps around the result in rax of an arithmetic operation on bytes
+func emitTruncate(typ *ast.Object) {
+	if underlying(typ) == globalByte {
+		emit("  movzbq %%al, %%rax\n")
+	}
+}
 
-	fmt.Printf("  leave\n")
-	// emit return statement
-	fmt.Printf("  ret\n")
+// emitNilCompare compares a slice or an interface with nil by its first word
+func emitNilCompare(x ast.Expr, op token.Token) {
+	emitExpr(x)
+	emit("  popq %%rax\n")
+	emit("  addq $%d, %%rsp\n", getExprSize(&x)-8)
+	emit("  cmpq $0, %%rax\n")
+	emitSetcc(op)
 }
 
-func emitFuncBody(body *ast.BlockStmt) {
-	for _, stmt := range body.List {
-		switch s := stmt.(type) {
-		case *ast.ExprStmt:
-			expr := s.X
-			emitExpr(expr)
-		case *ast.DeclStmt:
-			continue
-		case *ast.AssignStmt: // emit and analyze expression like x := y
-			fmt.Printf("  # *ast.AssignStmt\n")
-			emitAssignStmt(s)
-		case *ast.ReturnStmt:
-			if len(s.Results) == 1 {
-				emitExpr(s.Results[0])
-				fmt.Printf("  popq %%rax\n") // return value
-				if getType(s.Results[0]) == globalString {
-					fmt.Printf("  popq %%rsi\n")
-				}
-			}
-			fmt.Printf("  leave\n")
-			fmt.Printf("  ret\n")
-		default:
-			must(fmt.Errorf("unexpected stmt type %T", stmt))
-		}
+// emitSetcc pushes 1 if the last comparison satisfies op, otherwise 0
+func emitSetcc(op token.Token) {
+	setcc := map[token.Token]string{
+		token.EQL: "sete",
+		token.NEQ: "setne",
+		token.LSS: "setl",
+		token.LEQ: "setle",
+		token.GTR: "setg",
+		token.GEQ: "setge",
 	}
+	emit("  %s %%al\n", setcc[op])
+	emit("  movzbq %%al, %%rax\n")
+	emit("  pushq %%rax\n")
 }
 
-func emitAssignStmt(stmt *ast.AssignStmt) {
-	lhs := stmt.Lhs[0] // lhs is left side of assignment. e.g. x := 5, lhs is x
-	rhs := stmt.Rhs[0] // rhs is right side of assignment. e.g. x := 5, rhs is 5
-	emitAddr(&lhs)
-	emitExpr(rhs) // push rhs to stack
-
-	switch getType(lhs) {
-	// variable type is string
-	case globalString:
-		fmt.Printf("  popq %%rcx # rhs len\n")
-		fmt.Printf("  popq %%rax # rhs pointer\n")
-		fmt.Printf("  popq %%rdx # lhs len\n")
-		fmt.Printf("  popq %%rsi # lhs pointer\n")
-		fmt.Printf("  movq %%rcx, (%%rdx) # rhs len address -> rdx\n")
-		fmt.Printf("  movq %%rax, (%%rsi) # rhs pointer address -> rax\n")
-	// int
+// emitStringBinaryExpr concatenates or compares two strings with the runtime
+func emitStringBinaryExpr(expr *ast.BinaryExpr) {
+	emitExpr(expr.X) // left
+	emitExpr(expr.Y) // right
+	switch expr.Op {
+	case token.ADD:
+		emit("  callq runtime.concatstring\n")
+		emit("  addq $32, %%rsp\n")
+		emit("  pushq %%rsi # len\n")
+		emit("  pushq %%rax # ptr\n")
+	case token.EQL, token.NEQ, token.LSS, token.LEQ, token.GTR, token.GEQ:
+		emit("  callq runtime.cmpstring\n")
+		emit("  addq $32, %%rsp\n")
+		emit("  cmpq $0, %%rax\n")
+		emitSetcc(expr.Op)
 	default:
-		fmt.Printf("  popq %%rdi # rhs evaluated -> rdi\n")
-		fmt.Printf("  popq %%rax # lhs address -> rax\n")
-		fmt.Printf("  movq %%rdi, (%%rax) # rhs address -> rax\n ")
+		must(fmt.Errorf("unexpected string operator: %s", expr.Op.String()))
 	}
 }
 
-func emitAddr(expr *ast.Expr) {
-	// emit variable address like emitGlobalVariables
-	switch e := (*expr).(type) {
-	case *ast.Ident:
-		if e.Obj.Kind == ast.Var {
-			emitVariableAddr(e.Obj)
-		}
+// emitLogicalExpr emits && and || which don't evaluate the right operand if the left one decides the result
+func emitLogicalExpr(expr *ast.BinaryExpr) {
+	labelSeq++
+	label := fmt.Sprintf(".L.logical.%d", labelSeq)
+	emitExpr(expr.X)
+	emit("  popq %%rax\n")
+	emit("  cmpq $0, %%rax\n")
+	if expr.Op == token.LAND {
+		emit("  je %s\n", label) // left is false
+	} else {
+		emit("  jne %s\n", label) // left is true
 	}
+	emitExpr(expr.Y)
+	emit("  popq %%rax\n")
+	emit("%s:\n", label)
+	emit("  pushq %%rax\n")
 }
 
-func emitVariableAddr(obj *ast.Object) {
-	decl, ok := obj.Decl.(*ast.ValueSpec)
-	if !ok {
-		must(fmt.Errorf("unexpected variable decl type %T", obj.Decl))
+func emitUnaryExpr(expr *ast.UnaryExpr) {
+	if expr.Op == token.AND {
+		emitAddrOf(expr.X)
+		return
 	}
+	emitExpr(expr.X)
+	emit("  popq %%rax\n")
+	switch expr.Op {
+	case token.SUB:
+		emit("  negq %%rax\n")
+	case token.NOT:
+		emit("  xorq $1, %%rax\n")
+	case token.XOR:
+		emit("  notq %%rax\n")
+	case token.ADD:
+	default:
+		must(fmt.Errorf("unexpected unary operator: %s", expr.Op.String()))
+	}
+	emitTruncate(getType(expr))
+	emit("  pushq %%rax\n")
+}
 
-	fmt.Printf("  # ident kind=%v\n", obj.Kind)
-	fmt.Printf("  # Obj=%v\n", obj)
-
-	isString := getType(decl.Type) == globalString
-	isInt := getType(decl.Type) == globalInt
+// emitSelectorExpr pushes the value of the struct field x.f or the package level variable pkg.x
+func emitSelectorExpr(expr *ast.SelectorExpr) {
+	if ident := qualifiedIdent(expr); ident != nil {
+		emitExpr(ident)
+		return
+	}
+	var e ast.Expr = expr
+	if isAddressable(expr) {
+		emitAddr(&e)
+		emitLoad(getExprSize(&e), expr.Sel.Name)
+		return
+	}
 
-	fmt.Printf("# getObjectData: %d\n", getObjectData(obj))
+	// the struct is a temporary value like f().x. move the field to the end of the value and drop the rest
+	x := expr.X
+	size := getExprSize(&x)
+	field := lookupField(getType(expr.X), expr.Sel.Name)
+	fieldSize := sizeOf(field.typ)
+	emitExpr(expr.X)
+	for off := fieldSize - 8; off >= 0; off -= 8 {
+		emit("  movq %d(%%rsp), %%rax\n", field.offset+off)
+		emit("  movq %%rax, %d(%%rsp)\n", size-fieldSize+off)
+	}
+	emit("  addq $%d, %%rsp # .%s\n", size-fieldSize, expr.Sel.Name)
+}
 
-	// analyzed variable is global string variable.
-	if isString && getObjectData(obj) == -1 {
-		fmt.Printf("# Global\n")
-		fmt.Printf("  leaq %s+0(%%rip), %%rax\n", obj.Name)
-		fmt.Printf("  leaq %s+8(%%rip), %%rcx\n", obj.Name)
-		fmt.Printf("  pushq %%rcx\n")
-		fmt.Printf("  pushq %%rax\n")
+// emitCompositeLit pushes a struct literal like Pair[int, string]{key: 1, value: "a"}.
+// fields without element are zero
+func emitCompositeLit(expr *ast.CompositeLit) {
+	typ := getType(expr.Type)
+	if isSlice(underlying(typ)) {
+		emitSliceLit(expr.Elts, sliceElem(typ))
+		return
+	}
+	fields := structFields(typ)
+	values := make([]ast.Expr, len(fields))
+	for i, elt := range expr.Elts {
+		kv, ok := elt.(*ast.KeyValueExpr)
+		if !ok {
+			values[i] = elt
+			continue
+		}
+		key := kv.Key.(*ast.Ident)
+		for j, field := range fields {
+			if field.name == key.Name {
+				values[j] = kv.Value
+			}
+		}
+	}
+	emit("  # %s{}\n", typ.Name)
+	for i := len(fields) - 1; i >= 0; i-- {
+		if values[i] == nil {
+			emitZero(sizeOf(fields[i].typ))
+			continue
+		}
+		emitExprAs(values[i], fields[i].typ)
 	}
+}
 
-	// analyzed variable is local string variable.
-	if isString && getObjectData(obj) != -1 {
-		localOffset := getObjectData(obj)
-		fmt.Printf("  # Local\n")
-		fmt.Printf("  leaq -%d(%%rbp), %%rax # ptr %s\n", localOffset, obj.Name)
-		fmt.Printf("  leaq -%d(%%rbp), %%rcx # len %s\n", localOffset-8, obj.Name)
-		fmt.Printf("  pushq %%rcx\n")
-		fmt.Printf("  pushq %%rax\n")
+func emitZero(size int) {
+	for off := 0; off < size; off += 8 {
+		emit("  pushq $0\n")
 	}
+}
 
-	if isInt && getObjectData(obj) == -1 {
-		fmt.Printf("  # Global\n")
-		fmt.Printf("  leaq %s+0(%%rip), %%rax\n", obj.Name)
-		fmt.Printf("  pushq %%rax\n")
+func emitFunc(expr *ast.CallExpr) {
+	fun := expr.Fun
+	emit("  # fun = %T\n", fun)
+	if typ := conversionType(expr); typ != nil {
+		emitConversion(expr.Args[0], typ)
+		return
 	}
-	if isInt && getObjectData(obj) != -1 {
-		localOffset := getObjectData(obj)
-		fmt.Printf("  # Local\n")
-		fmt.Printf("  leaq -%d(%%rbp), %%rax # %s \n", localOffset, obj.Name)
-		fmt.Printf("  pushq %%rax\n")
+	ident := funcIdent(fun)
+	if ident == nil || !isBuiltin(ident, ident.Name) {
+		emitCall(expr)
+		return
+	}
+	switch ident.Name {
+	case "print", "println":
+		emitPrint(expr, ident.Name == "println")
+	case "new":
+		typ := getType(expr.Args[0])
+		emit("  pushq $%d\n", sizeOf(typ))
+		emit("  callq runtime.alloc # new(%s)\n", typ.Name)
+		emit("  addq $8, %%rsp\n")
+		emit("  pushq %%rax\n")
+	case "len", "cap":
+		emitLen(expr.Args[0], ident.Name == "cap")
+	case "append":
+		emitAppend(expr)
+	case "make":
+		emitMake(expr)
 	}
 }
 
-func emitGlobalVariables() {
-	for _, valSpec := range globalVariables {
-		tag := valSpec.tag
-		value := valSpec.value
-		ident := valSpec.typ
-		if ident.Obj == globalString {
-			fmt.Printf("%s:\n", tag)
-			// FIXME: searchTag time computational complexity is O(n) where n is the number of string literals.
-			fmt.Printf("  .quad %s\n", searchTag(value))
-			fmt.Printf("  .quad %d\n", len(value)-1-2)
-		} else if ident.Obj == globalInt {
-			fmt.Printf("%s:\n", tag)
-			fmt.Printf("  .quad %s\n", value)
+// emitCall calls a function or a method and pushes the result.
+// the receiver of a method is the first argument, so it is pushed after the other arguments
+func emitCall(expr *ast.CallExpr) {
+	fnc := calleeFunc(expr)
+	if isExtern(fnc) {
+		emitExternCall(fnc, expr)
+		return
+	}
+	retType := resultType(fnc)
+	if retType != nil && isResultInMemory(retType) {
+		emit("  subq $%d, %%rsp # result\n", sizeOf(retType))
+	}
+	// push args like stack
+	argsSize := 0
+	sel := methodSelector(expr)
+	switch {
+	case usesRegABI(fnc):
+		emitRegArgs(fnc, expr)
+	case sel != nil && hasCall(sel.X) && argsHaveCalls(expr.Args, 1):
+		// the receiver is evaluated before the arguments, while it is pushed after them
+		sizes, operands := argOperands(fnc, expr)
+		if isInterface(getType(sel.X)) {
+			sizes = append([]int{16}, sizes...)
+			operands = append([]func(){func() { emitExpr(sel.X) }}, operands...)
 		} else {
-			must(fmt.Errorf("unexpected type ident %v", ident))
+			sizes = append([]int{recvSize(fnc)}, sizes...)
+			operands = append([]func(){func() { emitRecv(fnc, sel.X) }}, operands...)
+		}
+		argsSize = emitOperandsInOrder(sizes, operands)
+	default:
+		argsSize = emitArgs(fnc, expr)
+		switch {
+		case sel != nil && isInterface(getType(sel.X)):
+			emitExpr(sel.X)
+			argsSize += 16
+		case sel != nil:
+			argsSize += emitRecv(fnc, sel.X)
 		}
 	}
-	fmt.Printf("\n")
-}
+	switch {
+	case sel != nil && isInterface(getType(sel.X)):
+		emitIfaceCall(sel.X, sel.Sel.Name)
+		argsSize -= 8
+	default:
+		emit("  callq %s\n", calleeSymbol(fnc))
+	}
+	if argsSize > 0 {
+		emit("  addq $%d, %%rsp\n", argsSize)
+	}
 
-// emitSL assmbly string literals in .data section
-func emitSL() {
-	fmt.Printf(".data\n")
-	for i, sl := range stringLiterals {
-		fmt.Printf(".S%d:\n", i)
-		fmt.Printf("  .string %s\n", sl.value)
-		stringLiterals[i].tag = fmt.Sprintf(".S%d", i)
+	// results in memory are on the top of the stack already
+	if retType != nil && !isResultInMemory(retType) {
+		switch sizeOf(retType) {
+		case 8:
+			emit("  pushq %%rax\n")
+		case 16:
+			emit("  pushq %%rsi # len \n")
+			emit("  pushq %%rax # ptr \n")
+		}
 	}
-	fmt.Printf("\n")
 }
 
-func getExprSize(expr *ast.Expr) int {
-	typ := getType(*expr)
-	if typ == globalString {
-		return 8 * 2
-	} else if typ == globalInt {
-		return 8
+// isResultInMemory reports whether the results of typ are returned in the area reserved by the caller above the args
+// instead of rax and rsi. multiple results are laid out in order like the fields of a struct
+func isResultInMemory(typ *ast.Object) bool {
+	return isTuple(typ) || sizeOf(typ) > 16
+}
+
+// emitArgs pushes the arguments of a call to fnc in reverse order and returns their size.
+// when two of them call functions, which is when the order is observable, they are evaluated from the first instead.
+// the rest arguments of a variadic function are packed into a slice unless the call passes a slice by f(s...)
+func emitArgs(fnc *Func, expr *ast.CallExpr) int {
+	sizes, operands := argOperands(fnc, expr)
+	if argsHaveCalls(expr.Args, 2) {
+		return emitOperandsInOrder(sizes, operands)
 	}
-	return 0
+	size := 0
+	for i := len(operands) - 1; i >= 0; i-- {
+		operands[i]()
+		size += sizes[i]
+	}
+	return size
 }
 
-func getType(typeExpr ast.Expr) *ast.Object {
-	switch expr := typeExpr.(type) {
-	case *ast.Ident:
-		if expr.Obj.Kind == ast.Var {
-			switch decl := expr.Obj.Decl.(type) {
-			case *ast.ValueSpec:
-				return getType(decl.Type)
-			case *ast.Field:
-				return getType(decl.Type)
-			}
+// argOperands returns the sizes of the arguments of a call to fnc and the functions pushing them
+func argOperands(fnc *Func, expr *ast.CallExpr) ([]int, []func()) {
+	var types []*ast.Object
+	withTypeArgs(fnc.typeArgs, func() {
+		for _, t := range paramTypes(fnc.decl) {
+			types = append(types, getType(t))
 		}
-		if expr.Obj.Kind == ast.Typ {
+	})
+	variadic := len(types) > 0 && isVariadic(fnc.decl) && !expr.Ellipsis.IsValid()
+	if !variadic && len(expr.Args) != len(types) {
+		must(fmt.Errorf("%s: got %d arguments but %d parameters", fnc.name, len(expr.Args), len(types)))
+	}
+	sizes := make([]int, len(types))
+	operands := make([]func(), len(types))
+	for i, typ := range types {
+		i, typ := i, typ
+		sizes[i] = sizeOf(typ)
+		if variadic && i == len(types)-1 {
+			operands[i] = func() { emitSliceLit(expr.Args[i:], sliceElem(typ)) }
+		} else {
+			operands[i] = func() { emitExprAs(expr.Args[i], typ) }
+		}
+	}
+	return sizes, operands
+}
+
+// argsHaveCalls reports whether n or more of args call functions, which is when the order of their evaluation is observable
+func argsHaveCalls(args []ast.Expr, n int) bool {
+	for _, arg := range args {
+		if hasCall(arg) {
+			n--
+		}
+	}
+	return n <= 0
+}
+
+// hasCall reports whether evaluating expr calls a function. conversions are not calls
+func hasCall(expr ast.Expr) bool {
+	found := false
+	ast.Inspect(expr, func(n ast.Node) bool {
+		if call, ok := n.(*ast.CallExpr); ok && conversionType(call) == nil {
+			found = true
+		}
+		return !found
+	})
+	return found
+}
+
+// emitOperandsInOrder evaluates the operands from the first like Go, and lays them out from the top of the stack
+// as if they were pushed in reverse order. it returns their size
+func emitOperandsInOrder(sizes []int, operands []func()) int {
+	total := 0
+	for _, size := range sizes {
+		total += size
+	}
+	emit("  subq $%d, %%rsp # the operands evaluated in order\n", total)
+	off := 0
+	for i, operand := range operands {
+		operand()
+		words := sizes[i] / 8
+		for w := 0; w < words; w++ {
+			emit("  popq %%rax\n")
+			emit("  movq %%rax, %d(%%rsp)\n", off+(words-1)*8)
+		}
+		off += sizes[i]
+	}
+	return total
+}
+
+func isVariadic(decl *ast.FuncDecl) bool {
+	params := decl.Type.Params.List
+	if len(params) == 0 {
+		return false
+	}
+	_, ok := params[len(params)-1].Type.(*ast.Ellipsis)
+	return ok
+}
+
+// calleeSymbol returns the symbol called for fnc. functions without body are bound to the runtime by //go:linkname
+func calleeSymbol(fnc *Func) string {
+	if fnc.decl.Body == nil && fnc.decl.Recv == nil {
+		return symbol(linkname(fnc.decl))
+	}
+	return funcSymbol(fnc.pkg.path, fnc.name)
+}
+
+// linkname returns the target of //go:linkname name target in the doc comment of decl, or ""
+func linkname(decl *ast.FuncDecl) string {
+	if decl.Doc == nil {
+		return ""
+	}
+	for _, comment := range decl.Doc.List {
+		fields := strings.Fields(comment.Text)
+		if len(fields) == 3 && fields[0] == "//go:linkname" && fields[1] == decl.Name.Name {
+			return fields[2]
+		}
+	}
+	return ""
+}
+
+// emitConversion pushes T(x). the representation doesn't change except for bytes, interfaces and []byte <-> string
+func emitConversion(x ast.Expr, typ *ast.Object) {
+	src := underlying(getType(x))
+	dst := underlying(typ)
+	switch {
+	case dst == globalString && isSlice(src):
+		emitExpr(x)
+		emit("  callq runtime.slicebytetostring\n")
+		emit("  addq $24, %%rsp\n")
+		emit("  pushq %%rsi\n")
+		emit("  pushq %%rax\n")
+	case isSlice(dst) && src == globalString:
+		emitExpr(x)
+		emit("  callq runtime.stringtoslicebyte\n")
+		emit("  addq $16, %%rsp\n")
+		emit("  pushq %%rdx\n")
+		emit("  pushq %%rsi\n")
+		emit("  pushq %%rax\n")
+	case dst == globalByte && src != globalByte:
+		emitExpr(x)
+		emit("  popq %%rax\n")
+		emitTruncate(dst)
+		emit("  pushq %%rax\n")
+	default:
+		emitExprAs(x, typ)
+	}
+}
+
+// emitPrint emits the print and println builtins. println puts spaces between the arguments and a newline
+func emitPrint(expr *ast.CallExpr, newline bool) {
+	// the arguments are evaluated before anything is printed, so the ones calling print come first
+	total := 0
+	for _, arg := range expr.Args {
+		emitExpr(arg)
+		total += sizeOf(underlying(getType(arg)))
+	}
+	above := total // the bytes of the arguments above the one printed
+	for i, arg := range expr.Args {
+		if newline && i > 0 {
+			emit("  callq runtime.printsp\n")
+		}
+		typ := underlying(getType(arg))
+		size := sizeOf(typ)
+		above -= size
+		for off := 0; off < size; off += 8 { // copy the argument to the top of the stack
+			emit("  pushq %d(%%rsp)\n", above+size-8)
+		}
+		switch {
+		case typ == globalString:
+			emit("  callq runtime.printstring\n")
+		case typ == globalInt || typ == globalByte:
+			emit("  callq runtime.printint\n")
+		case typ == globalBool:
+			emit("  callq runtime.printbool\n")
+		case isPointer(typ):
+			emit("  callq runtime.printhex\n")
+		default:
+			must(fmt.Errorf("illegal types for operand: print %s", typ.Name))
+		}
+		emit("  addq $%d, %%rsp\n", size)
+	}
+	if newline {
+		emit("  callq runtime.printnl\n")
+	}
+	if total > 0 {
+		emit("  addq $%d, %%rsp\n", total)
+	}
+}
+
+// emitDeclFunc emits assembly code for a declarated function. parse func XXX(...) {...}
+func emitDeclFunc(pkg string, fnc *Func) {
+	funcDecl := fnc.decl
+	if fnc.typeArgs != nil {
+		// restore the frame layout of this instance
+		funcWalk(fnc)
+	}
+	curFunc = fnc
+	curPkg = fnc.pkg
+	curTypeArgs = fnc.typeArgs
+	defer func() { curTypeArgs = nil }()
+
+	emit("# %T\n", funcDecl)
+	emit(".text\n")
+	emit("%s: # args %d, locals %d\n",
+		funcSymbol(pkg, fnc.name),
+		fnc.argsarea,
+		fnc.localarea)
+	loc := func(obj *ast.Object) (string, bool) {
+		return fmt.Sprintf("%d(%%rbp)", getObjectData(obj)), isHeapVar(obj)
+	}
+	debugFuncStart(fnc, funcSymbol(pkg, fnc.name), loc)
+	recordFrame(fnc, funcSymbol(pkg, fnc.name), fnc.localarea, false, loc)
+	emitPrologue()
+	emit("# localvars: %s\n", localvarsString(fnc.localvars))
+	if len(fnc.localvars) > 0 {
+		emit("  subq $%d, %%rsp\n", fnc.localarea)
+	}
+	tailLabel = ""
+	if hasTailCall(fnc) {
+		tailLabel = newTailLabel()
+		emit("%s:\n", tailLabel)
+	}
+	if usesRegABI(fnc) {
+		emitSpills(fnc)
+	}
+	for _, param := range fnc.heapParams {
+		emitNewHeapVar(param.obj)
+		emitCopy(param.offset, param.obj)
+	}
+	for _, name := range namedResults(funcDecl) {
+		if isHeapVar(name.Obj) {
+			emitNewHeapVar(name.Obj)
+		}
+		size := sizeOf(varType(name.Obj))
+		emitZero(size)
+		emitVariableAddr(name.Obj)
+		emitStore(size, name.Name)
+	}
+
+	// emit assembly code for function body. parse {...}
+	emitFuncBody(funcDecl.Body)
+
+	debugLoc(funcDecl.Body.Rbrace)
+	emitEpilogue()
+	debugFuncEnd()
+}
+
+// emitPrologue saves rbp of the caller and makes the frame of the function.
+// with -g, the CFI follows the CFA from rsp to rbp
+func emitPrologue() {
+	emit("  pushq %%rbp\n")
+	debugCFI(".cfi_def_cfa_offset 16")
+	debugCFI(".cfi_offset %d, -16", dwarfRegs["rbp"])
+	emit("  movq %%rsp, %%rbp\n")
+	debugCFI(".cfi_def_cfa_register %d", dwarfRegs["rbp"])
+}
+
+// emitEpilogue leaves the frame and returns. the CFI of the frame is restored after ret
+// for the code after a return in the middle of the function
+func emitEpilogue() {
+	debugCFI(".cfi_remember_state")
+	emit("  leave\n")
+	debugCFI(".cfi_def_cfa %d, 8", dwarfRegs["rsp"])
+	emit("  ret\n")
+	debugCFI(".cfi_restore_state")
+}
+
+func emitFuncBody(body *ast.BlockStmt) {
+	for _, stmt := range body.List {
+		emitStmt(stmt)
+	}
+}
+
+func emitStmt(stmt ast.Stmt) {
+	debugLoc(stmt.Pos())
+	switch s := stmt.(type) {
+	case *ast.ExprStmt:
+		expr := s.X
+		emitExpr(expr)
+		if size := getExprSize(&expr); size > 0 {
+			emit("  addq $%d, %%rsp # discard\n", size)
+		}
+	case *ast.DeclStmt:
+		emitNewHeapVars(s)
+		emitLocalDecl(s)
+	case *ast.AssignStmt: // emit and analyze expression like x := y
+		emit("  # *ast.AssignStmt\n")
+		emitNewHeapVars(s)
+		emitAssignStmt(s)
+	case *ast.ReturnStmt:
+		emitReturn(s)
+	case *ast.IfStmt:
+		emitIfStmt(s)
+	case *ast.BlockStmt:
+		emitFuncBody(s)
+	case *ast.ForStmt:
+		emitForStmt(s)
+	case *ast.RangeStmt:
+		emitStmt(desugared[s])
+	case *ast.SwitchStmt:
+		if d := desugared[s]; d != nil {
+			emitStmt(d)
+		} else {
+			emitSwitchStmt(s)
+		}
+	case *ast.IncDecStmt: // x++ is x = x + 1
+		op := token.ADD
+		if s.Tok == token.DEC {
+			op = token.SUB
+		}
+		one := &ast.BasicLit{Kind: token.INT, Value: "1"}
+		emitAssignStmt(&ast.AssignStmt{Lhs: []ast.Expr{s.X}, Tok: token.ASSIGN, Rhs: []ast.Expr{&ast.BinaryExpr{X: s.X, Op: op, Y: one}}})
+	case *ast.BranchStmt:
+		switch s.Tok {
+		case token.BREAK:
+			emit("  jmp %s # break\n", breakLabels[len(breakLabels)-1])
+		case token.CONTINUE:
+			emit("  jmp %s # continue\n", continueLabels[len(continueLabels)-1])
+		case token.FALLTHROUGH: // the next clause follows
+		}
+	default:
+		must(fmt.Errorf("unexpected stmt type %T", stmt))
+	}
+}
+
+// emitReturn returns the results. return without results returns the named results
+func emitReturn(stmt *ast.ReturnStmt) {
+	if call := tailCall(curFunc, stmt); call != nil {
+		emitTailCall(call)
+		return
+	}
+	results := stmt.Results
+	if len(results) == 0 {
+		for _, name := range namedResults(curFunc.decl) {
+			results = append(results, name)
+		}
+	}
+	retType := resultType(curFunc)
+	switch {
+	case len(results) == 0:
+	case !isResultInMemory(retType):
+		emitExprAs(results[0], retType)
+		emit("  popq %%rax\n") // return value
+		if sizeOf(retType) == 16 {
+			emit("  popq %%rsi\n")
+		}
+	case len(results) == 1: // a large value, or the results of a call like return f()
+		emitExprAs(results[0], retType)
+		// large values are returned in the area reserved by the caller above the args
+		emit("  leaq %d(%%rbp), %%rax\n", curFunc.argsarea)
+		emit("  pushq %%rax\n")
+		emitStore(sizeOf(retType), "result")
+	default:
+		offset := curFunc.argsarea
+		for i, typ := range tupleTypes(retType) {
+			emitExprAs(results[i], typ)
+			emit("  leaq %d(%%rbp), %%rax\n", offset)
+			emit("  pushq %%rax\n")
+			emitStore(sizeOf(typ), fmt.Sprintf("result %d", i))
+			offset += sizeOf(typ)
+		}
+	}
+	emitEpilogue()
+}
+
+func emitForStmt(stmt *ast.ForStmt) {
+	labelSeq++
+	beginLabel := fmt.Sprintf(".L.for.%d", labelSeq)
+	continueLabel := fmt.Sprintf(".L.continue.%d", labelSeq)
+	endLabel := fmt.Sprintf(".L.endfor.%d", labelSeq)
+	emit("  # for\n")
+	if stmt.Init != nil {
+		emitStmt(stmt.Init)
+	}
+	emit("%s:\n", beginLabel)
+	if stmt.Cond != nil {
+		emitExpr(stmt.Cond)
+		emit("  popq %%rax\n")
+		emit("  cmpq $0, %%rax\n")
+		emit("  je %s\n", endLabel)
+	}
+	breakLabels = append(breakLabels, endLabel)
+	continueLabels = append(continueLabels, continueLabel)
+	emitFuncBody(stmt.Body)
+	breakLabels = breakLabels[:len(breakLabels)-1]
+	continueLabels = continueLabels[:len(continueLabels)-1]
+	emit("%s:\n", continueLabel)
+	if stmt.Init != nil {
+		emitRenewHeapVars(stmt.Init)
+	}
+	if stmt.Post != nil {
+		emitStmt(stmt.Post)
+	}
+	emit("  jmp %s\n", beginLabel)
+	emit("%s:\n", endLabel)
+}
+
+// emitSwitchStmt emits a switch without tag. the conditions are tested in order and jump to the body of their clause,
+// which jumps to the end unless it ends with fallthrough
+func emitSwitchStmt(stmt *ast.SwitchStmt) {
+	labelSeq++
+	seq := labelSeq
+	endLabel := fmt.Sprintf(".L.endswitch.%d", seq)
+	defaultLabel := endLabel
+	emit("  # switch\n")
+	for i, clause := range stmt.Body.List {
+		label := fmt.Sprintf(".L.case.%d.%d", seq, i)
+		cc := clause.(*ast.CaseClause)
+		if cc.List == nil {
+			defaultLabel = label
+			continue
+		}
+		for _, cond := range cc.List {
+			emitExpr(cond)
+			emit("  popq %%rax\n")
+			emit("  cmpq $0, %%rax\n")
+			emit("  jne %s\n", label)
+		}
+	}
+	emit("  jmp %s\n", defaultLabel)
+
+	breakLabels = append(breakLabels, endLabel)
+	for i, clause := range stmt.Body.List {
+		cc := clause.(*ast.CaseClause)
+		emit(".L.case.%d.%d:\n", seq, i)
+		for _, s := range cc.Body {
+			emitStmt(s)
+		}
+		if n := len(cc.Body); n > 0 {
+			if branch, ok := cc.Body[n-1].(*ast.BranchStmt); ok && branch.Tok == token.FALLTHROUGH {
+				continue
+			}
+		}
+		emit("  jmp %s\n", endLabel)
+	}
+	breakLabels = breakLabels[:len(breakLabels)-1]
+	emit("%s:\n", endLabel)
+}
+
+func emitIfStmt(stmt *ast.IfStmt) {
+	labelSeq++
+	elseLabel := fmt.Sprintf(".L.else.%d", labelSeq)
+	endLabel := fmt.Sprintf(".L.endif.%d", labelSeq)
+	emit("  # if\n")
+	emitExpr(stmt.Cond)
+	emit("  popq %%rax\n")
+	emit("  cmpq $0, %%rax\n")
+	emit("  je %s\n", elseLabel)
+	emitFuncBody(stmt.Body)
+	emit("  jmp %s\n", endLabel)
+	emit("%s:\n", elseLabel)
+	if stmt.Else != nil {
+		emitStmt(stmt.Else)
+	}
+	emit("%s:\n", endLabel)
+}
+
+// emitLocalDecl initializes local variables declared by var x T = v, or with the zero value
+func emitLocalDecl(stmt *ast.DeclStmt) {
+	decl := stmt.Decl.(*ast.GenDecl)
+	valSpec, ok := decl.Specs[0].(*ast.ValueSpec)
+	if !ok || decl.Tok == token.CONST {
+		return
+	}
+	if len(valSpec.Names) > 1 && len(valSpec.Values) == 1 { // var a, b = f()
+		emitExpr(valSpec.Values[0])
+		for _, name := range valSpec.Names {
+			emitVariableAddr(name.Obj)
+			emitStore(sizeOf(varType(name.Obj)), name.Name)
+		}
+		return
+	}
+	for i, name := range valSpec.Names {
+		size := sizeOf(varType(name.Obj))
+		if i < len(valSpec.Values) {
+			emitExprAs(valSpec.Values[i], varType(name.Obj))
+		} else {
+			emitZero(size)
+		}
+		emitVariableAddr(name.Obj)
+		emitStore(size, name.Name)
+	}
+}
+
+func emitAssignStmt(stmt *ast.AssignStmt) {
+	if len(stmt.Lhs) > 1 {
+		emitTupleAssign(stmt)
+		return
+	}
+	lhs := stmt.Lhs[0] // lhs is left side of assignment. e.g. x := 5, lhs is x
+	rhs := stmt.Rhs[0] // rhs is right side of assignment. e.g. x := 5, rhs is 5
+
+	// x += y is x = x + y
+	if op, ok := assignOps[stmt.Tok]; ok {
+		rhs = &ast.BinaryExpr{X: lhs, Op: op, Y: rhs}
+	}
+	if isBlank(lhs) {
+		emitExpr(rhs)
+		emit("  addq $%d, %%rsp # discard\n", getExprSize(&rhs))
+		return
+	}
+	emitExprAs(rhs, getType(lhs)) // push rhs to stack
+	emitAddr(&lhs)
+	emitStore(memSize(lhs), fmt.Sprint(lhs))
+}
+
+// emitTupleAssign emits a, b = x, y. all values are pushed before they are stored, so a, b = b, a swaps.
+// the results of a, b = f() are pushed by the call with the first one on the top
+func emitTupleAssign(stmt *ast.AssignStmt) {
+	if len(stmt.Rhs) == 1 {
+		emitExpr(stmt.Rhs[0])
+		for i, typ := range tupleTypes(getType(stmt.Rhs[0])) {
+			lhs := stmt.Lhs[i]
+			if isBlank(lhs) {
+				emit("  addq $%d, %%rsp # discard\n", sizeOf(typ))
+				continue
+			}
+			emitAddr(&lhs)
+			emitStore(memSize(lhs), fmt.Sprint(lhs))
+		}
+		return
+	}
+	for i, rhs := range stmt.Rhs {
+		if isBlank(stmt.Lhs[i]) {
+			emitExpr(rhs)
+		} else {
+			emitExprAs(rhs, getType(stmt.Lhs[i]))
+		}
+	}
+	for i := len(stmt.Lhs) - 1; i >= 0; i-- {
+		lhs := stmt.Lhs[i]
+		if isBlank(lhs) {
+			emit("  addq $%d, %%rsp # discard\n", getExprSize(&stmt.Rhs[i]))
+			continue
+		}
+		emitAddr(&lhs)
+		emitStore(memSize(lhs), fmt.Sprint(lhs))
+	}
+}
+
+var assignOps = map[token.Token]token.Token{
+	token.ADD_ASSIGN:     token.ADD,
+	token.SUB_ASSIGN:     token.SUB,
+	token.MUL_ASSIGN:     token.MUL,
+	token.QUO_ASSIGN:     token.QUO,
+	token.REM_ASSIGN:     token.REM,
+	token.AND_ASSIGN:     token.AND,
+	token.OR_ASSIGN:      token.OR,
+	token.XOR_ASSIGN:     token.XOR,
+	token.SHL_ASSIGN:     token.SHL,
+	token.SHR_ASSIGN:     token.SHR,
+	token.AND_NOT_ASSIGN: token.AND_NOT,
+}
+
+func emitAddr(expr *ast.Expr) {
+	// emit variable address like emitGlobalVariables
+	switch e := (*expr).(type) {
+	case *ast.Ident:
+		if e.Obj.Kind == ast.Var {
+			emitVariableAddr(e.Obj)
+		}
+	case *ast.ParenExpr:
+		emitAddr(&e.X)
+	case *ast.StarExpr:
+		emitExpr(e.X) // the pointer is the address
+	case *ast.SelectorExpr:
+		if ident := qualifiedIdent(e); ident != nil {
+			emitVariableAddr(ident.Obj)
+			return
+		}
+		if isPointer(getType(e.X)) { // p.x is (*p).x
+			emitExpr(e.X)
+		} else {
+			emitAddr(&e.X)
+		}
+		field := lookupField(getType(e.X), e.Sel.Name)
+		emit("  popq %%rax\n")
+		emit("  addq $%d, %%rax # .%s\n", field.offset, e.Sel.Name)
+		emit("  pushq %%rax\n")
+	case *ast.IndexExpr:
+		emitIndexAddr(e)
+	default:
+		must(fmt.Errorf("unexpected addressable expr type %T", *expr))
+	}
+}
+
+// emitAddrOf pushes &x. composite literals are allocated in the heap
+func emitAddrOf(expr ast.Expr) {
+	lit, ok := expr.(*ast.CompositeLit)
+	if !ok {
+		emitAddr(&expr)
+		return
+	}
+	size := getExprSize(&expr)
+	emitCompositeLit(lit)
+	emit("  pushq $%d\n", size)
+	emit("  callq runtime.alloc # &%s{}\n", getType(lit.Type).Name)
+	emit("  addq $8, %%rsp\n")
+	emit("  pushq %%rax\n")
+	emitStore(size, "&"+getType(lit.Type).Name)
+	emit("  pushq %%rax\n")
+}
+
+func isAddressable(expr ast.Expr) bool {
+	switch e := expr.(type) {
+	case *ast.Ident:
+		return e.Obj != nil && e.Obj.Kind == ast.Var
+	case *ast.ParenExpr:
+		return isAddressable(e.X)
+	case *ast.SelectorExpr:
+		if ident := qualifiedIdent(e); ident != nil {
+			return isAddressable(ident)
+		}
+		return isPointer(getType(e.X)) || isAddressable(e.X)
+	case *ast.StarExpr:
+		return true
+	case *ast.IndexExpr: // elements of slices, not bytes of strings
+		return isSlice(underlying(getType(e.X)))
+	}
+	return false
+}
+
+func emitVariableAddr(obj *ast.Object) {
+	emit("  # ident kind=%v\n", obj.Kind)
+	emit("  # Obj=%s\n", objString(obj))
+
+	emit("# getObjectData: %d\n", getObjectData(obj))
+
+	// analyzed variable is global variable.
+	if getObjectData(obj) == -1 {
+		emit("  # Global\n")
+		emit("  leaq %s+0(%%rip), %%rax\n", globalSymbol(obj))
+		emit("  pushq %%rax\n")
+		return
+	}
+
+	// analyzed variable is local variable or parameter. local variables have negative offsets.
+	emit("  # Local\n")
+	if isHeapVar(obj) {
+		emit("  movq %d(%%rbp), %%rax # &%s in the heap\n", getObjectData(obj), obj.Name)
+	} else {
+		emit("  leaq %d(%%rbp), %%rax # %s\n", getObjectData(obj), obj.Name)
+	}
+	emit("  pushq %%rax\n")
+}
+
+func emitGlobalVariables() {
+	emit(".data\n")
+	for _, valSpec := range globalVariables {
+		tag := valSpec.tag
+		value := valSpec.value
+		typ := underlying(valSpec.typ)
+		if valSpec.value == "" { // zero value, or initialized by the package initialization
+			emit("%s:\n", tag)
+			emit("  .zero %d\n", sizeOf(typ))
+		} else if typ == globalString {
+			emit("%s:\n", tag)
+			// FIXME: searchTag time computational complexity is O(n) where n is the number of string literals.
+			emit("  .quad %s\n", searchTag(value))
+			emit("  .quad %d\n", stringLen(value))
+		} else if typ == globalInt || typ == globalByte {
+			emit("%s:\n", tag)
+			emit("  .quad %s\n", value)
+		} else {
+			must(fmt.Errorf("unexpected type ident %v", typ.Name))
+		}
+	}
+	emit("\n")
+}
+
+// emitSL assmbly string literals in .rodata section
+func emitSL() {
+	emit(".section .rodata\n")
+	for i, sl := range stringLiterals {
+		emit(".S%d:\n", i)
+		emit("  .string %s\n", gasString(sl.value))
+		stringLiterals[i].tag = fmt.Sprintf(".S%d", i)
+	}
+	emit("\n")
+}
+
+func getExprSize(expr *ast.Expr) int {
+	typ := getType(*expr)
+	if typ == nil {
+		return 0
+	}
+	return sizeOf(typ)
+}
+
+func getType(typeExpr ast.Expr) *ast.Object {
+	switch expr := typeExpr.(type) {
+	case *ast.Ident:
+		if expr.Obj.Kind == ast.Var {
+			return varType(expr.Obj)
+		}
+		if expr.Obj == globalNil {
+			return untypedNil
+		}
+		if expr.Obj.Kind == ast.Con {
+			if spec, ok := expr.Obj.Decl.(*ast.ValueSpec); ok && spec.Type != nil {
+				return getType(spec.Type)
+			}
+			if value := constValue(expr.Obj); value != nil {
+				return getType(value)
+			}
+			return globalBool // true or false
+		}
+		if expr.Obj.Kind == ast.Typ {
+			if typ, ok := curTypeArgs[expr.Obj]; ok { // type parameter of the instance being walked
+				return typ
+			}
 			return expr.Obj
 		}
 	case *ast.BasicLit:
 		switch expr.Kind.String() {
 		case "STRING":
 			return globalString
-		case "INT":
+		case "INT", "CHAR":
 			return globalInt
 		}
 	case *ast.BinaryExpr:
+		switch expr.Op {
+		case token.EQL, token.NEQ, token.LSS, token.LEQ, token.GTR, token.GEQ, token.LAND, token.LOR:
+			return globalBool
+		case token.SHL, token.SHR:
+			return getType(expr.X)
+		}
+		if isUntypedConst(expr.X) && !isUntypedConst(expr.Y) { // '0' + b is a byte if b is
+			return getType(expr.Y)
+		}
 		return getType(expr.X)
+	case *ast.UnaryExpr:
+		switch expr.Op {
+		case token.AND:
+			return pointerTo(getType(expr.X))
+		case token.NOT:
+			return globalBool
+		}
+		return getType(expr.X)
+	case *ast.StarExpr:
+		if isTypeExpr(expr.X) { // pointer type *T
+			return pointerTo(getType(expr.X))
+		}
+		return elemType(getType(expr.X)) // *p
+	case *ast.ParenExpr:
+		return getType(expr.X)
+	case *ast.CallExpr:
+		if typ := conversionType(expr); typ != nil {
+			return typ
+		}
+		ident := funcIdent(expr.Fun)
+		if ident == nil || !isBuiltin(ident, ident.Name) {
+			return resultType(calleeFunc(expr))
+		}
+		switch ident.Name {
+		case "new":
+			return pointerTo(getType(expr.Args[0]))
+		case "len", "cap":
+			return globalInt
+		case "append", "make":
+			return getType(expr.Args[0])
+		}
+		return nil // print
+	case *ast.SelectorExpr:
+		if ident := qualifiedIdent(expr); ident != nil {
+			return getType(ident)
+		}
+		return lookupField(getType(expr.X), expr.Sel.Name).typ
+	case *ast.CompositeLit:
+		return getType(expr.Type)
+	case *ast.IndexExpr:
+		if isTypeExpr(expr.X) {
+			return instantiateType(expr)
+		}
+		if underlying(getType(expr.X)) == globalString {
+			return globalByte
+		}
+		return sliceElem(getType(expr.X))
+	case *ast.IndexListExpr:
+		return instantiateType(expr)
+	case *ast.SliceExpr:
+		return getType(expr.X)
+	case *ast.ArrayType:
+		if expr.Len != nil {
+			must(fmt.Errorf("arrays are not supported"))
+		}
+		return sliceOf(getType(expr.Elt))
+	case *ast.Ellipsis: // ...T parameter
+		return sliceOf(getType(expr.Elt))
+	case *ast.InterfaceType:
+		if expr.Methods.NumFields() > 0 {
+			must(fmt.Errorf("interface literals with methods are not supported"))
+		}
+		return globalAny
+	case *ast.FuncType:
+		must(fmt.Errorf("%s: function types are not supported: %s", fileSet.Position(expr.Pos()), exprString(expr)))
 	default:
 		must(fmt.Errorf("unexpected typeExpr type %T", typeExpr))
 	}
 	return nil
 }
 
+// varType returns the type of a variable declared by var x T, var x = v, x := v or a parameter
+func varType(obj *ast.Object) *ast.Object {
+	switch decl := obj.Decl.(type) {
+	case *ast.ValueSpec:
+		if decl.Type != nil {
+			return getType(decl.Type)
+		}
+		for i, name := range decl.Names {
+			if name.Obj == obj {
+				if len(decl.Values) == 1 && len(decl.Names) > 1 { // var a, b = f()
+					return tupleTypes(getType(decl.Values[0]))[i]
+				}
+				return getType(decl.Values[i])
+			}
+		}
+	case *ast.Field:
+		return getType(decl.Type)
+	case *ast.AssignStmt:
+		if r, ok := decl.Rhs[0].(*ast.UnaryExpr); ok && r.Op == token.RANGE { // for k, v := range x
+			if decl.Lhs[0].(*ast.Ident).Obj == obj {
+				return globalInt
+			}
+			return sliceElem(getType(r.X))
+		}
+		for i, lhs := range decl.Lhs {
+			if ident, ok := lhs.(*ast.Ident); ok && ident.Obj == obj {
+				if len(decl.Rhs) == 1 && len(decl.Lhs) > 1 { // a, b := f()
+					return tupleTypes(getType(decl.Rhs[0]))[i]
+				}
+				return getType(decl.Rhs[i])
+			}
+		}
+	}
+	must(fmt.Errorf("unexpected variable decl type %T", obj.Decl))
+	return nil
+}
+
+// conversionType returns the type converted to by T(x), or nil if expr is a function call
+func conversionType(expr *ast.CallExpr) *ast.Object {
+	if !isTypeExpr(expr.Fun) {
+		return nil
+	}
+	return getType(expr.Fun)
+}
+
+// constValue returns the value expression of a declared constant, or nil for true and false
+func constValue(obj *ast.Object) ast.Expr {
+	spec, ok := obj.Decl.(*ast.ValueSpec)
+	if !ok {
+		return nil
+	}
+	for i, name := range spec.Names {
+		if name.Obj == obj {
+			return spec.Values[i]
+		}
+	}
+	return nil
+}
+
+// intValue returns the value of an integer or rune literal
+func intValue(lit *ast.BasicLit) int {
+	if lit.Kind == token.CHAR {
+		r, _, _, err := strconv.UnquoteChar(lit.Value[1:len(lit.Value)-1], '\'')
+		must(err)
+		return int(r)
+	}
+	i, err := strconv.ParseInt(lit.Value, 0, 64)
+	if errors.Is(err, strconv.ErrRange) { // an untyped constant computed by fold
+		must(fmt.Errorf("cannot use constant %s as int value (overflows)", lit.Value))
+	}
+	must(err)
+	return int(i)
+}
+
+// funcIdent returns the name of the called function. f in f(x), f[T](x), pkg.f(x).
+// method calls like x.f() return nil
+func funcIdent(fun ast.Expr) *ast.Ident {
+	switch fn := fun.(type) {
+	case *ast.Ident:
+		return fn
+	case *ast.SelectorExpr:
+		return qualifiedIdent(fn)
+	case *ast.IndexExpr:
+		return funcIdent(fn.X)
+	case *ast.IndexListExpr:
+		return funcIdent(fn.X)
+	case *ast.ParenExpr:
+		return funcIdent(fn.X)
+	}
+	return nil
+}
+
+// calleeFunc returns the function called by expr. generic functions return their instance
+func calleeFunc(expr *ast.CallExpr) *Func {
+	if sel := methodSelector(expr); sel != nil {
+		return lookupMethod(getType(sel.X), sel.Sel.Name)
+	}
+	ident := funcIdent(expr.Fun)
+	dclfn, ok := ident.Obj.Decl.(*ast.FuncDecl)
+	if !ok {
+		must(fmt.Errorf("unexpected obj type %T", ident.Obj.Decl))
+	}
+	if dclfn.Type.TypeParams != nil {
+		return instantiateFunc(expr)
+	}
+	return &Func{decl: dclfn, pkg: pkgOf(ident.Obj), name: dclfn.Name.Name}
+}
+
+// resultType returns the type of the return value of fnc, a tuple if fnc returns multiple values, or nil if fnc returns nothing
+func resultType(fnc *Func) *ast.Object {
+	if fnc.decl.Type.Results == nil {
+		return nil
+	}
+	var types []*ast.Object
+	withTypeArgs(fnc.typeArgs, func() {
+		for _, field := range fnc.decl.Type.Results.List {
+			types = append(types, getType(field.Type))
+			for i := 1; i < len(field.Names); i++ {
+				types = append(types, getType(field.Type))
+			}
+		}
+	})
+	if len(types) == 1 {
+		return types[0]
+	}
+	return tupleOf(types)
+}
+
+// isBuiltin reports whether ident refers to the builtin function name in the universe block
+func isBuiltin(ident *ast.Ident, name string) bool {
+	return ident.Name == name && ident.Obj != nil && ident.Obj.Kind == ast.Fun && ident.Obj.Decl == nil
+}
+
 func getObjectData(object *ast.Object) int {
 	data, ok := object.Data.(int)
 	if !ok {
@@ -613,10 +2251,35 @@ func getObjectData(object *ast.Object) int {
 	return data
 }
 
+// objString identifies obj in the comments of the assembly by its name and the position of its declaration. e.g. x@main.go:5:2
+func objString(obj *ast.Object) string {
+	if !obj.Pos().IsValid() {
+		return obj.Name
+	}
+	p := fileSet.Position(obj.Pos())
+	return fmt.Sprintf("%s@%s:%d:%d", obj.Name, filepath.Base(p.Filename), p.Line, p.Column)
+}
+
+// localvarsString formats the local variables with their offsets from rbp. e.g. [x@main.go:5:2 -8, s@main.go:6:2 -24]
+func localvarsString(localvars []*ast.Object) string {
+	var vars []string
+	for _, obj := range localvars {
+		vars = append(vars, fmt.Sprintf("%s %d", objString(obj), getObjectData(obj)))
+	}
+	return "[" + strings.Join(vars, ", ") + "]"
+}
+
 func setObjectData(object *ast.Object, i int) {
 	object.Data = i
 }
 
+func boolValue(obj *ast.Object) int {
+	if obj.Name == "true" {
+		return 1
+	}
+	return 0
+}
+
 func searchTag(value string) string {
 	for _, sl := range stringLiterals {
 		if sl.value == value {
@@ -627,94 +2290,255 @@ func searchTag(value string) string {
 	return ""
 }
 
+func hasStringLiteral(value string) bool {
+	for _, sl := range stringLiterals {
+		if sl.value == value {
+			return true
+		}
+	}
+	return false
+}
+
+// stringLen returns the length of the string literal in bytes
+func stringLen(value string) int {
+	s, err := strconv.Unquote(value)
+	must(err)
+	return len(s)
+}
+
+// gasString quotes the string literal for .string directive of GNU as
+func gasString(value string) string {
+	s, err := strconv.Unquote(value)
+	must(err)
+	var b strings.Builder
+	b.WriteByte('"')
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		if c == '"' || c == '\\' {
+			b.WriteByte('\\')
+			b.WriteByte(c)
+		} else if c < 0x20 || c >= 0x7f {
+			fmt.Fprintf(&b, "\\%03o", c)
+		} else {
+			b.WriteByte(c)
+		}
+	}
+	b.WriteByte('"')
+	return b.String()
+}
+
+// gasUnquote decodes a string of .ascii and .string quoted by gasString
+func gasUnquote(s string) []byte {
+	var b []byte
+	s = s[1 : len(s)-1]
+	for i := 0; i < len(s); i++ {
+		if s[i] != '\\' {
+			b = append(b, s[i])
+			continue
+		}
+		i++
+		switch c := s[i]; {
+		case c >= '0' && c <= '7':
+			v := 0
+			for n := 0; n < 3 && i < len(s) && s[i] >= '0' && s[i] <= '7'; n++ {
+				v = v*8 + int(s[i]-'0')
+				i++
+			}
+			i--
+			b = append(b, byte(v))
+		case c == 'n':
+			b = append(b, '\n')
+		case c == 't':
+			b = append(b, '\t')
+		default:
+			b = append(b, c)
+		}
+	}
+	return b
+}
+
+// funcSymbol returns the assembly symbol of a function. e.g. main.f1, "main.Max[int]"
+func funcSymbol(pkg string, name string) string {
+	return symbol(pkg + "." + name)
+}
+
 func must(err error) {
 	if err != nil {
 		panic(err)
 	}
 }
 
-func setup(fset *token.FileSet, file *ast.File) {
+func setup() {
 	// setup universe block
 	// detail on https://motemen.github.io/go-for-go-book/#%E3%82%B9%E3%82%B3%E3%83%BC%E3%83%97
-	universe := &ast.Scope{
+	universe = &ast.Scope{
 		Outer:   nil,
 		Objects: make(map[string]*ast.Object),
 	}
 
 	universe.Insert(globalInt)
 	universe.Insert(globalString)
-	// insert build-in print function into universe block
+	universe.Insert(globalBool)
+	universe.Objects["byte"] = globalByte
+	universe.Insert(globalByte)
+	universe.Objects["rune"] = globalInt
+	universe.Insert(globalAny)
+	universe.Insert(globalComparable)
 	universe.Insert(&ast.Object{
-		Kind: ast.Fun,
-		Name: "print",
-		Decl: nil,
-		Data: nil,
-		Type: nil,
+		Kind: ast.Con,
+		Name: "true",
 	})
-
 	universe.Insert(&ast.Object{
-		Kind: ast.Pkg,
-		Name: "os", // why ???
-		Decl: nil,
-		Data: nil,
-		Type: nil,
+		Kind: ast.Con,
+		Name: "false",
 	})
-
-	ap, _ := ast.NewPackage(fset, map[string]*ast.File{"": file}, nil, universe)
-
-	var unresolved []*ast.Ident
-	for _, ident := range file.Unresolved {
-		if obj := universe.Lookup(ident.Name); obj != nil {
-			ident.Obj = obj
-		} else {
-			unresolved = append(unresolved, ident)
-		}
+	universe.Insert(globalNil)
+	universe.Insert(globalError)
+	// insert build-in functions into universe block
+	for _, name := range []string{"print", "println", "new", "len", "cap", "append", "make"} {
+		universe.Insert(&ast.Object{
+			Kind: ast.Fun,
+			Name: name,
+			Decl: nil,
+			Data: nil,
+			Type: nil,
+		})
 	}
-
-	fmt.Printf("# Package:   %s\n", ap.Name)
 }
 
 // semanticAnalyze analyzes the syntax tree and returns an error if there is any problem.
-// now semanticAnalyze extract string literals from the syntax tree
-func semanticAnalyze(file *ast.File) {
-
-	fmt.Printf("# global variables\n")
-	for _, decl := range file.Decls {
-		declWalk(&decl)
+// now semanticAnalyze extract string literals from the syntax tree.
+// packages are walked in dependency order
+func semanticAnalyze() {
+
+	emit("# global variables\n")
+	for _, pkg := range pkgOrder {
+		curPkg = pkg
+		fold(pkg)
+		for _, file := range pkg.files {
+			for _, decl := range file.Decls {
+				declWalk(&decl)
+			}
+		}
+		pkg.varInits = initOrder(pkg)
 	}
 }
 
-func generate(file *ast.File) {
+func generate() {
 	// emit string literals
 	emitSL()
 
 	// emit global variables
-	fmt.Printf("# global variables\n")
+	emit("# global variables\n")
 	emitGlobalVariables()
+	if !libc {
+		emitGCRoots()
+	}
 
 	// emit declaration functions
-	for _, fnc := range funcs {
-		emitDeclFunc(MAIN, fnc)
+	funcs = liveFuncs(funcs)
+	if !useIR {
+		dumpIR(nil, nil)
+		// methods of generic types can be instantiated by the functions emitted first
+		for i := 0; i < len(funcs); i++ {
+			emitDeclFunc(funcs[i].pkg.path, funcs[i])
+		}
+	} else {
+		// the methods of generic types instantiated by the lowering are lowered too
+		lowered := map[*Func]*ir.Func{}
+		for i := 0; i < len(funcs); i++ {
+			f := lowerFunc(funcs[i])
+			if tailCalls {
+				optimizeTailCalls(funcs[i], f)
+			}
+			lowered[funcs[i]] = f
+		}
+		if optLevel > 0 {
+			inlineFuncs(funcs, lowered)
+		}
+		dumpIR(funcs, lowered)
+		for _, fnc := range funcs {
+			emitIRFunc(fnc, lowered[fnc])
+		}
+	}
+	funcsEmitted = true
+
+	// emit package initialization
+	for _, pkg := range pkgOrder {
+		emitPackageInit(pkg)
 	}
+
+	emitTypeDescs()
+	debugNoLoc()
 }
 
 func main() {
+	input := flag.String("input", "./source/main.go", "go source file or package directory to compile")
+	flag.BoolVar(&useIR, "ir", true, "compile the functions through the IR. -ir=false compiles them from the AST by the stack machine only on linux/amd64")
+	flag.BoolVar(&regabi, "regabi", false, "pass the arguments of calls between compiled functions in registers")
+	flag.IntVar(&optLevel, "O", 1, "optimization level: 0 keeps the registers of the IR in the frame, 1 allocates machine registers")
+	flag.BoolFunc("O0", "same as -O=0", func(string) error { optLevel = 0; return nil })
+	flag.BoolVar(&peepholeStats, "peephole-stats", false, "print the peephole rules which fired and the number of instructions removed to stderr")
+	flag.BoolVar(&optReport, "m", false, "print the inlining decisions, the tail calls optimized and the variables moved to the heap in the main package to stderr")
+	flag.BoolVar(&tailCalls, "tailcall", false, "compile calls of functions to themselves in return statements to jumps reusing the frame")
+	flag.Func("target", "os/arch of the output: linux/amd64, linux/arm64, linux/riscv64 or wasip1/wasm (default linux/amd64)", setTarget)
+	flag.BoolVar(&objOutput, "obj", false, "write an ELF64 relocatable object by the built-in assembler instead of the assembly")
+	flag.Func("S", "comments of the assembly: comments, annotated with the source lines, or clean without comments (default comments)", setAsmListing)
+	flag.Func("asm-syntax", "syntax of the assembly output: att, intel (GNU as) or nasm (default att)", setAsmSyntax)
+	flag.BoolVar(&debugInfo, "g", false, "emit the line table, the call frame info and the DWARF debug info of the functions and the variables for gdb and lldb. the variables of the functions lowered to the IR are kept in the frame instead of the registers, so the code differs from the one without -g")
+	flag.Func("dump", "print the results of the phases in a comma separated list of tokens, ast, types, ir, frames and asm to stderr", setDump)
+	flag.Func("dump-format", "format of -dump: text or json, which is an object per phase on a line (default text)", setDumpFormat)
+	flag.BoolVar(&libc, "libc", false, "link with the C library: main is called by the C runtime and the heap is allocated by calloc")
+	output := flag.String("o", "main.out", "the executable written by build")
+	// gompiler build links the program into an executable by the built-in assembler and linker
+	args := os.Args[1:]
+	if len(args) > 0 && args[0] == "build" {
+		linkOutput = true
+		args = args[1:]
+	}
+	must(flag.CommandLine.Parse(args))
+
 	// define file set
-	fset := token.NewFileSet()
-	// parse source from source/main.go
-	f, err := parser.ParseFile(fset, "./source/main.go", nil, parser.ParseComments)
-	must(err)
+	fileSet = token.NewFileSet()
 
 	// setup
-	setup(fset, f)
+	setup()
+	// parse source from source/main.go and the packages it imports
+	loadProgram(fileSet, *input)
+	dumpTokens()
+	dumpAST()
 
 	// semantic Analyze
-	semanticAnalyze(f)
+	semanticAnalyze()
+	dumpTypes()
 	// generate assembly code
-	generate(f)
+	generate()
+	dumpFrameLayout()
 
-	// define runtime and os.Exit
+	// define runtime and syscalls
 	runtime()
-	osExit()
+	syscalls()
 	print()
+	alloc()
+	cmpstring()
+	concatstring()
+	slices()
+	efaces()
+	cstrings()
+	emitDebugInfo()
+
+	if optLevel > 0 {
+		peephole()
+	}
+	lowerTarget()
+	dumpAsm()
+	if !linkOutput {
+		writeTarget(os.Stdout)
+		return
+	}
+	f, err := os.OpenFile(*output, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0755)
+	must(err)
+	writeTarget(f)
+	must(f.Chmod(0755))
+	must(f.Close())
 }
@@ -0,0 +1,430 @@
+package main
+
+import (
+	"fmt"
+	"go/ast"
+	"sort"
+	"strings"
+)
+
+// a method is compiled as a function whose first argument is the receiver. e.g. main.Point.String, "main.(*File).Read"
+//
+// methods of interface values are looked up by name in the method table of the type descriptor and get the data word as the receiver.
+// so the tables of value types larger than a word and the value methods of pointer types refer to wrappers like
+// "main.(*Point).String", which dereference the receiver and call the method like the Go compiler does
+
+type methodEntry struct {
+	label  string // symbol of the method name
+	symbol string // function called with the data word as the receiver
+}
+
+var (
+	methodDecls = map[*ast.Object]map[string]*ast.FuncDecl{} // methods by the base type of the receiver and the name
+
+	wrappers        = map[string]*Func{} // wrappers of value methods by symbol
+	pendingWrappers []*Func              // wrappers and instances of methods not emitted yet
+
+	methodLabels    = map[string]string{} // method names by their symbols
+	methodLabelList []string              // symbols of method names in order of use
+)
+
+// declMethods registers the methods declared in the files of pkg by their receiver types
+func declMethods(pkg *Package) {
+	for _, file := range pkg.files {
+		for _, decl := range file.Decls {
+			funcDecl, ok := decl.(*ast.FuncDecl)
+			if !ok || funcDecl.Recv == nil {
+				continue
+			}
+			base := recvBase(funcDecl)
+			if methodDecls[base] == nil {
+				methodDecls[base] = make(map[string]*ast.FuncDecl)
+			}
+			if _, ok := methodDecls[base][funcDecl.Name.Name]; ok {
+				must(fmt.Errorf("method %s.%s already declared", base.Name, funcDecl.Name.Name))
+			}
+			methodDecls[base][funcDecl.Name.Name] = funcDecl
+		}
+	}
+}
+
+// recvBase returns T of the receiver T or *T of a method. the base of T[P] is the generic type T
+func recvBase(decl *ast.FuncDecl) *ast.Object {
+	typ, _ := recvTypeParams(decl)
+	ident, ok := typ.(*ast.Ident)
+	if !ok {
+		must(fmt.Errorf("invalid receiver type of %s", decl.Name.Name))
+	}
+	if ident.Obj == nil || ident.Obj.Kind != ast.Typ {
+		must(fmt.Errorf("undefined: %s", ident.Name))
+	}
+	return ident.Obj
+}
+
+// recvTypeParams splits the receiver T[P, Q] or *T[P, Q] of a method into T and the type parameters P and Q
+func recvTypeParams(decl *ast.FuncDecl) (ast.Expr, []ast.Expr) {
+	typ := decl.Recv.List[0].Type
+	if star, ok := typ.(*ast.StarExpr); ok {
+		typ = star.X
+	}
+	switch t := typ.(type) {
+	case *ast.IndexExpr:
+		return t.X, []ast.Expr{t.Index}
+	case *ast.IndexListExpr:
+		return t.X, t.Indices
+	}
+	return typ, nil
+}
+
+// isGenericMethod reports whether decl is a method of a generic type, which is walked per instance of the type
+func isGenericMethod(decl *ast.FuncDecl) bool {
+	if decl.Recv == nil {
+		return false
+	}
+	_, params := recvTypeParams(decl)
+	return params != nil
+}
+
+// recvTypeArgs maps the type parameters of the receiver of decl to the type arguments of the instance base,
+// or returns nil for the methods of other types
+func recvTypeArgs(base *ast.Object, decl *ast.FuncDecl) map[*ast.Object]*ast.Object {
+	inst, ok := base.Data.(*typeInstance)
+	if !ok {
+		return nil
+	}
+	_, params := recvTypeParams(decl)
+	if len(params) != len(inst.args) {
+		must(fmt.Errorf("%s: got %d type parameters but %d type arguments", decl.Name.Name, len(params), len(inst.args)))
+	}
+	typeArgs := make(map[*ast.Object]*ast.Object)
+	for i, param := range params {
+		if ident, ok := param.(*ast.Ident); ok && ident.Obj != nil {
+			typeArgs[ident.Obj] = inst.args[i]
+		}
+	}
+	return typeArgs
+}
+
+// methodsOf returns the methods of the base type of a receiver by name. instances of a generic type have the methods of the generic type
+func methodsOf(base *ast.Object) map[string]*ast.FuncDecl {
+	if inst, ok := base.Data.(*typeInstance); ok {
+		return methodDecls[inst.generic]
+	}
+	return methodDecls[base]
+}
+
+// methodFunc returns the function of the method decl of base. the methods of an instance of a generic type are instantiated like
+// generic functions, e.g. "main.(*Stack[int]).Push", and the ones instantiated after the functions are emitted are emitted with the wrappers
+func methodFunc(base *ast.Object, decl *ast.FuncDecl) *Func {
+	inst, ok := base.Data.(*typeInstance)
+	if !ok {
+		return &Func{decl: decl, pkg: pkgOf(base), name: methodFuncName(decl)}
+	}
+	pkg := pkgOf(inst.generic)
+	name := instanceName(inst.generic.Name, inst.args) + "." + decl.Name.Name
+	if hasPointerRecv(decl) {
+		name = "(*" + instanceName(inst.generic.Name, inst.args) + ")." + decl.Name.Name
+	}
+	if fnc, ok := instances[funcSymbol(pkg.path, name)]; ok {
+		return fnc
+	}
+
+	fnc := &Func{
+		decl:     decl,
+		pkg:      pkg,
+		name:     name,
+		typeArgs: recvTypeArgs(base, decl),
+	}
+	instances[funcSymbol(pkg.path, name)] = fnc
+	funcWalk(fnc)
+	funcs = append(funcs, fnc)
+	if funcsEmitted {
+		pendingWrappers = append(pendingWrappers, fnc)
+	}
+	return fnc
+}
+
+func hasPointerRecv(decl *ast.FuncDecl) bool {
+	_, ok := decl.Recv.List[0].Type.(*ast.StarExpr)
+	return ok
+}
+
+// methodFuncName returns the name of the function of a method without package. e.g. Point.String, (*File).Read
+func methodFuncName(decl *ast.FuncDecl) string {
+	if hasPointerRecv(decl) {
+		return "(*" + recvBase(decl).Name + ")." + decl.Name.Name
+	}
+	return recvBase(decl).Name + "." + decl.Name.Name
+}
+
+// methodSelector returns x.m of a method call x.m(...), or nil if the call is a function call like f(x) or pkg.f(x)
+func methodSelector(expr *ast.CallExpr) *ast.SelectorExpr {
+	sel, ok := expr.Fun.(*ast.SelectorExpr)
+	if !ok {
+		return nil
+	}
+	if x, ok := sel.X.(*ast.Ident); ok && x.Obj != nil && x.Obj.Kind == ast.Pkg {
+		return nil
+	}
+	return sel
+}
+
+// lookupMethod returns the method name of typ. the method of *T is looked up in T.
+// methods of interfaces have no body, they are called through the method table of the dynamic type
+func lookupMethod(typ *ast.Object, name string) *Func {
+	if isInterface(typ) {
+		for _, method := range interfaceMethods(typ) {
+			if method.Names[0].Name == name {
+				decl := &ast.FuncDecl{Name: method.Names[0], Type: method.Type.(*ast.FuncType)}
+				return &Func{decl: decl, pkg: objPkgs[underlying(typ)], name: name}
+			}
+		}
+		must(fmt.Errorf("%s has no method %s", typ.Name, name))
+	}
+	base := typ
+	if isPointer(typ) {
+		base = elemType(typ)
+	}
+	decl, ok := methodsOf(base)[name]
+	if !ok {
+		must(fmt.Errorf("type %s has no method %s", typ.Name, name))
+	}
+	return methodFunc(base, decl)
+}
+
+// interfaceMethods returns the methods of an interface type including the ones of embedded interfaces
+func interfaceMethods(typ *ast.Object) []*ast.Field {
+	u := underlying(typ)
+	if u == globalAny {
+		return nil
+	}
+	var methods []*ast.Field
+	for _, field := range u.Decl.(*ast.TypeSpec).Type.(*ast.InterfaceType).Methods.List {
+		if len(field.Names) > 0 {
+			methods = append(methods, field)
+		} else if isTypeExpr(field.Type) && isInterface(getType(field.Type)) {
+			methods = append(methods, interfaceMethods(getType(field.Type))...)
+		}
+	}
+	return methods
+}
+
+// emitRecv pushes the receiver x of a method call and returns its size.
+// x is addressed or dereferenced when it is T for a receiver *T or *T for a receiver T
+func emitRecv(fnc *Func, x ast.Expr) int {
+	var recvType *ast.Object
+	withTypeArgs(fnc.typeArgs, func() {
+		recvType = getType(fnc.decl.Recv.List[0].Type)
+	})
+	switch xType := getType(x); {
+	case isPointer(recvType) && !isPointer(xType):
+		if !isAddressable(x) {
+			must(fmt.Errorf("cannot call pointer method %s on %s", fnc.decl.Name.Name, xType.Name))
+		}
+		emitAddr(&x)
+	case !isPointer(recvType) && isPointer(xType):
+		emitExpr(&ast.StarExpr{X: x})
+	default:
+		emitExpr(x)
+	}
+	return sizeOf(recvType)
+}
+
+// recvSize returns the size of the receiver of the method fnc
+func recvSize(fnc *Func) int {
+	size := 0
+	withTypeArgs(fnc.typeArgs, func() {
+		size = sizeOf(getType(fnc.decl.Recv.List[0].Type))
+	})
+	return size
+}
+
+// emitIfaceCall calls the method name of the interface value x. the arguments and x are pushed already,
+// and the data word of x is left on the stack as the receiver
+func emitIfaceCall(x ast.Expr, name string) {
+	emit("  leaq %s(%%rip), %%rax\n", methodLabel(objPkgs[underlying(getType(x))], name))
+	emit("  pushq %%rax\n")
+	emit("  callq runtime.findmethod\n")
+	emit("  addq $16, %%rsp # the data is the receiver\n")
+	emit("  callq *%%rax # .%s\n", name)
+}
+
+// methodLabel returns the symbol identifying a method name. unexported names are qualified by the package
+func methodLabel(pkg *Package, name string) string {
+	if !ast.IsExported(name) {
+		name = pkg.path + "." + name
+	}
+	label := symbol("go.name." + name)
+	if _, ok := methodLabels[label]; !ok {
+		methodLabels[label] = name
+		methodLabelList = append(methodLabelList, label)
+	}
+	return label
+}
+
+// methodTable returns the methods of typ called through interface values, sorted by name
+func methodTable(typ *ast.Object) []methodEntry {
+	base := typ
+	if isPointer(typ) {
+		base = elemType(typ)
+	}
+	var names []string
+	for name := range methodsOf(base) {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	var table []methodEntry
+	for _, name := range names {
+		decl := methodsOf(base)[name]
+		var sym string
+		switch {
+		case isPointer(typ) == hasPointerRecv(decl) && sizeOf(typ) == 8:
+			fnc := methodFunc(base, decl)
+			sym = funcSymbol(fnc.pkg.path, fnc.name)
+		case !hasPointerRecv(decl):
+			sym = methodWrapper(base, decl)
+		default: // pointer methods are not in the method set of T
+			continue
+		}
+		table = append(table, methodEntry{label: methodLabel(pkgOf(recvBase(decl)), name), symbol: sym})
+	}
+	return table
+}
+
+// methodWrapper returns the symbol of the wrapper of a value method T.M, which is
+//
+//	func (p *T) M(args) results { return (*p).M(args) }
+//
+// wrappers are emitted after the type descriptors referring them
+func methodWrapper(base *ast.Object, decl *ast.FuncDecl) string {
+	method := methodFunc(base, decl)
+	pkg := method.pkg
+	name := "(*" + strings.TrimSuffix(method.name, "."+decl.Name.Name) + ")." + decl.Name.Name
+	sym := funcSymbol(pkg.path, name)
+	if _, ok := wrappers[sym]; ok {
+		return sym
+	}
+
+	recv := newParam(".recv", &ast.StarExpr{X: decl.Recv.List[0].Type})
+	params := &ast.FieldList{}
+	var args []ast.Expr
+	for i, typ := range paramTypes(decl) {
+		param := newParam(fmt.Sprintf(".arg%d", i), typ)
+		params.List = append(params.List, param)
+		args = append(args, param.Names[0])
+	}
+	call := &ast.CallExpr{
+		Fun:  &ast.SelectorExpr{X: &ast.StarExpr{X: recv.Names[0]}, Sel: ast.NewIdent(decl.Name.Name)},
+		Args: args,
+	}
+	if isVariadic(decl) {
+		call.Ellipsis = decl.Pos()
+	}
+	var body ast.Stmt = &ast.ExprStmt{X: call}
+	var results *ast.FieldList
+	if decl.Type.Results != nil {
+		results = &ast.FieldList{}
+		for _, field := range decl.Type.Results.List { // the results are unnamed
+			results.List = append(results.List, &ast.Field{Type: field.Type})
+			for i := 1; i < len(field.Names); i++ {
+				results.List = append(results.List, &ast.Field{Type: field.Type})
+			}
+		}
+		body = &ast.ReturnStmt{Results: []ast.Expr{call}}
+	}
+
+	fnc := &Func{
+		decl: &ast.FuncDecl{
+			Recv: &ast.FieldList{List: []*ast.Field{recv}},
+			Name: ast.NewIdent(decl.Name.Name),
+			Type: &ast.FuncType{Params: params, Results: results},
+			Body: &ast.BlockStmt{List: []ast.Stmt{body}},
+		},
+		pkg:      pkg,
+		name:     name,
+		typeArgs: method.typeArgs,
+	}
+	wrappers[sym] = fnc
+	pendingWrappers = append(pendingWrappers, fnc)
+	return sym
+}
+
+// newParam returns a parameter declaring name of typ
+func newParam(name string, typ ast.Expr) *ast.Field {
+	ident := ast.NewIdent(name)
+	field := &ast.Field{Names: []*ast.Ident{ident}, Type: typ}
+	ident.Obj = &ast.Object{Kind: ast.Var, Name: name, Decl: field}
+	return field
+}
+
+// emitWrappers emits the wrappers referred by the method tables
+func emitWrappers() {
+	for len(pendingWrappers) > 0 {
+		fnc := pendingWrappers[0]
+		pendingWrappers = pendingWrappers[1:]
+		funcWalk(fnc)
+		if useIR {
+			emitIRFunc(fnc, lowerFunc(fnc))
+		} else {
+			emitDeclFunc(fnc.pkg.path, fnc)
+		}
+	}
+}
+
+// checkImplements fails unless the concrete type typ has the methods of the interface iface
+func checkImplements(typ *ast.Object, iface *ast.Object) {
+	base := typ
+	if isPointer(typ) {
+		base = elemType(typ)
+	}
+	for _, method := range interfaceMethods(iface) {
+		name := method.Names[0].Name
+		decl, ok := methodsOf(base)[name]
+		switch {
+		case !ok:
+			must(fmt.Errorf("%s does not implement %s (missing method %s)", typ.Name, iface.Name, name))
+		case hasPointerRecv(decl) && !isPointer(typ):
+			must(fmt.Errorf("%s does not implement %s (method %s has pointer receiver)", typ.Name, iface.Name, name))
+		case !sameSignature(decl.Type, recvTypeArgs(base, decl), method.Type.(*ast.FuncType)):
+			must(fmt.Errorf("%s does not implement %s (wrong type for method %s)", typ.Name, iface.Name, name))
+		}
+	}
+}
+
+// sameSignature reports whether the function types x and y are identical. the type parameters in x are substituted by typeArgs
+func sameSignature(x *ast.FuncType, typeArgs map[*ast.Object]*ast.Object, y *ast.FuncType) bool {
+	var a []*ast.Object
+	withTypeArgs(typeArgs, func() {
+		a = signatureTypes(x)
+	})
+	b := signatureTypes(y)
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
+
+// signatureTypes returns the types of the parameters and the results of a function type separated by nil
+func signatureTypes(ft *ast.FuncType) []*ast.Object {
+	var types []*ast.Object
+	for _, list := range []*ast.FieldList{ft.Params, ft.Results} {
+		if list == nil {
+			continue
+		}
+		for _, field := range list.List {
+			typ := getType(field.Type)
+			types = append(types, typ)
+			for i := 1; i < len(field.Names); i++ {
+				types = append(types, typ)
+			}
+		}
+		types = append(types, nil)
+	}
+	return types
+}
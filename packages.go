@@ -0,0 +1,343 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"go/ast"
+	"go/parser"
+	"go/token"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// Package is a set of files compiled together.
+// packages of the module are loaded from the directories of their import paths, the standard library is provided by the runtime
+type Package struct {
+	name    string // package name. e.g. util
+	path    string // import path used as the prefix of symbols. main for the main package
+	dir     string
+	files   []*ast.File
+	scope   *ast.Scope
+	imports []*Package
+
+	varInits  []*varInit // initializers of package level variables evaluated by pkg.init
+	initFuncs []*Func    // func init() in the order of the files
+}
+
+var (
+	packages     = map[string]*Package{} // loaded packages by import path
+	loading      = map[string]bool{}     // packages being loaded, to detect import cycles
+	pkgOrder     []*Package              // packages in dependency order. the main package is the last
+	objPkgs      = map[*ast.Object]*Package{}
+	modulePath   string // module path in go.mod
+	moduleDir    string // directory of go.mod
+	universe     *ast.Scope
+	curPkg       *Package // package being walked or emitted
+	stdPackages  = map[string]bool{"unsafe": true}
+	mainPackage  *Package
+	fileSet      *token.FileSet        // positions of the parsed files for diagnostics
+	sources      = map[string][]byte{} // the parsed files by their names in fileSet, for -S=annotated
+	errNotLoaded = fmt.Errorf("package is provided by the runtime")
+)
+
+// loadProgram parses the main package at input, which is a go file or a directory, and the packages it imports
+func loadProgram(fset *token.FileSet, input string) {
+	info, err := os.Stat(input)
+	must(err)
+
+	var files []*ast.File
+	dir := input
+	if info.IsDir() {
+		files = parseDir(fset, input)
+	} else {
+		dir = filepath.Dir(input)
+		src, err := os.ReadFile(input)
+		must(err)
+		f, err := parser.ParseFile(fset, input, src, parser.ParseComments)
+		must(err)
+		sources[input] = src
+		files = []*ast.File{f}
+	}
+	findModule(dir)
+
+	mainPackage = &Package{
+		name:  files[0].Name.Name,
+		path:  MAIN,
+		dir:   dir,
+		files: files,
+	}
+	if mainPackage.name != MAIN {
+		must(fmt.Errorf("%s: package %s is not a main package", input, mainPackage.name))
+	}
+	loadImports(fset, mainPackage)
+	setupPackage(fset, mainPackage)
+	pkgOrder = append(pkgOrder, mainPackage)
+}
+
+// loadPackage parses the package of the import path and its imports
+func loadPackage(fset *token.FileSet, path string) *Package {
+	if pkg, ok := packages[path]; ok {
+		return pkg
+	}
+	if loading[path] {
+		must(fmt.Errorf("import cycle not allowed: %s", path))
+	}
+	loading[path] = true
+	defer delete(loading, path)
+
+	var dir string
+	var files []*ast.File
+	if libPackages[path] {
+		dir = "lib/" + path
+		sub, err := fs.Sub(libFS, dir)
+		must(err)
+		files = parseFS(fset, sub, dir)
+	} else {
+		if modulePath == "" || (path != modulePath && !strings.HasPrefix(path, modulePath+"/")) {
+			must(fmt.Errorf("package %s is not in module %q", path, modulePath))
+		}
+		dir = filepath.Join(moduleDir, strings.TrimPrefix(path, modulePath))
+		files = parseDir(fset, dir)
+	}
+	pkg := &Package{
+		name:  files[0].Name.Name,
+		path:  path,
+		dir:   dir,
+		files: files,
+	}
+	loadImports(fset, pkg)
+	setupPackage(fset, pkg)
+	packages[path] = pkg
+	pkgOrder = append(pkgOrder, pkg)
+	return pkg
+}
+
+func loadImports(fset *token.FileSet, pkg *Package) {
+	for _, file := range pkg.files {
+		for _, spec := range file.Imports {
+			path := strings.Trim(spec.Path.Value, `"`)
+			if stdPackages[path] {
+				continue
+			}
+			pkg.imports = append(pkg.imports, loadPackage(fset, path))
+		}
+	}
+}
+
+// parseDir parses the go files of a package except tests
+func parseDir(fset *token.FileSet, dir string) []*ast.File {
+	return parseFS(fset, os.DirFS(dir), dir)
+}
+
+// parseFS parses the go files at the root of fsys. dir is the directory shown in positions
+func parseFS(fset *token.FileSet, fsys fs.FS, dir string) []*ast.File {
+	entries, err := fs.ReadDir(fsys, ".")
+	must(err)
+	var names []string
+	for _, entry := range entries {
+		name := entry.Name()
+		if entry.IsDir() || !strings.HasSuffix(name, ".go") || strings.HasSuffix(name, "_test.go") {
+			continue
+		}
+		names = append(names, name)
+	}
+	if len(names) == 0 {
+		must(fmt.Errorf("no go files in %s", dir))
+	}
+	sort.Strings(names)
+
+	var files []*ast.File
+	for _, name := range names {
+		src, err := fs.ReadFile(fsys, name)
+		must(err)
+		f, err := parser.ParseFile(fset, filepath.Join(dir, name), src, parser.ParseComments)
+		must(err)
+		sources[filepath.Join(dir, name)] = src
+		if len(files) > 0 && f.Name.Name != files[0].Name.Name {
+			must(fmt.Errorf("found packages %s and %s in %s", files[0].Name.Name, f.Name.Name, dir))
+		}
+		files = append(files, f)
+	}
+	return files
+}
+
+// findModule reads the module path from go.mod in dir or its parents. programs without go.mod can import the standard library only
+func findModule(dir string) {
+	abs, err := filepath.Abs(dir)
+	must(err)
+	for {
+		f, err := os.Open(filepath.Join(abs, "go.mod"))
+		if err == nil {
+			defer f.Close()
+			scanner := bufio.NewScanner(f)
+			for scanner.Scan() {
+				fields := strings.Fields(scanner.Text())
+				if len(fields) == 2 && fields[0] == "module" {
+					modulePath = strings.Trim(fields[1], `"`)
+					moduleDir = abs
+					return
+				}
+			}
+			must(fmt.Errorf("%s/go.mod has no module directive", abs))
+		}
+		parent := filepath.Dir(abs)
+		if parent == abs {
+			return
+		}
+		abs = parent
+	}
+}
+
+// setupPackage resolves the identifiers of the files across the package, its imports and the universe block
+func setupPackage(fset *token.FileSet, pkg *Package) {
+	fileMap := make(map[string]*ast.File)
+	for i, file := range pkg.files {
+		fileMap[fmt.Sprint(i)] = file
+	}
+	importer := func(imports map[string]*ast.Object, path string) (*ast.Object, error) {
+		if obj, ok := imports[path]; ok {
+			return obj, nil
+		}
+		imported, ok := packages[path]
+		if !ok {
+			// keep it unresolved. unsafe is imported only for //go:linkname
+			return nil, errNotLoaded
+		}
+		obj := &ast.Object{
+			Kind: ast.Pkg,
+			Name: imported.name,
+			Decl: nil,
+			Data: imported.scope,
+			Type: nil,
+		}
+		imports[path] = obj
+		return obj, nil
+	}
+
+	ap, _ := ast.NewPackage(fset, fileMap, importer, universe)
+	pkg.scope = ap.Scope
+	for _, obj := range ap.Scope.Objects {
+		objPkgs[obj] = pkg
+	}
+
+	for _, file := range pkg.files {
+		resolveRecvTypeParams(file)
+		for _, ident := range file.Unresolved {
+			if obj := universe.Lookup(ident.Name); obj != nil {
+				ident.Obj = obj
+			} else {
+				must(fmt.Errorf("%s: undefined: %s", fset.Position(ident.Pos()), ident.Name))
+			}
+		}
+		resolveSelectors(file)
+	}
+	declMethods(pkg)
+
+	emit("# Package:   %s\n", pkg.path)
+}
+
+// resolveRecvTypeParams declares the type parameters of the receivers like T of func (s *Stack[T]) Push(v T),
+// which the parser leaves without objects, and resolves their uses in the methods. they are declared by the fields of the generic type
+func resolveRecvTypeParams(file *ast.File) {
+	for _, decl := range file.Decls {
+		funcDecl, ok := decl.(*ast.FuncDecl)
+		if !ok || !isGenericMethod(funcDecl) {
+			continue
+		}
+		spec, ok := recvBase(funcDecl).Decl.(*ast.TypeSpec)
+		if !ok || spec.TypeParams == nil {
+			must(fmt.Errorf("%s is not a generic type", recvBase(funcDecl).Name))
+		}
+		var fields []*ast.Field
+		for _, field := range spec.TypeParams.List {
+			for range field.Names {
+				fields = append(fields, field)
+			}
+		}
+		_, params := recvTypeParams(funcDecl)
+		if len(params) != len(fields) {
+			must(fmt.Errorf("%s: got %d type parameters but %d in %s", funcDecl.Name.Name, len(params), len(fields), spec.Name.Name))
+		}
+		objs := map[string]*ast.Object{}
+		for i, param := range params {
+			if ident, ok := param.(*ast.Ident); ok && ident.Name != "_" {
+				ident.Obj = &ast.Object{Kind: ast.Typ, Name: ident.Name, Decl: fields[i]}
+				objs[ident.Name] = ident.Obj
+			}
+		}
+		var resolve func(node ast.Node) bool
+		resolve = func(node ast.Node) bool {
+			switch n := node.(type) {
+			case *ast.SelectorExpr: // the selected name is a field or a method
+				ast.Inspect(n.X, resolve)
+				return false
+			case *ast.Ident:
+				if n.Obj == nil && objs[n.Name] != nil {
+					n.Obj = objs[n.Name]
+				}
+			}
+			return true
+		}
+		ast.Inspect(funcDecl, resolve)
+	}
+}
+
+// resolveSelectors sets the objects of qualified identifiers like util.Add, which the parser leaves unresolved
+func resolveSelectors(file *ast.File) {
+	ast.Inspect(file, func(node ast.Node) bool {
+		sel, ok := node.(*ast.SelectorExpr)
+		if !ok {
+			return true
+		}
+		x, ok := sel.X.(*ast.Ident)
+		if !ok || x.Obj == nil || x.Obj.Kind != ast.Pkg {
+			return true
+		}
+		scope, ok := x.Obj.Data.(*ast.Scope)
+		if !ok {
+			return true
+		}
+		if !ast.IsExported(sel.Sel.Name) {
+			must(fmt.Errorf("%s.%s is not exported", x.Name, sel.Sel.Name))
+		}
+		sel.Sel.Obj = scope.Lookup(sel.Sel.Name)
+		if sel.Sel.Obj == nil {
+			must(fmt.Errorf("undefined: %s.%s", x.Name, sel.Sel.Name))
+		}
+		return true
+	})
+}
+
+// qualifiedIdent returns Add of util.Add if the selector refers to a package of the module, otherwise nil
+func qualifiedIdent(sel *ast.SelectorExpr) *ast.Ident {
+	x, ok := sel.X.(*ast.Ident)
+	if !ok || x.Obj == nil || x.Obj.Kind != ast.Pkg || sel.Sel.Obj == nil {
+		return nil
+	}
+	return sel.Sel
+}
+
+// pkgOf returns the package declaring a package level object
+func pkgOf(obj *ast.Object) *Package {
+	pkg, ok := objPkgs[obj]
+	if !ok {
+		must(fmt.Errorf("%s is not a package level object", obj.Name))
+	}
+	return pkg
+}
+
+// symbol quotes a symbol when it contains characters GNU as doesn't accept in names. e.g. "example.com/util.Add", "main.Max[int]", "type.*main.Point", "main.(*File).Read"
+func symbol(name string) string {
+	if strings.ContainsAny(name, "[],/-*() ") {
+		return fmt.Sprintf("%q", name)
+	}
+	return name
+}
+
+// globalSymbol returns the symbol of a package level variable. e.g. main.globalint1
+func globalSymbol(obj *ast.Object) string {
+	return symbol(pkgOf(obj).path + "." + obj.Name)
+}
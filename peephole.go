@@ -0,0 +1,219 @@
+package main
+
+import (
+	"fmt"
+	"math/bits"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// peepholeStats prints the rules which fired and the instructions removed by peephole to stderr
+var peepholeStats bool
+
+// peepholeRule rewrites the instructions at the start of lines, which are the rest of the output without comments.
+// it returns the number of lines it replaces and their replacement, or 0 if it doesn't apply
+type peepholeRule struct {
+	name  string
+	apply func(lines []*asmLine) (int, []*asmLine)
+}
+
+// peepholeRules are tried in order at every instruction. new rules are added here
+var peepholeRules = []peepholeRule{
+	{"push-pop-same", pushPopSame},
+	{"push-pop-move", pushPopMove},
+	{"move-self", moveSelf},
+	{"store-load", storeLoad},
+	{"zero-xor", zeroXor},
+	{"mul-shift", mulShift},
+	{"jump-next", jumpNext},
+}
+
+// peephole rewrites asmLines by peepholeRules until none of them applies
+func peephole() {
+	fired := map[string]int{}
+	before := countInstrs()
+	for changed := true; changed; {
+		changed = false
+		var lines []*asmLine
+		for _, line := range asmLines {
+			if !line.removed && !isAsmComment(line) && !isAsmLoc(line) {
+				lines = append(lines, line)
+			}
+		}
+		for i := 0; i < len(lines); i++ {
+			if lines[i].op == "" {
+				continue
+			}
+			for _, rule := range peepholeRules {
+				n, repl := rule.apply(lines[i:])
+				if n == 0 {
+					continue
+				}
+				for k, line := range lines[i : i+n] {
+					if k < len(repl) {
+						*line = *repl[k]
+					} else {
+						line.removed = true
+					}
+				}
+				fired[rule.name]++
+				changed = true
+				i += n - 1
+				break
+			}
+		}
+	}
+
+	if peepholeStats {
+		var names []string
+		for name := range fired {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		for _, name := range names {
+			fmt.Fprintf(os.Stderr, "peephole: %s fired %d times\n", name, fired[name])
+		}
+		after := countInstrs()
+		fmt.Fprintf(os.Stderr, "peephole: %d of %d instructions removed\n", before-after, before)
+	}
+}
+
+func countInstrs() int {
+	n := 0
+	for _, line := range asmLines {
+		if !line.removed && line.op != "" {
+			n++
+		}
+	}
+	return n
+}
+
+func isAsmComment(line *asmLine) bool {
+	s := strings.TrimSpace(line.text)
+	return line.op == "" && (s == "" || strings.HasPrefix(s, "#"))
+}
+
+// isAsmLoc reports whether line is a .loc directive of -g, which doesn't change the code
+func isAsmLoc(line *asmLine) bool {
+	return line.op == "" && strings.HasPrefix(strings.TrimSpace(line.text), ".loc ")
+}
+
+func isAsmReg(operand string) bool {
+	return strings.HasPrefix(operand, "%")
+}
+
+func isAsmMem(operand string) bool {
+	return strings.HasSuffix(operand, ")")
+}
+
+// is reports whether line is the instruction op with n operands
+func (l *asmLine) is(op string, n int) bool {
+	return l.op == op && len(l.args) == n
+}
+
+// pushq %rax; popq %rax is removed
+func pushPopSame(lines []*asmLine) (int, []*asmLine) {
+	if len(lines) < 2 || !lines[0].is("pushq", 1) || !lines[1].is("popq", 1) {
+		return 0, nil
+	}
+	if x := lines[0].args[0]; isAsmReg(x) && x == lines[1].args[0] {
+		return 2, nil
+	}
+	return 0, nil
+}
+
+// pushq x; popq %rdi is movq x, %rdi
+func pushPopMove(lines []*asmLine) (int, []*asmLine) {
+	if len(lines) < 2 || !lines[0].is("pushq", 1) || !lines[1].is("popq", 1) {
+		return 0, nil
+	}
+	x, y := lines[0].args[0], lines[1].args[0]
+	if !isAsmReg(y) || y == "%rsp" || strings.Contains(x, "%rsp") {
+		return 0, nil
+	}
+	return 2, []*asmLine{asmInstr("movq", x, y)}
+}
+
+// movq %rax, %rax is removed
+func moveSelf(lines []*asmLine) (int, []*asmLine) {
+	if lines[0].is("movq", 2) && lines[0].args[0] == lines[0].args[1] && isAsmReg(lines[0].args[0]) {
+		return 1, nil
+	}
+	return 0, nil
+}
+
+// movq %rax, -8(%rbp); movq -8(%rbp), %rax loads the value the register already has
+func storeLoad(lines []*asmLine) (int, []*asmLine) {
+	if len(lines) < 2 || !lines[0].is("movq", 2) || !lines[1].is("movq", 2) {
+		return 0, nil
+	}
+	reg, mem := lines[0].args[0], lines[0].args[1]
+	if isAsmReg(reg) && isAsmMem(mem) && lines[1].args[0] == mem && lines[1].args[1] == reg {
+		return 2, lines[:1]
+	}
+	return 0, nil
+}
+
+var asmReg32 = map[string]string{
+	"%rax": "%eax", "%rbx": "%ebx", "%rcx": "%ecx", "%rdx": "%edx", "%rsi": "%esi", "%rdi": "%edi",
+	"%r8": "%r8d", "%r9": "%r9d", "%r10": "%r10d", "%r11": "%r11d",
+	"%r12": "%r12d", "%r13": "%r13d", "%r14": "%r14d", "%r15": "%r15d",
+}
+
+// movq $0, %rax is xorl %eax, %eax, which is shorter. xorl changes the flags,
+// so it is used only when the flags are set again before they are read
+func zeroXor(lines []*asmLine) (int, []*asmLine) {
+	if !lines[0].is("movq", 2) || lines[0].args[0] != "$0" {
+		return 0, nil
+	}
+	reg, ok := asmReg32[lines[0].args[1]]
+	if !ok || !flagsDead(lines[1:]) {
+		return 0, nil
+	}
+	return 1, []*asmLine{asmInstr("xorl", reg, reg)}
+}
+
+// flagsDead reports whether the flags are set or clobbered by a call before lines read them
+func flagsDead(lines []*asmLine) bool {
+	for _, line := range lines {
+		switch op := line.op; {
+		case op == "":
+			return false // a label may be jumped to with the flags
+		case op == "callq" || op == "ret" || op == "jmp" || op == "syscall":
+			return true
+		case strings.HasPrefix(op, "j") || strings.HasPrefix(op, "set") || strings.HasPrefix(op, "cmov") || op == "adcq" || op == "sbbq":
+			return false
+		case op == "cmpq" || op == "testq" || op == "addq" || op == "subq" || op == "andq" || op == "orq" || op == "xorq" || op == "xorl" || op == "negq":
+			return true
+		}
+	}
+	return true
+}
+
+// imulq $8, %rcx is salq $3, %rcx
+func mulShift(lines []*asmLine) (int, []*asmLine) {
+	if !lines[0].is("imulq", 2) || !strings.HasPrefix(lines[0].args[0], "$") || !isAsmReg(lines[0].args[1]) {
+		return 0, nil
+	}
+	n, err := strconv.Atoi(lines[0].args[0][1:])
+	if err != nil || n <= 0 || n&(n-1) != 0 {
+		return 0, nil
+	}
+	if n == 1 {
+		return 1, nil
+	}
+	return 1, []*asmLine{asmInstr("salq", fmt.Sprintf("$%d", bits.TrailingZeros(uint(n))), lines[0].args[1])}
+}
+
+// jmp .L1 right before .L1: is removed
+func jumpNext(lines []*asmLine) (int, []*asmLine) {
+	if len(lines) < 2 || !lines[0].is("jmp", 1) || lines[1].op != "" {
+		return 0, nil
+	}
+	if strings.TrimSpace(lines[1].text) == lines[0].args[0]+":" {
+		return 1, nil
+	}
+	return 0, nil
+}
@@ -0,0 +1,150 @@
+package main
+
+import (
+	"fmt"
+	"go/ast"
+	"go/token"
+)
+
+// with -regabi, functions are called with the register ABI like ABIInternal of the Go compiler.
+// the words of the arguments are passed in abiRegs in order and the callee spills them to slots in its frame,
+// so parameters are local variables in the body. results are returned like the stack ABI.
+//
+// methods, which are called through method tables, and functions with more argument words than registers keep the stack ABI,
+// as do the runtime routines written in assembly
+
+var (
+	// regabi is set by -regabi
+	regabi bool
+
+	abiRegs = []string{"rax", "rbx", "rcx", "rdi", "rsi", "r8", "r9", "r10", "r11"}
+)
+
+// usesRegABI reports whether fnc takes its arguments in registers
+func usesRegABI(fnc *Func) bool {
+	if !regabi || fnc.decl.Recv != nil || fnc.decl.Body == nil {
+		return false
+	}
+	words := 0
+	withTypeArgs(fnc.typeArgs, func() {
+		for _, t := range paramTypes(fnc.decl) {
+			words += (sizeOf(getType(t)) + 7) / 8
+		}
+	})
+	return words <= len(abiRegs)
+}
+
+// regParamsWalk allocates the spill slots of the parameters of fnc in the local area
+func regParamsWalk(fnc *Func, localvars []*ast.Object, localoffset *int) []*ast.Object {
+	for _, field := range fnc.decl.Type.Params.List {
+		for _, name := range field.Names {
+			localvars = allocSlot(name.Obj, sizeOf(varType(name.Obj)), localvars, localoffset)
+		}
+	}
+	return localvars
+}
+
+// emitSpills stores the argument registers to the spill slots of the parameters. unnamed parameters are dropped
+func emitSpills(fnc *Func) {
+	reg := 0
+	for _, field := range fnc.decl.Type.Params.List {
+		words := (sizeOf(getType(field.Type)) + 7) / 8
+		if len(field.Names) == 0 {
+			reg += words
+			continue
+		}
+		for _, name := range field.Names {
+			for i := 0; i < words; i++ {
+				emit("  movq %%%s, %d(%%rbp) # spill %s\n", abiRegs[reg], paramOffset(fnc, name.Obj)+i*8, name.Name)
+				reg++
+			}
+		}
+	}
+}
+
+// emitRegArgs loads the arguments of a call to fnc into the registers.
+// the other arguments are evaluated on the stack first in the order of the stack ABI, and then locals and literals are moved to their registers directly.
+// when two of them call functions, they are evaluated from the first like Go
+func emitRegArgs(fnc *Func, expr *ast.CallExpr) {
+	var types []*ast.Object
+	withTypeArgs(fnc.typeArgs, func() {
+		for _, t := range paramTypes(fnc.decl) {
+			types = append(types, getType(t))
+		}
+	})
+	if isVariadic(fnc.decl) && !expr.Ellipsis.IsValid() {
+		size := emitArgs(fnc, expr)
+		for i := 0; i < size/8; i++ {
+			emit("  popq %%%s\n", abiRegs[i])
+		}
+		return
+	}
+	if len(expr.Args) != len(types) {
+		must(fmt.Errorf("%s: got %d arguments but %d parameters", fnc.name, len(expr.Args), len(types)))
+	}
+
+	regs := make([]int, len(types)) // the first register of each argument
+	words := 0
+	for i, typ := range types {
+		regs[i] = words
+		words += (sizeOf(typ) + 7) / 8
+	}
+	var sizes []int
+	var operands []func()
+	for i, typ := range types {
+		i, typ := i, typ
+		if !isRegOperand(expr.Args[i], typ) {
+			sizes = append(sizes, sizeOf(typ))
+			operands = append(operands, func() { emitExprAs(expr.Args[i], typ) })
+		}
+	}
+	if argsHaveCalls(expr.Args, 2) {
+		emitOperandsInOrder(sizes, operands)
+	} else {
+		for i := len(operands) - 1; i >= 0; i-- {
+			operands[i]()
+		}
+	}
+	for i, typ := range types {
+		if isRegOperand(expr.Args[i], typ) {
+			continue
+		}
+		for w := 0; w < (sizeOf(typ)+7)/8; w++ {
+			emit("  popq %%%s\n", abiRegs[regs[i]+w])
+		}
+	}
+	for i, typ := range types {
+		if isRegOperand(expr.Args[i], typ) {
+			emit("  movq %s, %%%s\n", regOperand(expr.Args[i]), abiRegs[regs[i]])
+		}
+	}
+}
+
+// isRegOperand reports whether arg is an int literal or a word variable, which is moved to the register without the stack
+func isRegOperand(arg ast.Expr, typ *ast.Object) bool {
+	if sizeOf(typ) != 8 || isInterface(typ) {
+		return false
+	}
+	switch a := arg.(type) {
+	case *ast.BasicLit:
+		return a.Kind == token.INT || a.Kind == token.CHAR
+	case *ast.Ident:
+		return a.Obj != nil && a.Obj.Kind == ast.Var && sizeOf(varType(a.Obj)) == 8 && !isHeapVar(a.Obj)
+	}
+	return false
+}
+
+// regOperand returns the source operand of movq for a register operand
+func regOperand(arg ast.Expr) string {
+	switch a := arg.(type) {
+	case *ast.BasicLit:
+		return fmt.Sprintf("$%d", intValue(a))
+	case *ast.Ident:
+		if getObjectData(a.Obj) == -1 {
+			return globalSymbol(a.Obj) + "(%rip)"
+		}
+		return fmt.Sprintf("%d(%%rbp)", getObjectData(a.Obj))
+	}
+	must(fmt.Errorf("unexpected register operand %T", arg))
+	return ""
+}
@@ -0,0 +1,634 @@
+package main
+
+import (
+	"fmt"
+	"math/bits"
+	"strings"
+)
+
+// the riscv64 backend lowers every x86-64 instruction of the output to RV64GC instructions like the arm64 backend.
+// the registers of x86-64 live in a0, a2-a7, s0-s7 and sp, which riscv64 doesn't need to keep aligned.
+// riscv64 has no flags, so an instruction setting the flags keeps the operands as if they were compared by cmp in s8 and s9
+// when an instruction may read them by flagsRead, and jcc, setcc and cmovcc compare s8 and s9.
+// t0-t6, a1, s10 and s11 are scratch registers of the lowering
+var riscv64Regs = map[string]string{
+	"rax": "a0", "rbx": "s1", "rcx": "a2", "rdx": "a3", "rsi": "a4", "rdi": "a5", "r8": "a6", "r9": "a7",
+	"r10": "s2", "r11": "s3", "r12": "s4", "r13": "s5", "r14": "s6", "r15": "s7", "rbp": "s0", "rsp": "sp",
+}
+
+// x86 syscall numbers to riscv64, which are the same as arm64
+var riscv64Syscalls = arm64Syscalls
+
+type riscv64Lowering struct {
+	out    []*asmLine
+	labels int
+	text   bool       // in the .text section
+	rest   []*asmLine // the lines after the one being lowered
+}
+
+func lowerRiscv64(lines []*asmLine) []*asmLine {
+	r := &riscv64Lowering{}
+	for i, line := range lines {
+		r.rest = lines[i+1:]
+		r.line(line)
+	}
+	r.syscall()
+	return r.out
+}
+
+func (r *riscv64Lowering) emit(format string, args ...any) {
+	r.out = append(r.out, &asmLine{text: fmt.Sprintf(format, args...)})
+}
+
+func (r *riscv64Lowering) ins(format string, args ...any) {
+	r.emit("  "+format, args...)
+}
+
+func (r *riscv64Lowering) newLabel() string {
+	r.labels++
+	return fmt.Sprintf(".L.riscv64.%d", r.labels)
+}
+
+func (r *riscv64Lowering) line(line *asmLine) {
+	s := strings.TrimSpace(line.text)
+	switch {
+	case line.native:
+		r.out = append(r.out, line)
+	case s == "", strings.HasPrefix(s, "#"), isAsmLabel(line):
+		r.emit("%s", line.text)
+	case line.op == "":
+		r.emit("%s", line.text)
+		directive, _, _ := strings.Cut(s, " ")
+		switch directive {
+		case ".text":
+			r.text = true
+			r.ins(".p2align 2")
+		case ".data", ".section":
+			r.text = false
+		case ".quad", ".string", ".ascii", ".zero", ".byte":
+			if r.text { // keep the next instruction aligned
+				r.ins(".p2align 2")
+			}
+		}
+	default:
+		if line.comment != "" {
+			r.ins("# %s", line.comment)
+		}
+		var args []x86Operand
+		for _, arg := range line.args {
+			args = append(args, parseX86Operand(arg))
+		}
+		r.instr(line.op, args)
+	}
+}
+
+func riscv64Reg(reg string) string {
+	x, ok := riscv64Regs[reg]
+	if !ok {
+		must(fmt.Errorf("riscv64: unknown register %s", reg))
+	}
+	return x
+}
+
+// isImm12 reports whether v fits the immediates of addi and the offsets of loads and stores
+func isImm12(v int64) bool {
+	return v >= -2048 && v < 2048
+}
+
+// sext sign extends the low width bits of src to dst
+func (r *riscv64Lowering) sext(dst, src string, width int) {
+	switch width {
+	case 64:
+		if dst != src {
+			r.ins("mv %s, %s", dst, src)
+		}
+	case 32:
+		r.ins("sext.w %s, %s", dst, src)
+	default:
+		r.ins("slli %s, %s, %d", dst, src, 64-width)
+		r.ins("srai %s, %s, %d", dst, dst, 64-width)
+	}
+}
+
+// setFlags keeps the operands of cmp src, dst, which is the register x, in s8 and s9
+func (r *riscv64Lowering) setFlags(x string, src x86Operand, width int) {
+	r.sext("s8", x, width)
+	if src.isImm {
+		r.ins("li s9, %d", src.imm)
+		r.sext("s9", "s9", width)
+		return
+	}
+	r.sext("s9", r.value(src, width, "t1"), width)
+}
+
+// setResultFlags sets the flags of the result in x like test, which compares it with 0
+func (r *riscv64Lowering) setResultFlags(x string, width int) {
+	r.sext("s8", x, width)
+	r.ins("mv s9, zero")
+}
+
+// branchIf jumps to the near label on cond of the flags in s8 and s9
+func (r *riscv64Lowering) branchIf(cond, label string) {
+	switch cond {
+	case "mi":
+		r.ins("sub t1, s8, s9")
+		r.ins("bltz t1, %s", label)
+	case "pl":
+		r.ins("sub t1, s8, s9")
+		r.ins("bgez t1, %s", label)
+	default:
+		insn := map[string]string{
+			"eq": "beq", "ne": "bne", "lt": "blt", "ge": "bge", "gt": "bgt", "le": "ble",
+			"lo": "bltu", "hs": "bgeu", "hi": "bgtu", "ls": "bleu",
+		}[cond]
+		r.ins("%s s8, s9, %s", insn, label)
+	}
+}
+
+// setIf sets t1 to 1 on cond of the flags in s8 and s9, and 0 otherwise
+func (r *riscv64Lowering) setIf(cond string) {
+	switch cond {
+	case "eq", "ne":
+		r.ins("sub t1, s8, s9")
+	case "lt", "ge":
+		r.ins("slt t1, s8, s9")
+	case "gt", "le":
+		r.ins("slt t1, s9, s8")
+	case "lo", "hs":
+		r.ins("sltu t1, s8, s9")
+	case "hi", "ls":
+		r.ins("sltu t1, s9, s8")
+	case "mi", "pl":
+		r.ins("sub t1, s8, s9")
+		r.ins("sltz t1, t1")
+	}
+	switch cond {
+	case "eq":
+		r.ins("seqz t1, t1")
+	case "ne":
+		r.ins("snez t1, t1")
+	case "ge", "le", "hs", "ls", "pl":
+		r.ins("xori t1, t1, 1")
+	}
+}
+
+// branch jumps to label on cond. conditional branches reach 4KB, so they branch over j
+func (r *riscv64Lowering) branch(cond, label string) {
+	skip := r.newLabel()
+	r.branchIf(arm64Inverse[cond], skip)
+	r.ins("j %s", label)
+	r.emit("%s:", skip)
+}
+
+// addr computes the address of the memory operand m to the register x
+func (r *riscv64Lowering) addr(x string, m x86Operand) {
+	switch {
+	case m.sym != "":
+		sym := m.sym
+		if m.disp != 0 {
+			sym = fmt.Sprintf("%s%+d", sym, m.disp)
+		}
+		r.ins("lla %s, %s", x, sym)
+		if m.base != "" && m.base != "rip" {
+			r.ins("add %s, %s, %s", x, x, riscv64Reg(m.base))
+		}
+	case m.base == "":
+		r.ins("li %s, %d", x, m.disp)
+	case isImm12(m.disp):
+		r.ins("addi %s, %s, %d", x, riscv64Reg(m.base), m.disp)
+	default:
+		r.ins("li %s, %d", x, m.disp)
+		r.ins("add %s, %s, %s", x, x, riscv64Reg(m.base))
+	}
+	if m.index != "" {
+		r.ins("slli a1, %s, %d", riscv64Reg(m.index), bits.TrailingZeros(uint(m.scale)))
+		r.ins("add %s, %s, a1", x, x)
+	}
+}
+
+// mem returns the operand of loads and stores for the memory operand m.
+// the address is computed to t0 unless it is a register with an offset in the range of 12 bits
+func (r *riscv64Lowering) mem(m x86Operand) string {
+	if m.sym == "" && m.base != "" && m.index == "" && isImm12(m.disp) {
+		return fmt.Sprintf("%d(%s)", m.disp, riscv64Reg(m.base))
+	}
+	r.addr("t0", m)
+	return "0(t0)"
+}
+
+// load loads width bits at m to x zero extended
+func (r *riscv64Lowering) load(x string, m x86Operand, width int) {
+	insn := map[int]string{8: "lbu", 16: "lhu", 32: "lwu", 64: "ld"}[width]
+	r.ins("%s %s, %s", insn, x, r.mem(m))
+}
+
+func (r *riscv64Lowering) store(x string, m x86Operand, width int) {
+	insn := map[int]string{8: "sb", 16: "sh", 32: "sw", 64: "sd"}[width]
+	r.ins("%s %s, %s", insn, x, r.mem(m))
+}
+
+// value returns a register holding the operand in the low width bits, which is loaded to the scratch register x if needed
+func (r *riscv64Lowering) value(op x86Operand, width int, x string) string {
+	switch {
+	case op.reg != "":
+		return riscv64Reg(op.reg)
+	case op.isImm:
+		if op.imm == 0 {
+			return "zero"
+		}
+		r.ins("li %s, %d", x, op.imm)
+	default:
+		op.mem = true
+		r.load(x, op, width)
+	}
+	return x
+}
+
+// writeBack stores the register x to the destination operand dst of width bits.
+// bytes of registers keep the other bits like x86-64, while 32 bits are zero extended
+func (r *riscv64Lowering) writeBack(x string, dst x86Operand, width int) {
+	if dst.reg == "" {
+		r.store(x, dst, width)
+		return
+	}
+	d := riscv64Reg(dst.reg)
+	switch width {
+	case 8:
+		r.ins("andi t4, %s, 255", x)
+		r.ins("andi %s, %s, -256", d, d)
+		r.ins("or %s, %s, t4", d, d)
+	case 16:
+		r.ins("slli t4, %s, 48", x)
+		r.ins("srli t4, t4, 48")
+		r.ins("srli %s, %s, 16", d, d)
+		r.ins("slli %s, %s, 16", d, d)
+		r.ins("or %s, %s, t4", d, d)
+	case 32:
+		r.ins("slli %s, %s, 32", d, x)
+		r.ins("srli %s, %s, 32", d, d)
+	default:
+		if d != x {
+			r.ins("mv %s, %s", d, x)
+		}
+	}
+}
+
+// dst returns the register to compute the result for the destination operand dst to,
+// which is the register itself for 64 bits and t2 otherwise, and the register holding the value of dst
+func (r *riscv64Lowering) dst(dst x86Operand, width int) (string, string) {
+	x := r.value(dst, width, "t2")
+	if dst.reg != "" && width == 64 {
+		return x, x
+	}
+	return "t2", x
+}
+
+// call pushes the return address to the stack like callq
+func (r *riscv64Lowering) call(target x86Operand) {
+	ret := r.newLabel()
+	jump := ""
+	if target.reg != "" || target.mem {
+		jump = r.value(target, 64, "t1")
+	}
+	r.ins("lla t0, %s", ret)
+	r.ins("addi sp, sp, -8")
+	r.ins("sd t0, 0(sp)")
+	if jump != "" {
+		r.ins("jr %s", jump)
+	} else {
+		r.ins("j %s", target.sym)
+	}
+	r.emit("%s:", ret)
+}
+
+func (r *riscv64Lowering) instr(op string, args []x86Operand) {
+	switch op {
+	case "ret":
+		r.ins("ld t0, 0(sp)")
+		r.ins("addi sp, sp, 8")
+		r.ins("jr t0")
+		return
+	case "leave":
+		r.ins("mv sp, s0")
+		r.ins("ld s0, 0(sp)")
+		r.ins("addi sp, sp, 8")
+		return
+	case "syscall":
+		r.ins("call runtime.syscall")
+		return
+	case "cqto", "cqo":
+		r.ins("srai a3, a0, 63")
+		return
+	case "callq", "call":
+		r.call(args[0])
+		return
+	case "jmp":
+		if args[0].reg != "" {
+			r.ins("jr %s", riscv64Reg(args[0].reg))
+		} else {
+			r.ins("j %s", args[0].sym)
+		}
+		return
+	case "rep", "repe":
+		r.repeat(op + " " + args[0].sym)
+		return
+	case "movzbq", "movzbl", "movzwq", "movzwl", "movsbq", "movsbl", "movswq", "movslq":
+		r.extend(op, args[0], args[1])
+		return
+	}
+	if cond, ok := arm64Conds[strings.TrimPrefix(op, "j")]; ok && op[0] == 'j' {
+		r.branch(cond, args[0].sym)
+		return
+	}
+	if cond, ok := arm64Conds[strings.TrimPrefix(op, "set")]; ok && strings.HasPrefix(op, "set") {
+		r.setIf(cond)
+		r.writeBack("t1", args[0], 8)
+		return
+	}
+	if cond, ok := arm64Conds[strings.TrimSuffix(strings.TrimPrefix(op, "cmov"), "q")]; ok && strings.HasPrefix(op, "cmov") {
+		skip := r.newLabel()
+		r.branchIf(arm64Inverse[cond], skip)
+		r.writeBack(r.value(args[0], 64, "t1"), args[1], 64)
+		r.emit("%s:", skip)
+		return
+	}
+
+	width := x86Width(op)
+	base := op[:len(op)-1]
+	switch base {
+	case "mov":
+		r.move(args[0], args[1], width)
+	case "lea":
+		d := riscv64Reg(args[1].reg)
+		m := args[0]
+		if m.sym == "" && m.base != "" && m.index == "" && isImm12(m.disp) {
+			r.ins("addi %s, %s, %d", d, riscv64Reg(m.base), m.disp)
+		} else {
+			r.addr("t0", m)
+			r.ins("mv %s, t0", d)
+		}
+	case "push":
+		src := r.value(args[0], 64, "t1")
+		if src == "sp" {
+			r.ins("mv t1, sp")
+			src = "t1"
+		}
+		r.ins("addi sp, sp, -8")
+		r.ins("sd %s, 0(sp)", src)
+	case "pop":
+		x := "t1"
+		if args[0].reg != "" {
+			x = riscv64Reg(args[0].reg)
+		}
+		r.ins("ld %s, 0(sp)", x)
+		r.ins("addi sp, sp, 8")
+		if args[0].reg == "" {
+			r.store(x, args[0], 64)
+		}
+	case "add", "sub", "and", "or", "xor":
+		r.arith(base, args[0], args[1], width)
+	case "cmp":
+		r.setFlags(r.value(args[1], width, "t2"), args[0], width)
+	case "test":
+		x := r.value(args[1], width, "t2")
+		if args[0].isImm && isImm12(args[0].imm) {
+			r.ins("andi t2, %s, %d", x, args[0].imm)
+		} else {
+			r.ins("and t2, %s, %s", x, r.value(args[0], width, "t1"))
+		}
+		r.setResultFlags("t2", width)
+	case "inc", "dec", "neg", "not":
+		res, x := r.dst(args[0], width)
+		flags := base != "not" && flagsRead(r.rest)
+		switch base {
+		case "inc":
+			r.ins("addi %s, %s, 1", res, x)
+		case "dec":
+			r.ins("addi %s, %s, -1", res, x)
+		case "neg":
+			if flags { // like cmp of x and 0
+				r.ins("mv s8, zero")
+				r.sext("s9", x, width)
+				flags = false
+			}
+			r.ins("neg %s, %s", res, x)
+		case "not":
+			r.ins("not %s, %s", res, x)
+		}
+		if flags {
+			r.setResultFlags(res, width)
+		}
+		r.writeBack(res, args[0], width)
+	case "imul":
+		if len(args) == 3 { // imulq $n, src, dst
+			r.ins("mul %s, %s, %s", riscv64Reg(args[2].reg), r.value(args[1], 64, "t2"), r.value(args[0], 64, "t1"))
+			return
+		}
+		res, x := r.dst(args[1], 64)
+		r.ins("mul %s, %s, %s", res, x, r.value(args[0], 64, "t1"))
+		r.writeBack(res, args[1], 64)
+	case "idiv", "div":
+		d := r.value(args[0], 64, "t1")
+		suffix := map[string]string{"idiv": "", "div": "u"}[base]
+		r.ins("div%s t2, a0, %s", suffix, d)
+		r.ins("rem%s a3, a0, %s", suffix, d)
+		r.ins("mv a0, t2")
+	case "shl", "sal", "shr", "sar":
+		shift := map[string]string{"shl": "sll", "sal": "sll", "shr": "srl", "sar": "sra"}[base]
+		dst := args[len(args)-1]
+		res, x := r.dst(dst, width)
+		switch {
+		case len(args) == 1:
+			r.ins("%si %s, %s, 1", shift, res, x)
+		case args[0].isImm:
+			r.ins("%si %s, %s, %d", shift, res, x, args[0].imm)
+		default: // %cl
+			r.ins("%s %s, %s, a2", shift, res, x)
+		}
+		if flagsRead(r.rest) {
+			r.setResultFlags(res, width)
+		}
+		r.writeBack(res, dst, width)
+	case "bts", "btr":
+		// the bit string at the memory operand, indexed by a register
+		bit := riscv64Reg(args[0].reg)
+		r.addr("t0", args[1])
+		r.ins("srli t1, %s, 6", bit)
+		r.ins("slli t1, t1, 3")
+		r.ins("add t0, t0, t1")
+		r.ins("ld t2, 0(t0)")
+		r.ins("andi t1, %s, 63", bit)
+		r.ins("li t3, 1")
+		r.ins("sll t3, t3, t1")
+		if base == "bts" {
+			r.ins("or t2, t2, t3")
+		} else {
+			r.ins("not t3, t3")
+			r.ins("and t2, t2, t3")
+		}
+		r.ins("sd t2, 0(t0)")
+	case "bsr":
+		// the index of the highest set bit, searched down from 63 without the instructions of Zbb
+		src := r.value(args[0], 64, "t2")
+		loop, done := r.newLabel(), r.newLabel()
+		r.ins("li t1, 63")
+		r.emit("%s:", loop)
+		r.ins("srl t3, %s, t1", src)
+		r.ins("bnez t3, %s", done)
+		r.ins("addi t1, t1, -1")
+		r.ins("bgez t1, %s", loop)
+		r.emit("%s:", done)
+		r.ins("mv %s, t1", riscv64Reg(args[1].reg))
+	default:
+		must(fmt.Errorf("riscv64: unsupported instruction %s", op))
+	}
+}
+
+// move lowers mov from src to dst of width bits
+func (r *riscv64Lowering) move(src, dst x86Operand, width int) {
+	if dst.reg == "" || width < 32 {
+		r.writeBack(r.value(src, width, "t1"), dst, width)
+		return
+	}
+	d := riscv64Reg(dst.reg)
+	switch {
+	case src.reg != "":
+		r.writeBack(riscv64Reg(src.reg), dst, width)
+	case src.isImm:
+		v := src.imm
+		if width == 32 {
+			v = int64(uint32(v))
+		}
+		r.ins("li %s, %d", d, v)
+	default:
+		r.load(d, src, width)
+	}
+}
+
+// arith lowers add, sub, and, or and xor
+func (r *riscv64Lowering) arith(op string, src, dst x86Operand, width int) {
+	res, x := r.dst(dst, width)
+	flags := flagsRead(r.rest)
+	if flags && op == "sub" { // sub sets the flags like cmp
+		r.setFlags(x, src, width)
+	}
+	imm := src.imm
+	if op == "sub" {
+		imm = -imm
+	}
+	switch {
+	case src.isImm && isImm12(imm):
+		insn := map[string]string{"add": "addi", "sub": "addi", "and": "andi", "or": "ori", "xor": "xori"}[op]
+		r.ins("%s %s, %s, %d", insn, res, x, imm)
+	default:
+		r.ins("%s %s, %s, %s", op, res, x, r.value(src, width, "t1"))
+	}
+	if flags && op != "sub" {
+		r.setResultFlags(res, width)
+	}
+	if res != x || dst.reg == "" {
+		r.writeBack(res, dst, width)
+	}
+}
+
+// extend lowers movzx and movsx like movzbq
+func (r *riscv64Lowering) extend(op string, src, dst x86Operand) {
+	from := map[byte]int{'b': 8, 'w': 16, 'l': 32}[op[4]]
+	signed := op[3] == 's'
+	d := riscv64Reg(dst.reg)
+	if src.mem || src.sym != "" {
+		insn := map[int]string{8: "lb", 16: "lh", 32: "lw"}[from]
+		if !signed {
+			insn += "u"
+		}
+		r.ins("%s %s, %s", insn, d, r.mem(src))
+		return
+	}
+	s := riscv64Reg(src.reg)
+	switch {
+	case signed:
+		r.sext(d, s, from)
+	case from == 8:
+		r.ins("andi %s, %s, 255", d, s)
+	default:
+		r.ins("slli %s, %s, %d", d, s, 64-from)
+		r.ins("srli %s, %s, %d", d, d, 64-from)
+	}
+}
+
+// repeat lowers rep movsb, rep stosb and repe cmpsb to loops counting rcx down
+func (r *riscv64Lowering) repeat(op string) {
+	loop, done := r.newLabel(), r.newLabel()
+	r.ins("beqz a2, %s", done)
+	r.emit("%s:", loop)
+	switch op {
+	case "rep movsb":
+		r.ins("lbu t1, 0(a4)")
+		r.ins("sb t1, 0(a5)")
+		r.ins("addi a4, a4, 1")
+		r.ins("addi a5, a5, 1")
+	case "rep stosb":
+		r.ins("sb a0, 0(a5)")
+		r.ins("addi a5, a5, 1")
+	case "repe cmpsb":
+		r.ins("lbu s8, 0(a4)")
+		r.ins("lbu s9, 0(a5)")
+		r.ins("addi a4, a4, 1")
+		r.ins("addi a5, a5, 1")
+		r.ins("addi a2, a2, -1")
+		r.ins("bne s8, s9, %s", done)
+		r.ins("bnez a2, %s", loop)
+		r.emit("%s:", done)
+		return
+	default:
+		must(fmt.Errorf("riscv64: unsupported instruction %s", op))
+	}
+	r.ins("addi a2, a2, -1")
+	r.ins("bnez a2, %s", loop)
+	r.emit("%s:", done)
+}
+
+// syscall emits runtime.syscall, which makes the x86-64 syscall of rax with the arguments in rdi, rsi, rdx, r10, r8 and r9.
+// unknown numbers return -ENOSYS
+func (r *riscv64Lowering) syscall() {
+	r.emit(".text")
+	r.emit("runtime.syscall:")
+	saved := []string{"t3", "t4", "t5", "t6", "s10", "s11"}
+	for i, x := range saved {
+		r.ins("mv %s, a%d", x, i+2)
+	}
+	r.ins("mv t0, a0")
+	r.ins("mv a0, t6")
+	r.ins("mv a1, t5")
+	r.ins("mv a2, t4")
+	r.ins("mv a3, s2")
+	r.ins("mv a4, s10")
+	r.ins("mv a5, s11")
+	svc := r.newLabel()
+	for _, nr := range riscv64Syscalls {
+		next := r.newLabel()
+		r.ins("li t1, %d", nr[0])
+		r.ins("bne t0, t1, %s", next)
+		r.ins("li a7, %d", nr[1])
+		r.ins("j %s", svc)
+		r.emit("%s:", next)
+	}
+	open, done := r.newLabel(), r.newLabel()
+	r.ins("li t1, 2")
+	r.ins("beq t0, t1, %s", open)
+	r.ins("li a0, -38")
+	r.ins("j %s", done)
+	r.emit("%s:", open)
+	r.ins("mv a3, a2")
+	r.ins("mv a2, a1")
+	r.ins("mv a1, a0")
+	r.ins("li a0, -100") // AT_FDCWD
+	r.ins("li a7, 56")
+	r.emit("%s:", svc)
+	r.ins("ecall")
+	r.emit("%s:", done)
+	for i, x := range saved {
+		r.ins("mv a%d, %s", i+2, x)
+	}
+	r.ins("ret")
+}
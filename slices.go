@@ -0,0 +1,317 @@
+package main
+
+import (
+	"fmt"
+	"go/ast"
+)
+
+// a slice is 3 words ptr, len and cap. a string is ptr and len, so both are indexed and sliced in the same way
+
+// emitIndexAddr pushes the address of the element x[i] of a slice or a string. the index is checked against the length
+func emitIndexAddr(expr *ast.IndexExpr) {
+	typ := underlying(getType(expr.X))
+	size := 1
+	if typ != globalString {
+		size = elemSize(sliceElem(typ))
+	}
+	emitExpr(expr.X)
+	emitExpr(expr.Index)
+	emit("  popq %%rcx # index\n")
+	emit("  popq %%rax # ptr\n")
+	emit("  popq %%rdx # len\n")
+	if typ != globalString {
+		emit("  addq $8, %%rsp # cap\n")
+	}
+	emit("  cmpq %%rdx, %%rcx\n")
+	emit("  jae runtime.panicindex\n") // negative indices are large as unsigned
+	if size > 1 {
+		emit("  imulq $%d, %%rcx\n", size)
+	}
+	emit("  addq %%rcx, %%rax\n")
+	emit("  pushq %%rax\n")
+}
+
+// memSize returns the size of the memory expr is stored in. it differs from the size of the value for bytes in slices and strings
+func memSize(expr ast.Expr) int {
+	switch e := expr.(type) {
+	case *ast.ParenExpr:
+		return memSize(e.X)
+	case *ast.IndexExpr:
+		if typ := underlying(getType(e.X)); typ == globalString || isSlice(typ) {
+			return elemSize(getType(e))
+		}
+	}
+	return getExprSize(&expr)
+}
+
+// emitSliceExpr pushes x[low:high] of a slice or a string, which shares the memory of x
+func emitSliceExpr(expr *ast.SliceExpr) {
+	if expr.Slice3 {
+		must(fmt.Errorf("3-index slices are not supported"))
+	}
+	typ := underlying(getType(expr.X))
+	size := 1
+	if typ != globalString {
+		size = elemSize(sliceElem(typ))
+	}
+	emitExpr(expr.X)
+	if expr.Low != nil {
+		emitExpr(expr.Low)
+	} else {
+		emit("  pushq $0\n")
+	}
+	if expr.High != nil {
+		emitExpr(expr.High)
+	} else {
+		emit("  pushq 16(%%rsp) # len\n")
+	}
+	emit("  popq %%rdx # high\n")
+	emit("  popq %%rcx # low\n")
+	emit("  popq %%rax # ptr\n")
+	emit("  popq %%rsi # len\n")
+	if typ != globalString {
+		emit("  popq %%rsi # cap\n") // high can be up to the capacity
+	}
+	emit("  cmpq %%rdx, %%rcx\n")
+	emit("  ja runtime.panicslice\n")
+	emit("  cmpq %%rsi, %%rdx\n")
+	emit("  ja runtime.panicslice\n")
+	if typ != globalString {
+		emit("  subq %%rcx, %%rsi\n")
+		emit("  pushq %%rsi # cap\n")
+	}
+	emit("  subq %%rcx, %%rdx\n")
+	emit("  pushq %%rdx # len\n")
+	if size > 1 {
+		emit("  imulq $%d, %%rcx\n", size)
+	}
+	emit("  addq %%rcx, %%rax\n")
+	emit("  pushq %%rax # ptr\n")
+}
+
+// emitSliceLit pushes a new slice of the elements. it is used by []T{...} and the rest arguments of variadic functions
+func emitSliceLit(elems []ast.Expr, elem *ast.Object) {
+	size := elemSize(elem)
+	emit("  pushq $%d\n", len(elems)*size)
+	emit("  callq runtime.alloc # []%s{}\n", elem.Name)
+	emit("  addq $8, %%rsp\n")
+	emit("  pushq $%d # cap\n", len(elems))
+	emit("  pushq $%d # len\n", len(elems))
+	emit("  pushq %%rax # ptr\n")
+	for i, e := range elems {
+		emitExprAs(e, elem)
+		emit("  movq %d(%%rsp), %%rax\n", sizeOf(elem))
+		emit("  addq $%d, %%rax\n", i*size)
+		emit("  pushq %%rax\n")
+		emitStore(size, fmt.Sprintf("[%d]", i))
+	}
+}
+
+// emitLen pushes len(x) or cap(x) of a slice or a string
+func emitLen(x ast.Expr, capacity bool) {
+	off := 8
+	if capacity {
+		off = 16
+	}
+	emitExpr(x)
+	emit("  movq %d(%%rsp), %%rax\n", off)
+	emit("  addq $%d, %%rsp\n", getExprSize(&x))
+	emit("  pushq %%rax\n")
+}
+
+// emitMake pushes make([]T, len) or make([]T, len, cap)
+func emitMake(expr *ast.CallExpr) {
+	typ := getType(expr.Args[0])
+	if !isSlice(underlying(typ)) {
+		must(fmt.Errorf("cannot make %s", typ.Name))
+	}
+	if len(expr.Args) == 3 {
+		emitExpr(expr.Args[2])
+	} else {
+		emitExpr(expr.Args[1])
+	}
+	emitExpr(expr.Args[1])
+	emit("  pushq $%d\n", elemSize(sliceElem(typ)))
+	emit("  callq runtime.makeslice\n")
+	emit("  addq $24, %%rsp\n")
+	emitSliceResult()
+}
+
+// emitAppend pushes append(s, x, y) or append(s, t...). t is a slice or a string
+func emitAppend(expr *ast.CallExpr) {
+	typ := getType(expr.Args[0])
+	elem := sliceElem(typ)
+	size := elemSize(elem)
+	if expr.Ellipsis.IsValid() {
+		emitExpr(expr.Args[0])
+		emitExpr(expr.Args[1])
+		if underlying(getType(expr.Args[1])) == globalString {
+			emit("  popq %%rax\n")
+			emit("  pushq (%%rsp) # the length is the capacity\n")
+			emit("  pushq %%rax\n")
+		}
+		emit("  pushq $%d\n", size)
+		emit("  callq runtime.appendslice\n")
+		emit("  addq $56, %%rsp\n")
+		emitSliceResult()
+		return
+	}
+
+	n := len(expr.Args) - 1
+	emitExpr(expr.Args[0])
+	emit("  pushq $%d\n", size)
+	emit("  pushq $%d\n", n)
+	emit("  callq runtime.growslice\n")
+	emit("  addq $40, %%rsp\n")
+	emitSliceResult()
+	// the new elements are stored after the old length
+	for i, arg := range expr.Args[1:] {
+		emitExprAs(arg, elem)
+		emit("  movq %d(%%rsp), %%rax # ptr\n", sizeOf(elem))
+		emit("  movq %d(%%rsp), %%rcx # len\n", sizeOf(elem)+8)
+		emit("  subq $%d, %%rcx\n", n-i)
+		if size > 1 {
+			emit("  imulq $%d, %%rcx\n", size)
+		}
+		emit("  addq %%rcx, %%rax\n")
+		emit("  pushq %%rax\n")
+		emitStore(size, fmt.Sprintf("[len+%d]", i))
+	}
+}
+
+// emitSliceResult pushes the slice returned by the runtime in rax (ptr), rsi (len) and rdx (cap)
+func emitSliceResult() {
+	emit("  pushq %%rdx # cap\n")
+	emit("  pushq %%rsi # len\n")
+	emit("  pushq %%rax # ptr\n")
+}
+
+// slices emits the runtime routines of slices. they return a slice in rax (ptr), rsi (len) and rdx (cap)
+func slices() {
+	emit("# slices\n")
+	emit(".text\n")
+
+	// growslice extends the slice at 24(rsp) by n(8(rsp)) elements of size 16(rsp).
+	// the elements are moved to a new memory twice as large when the capacity is not enough
+	emit("runtime.growslice:\n")
+	emit("  movq 24(%%rsp), %%rax\n")
+	emit("  movq 32(%%rsp), %%rsi\n")
+	emit("  addq 8(%%rsp), %%rsi\n")
+	emit("  movq 40(%%rsp), %%rdx\n")
+	emit("  cmpq %%rdx, %%rsi\n")
+	emit("  jg runtime.growslice.grow\n")
+	emit("  ret\n")
+	emit("runtime.growslice.grow:\n")
+	emit("  addq %%rdx, %%rdx\n")
+	emit("  cmpq %%rsi, %%rdx\n")
+	emit("  cmovlq %%rsi, %%rdx\n") // at least the new length
+	emit("  pushq %%rsi\n")
+	emit("  pushq %%rdx\n")
+	emit("  movq %%rdx, %%rax\n")
+	emit("  imulq 32(%%rsp), %%rax\n")
+	emit("  pushq %%rax\n")
+	emit("  callq runtime.alloc\n")
+	emit("  addq $8, %%rsp\n")
+	emit("  movq %%rax, %%rdi\n")
+	emit("  movq 40(%%rsp), %%rsi\n") // old ptr
+	emit("  movq 48(%%rsp), %%rcx\n") // old len
+	emit("  imulq 32(%%rsp), %%rcx\n")
+	emit("  rep movsb\n")
+	emit("  popq %%rdx\n")
+	emit("  popq %%rsi\n")
+	emit("  ret\n\n")
+
+	// appendslice appends the elements of the slice at 16(rsp) to the slice at 40(rsp). the element size is 8(rsp)
+	emit("runtime.appendslice:\n")
+	emit("  pushq 56(%%rsp)\n") // cap of s
+	emit("  pushq 56(%%rsp)\n") // len of s
+	emit("  pushq 56(%%rsp)\n") // ptr of s
+	emit("  pushq 32(%%rsp)\n") // element size
+	emit("  pushq 56(%%rsp)\n") // len of t
+	emit("  callq runtime.growslice\n")
+	emit("  addq $40, %%rsp\n")
+	emit("  pushq %%rax\n")
+	emit("  pushq %%rsi\n")
+	emit("  pushq %%rdx\n")
+	emit("  movq 72(%%rsp), %%rdi\n") // old len of s
+	emit("  imulq 32(%%rsp), %%rdi\n")
+	emit("  addq %%rax, %%rdi\n")
+	emit("  movq 40(%%rsp), %%rsi\n") // ptr of t
+	emit("  movq 48(%%rsp), %%rcx\n") // len of t
+	emit("  imulq 32(%%rsp), %%rcx\n")
+	emit("  rep movsb\n")
+	emit("  popq %%rdx\n")
+	emit("  popq %%rsi\n")
+	emit("  popq %%rax\n")
+	emit("  ret\n\n")
+
+	// makeslice allocates len(16(rsp)) and cap(24(rsp)) elements of size 8(rsp). the memory from runtime.alloc is zero
+	emit("runtime.makeslice:\n")
+	emit("  movq 24(%%rsp), %%rax\n")
+	emit("  imulq 8(%%rsp), %%rax\n")
+	emit("  pushq %%rax\n")
+	emit("  callq runtime.alloc\n")
+	emit("  addq $8, %%rsp\n")
+	emit("  movq 16(%%rsp), %%rsi\n")
+	emit("  movq 24(%%rsp), %%rdx\n")
+	emit("  ret\n\n")
+
+	// slicebytetostring copies the bytes of the slice at 8(rsp) to a new string
+	emit("runtime.slicebytetostring:\n")
+	emit("  pushq 16(%%rsp)\n")
+	emit("  callq runtime.alloc\n")
+	emit("  addq $8, %%rsp\n")
+	emit("  movq %%rax, %%rdi\n")
+	emit("  movq 8(%%rsp), %%rsi\n")
+	emit("  movq 16(%%rsp), %%rcx\n")
+	emit("  rep movsb\n")
+	emit("  movq 16(%%rsp), %%rsi\n")
+	emit("  ret\n\n")
+
+	// stringtoslicebyte copies the bytes of the string at 8(rsp) to a new slice
+	emit("runtime.stringtoslicebyte:\n")
+	emit("  pushq 16(%%rsp)\n")
+	emit("  callq runtime.alloc\n")
+	emit("  addq $8, %%rsp\n")
+	emit("  movq %%rax, %%rdi\n")
+	emit("  movq 8(%%rsp), %%rsi\n")
+	emit("  movq 16(%%rsp), %%rcx\n")
+	emit("  rep movsb\n")
+	emit("  movq 16(%%rsp), %%rsi\n")
+	emit("  movq %%rsi, %%rdx\n")
+	emit("  ret\n\n")
+
+	emit(".data\n")
+	emit("runtime.indexmsg:\n")
+	emit("  .ascii \"panic: runtime error: index out of range\\n\"\n")
+	emit("runtime.slicemsg:\n")
+	emit("  .ascii \"panic: runtime error: slice bounds out of range\\n\"\n")
+	emit("runtime.dividemsg:\n")
+	emit("  .ascii \"panic: runtime error: integer divide by zero\\n\"\n")
+	emit("runtime.nilmsg:\n")
+	emit("  .ascii \"panic: runtime error: invalid memory address or nil pointer dereference\\n\"\n")
+	emit(".text\n")
+	emit("runtime.panicnil:\n")
+	emit("  leaq runtime.nilmsg(%%rip), %%rsi\n")
+	emit("  movq $72, %%rdx\n")
+	emit("  jmp runtime.panicmsg\n")
+	emit("runtime.panicdivide:\n")
+	emit("  leaq runtime.dividemsg(%%rip), %%rsi\n")
+	emit("  movq $45, %%rdx\n")
+	emit("  jmp runtime.panicmsg\n")
+	emit("runtime.panicindex:\n")
+	emit("  leaq runtime.indexmsg(%%rip), %%rsi\n")
+	emit("  movq $41, %%rdx\n")
+	emit("  jmp runtime.panicmsg\n")
+	emit("runtime.panicslice:\n")
+	emit("  leaq runtime.slicemsg(%%rip), %%rsi\n")
+	emit("  movq $48, %%rdx\n")
+	// panicmsg writes the message to stderr and exits with 2 like an unrecovered panic
+	emit("runtime.panicmsg:\n")
+	emit("  movq $2, %%rdi\n")
+	emit("  movq $1, %%rax\n")
+	emit("  syscall\n")
+	emit("  movq $2, %%rdi\n")
+	emit("  movq $60, %%rax\n")
+	emit("  syscall\n\n")
+}
@@ -0,0 +1,300 @@
+package main
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// the code generator emits AT&T syntax. -asm-syntax=intel renders the same lines in the Intel syntax of GNU as
+// like the programs in inspect/, and -asm-syntax=nasm in the syntax of nasm and yasm
+var asmSyntax = "att"
+
+// setAsmSyntax selects the syntax of the assembly output
+func setAsmSyntax(name string) error {
+	switch name {
+	case "att", "intel", "nasm":
+		asmSyntax = name
+		return nil
+	}
+	return fmt.Errorf("unsupported syntax %s, expected one of att, intel, nasm", name)
+}
+
+// renderSyntax rewrites the text of asmLines in asmSyntax
+func renderSyntax() {
+	if asmSyntax == "att" {
+		return
+	}
+	r := &intelRenderer{nasm: asmSyntax == "nasm", defined: map[string]bool{}, extern: map[string]bool{}}
+	var lines []*asmLine
+	for _, line := range asmLines {
+		if !line.removed {
+			lines = append(lines, line)
+			if isAsmLabel(line) {
+				label, _ := cutAsmComment(line.text)
+				r.defined[strings.TrimSuffix(label, ":")] = true
+			}
+		}
+	}
+	rendered := []*asmLine{{text: ".intel_syntax noprefix"}}
+	if r.nasm {
+		rendered = []*asmLine{{text: "bits 64"}}
+	}
+	for _, line := range lines {
+		rendered = append(rendered, &asmLine{text: r.line(line)})
+	}
+	if r.nasm { // nasm needs the symbols of the C library and the C objects to be declared
+		var externs []*asmLine
+		for _, sym := range r.undefined {
+			externs = append(externs, &asmLine{text: "extern " + sym})
+		}
+		rendered = append(rendered[:1], append(externs, rendered[1:]...)...)
+	}
+	asmLines = rendered
+}
+
+type intelRenderer struct {
+	nasm      bool
+	defined   map[string]bool // the labels in the output
+	undefined []string        // the symbols referred to but not defined, in the syntax of nasm
+	extern    map[string]bool // undefined as a set
+}
+
+// x86Suffixed are the mnemonics taking the suffix of the operand size in AT&T syntax
+var x86Suffixed = map[string]bool{
+	"add": true, "sub": true, "and": true, "or": true, "xor": true, "cmp": true, "test": true, "mov": true, "lea": true,
+	"push": true, "pop": true, "inc": true, "dec": true, "neg": true, "not": true, "imul": true, "idiv": true, "div": true,
+	"shl": true, "sal": true, "shr": true, "sar": true, "bts": true, "btr": true, "bsr": true,
+}
+
+func (r *intelRenderer) line(line *asmLine) string {
+	s := strings.TrimSpace(line.text)
+	indent := line.text[:len(line.text)-len(strings.TrimLeft(line.text, " "))]
+	switch {
+	case s == "":
+		return line.text
+	case strings.HasPrefix(s, "#"):
+		return indent + r.comment(strings.TrimSpace(s[1:]))
+	case line.op != "":
+		text := indent + r.instr(line)
+		if line.comment != "" {
+			text += " " + r.comment(line.comment)
+		}
+		return text
+	}
+	text, comment := cutAsmComment(s)
+	if isAsmLabel(line) {
+		text = r.symbol(strings.TrimSuffix(text, ":")) + ":"
+	} else {
+		text = r.directive(text)
+	}
+	if comment != "" {
+		text += " " + r.comment(comment)
+	}
+	return indent + text
+}
+
+func (r *intelRenderer) comment(text string) string {
+	if r.nasm {
+		return "; " + text
+	}
+	return "# " + text
+}
+
+// directive renders the directives of sections, symbols and data. GNU as takes them in both syntaxes
+func (r *intelRenderer) directive(text string) string {
+	if !r.nasm {
+		return text
+	}
+	directive, args, _ := strings.Cut(text, " ")
+	switch directive {
+	case ".text", ".data":
+		return "section " + directive
+	case ".section":
+		return "section " + strings.TrimSpace(args)
+	case ".global":
+		return "global " + r.symbol(strings.TrimSpace(args))
+	case ".quad":
+		var values []string
+		for _, arg := range splitAsmOperands(args) {
+			if _, err := strconv.ParseInt(arg, 0, 64); err == nil {
+				values = append(values, arg)
+			} else {
+				values = append(values, r.symbolExpr(arg))
+			}
+		}
+		return "dq " + strings.Join(values, ", ")
+	case ".zero":
+		return "times " + strings.TrimSpace(args) + " db 0"
+	case ".ascii", ".string":
+		b := gasUnquote(strings.TrimSpace(args))
+		if directive == ".string" {
+			b = append(b, 0)
+		}
+		if len(b) == 0 {
+			return ""
+		}
+		return "db " + nasmBytes(b)
+	}
+	must(fmt.Errorf("nasm: unsupported directive %s", directive))
+	return ""
+}
+
+// nasmBytes renders b as the operands of db, with the printable runs in backquoted strings
+func nasmBytes(b []byte) string {
+	var operands []string
+	var run strings.Builder
+	for _, c := range b {
+		if c >= ' ' && c < 0x7f {
+			if c == '`' || c == '\\' {
+				run.WriteByte('\\')
+			}
+			run.WriteByte(c)
+			continue
+		}
+		if run.Len() > 0 {
+			operands = append(operands, "`"+run.String()+"`")
+			run.Reset()
+		}
+		operands = append(operands, strconv.Itoa(int(c)))
+	}
+	if run.Len() > 0 {
+		operands = append(operands, "`"+run.String()+"`")
+	}
+	return strings.Join(operands, ", ")
+}
+
+// instr renders an instruction. the operands are reversed, and memory operands take the size of the instruction
+func (r *intelRenderer) instr(line *asmLine) string {
+	op, args := line.op, line.args
+	name, width := op, 0
+	switch {
+	case op == "rep", op == "repe": // rep movsb
+		return op + " " + strings.Join(args, " ")
+	case op == "cqto":
+		name = "cqo"
+	case op == "callq":
+		name = "call"
+	case len(op) == 6 && (strings.HasPrefix(op, "movz") || strings.HasPrefix(op, "movs")): // movzbq, movslq
+		width = map[byte]int{'b': 8, 'w': 16, 'l': 32}[op[4]]
+		name = op[:4] + "x"
+		if op[3] == 's' && width == 32 {
+			name = "movsxd"
+		}
+	case strings.HasPrefix(op, "cmov"):
+		name, width = op[:len(op)-1], x86Width(op)
+	case x86Suffixed[op[:len(op)-1]]:
+		name, width = op[:len(op)-1], x86Width(op)
+		if name == "lea" {
+			width = 0
+		}
+	}
+	branch := op[0] == 'j' || op == "callq" || op == "call"
+	var operands []string
+	for i := len(args) - 1; i >= 0; i-- {
+		operands = append(operands, r.operand(args[i], width, branch))
+	}
+	if len(operands) == 0 {
+		return name
+	}
+	return name + " " + strings.Join(operands, ", ")
+}
+
+var intelSizes = map[int]string{8: "BYTE", 16: "WORD", 32: "DWORD", 64: "QWORD"}
+
+// operand renders an operand of AT&T syntax. width is the bits of memory operands, or 0 for the address of lea
+func (r *intelRenderer) operand(s string, width int, branch bool) string {
+	s = strings.TrimPrefix(s, "*") // callq *%rax
+	switch {
+	case strings.HasPrefix(s, "$"):
+		if _, err := strconv.ParseInt(s[1:], 0, 64); err == nil || r.nasm {
+			return r.symbolExpr(s[1:])
+		}
+		return "OFFSET " + s[1:]
+	case strings.HasPrefix(s, "%"):
+		return s[1:]
+	}
+	op := parseX86Operand(s)
+	if !op.mem && branch {
+		return r.symbol(op.sym)
+	}
+	var terms []string
+	if op.base == "rip" && r.nasm {
+		terms = append(terms, "rel "+r.symbolExpr(op.sym))
+	} else {
+		if op.base != "" {
+			terms = append(terms, op.base)
+		}
+		if op.index != "" {
+			terms = append(terms, fmt.Sprintf("%s*%d", op.index, op.scale))
+		}
+		if op.sym != "" {
+			terms = append(terms, r.symbolExpr(op.sym))
+		}
+	}
+	addr := strings.Join(terms, " + ")
+	switch {
+	case op.disp > 0 || op.disp == 0 && addr == "":
+		addr += fmt.Sprintf(" + %d", op.disp)
+	case op.disp < 0:
+		addr += fmt.Sprintf(" - %d", -op.disp)
+	}
+	addr = "[" + strings.TrimPrefix(addr, " + ") + "]"
+	if width == 0 {
+		return addr
+	}
+	if r.nasm {
+		return strings.ToLower(intelSizes[width]) + " " + addr
+	}
+	return intelSizes[width] + " PTR " + addr
+}
+
+// symbolExpr renders an expression of a symbol and an offset like "main.x"+8
+func (r *intelRenderer) symbolExpr(expr string) string {
+	name, offset := expr, ""
+	if strings.HasPrefix(expr, "\"") {
+		quoted, err := strconv.QuotedPrefix(expr)
+		must(err)
+		name, offset = quoted, expr[len(quoted):]
+	} else if i := strings.LastIndexAny(expr, "+-"); i > 0 {
+		name, offset = expr[:i], expr[i:]
+	}
+	if _, err := strconv.ParseInt(name, 0, 64); err == nil {
+		return expr
+	}
+	return r.symbol(name) + offset
+}
+
+// symbol renders the name of a label. nasm has no quoted names, so the bytes other than the characters of
+// its identifiers are escaped like $28, and names starting with . get ..@ not to be local to the previous label
+func (r *intelRenderer) symbol(name string) string {
+	if !r.nasm {
+		return name
+	}
+	unquoted := name
+	if strings.HasPrefix(name, "\"") {
+		s, err := strconv.Unquote(name)
+		must(err)
+		unquoted = s
+	}
+	var b strings.Builder
+	if strings.HasPrefix(unquoted, ".") {
+		b.WriteString("..@")
+		unquoted = unquoted[1:]
+	}
+	for i := 0; i < len(unquoted); i++ {
+		c := unquoted[i]
+		switch {
+		case c >= 'a' && c <= 'z', c >= 'A' && c <= 'Z', c >= '0' && c <= '9', c == '_', c == '.':
+			b.WriteByte(c)
+		default:
+			fmt.Fprintf(&b, "$%02x", c)
+		}
+	}
+	sym := b.String()
+	if !r.defined[name] && !r.extern[sym] {
+		r.extern[sym] = true
+		r.undefined = append(r.undefined, sym)
+	}
+	return sym
+}
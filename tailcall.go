@@ -0,0 +1,93 @@
+package main
+
+import (
+	"fmt"
+	"go/ast"
+	"go/token"
+
+	"github.com/lkeix/gompiler/ir"
+)
+
+// tailCalls compiles the calls of functions to themselves in return statements to jumps reusing the frame
+var tailCalls bool
+
+// tailLabel is the label after the prologue of curFunc which its tail calls jump to, or "" if it has none
+var tailLabel string
+
+// optimizeTailCalls replaces the self tail calls of the function lowered to f
+func optimizeTailCalls(fnc *Func, f *ir.Func) {
+	calls := f.TailCalls()
+	for _, call := range calls {
+		reportOpt(fnc.pkg, call.Pos, "tail call to %s optimized", fnc.name)
+	}
+	if len(calls) > 0 {
+		must(ir.Verify(f))
+	}
+}
+
+// tailCall returns the call of return f(args) in f, or nil if the call is not compiled to a jump.
+// methods, generic and variadic functions, and functions which may take the address of their variables keep their calls
+func tailCall(fnc *Func, stmt *ast.ReturnStmt) *ast.CallExpr {
+	if !tailCalls || len(stmt.Results) != 1 || fnc.decl.Recv != nil || fnc.typeArgs != nil || isVariadic(fnc.decl) {
+		return nil
+	}
+	call, ok := stmt.Results[0].(*ast.CallExpr)
+	if !ok || methodSelector(call) != nil {
+		return nil
+	}
+	ident := funcIdent(call.Fun)
+	if ident == nil || ident.Obj == nil || ident.Obj.Decl != fnc.decl || takesAddress(fnc.decl) {
+		return nil
+	}
+	return call
+}
+
+// hasTailCall reports whether fnc has a return statement compiled to a jump
+func hasTailCall(fnc *Func) bool {
+	found := false
+	ast.Inspect(fnc.decl.Body, func(n ast.Node) bool {
+		if stmt, ok := n.(*ast.ReturnStmt); ok && tailCall(fnc, stmt) != nil {
+			found = true
+		}
+		return !found
+	})
+	return found
+}
+
+// takesAddress reports whether the body of decl may refer to its variables by pointers,
+// which would see the variables of the next call after a tail call. &x, slicing arrays and method calls on variables do
+func takesAddress(decl *ast.FuncDecl) bool {
+	found := false
+	ast.Inspect(decl.Body, func(n ast.Node) bool {
+		switch e := n.(type) {
+		case *ast.UnaryExpr:
+			found = found || e.Op == token.AND
+		case *ast.SliceExpr, *ast.FuncLit:
+			found = true
+		case *ast.CallExpr:
+			found = found || methodSelector(e) != nil
+		}
+		return !found
+	})
+	return found
+}
+
+// emitTailCall stores the arguments of call to the parameters of curFunc and jumps to tailLabel
+func emitTailCall(call *ast.CallExpr) {
+	reportOpt(curPkg, call.Pos(), "tail call to %s optimized", curFunc.name)
+	if usesRegABI(curFunc) { // the registers are spilled to the parameters after tailLabel
+		emitRegArgs(curFunc, call)
+	} else {
+		// the arguments are pushed like a call and popped to the parameters in order
+		size := emitArgs(curFunc, call)
+		for off := 0; off < size; off += 8 {
+			emit("  popq %d(%%rbp)\n", 16+off)
+		}
+	}
+	emit("  jmp %s # tail call\n", tailLabel)
+}
+
+func newTailLabel() string {
+	labelSeq++
+	return fmt.Sprintf(".L.tail.%d", labelSeq)
+}
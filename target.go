@@ -0,0 +1,238 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+)
+
+// target is the operating system and the architecture the output is for. the functions lowered to the IR
+// are emitted by the irArch of the target in its own instructions. the runtime is written in x86-64,
+// and the other architectures lower its lines to their instructions after the peephole pass, or assemble them for wasm.
+// the lowerings translate an instruction at a time and keep the frames and the flags of x86-64,
+// so they are only as right as the programs of testdata run under qemu by test.sh
+type target struct {
+	os, arch string
+	lower    func(lines []*asmLine) []*asmLine // nil for amd64
+	assemble func(lines []*asmLine) []byte     // writes a binary instead of the assembly
+	heapMax  int64                             // the bytes reserved for the heap
+	ir       irArch                            // emits the functions lowered to the IR
+}
+
+var targets = []*target{
+	{os: "linux", arch: "amd64", heapMax: 1 << 32, ir: amd64IR{}},
+	{os: "linux", arch: "arm64", lower: lowerArm64, heapMax: 1 << 32, ir: arm64IR{}},
+	{os: "linux", arch: "riscv64", lower: lowerRiscv64, heapMax: 1 << 32, ir: riscv64IR{}},
+	{os: "wasip1", arch: "wasm", assemble: assembleWasm, heapMax: 1 << 28, ir: wasmIR{}}, // the memory of wasm32 is 4GB at most
+}
+
+// curTarget is selected by -target
+var curTarget = targets[0]
+
+func (t *target) String() string {
+	return t.os + "/" + t.arch
+}
+
+// setTarget selects the target named like linux/arm64
+func setTarget(name string) error {
+	var names []string
+	for _, t := range targets {
+		if t.String() == name {
+			curTarget = t
+			return nil
+		}
+		names = append(names, t.String())
+	}
+	return fmt.Errorf("unsupported target %s, expected one of %s", name, strings.Join(names, ", "))
+}
+
+// lowerTarget rewrites asmLines for curTarget
+func lowerTarget() {
+	if libc && curTarget.arch != "amd64" {
+		must(fmt.Errorf("-libc is not supported on %s", curTarget))
+	}
+	if asmSyntax != "att" && curTarget.arch != "amd64" {
+		must(fmt.Errorf("-asm-syntax is only for linux/amd64"))
+	}
+	if objOutput && (curTarget.arch != "amd64" || asmSyntax != "att") {
+		must(fmt.Errorf("-obj is only for linux/amd64 and doesn't take -asm-syntax"))
+	}
+	if debugInfo && (curTarget.arch != "amd64" || asmSyntax == "nasm" || objOutput || linkOutput) {
+		must(fmt.Errorf("-g is only for the assembly of linux/amd64 in the syntax of GNU as"))
+	}
+	if !useIR && curTarget.arch != "amd64" {
+		must(fmt.Errorf("-ir=false is not supported on %s", curTarget))
+	}
+	if linkOutput && (curTarget.arch != "amd64" || asmSyntax != "att" || objOutput || libc) {
+		must(fmt.Errorf("build is only for linux/amd64 and doesn't take -asm-syntax, -obj and -libc"))
+	}
+	renderListing()
+	if curTarget.lower == nil {
+		return
+	}
+	var lines []*asmLine
+	for _, line := range asmLines {
+		if !line.removed {
+			lines = append(lines, line)
+		}
+	}
+	asmLines = curTarget.lower(lines)
+}
+
+// objOutput is set by -obj to write an ELF64 object by the built-in assembler instead of the assembly
+var objOutput bool
+
+// linkOutput is set by gompiler build to write an executable linked by the built-in linker
+var linkOutput bool
+
+// writeTarget writes the assembly of asmLines, or the binary of curTarget or the object or the executable made from it
+func writeTarget(w io.Writer) {
+	assemble := curTarget.assemble
+	switch {
+	case objOutput:
+		assemble = func(lines []*asmLine) []byte { return writeELF(assembleX86(lines)) }
+	case linkOutput:
+		assemble = func(lines []*asmLine) []byte { return linkELF(assembleX86(lines)) }
+	}
+	if assemble == nil {
+		renderSyntax()
+		flushAsm(w)
+		return
+	}
+	var lines []*asmLine
+	for _, line := range asmLines {
+		if !line.removed {
+			lines = append(lines, line)
+		}
+	}
+	_, err := w.Write(assemble(lines))
+	must(err)
+}
+
+// flagsRead reports whether an instruction in rest, the lines after an instruction setting the flags, may read them.
+// the targets without the flags of x86-64 keep them only when they are read. the flags are assumed to be read after labels and jumps
+func flagsRead(rest []*asmLine) bool {
+	for _, line := range rest {
+		if isAsmLabel(line) {
+			return true
+		}
+		op := line.op
+		switch {
+		case op == "":
+			continue
+		case op == "jmp", op == "callq", op == "call", op == "ret":
+			return true
+		case op[0] == 'j', strings.HasPrefix(op, "set"), strings.HasPrefix(op, "cmov"):
+			return true
+		case op == "repe":
+			return false
+		}
+		switch op[:len(op)-1] {
+		case "cmp", "test", "add", "sub", "and", "or", "xor", "inc", "dec", "neg", "shl", "sal", "shr", "sar", "imul", "bsr", "bts", "btr":
+			return false
+		}
+	}
+	return true
+}
+
+// x86Operand is an operand of an x86-64 instruction in AT&T syntax, which the other targets lower
+type x86Operand struct {
+	reg   string // the 64-bit register of a register operand like rax for %al, or "" if it is not a register
+	width int    // the bits of a register operand
+	imm   int64
+	isImm bool
+	mem   bool   // a memory operand like sym+8(%rip) or -8(%rbp,%rcx,8)
+	sym   string // the symbol of a memory operand, or the label of a jump or a call
+	disp  int64
+	base  string // rip for pc-relative operands
+	index string
+	scale int
+}
+
+// x86Regs are the 64-bit registers by the names of their lower 32, 16 and 8 bits
+var x86Regs = map[string]x86Operand{}
+
+func init() {
+	for _, r := range []string{"ax", "bx", "cx", "dx", "si", "di", "bp", "sp"} {
+		x86Regs["r"+r] = x86Operand{reg: "r" + r, width: 64}
+		x86Regs["e"+r] = x86Operand{reg: "r" + r, width: 32}
+		x86Regs[r] = x86Operand{reg: "r" + r, width: 16}
+		if strings.HasSuffix(r, "x") {
+			x86Regs[r[:1]+"l"] = x86Operand{reg: "r" + r, width: 8}
+		} else {
+			x86Regs[r+"l"] = x86Operand{reg: "r" + r, width: 8}
+		}
+	}
+	for i := 8; i <= 15; i++ {
+		r := fmt.Sprintf("r%d", i)
+		x86Regs[r] = x86Operand{reg: r, width: 64}
+		x86Regs[r+"d"] = x86Operand{reg: r, width: 32}
+		x86Regs[r+"w"] = x86Operand{reg: r, width: 16}
+		x86Regs[r+"b"] = x86Operand{reg: r, width: 8}
+	}
+}
+
+// parseX86Operand parses an operand like $1, %rax, *%rax, label or -8(%rbp,%rcx,8)
+func parseX86Operand(s string) x86Operand {
+	s = strings.TrimPrefix(s, "*") // indirect calls and jumps
+	switch {
+	case strings.HasPrefix(s, "$"):
+		v, err := strconv.ParseInt(s[1:], 0, 64)
+		must(err)
+		return x86Operand{imm: v, isImm: true}
+	case strings.HasPrefix(s, "%"):
+		r, ok := x86Regs[s[1:]]
+		if !ok {
+			must(fmt.Errorf("unknown register %s", s))
+		}
+		return r
+	}
+	open := strings.Index(s, "(")
+	if strings.HasPrefix(s, "\"") { // a quoted symbol like "main.(*T).M"
+		end := strings.Index(s[1:], "\"") + 2
+		open = strings.Index(s[end:], "(")
+		if open >= 0 {
+			open += end
+		}
+	}
+	if open < 0 {
+		return x86Operand{sym: s}
+	}
+	op := x86Operand{mem: true, scale: 1}
+	if disp := s[:open]; disp != "" {
+		if v, err := strconv.ParseInt(disp, 0, 64); err == nil {
+			op.disp = v
+		} else {
+			op.sym = disp
+		}
+	}
+	regs := strings.Split(strings.TrimSuffix(s[open+1:], ")"), ",")
+	if base := strings.TrimPrefix(regs[0], "%"); base == "rip" {
+		op.base = base
+	} else if base != "" {
+		op.base = x86Regs[base].reg
+	}
+	if len(regs) > 1 {
+		op.index = x86Regs[strings.TrimPrefix(regs[1], "%")].reg
+	}
+	if len(regs) > 2 {
+		scale, err := strconv.ParseInt(regs[2], 0, 64)
+		must(err)
+		op.scale = int(scale)
+	}
+	return op
+}
+
+// x86Width returns the bits of the operands of an instruction by the suffix of op like movq, or 64
+func x86Width(op string) int {
+	switch op[len(op)-1] {
+	case 'b':
+		return 8
+	case 'w':
+		return 16
+	case 'l':
+		return 32
+	}
+	return 64
+}
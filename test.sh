@@ -7,4 +7,256 @@ if [[ $? -eq 42 ]]; then
 else
   echo error
   exit 1
-fi
\ No newline at end of file
+fi
+
+# compile every program (a go file or a module directory) in testdata and compare stderr and exit status with the go toolchain.
+tmp=$(mktemp -d)
+
+# skip reports the tests left out because their tools are not installed. CI installs every tool, so a skip fails there
+skip() {
+  echo "skip $1"
+  if [ -n "$CI" ]; then
+    exit 1
+  fi
+}
+
+# assemble assembles the output, which is in the syntax of nasm with -asm-syntax=nasm and is already an object with -obj
+assemble() {
+  if [[ "$flags" == *-asm-syntax=nasm* ]]; then
+    nasm -f elf64 -o "$1" "$2"
+  elif [[ "$flags" == *-obj* ]]; then
+    cp "$2" "$1"
+  else
+    as -o "$1" "$2"
+  fi
+}
+
+assert() {
+  input="$1"
+
+  if [ -d "$input" ]; then
+    (cd "$input" && go build -o "$tmp/expect.out" .) || exit 1
+  else
+    go build -o "$tmp/expect.out" "$input" || exit 1
+  fi
+  expect=$("$tmp/expect.out" 2>&1)
+  expect_status="$?"
+
+  if [[ "$flags" == build* ]]; then
+    ./gompiler $flags -o "$tmp/main.out" -input="$input" || exit 1
+  else
+    ./gompiler $flags -input="$input" > "$tmp/main.s" && \
+    assemble "$tmp/main.o" "$tmp/main.s" && \
+    ld -o "$tmp/main.out" "$tmp/main.o" || exit 1
+  fi
+  if [[ " $flags " == *" -g "* ]] && command -v llvm-dwarfdump > /dev/null; then
+    llvm-dwarfdump --verify "$tmp/main.out" > /dev/null || exit 1
+  fi
+  actual=$("$tmp/main.out" 2>&1)
+  actual_status="$?"
+
+  if [ "$actual" = "$expect" ] && [ "$actual_status" = "$expect_status" ]; then
+    echo "$input${flags:+ $flags} => ok"
+  else
+    echo "$input => $expect_status expect, but got $actual_status"
+    echo "$actual"
+    exit 1
+  fi
+}
+
+# programs calling C functions can't be built by the go toolchain. they are linked with the C objects
+# and the C library, and their output followed by the exit status is compared with expected.txt
+assert_c() {
+  input="$1"
+  libdir=/usr/lib/x86_64-linux-gnu
+
+  objs=()
+  for src in "$input"*.c; do
+    obj="$tmp/$(basename "$src" .c).o"
+    gcc -c -O2 -o "$obj" "$src" || exit 1
+    objs+=("$obj")
+  done
+  ./gompiler $flags -libc -input="$input" > "$tmp/main.s" && \
+  assemble "$tmp/main.o" "$tmp/main.s" && \
+  ld -z noexecstack -o "$tmp/main.out" -dynamic-linker /lib64/ld-linux-x86-64.so.2 \
+    "$libdir/crt1.o" "$libdir/crti.o" "$tmp/main.o" "${objs[@]}" -L"$libdir" -lc "$libdir/crtn.o" || exit 1
+  actual=$("$tmp/main.out" 2>&1; echo "exit $?")
+
+  if [ "$actual" = "$(cat "$input"expected.txt)" ]; then
+    echo "$input${flags:+ $flags} => ok"
+  else
+    echo "$input => unexpected output"
+    echo "$actual"
+    exit 1
+  fi
+}
+
+# every program is compiled with the stack ABI, the register ABI and without the IR, in the other syntaxes, and with the debug info and the listings
+syntaxes=(-asm-syntax=intel)
+if command -v nasm > /dev/null; then
+  syntaxes+=(-asm-syntax=nasm)
+else
+  skip "-asm-syntax=nasm: nasm is not installed"
+fi
+for flags in "" -O0 -regabi "-regabi -O0" -ir=false -tailcall "-tailcall -ir=false" "${syntaxes[@]}" -obj -g "-g -O0" "-g -asm-syntax=intel" -S=annotated -S=clean "-S=clean -asm-syntax=intel"; do
+  for input in testdata/*.go testdata/*/; do
+    if compgen -G "$input*.c" > /dev/null; then
+      assert_c "$input"
+    else
+      assert "$input"
+    fi
+  done
+done
+
+# gompiler build links the programs without binutils
+for flags in build "build -O0" "build -regabi" "build -ir=false"; do
+  for input in testdata/*.go testdata/*/; do
+    if ! compgen -G "$input*.c" > /dev/null; then
+      assert "$input"
+    fi
+  done
+done
+
+# the objects of the built-in assembler must have the same code, data, symbols and relocations as the ones of GNU as
+assert_obj() {
+  input="$1"
+
+  ./gompiler $flags -input="$input" > "$tmp/main.s" && \
+  as -o "$tmp/expect.o" "$tmp/main.s" && \
+  ./gompiler $flags -obj -input="$input" > "$tmp/main.o" || exit 1
+
+  # the headers of the relocation sections differ only in their file offsets
+  if cmp -s <(objdump -dr -s "$tmp/expect.o" | tail -n +3) <(objdump -dr -s "$tmp/main.o" | tail -n +3) && \
+    cmp -s <(readelf -sr "$tmp/expect.o" | grep -v "^Relocation section") <(readelf -sr "$tmp/main.o" | grep -v "^Relocation section"); then
+    echo "$input -obj${flags:+ $flags} => ok"
+  else
+    echo "$input -obj${flags:+ $flags} => the object differs from the one of GNU as"
+    diff <(objdump -dr -s "$tmp/expect.o" | tail -n +3) <(objdump -dr -s "$tmp/main.o" | tail -n +3)
+    exit 1
+  fi
+}
+
+for flags in "" -O0 -regabi "-regabi -O0" -ir=false -tailcall "-tailcall -ir=false" -libc; do
+  for input in testdata/*.go testdata/*/; do
+    assert_obj "$input"
+  done
+done
+
+# -dump writes the results of the phases to stderr without changing the output. the JSON dump has an object per phase on a line
+assert_dump() {
+  input="$1"
+  phases=tokens,ast,types,ir,frames,asm
+
+  ./gompiler $flags -S=clean -input="$input" > "$tmp/expect.s" && \
+  ./gompiler $flags -S=clean -dump=$phases -input="$input" > "$tmp/main.s" 2> "$tmp/dump.txt" && \
+  ./gompiler $flags -S=clean -dump=$phases -dump-format=json -input="$input" > "$tmp/main.json.s" 2> "$tmp/dump.json" || exit 1
+  if command -v node > /dev/null; then
+    node -e 'for (const line of require("fs").readFileSync(0, "utf8").split("\n").filter(Boolean)) JSON.parse(line)' < "$tmp/dump.json" || exit 1
+  fi
+
+  if cmp -s "$tmp/expect.s" "$tmp/main.s" && cmp -s "$tmp/expect.s" "$tmp/main.json.s" && \
+    [ "$(grep -c "^# dump " "$tmp/dump.txt")" = 6 ] && [ "$(wc -l < "$tmp/dump.json")" = 6 ]; then
+    echo "$input -dump${flags:+ $flags} => ok"
+  else
+    echo "$input -dump${flags:+ $flags} => the dump changed the output or missed a phase"
+    exit 1
+  fi
+}
+
+for flags in "" -O0 -ir=false; do
+  for input in testdata/*.go testdata/*/; do
+    if ! compgen -G "$input*.c" > /dev/null; then
+      assert_dump "$input"
+    fi
+  done
+done
+
+# the other targets run under qemu-user when it and the binutils of the target are installed
+assert_cross() {
+  input="$1"
+  arch="$2"
+  prefix="$3"
+
+  if [ -d "$input" ]; then
+    (cd "$input" && go build -o "$tmp/expect.out" .) || exit 1
+  else
+    go build -o "$tmp/expect.out" "$input" || exit 1
+  fi
+  expect=$("$tmp/expect.out" 2>&1)
+  expect_status="$?"
+
+  ./gompiler $flags -target=linux/$arch -input="$input" > "$tmp/main.s" && \
+  $prefix-as -o "$tmp/main.o" "$tmp/main.s" && \
+  $prefix-ld -o "$tmp/main.out" "$tmp/main.o" || exit 1
+  actual=$(qemu-$(uname_arch "$arch") "$tmp/main.out" 2>&1)
+  actual_status="$?"
+
+  if [ "$actual" = "$expect" ] && [ "$actual_status" = "$expect_status" ]; then
+    echo "$input -target=linux/$arch${flags:+ $flags} => ok"
+  else
+    echo "$input -target=linux/$arch => $expect_status expect, but got $actual_status"
+    echo "$actual"
+    exit 1
+  fi
+}
+
+uname_arch() {
+  case "$1" in
+    arm64) echo aarch64 ;;
+    *) echo "$1" ;;
+  esac
+}
+
+for target in "arm64 aarch64-linux-gnu" "riscv64 riscv64-linux-gnu"; do
+  read -r arch prefix <<< "$target"
+  if ! command -v "qemu-$(uname_arch "$arch")" > /dev/null || ! command -v "$prefix-as" > /dev/null; then
+    skip "linux/$arch: qemu-$(uname_arch "$arch") or $prefix-as is not installed"
+    continue
+  fi
+  for flags in "" -O0 -regabi "-regabi -O0" -tailcall; do
+    for input in testdata/*.go testdata/*/; do
+      if ! compgen -G "$input*.c" > /dev/null; then
+        assert_cross "$input" "$arch" "$prefix"
+      fi
+    done
+  done
+done
+
+# the wasip1/wasm target runs under the WASI of node
+assert_wasm() {
+  input="$1"
+
+  if [ -d "$input" ]; then
+    (cd "$input" && go build -o "$tmp/expect.out" .) || exit 1
+  else
+    go build -o "$tmp/expect.out" "$input" || exit 1
+  fi
+  expect=$("$tmp/expect.out" 2>&1)
+  expect_status="$?"
+
+  ./gompiler $flags -target=wasip1/wasm -input="$input" > "$tmp/main.wasm" || exit 1
+  actual=$(node --no-warnings assemble/wasi/run.js "$tmp/main.wasm" 2>&1)
+  actual_status="$?"
+
+  if [ "$actual" = "$expect" ] && [ "$actual_status" = "$expect_status" ]; then
+    echo "$input -target=wasip1/wasm${flags:+ $flags} => ok"
+  else
+    echo "$input -target=wasip1/wasm => $expect_status expect, but got $actual_status"
+    echo "$actual"
+    exit 1
+  fi
+}
+
+if command -v node > /dev/null; then
+  for flags in "" -O0 -regabi "-regabi -O0" -tailcall; do
+    for input in testdata/*.go testdata/*/; do
+      if ! compgen -G "$input*.c" > /dev/null; then
+        assert_wasm "$input"
+      fi
+    done
+  done
+else
+  skip "wasip1/wasm: node is not installed"
+fi
+
+rm -rf "$tmp"
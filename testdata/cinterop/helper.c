@@ -0,0 +1,44 @@
+#include <stdio.h>
+#include <string.h>
+
+long add3(long a, long b, long c) {
+	return a + b + c;
+}
+
+/* the 7th and 8th arguments are passed on the stack */
+long weigh8(long a, long b, long c, long d, long e, long f, long g, long h) {
+	return a + 2 * b + 3 * c + 4 * d + 5 * e + 6 * f + 7 * g + 8 * h;
+}
+
+long weigh7(long a, long b, long c, long d, long e, long f, long g) {
+	return weigh8(a, b, c, d, e, f, g, 0);
+}
+
+long count_byte(const char *p, long n, char c) {
+	long count = 0;
+	for (long i = 0; i < n; i++) {
+		if (p[i] == c) {
+			count++;
+		}
+	}
+	return count;
+}
+
+_Bool is_upper(long c) {
+	return c >= 'A' && c <= 'Z';
+}
+
+const char *greet(const char *name) {
+	static char buf[64];
+	snprintf(buf, sizeof(buf), "hello, %s", name);
+	return buf;
+}
+
+void show(const char *label, long n) {
+	printf("%s %ld\n", label, n);
+	fflush(stdout);
+}
+
+void bump(long *p) {
+	(*p)++;
+}
@@ -0,0 +1,70 @@
+package main
+
+import (
+	"fmt"
+	"os"
+)
+
+//extern add3
+func add3(a int, b int, c int) int
+
+//extern weigh8
+func weigh8(a, b, c, d, e, f, g, h int) int
+
+//extern weigh7
+func weigh7(a, b, c, d, e, f, g int) int
+
+//extern count_byte
+func countByte(p []byte, n int, c byte) int
+
+//extern is_upper
+func isUpper(c int) bool
+
+//extern greet
+func greet(name string) string
+
+//extern show
+func show(label string, n int)
+
+//extern bump
+func bump(p *int)
+
+//extern strlen
+func strlen(s string) int
+
+//extern strtol
+func strtol(s string, end *int, base int) int
+
+//extern puts
+func puts(s string) int
+
+//extern fflush
+func fflush(f *int) int
+
+type point struct {
+	x, y int
+}
+
+func main() {
+	fmt.Println(add3(1, 2, 3), add3(add3(1, 1, 1), -5, 10))
+	fmt.Println(weigh8(1, 1, 1, 1, 1, 1, 1, 1), weigh7(1, 2, 3, 4, 5, 6, 7))
+
+	b := []byte("banana")
+	fmt.Println(countByte(b, len(b), 'a'), countByte(b[1:], 2, 'n'))
+	fmt.Println(isUpper('G'), isUpper('g'))
+	fmt.Println(greet("gompiler"), len(greet("C")))
+
+	p := &point{x: 1, y: 2}
+	bump(&p.y)
+	n := 41
+	bump(&n)
+	fmt.Println(p.y, n)
+
+	fmt.Println(strlen("hello"), strlen(""), strtol("-1234", nil, 10), strtol("ff", nil, 16))
+	show("show", 7)
+	puts("from puts")
+	fflush(nil)
+	fmt.Println("after fflush")
+	puts("flushed by exit")
+	os.Exit(3)
+}
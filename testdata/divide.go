@@ -0,0 +1,40 @@
+package main
+
+const minInt = -1 << 63
+
+type frac struct {
+	name string
+	n, d int
+}
+
+// quo divides in a function taking a struct, which is compiled from the AST
+func quo(f frac) int {
+	return f.n / f.d
+}
+
+func rem(f frac) int {
+	return f.n % f.d
+}
+
+// div and mod take only ints, so they are lowered to the IR
+func div(a, b int) int {
+	return a / b
+}
+
+func mod(a, b int) int {
+	return a % b
+}
+
+func main() {
+	println(div(minInt, -1), mod(minInt, -1), div(7, -1), mod(-7, -1), div(-17, 5), mod(-17, 5))
+	fracs := []frac{frac{"min", minInt, -1}, frac{"neg", 7, -1}, frac{"pos", -17, 5}, frac{"one", minInt, 1}}
+	for _, f := range fracs {
+		println(f.name, quo(f), rem(f))
+	}
+	m := minInt
+	d := -1
+	println(m/d, m%d, -m, m*d)
+	var b byte = 200
+	var c byte = 7
+	println(b/c, b%c)
+}
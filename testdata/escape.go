@@ -0,0 +1,149 @@
+package main
+
+type point struct {
+	x, y int
+}
+
+func (p *point) move(dx, dy int) {
+	p.x += dx
+	p.y += dy
+}
+
+type node struct {
+	value int
+	next  *node
+}
+
+func newCounter() *int {
+	n := 0
+	return &n
+}
+
+func newPoint(x, y int) *point {
+	p := point{x, y}
+	q := &p
+	return q
+}
+
+func push(head *node, value int) *node {
+	n := node{value, head}
+	return &n
+}
+
+// list builds the list from the variables declared in the loop, which move to the heap because head is declared outside it
+func list(n int) *node {
+	var head *node
+	for i := 0; i < n; i++ {
+		v := node{i, head}
+		head = &v
+	}
+	return head
+}
+
+func collect(values []int) []*int {
+	var ptrs []*int
+	for _, v := range values {
+		ptrs = append(ptrs, &v)
+	}
+	return ptrs
+}
+
+// param returns the address of its parameter
+func param(x int) *int {
+	x *= 2
+	return &x
+}
+
+func sum(a, b int) int {
+	s := 0
+	p := &s // doesn't escape
+	*p = a + b
+	return s
+}
+
+// deref returns the int p points to, which doesn't carry the address of k
+func deref(a int) int {
+	k := a
+	p := &k
+	return *p
+}
+
+func named() (p *point) {
+	var origin point
+	origin.move(1, 1)
+	p = &origin
+	return
+}
+
+// loops keeps the addresses of the variables declared in loops in variables declared outside them,
+// so each iteration has its own variable even though none of them is returned
+func loops(xs []int) {
+	var list *node
+	for i := 0; i < 3; i++ {
+		n := node{i, list}
+		list = &n
+	}
+	for n := list; n != nil; n = n.next {
+		print(n.value)
+	}
+	println()
+
+	var q *int
+	for _, v := range xs {
+		if v == 8 {
+			q = &v
+		}
+	}
+	println(*q)
+
+	var ps []*int
+	for i := 0; i < 3; i++ {
+		ps = append(ps, &i)
+	}
+	println(*ps[0], *ps[1], *ps[2])
+
+	var last *int
+	for i := 0; i < 3; i++ {
+		last = &i
+		i++
+	}
+	println(*last)
+}
+
+func main() {
+	c := newCounter()
+	*c += 1
+	d := newCounter()
+	*d += 2
+	println(*c, *d)
+
+	p := newPoint(1, 2)
+	p.move(3, 4)
+	println(p.x, p.y)
+
+	var head *node
+	for i := 0; i < 3; i++ {
+		head = push(head, i)
+	}
+	for n := head; n != nil; n = n.next {
+		println(n.value)
+	}
+	for n := list(4); n != nil; n = n.next {
+		println(n.value)
+	}
+
+	ptrs := collect([]int{7, 8, 9})
+	for _, ptr := range ptrs {
+		println(*ptr)
+	}
+
+	x := param(21)
+	y := param(5)
+	println(*x, *y)
+	println(sum(3, 4), deref(5))
+
+	o := named()
+	println(o.x, o.y)
+
+	loops([]int{7, 8, 9})
+}
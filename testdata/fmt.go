@@ -0,0 +1,49 @@
+package main
+
+import (
+	"fmt"
+	"os"
+)
+
+type MyInt int
+
+type Point struct {
+	X, Y int
+	Name string
+}
+
+type Line struct {
+	From, To Point
+	Tags     []string
+}
+
+func show(format string, args ...any) string {
+	return "[" + fmt.Sprintf(format, args...) + "]"
+}
+
+func main() {
+	fmt.Println("hello", 42, true, -7)
+	fmt.Print("a", 1, 2, "b", 3, false, "\n")
+	fmt.Printf("%d %s %v %t %c %%\n", 10, "str", "v", true, 'G')
+	fmt.Printf("%x %X %x %x\n", 255, 255, -255, "hi")
+	fmt.Printf("%q %q %q\n", "quote\"d\n", "tab\there", 'x')
+	fmt.Printf("|%5d|%-5d|%05d|%05d|\n", 42, 42, 42, -42)
+	fmt.Printf("|%6s|%-6s|%3s|\n", "go", "go", "long")
+	fmt.Printf("|%c%c%c|\n", 'é', 'a', 0x4e16)
+	fmt.Printf("%d %d\n", 1)
+	fmt.Printf("%d\n", 1, "extra", 3)
+	fmt.Printf("%d %t\n", "s", 1)
+	fmt.Println(MyInt(3), Point{1, 2, "p"}, Line{Point{0, 0, "a"}, Point{3, 4, "b"}, []string{"x", "y"}})
+	fmt.Println([]int{1, 2, 3}, []byte("hi"), nil, []any{1, "a", nil, true})
+	fmt.Printf("%s %v %x\n", []byte("go"), []byte("go"), []byte("go"))
+
+	s := fmt.Sprintf("%d-%s", 7, "seven")
+	fmt.Println(s, len(s))
+	fmt.Println(fmt.Sprint("x", 1, 2), fmt.Sprintln("y", 3) == "y 3\n")
+	fmt.Println(show("%d+%d=%d", 1, 2, 3), show("none"))
+
+	var p *Point
+	fmt.Println(p == nil, fmt.Sprint(p))
+	fmt.Printf("%d %v\n", 9223372036854775807, -9223372036854775807-1)
+	os.Exit(3)
+}
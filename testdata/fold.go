@@ -0,0 +1,79 @@
+package main
+
+const (
+	width  = 8
+	height = width * 3
+	area   = width * height
+	debug  = false
+	name   = "gom" + "piler"
+)
+
+const shift = 1 << 10
+
+// untyped constants are exact, so the intermediate values may be larger than int
+const (
+	huge  = 1 << 70
+	small = huge >> 68
+	n     = 1 << 10
+	min   = -1 << 63
+)
+
+func unused(x int) int {
+	return helper(x) * 2
+}
+
+func helper(x int) int {
+	return x + 1
+}
+
+func clamp(x int) int {
+	if x > area-100 {
+		return area - 100
+	}
+	return x
+}
+
+func report(x int) {
+	println("report", x)
+	return
+	println("unreachable")
+}
+
+func sign(x int) int {
+	if debug {
+		println("sign", x)
+	}
+	if !debug && x < 0 {
+		return -1
+	} else if width > 100 {
+		return 100
+	}
+	return 1
+}
+
+func main() {
+	println(width, height, area, shift, -(width + 2))
+	println(name, len(name), name == "gompiler", "a" < "b")
+	println((width+1)*(height-4)/3%7, width&^3, width^5, shift>>3)
+	report(area)
+	println(clamp(1000), clamp(3), sign(-5), sign(5))
+	if true {
+		println("always")
+	} else {
+		println("never")
+	}
+	for i := 0; false; i++ {
+		println("never", i)
+	}
+	x := 0
+	for i := 0; i < 10; i++ {
+		if i == 3 {
+			continue
+			println("skipped")
+		}
+		x += i
+	}
+	println(x, width < height && !debug, debug || area > 0)
+	y := (1 << 70) >> 68
+	println(y, small, n*n*n*n*n*n*n/n/n/n/n/n/n, huge/n/n/n/n/n/n, huge > n, min, -min-1, ^-huge>>65)
+}
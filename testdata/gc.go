@@ -0,0 +1,61 @@
+package main
+
+// the program allocates more than the heap can hold, so it needs the memory of the garbage collected.
+// the live values are reachable from a global variable, locals and the middle of a slice
+
+type node struct {
+	value int
+	next  *node
+}
+
+var names []string
+
+func buildList(n int) *node {
+	var head *node
+	for i := 0; i < n; i++ {
+		head = &node{i, head}
+	}
+	return head
+}
+
+func sumList(head *node) int {
+	sum := 0
+	for n := head; n != nil; n = n.next {
+		sum += n.value
+	}
+	return sum
+}
+
+// churn allocates n buffers of 64KB which are dropped right away
+func churn(n int) int {
+	total := 0
+	for i := 0; i < n; i++ {
+		buf := make([]int, 8192)
+		buf[i%8192] = i
+		total += buf[i%8192] + len(buf)
+	}
+	return total
+}
+
+func main() {
+	list := buildList(1000)
+	for i := 0; i < 10; i++ {
+		names = append(names, "name"+string([]byte{byte('a' + i)}))
+	}
+	middle := make([]int, 1000)[500:] // only the middle of the array is referred to
+	for i := range middle {
+		middle[i] = i
+	}
+
+	total := churn(70000)
+
+	println(total)
+	println(sumList(list))
+	println(names[0], names[9])
+	println(middle[0], middle[499])
+	s := ""
+	for i := 0; i < 2000; i++ {
+		s = s + "x"
+	}
+	println(len(s))
+}
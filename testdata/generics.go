@@ -0,0 +1,107 @@
+package main
+
+import "os"
+
+type Ordered interface {
+	~int | ~string
+}
+
+type MyInt int
+
+type Pair[K comparable, V any] struct {
+	key   K
+	value V
+}
+
+func Max[T Ordered](a T, b T) T {
+	if a > b {
+		return a
+	}
+	return b
+}
+
+func Swap[K comparable, V any](p Pair[K, V]) Pair[K, V] {
+	var q Pair[K, V]
+	q.key = p.key
+	q.value = p.value
+	return q
+}
+
+func Key[K comparable, V any](p Pair[K, V]) K {
+	return p.key
+}
+
+// Stack is a container with methods, which are instantiated with the type
+type Stack[T any] struct {
+	items []T
+}
+
+func (s *Stack[T]) Push(v T) {
+	s.items = append(s.items, v)
+}
+
+func (s *Stack[T]) Pop() T {
+	v := s.items[len(s.items)-1]
+	s.items = s.items[:len(s.items)-1]
+	return v
+}
+
+func (s Stack[T]) Len() int {
+	return len(s.items)
+}
+
+func (p Pair[K, V]) With(value V) Pair[K, V] {
+	return Pair[K, V]{key: p.key, value: value}
+}
+
+type Lener interface {
+	Len() int
+}
+
+func Sum[T Ordered](values []T) T {
+	var sum T
+	for _, v := range values {
+		sum += v
+	}
+	return sum
+}
+
+func Deref[T any](p *T) T {
+	return *p
+}
+
+func Equal[T comparable](a T, b T) bool {
+	return a == b
+}
+
+func main() {
+	print(Max("abc", "abd") + "\n")
+	print(Max[string]("b", "a") + "\n")
+
+	var m MyInt = 3
+	n := Max(m, 40)
+
+	p := Pair[string, int]{key: "pair\n", value: 2}
+	print(Key(Swap(p)))
+	if Equal("x", "x") && !Equal(1, 2) {
+		print("equal\n")
+	}
+
+	var s Stack[int]
+	s.Push(1)
+	s.Push(2)
+	s.Push(3)
+	println(s.Pop(), s.Len())
+	words := &Stack[string]{}
+	words.Push("generic")
+	words.Push("methods")
+	var l Lener = words
+	var v Lener = s
+	println(words.Pop(), l.Len(), v.Len())
+
+	q := Pair[string, int]{key: "one", value: 1}.With(10)
+	println(q.key, q.value)
+	println(Sum([]int{1, 2, 3}), Sum([]MyInt{4, 5}), Sum([]string{"a", "b"}), Deref(&m), Deref(&q).value)
+
+	os.Exit(int(n) + Max(p.value, Key(Pair[int, bool]{1, true})))
+}
@@ -0,0 +1,58 @@
+package main
+
+import "os"
+
+var total = sum(a, b) + c
+
+var a int = 1 + 2
+
+var (
+	b       = double(a)
+	c       int
+	message string = "init " + name
+	name           = "order\n"
+	_              = trace("blank\n")
+)
+
+var p = Point{x: a, y: b}
+
+var d, e = divmod(total, 4)
+
+var _, f = divmod(e, 2)
+
+type Point struct {
+	x int
+	y int
+}
+
+func sum(x int, y int) int {
+	return x + y
+}
+
+func double(x int) int {
+	return x * 2
+}
+
+func divmod(x int, y int) (int, int) {
+	return x / y, x % y
+}
+
+func trace(s string) int {
+	print(s)
+	return 0
+}
+
+func init() {
+	print(message)
+	c = 30
+}
+
+func init() {
+	if c == 30 {
+		print("second init\n")
+	}
+}
+
+func main() {
+	os.Exit(total + c + p.y + d*10 + e*5 + f)
+}
@@ -0,0 +1,74 @@
+package main
+
+func abs(x int) int {
+	if x < 0 {
+		return -x
+	}
+	return x
+}
+
+func max(a, b int) int {
+	if a > b {
+		return a
+	}
+	return b
+}
+
+func lower(c byte) byte {
+	if c >= 'A' && c <= 'Z' {
+		return c + 'a' - 'A'
+	}
+	return c
+}
+
+func even(n int) bool {
+	return n%2 == 0
+}
+
+//go:noinline
+func square(x int) int {
+	return x * x
+}
+
+func fact(n int) int {
+	if n <= 1 {
+		return 1
+	}
+	return n * fact(n-1)
+}
+
+// collatzLen calls the small functions from a function lowered to the IR
+func collatzLen(n int) int {
+	steps := 0
+	for n != 1 {
+		if even(n) {
+			n /= 2
+		} else {
+			n = 3*n + 1
+		}
+		steps++
+	}
+	return max(steps, abs(-steps))
+}
+
+// countLower calls them from a function compiled from the AST
+func countLower(s string) int {
+	n := 0
+	for i := 0; i < len(s); i++ {
+		if lower(s[i]) == s[i] && s[i] != ' ' {
+			n++
+		}
+	}
+	return max(n, 0)
+}
+
+func main() {
+	println(abs(-7), abs(7), max(3, 9), max(abs(-12), square(3)))
+	println(collatzLen(27), fact(10), even(4), even(7))
+	println(countLower("HeLLo, WORLD"), lower('Q'), lower('q'))
+	total := 0
+	for i := -5; i <= 5; i++ {
+		total += max(abs(i), square(i)/2)
+	}
+	println(total)
+}
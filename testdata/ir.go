@@ -0,0 +1,150 @@
+package main
+
+import "os"
+
+// the functions of this program take and return ints, bools and bytes only, so they are compiled through the IR
+
+type celsius int
+
+const limit = 10
+
+var counter int
+var flag bool
+
+func fib(n int) int {
+	if n < 2 {
+		return n
+	}
+	return fib(n-1) + fib(n-2)
+}
+
+func gcd(a, b int) int {
+	for b != 0 {
+		a, b = b, a%b
+	}
+	return a
+}
+
+func collatz(n int) int {
+	steps := 0
+	for n != 1 {
+		switch {
+		case n%2 == 0:
+			n /= 2
+		default:
+			n = 3*n + 1
+		}
+		steps++
+	}
+	return steps
+}
+
+func classify(n int) int {
+	switch n {
+	case 0:
+		return 0
+	case 1, 2:
+		return 1
+	case 3:
+		fallthrough
+	case 4:
+		return 2
+	}
+	return 3
+}
+
+func sumTo(n int) int {
+	sum := 0
+	for i := range n {
+		if i%3 == 0 {
+			continue
+		}
+		if i > limit {
+			break
+		}
+		sum += i
+	}
+	return sum
+}
+
+func wrap(b byte) byte {
+	b += 200
+	return b >> 1
+}
+
+func inRange(x int, lo int, hi int) bool {
+	return lo <= x && x < hi || x == -1
+}
+
+func bump(by int) {
+	counter += by
+	flag = !flag
+}
+
+func warm(c celsius) celsius {
+	return c*9/5 + 32
+}
+
+func bits(x int) int {
+	return (x<<3|5)&^4 ^ -x>>1
+}
+
+// shifts by the width or more leave 0 or the sign bits, unlike the count modulo 64 of x86-64
+func shl(x int, n int) int {
+	return x << n
+}
+
+func shr(x int, n int) int {
+	return x >> n
+}
+
+func shrByte(b byte, n int) byte {
+	return b >> n
+}
+
+func unreachable(n int) int {
+	for {
+		if n > 100 {
+			return n
+		}
+		n *= 3
+	}
+	return -1
+}
+
+// pressure keeps more values live across calls than there are registers, so some are spilled
+func pressure(x int) int {
+	a, b, c, d, e, f := x+1, x+2, x+3, x+4, x+5, x+6
+	g, h, i, j, k, l := a*b, b*c, c*d, d*e, e*f, f*a
+	m := gcd(g, h) + gcd(i, j)
+	n := fib(k%10) - fib(l%10)
+	return a + b + c + d + e + f + g + h + i + j + k + l + m + n
+}
+
+func trace(n int) int {
+	println("trace", n)
+	return n
+}
+
+// ordered calls trace for its arguments from the left like Go
+func ordered(x int) int {
+	return gcd(trace(x), trace(x*2)) - trace(1)
+}
+
+func main() {
+	println("fib", fib(15), "gcd", gcd(84, 36), "collatz", collatz(27))
+	println(classify(0), classify(2), classify(3), classify(4), classify(9))
+	println(sumTo(100), wrap(100), wrap(250))
+	println(inRange(3, 1, 5), inRange(5, 1, 5), inRange(-1, 0, 0))
+	bump(3)
+	bump(4)
+	println(counter, flag)
+	println(warm(100), bits(11), unreachable(2), pressure(4), pressure(-9))
+	println(shl(1, 64), shl(3, 100), shl(1, 63), shl(5, 2), shr(8, 64), shr(-8, 100), shr(-8, 1), shr(1<<62, 62))
+	println(ordered(6))
+	println(shrByte(200, 64), shrByte(200, 8), shrByte(200, 3))
+	var b byte = 'z'
+	b++
+	println(b, int(b)+1)
+	os.Exit(collatz(9))
+}
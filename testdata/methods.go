@@ -0,0 +1,163 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"os"
+)
+
+type Shape interface {
+	Area() int
+	Name() string
+}
+
+type Rect struct {
+	W, H int
+}
+
+func (r Rect) Area() int {
+	return r.W * r.H
+}
+
+func (r Rect) Name() string {
+	return "rect"
+}
+
+func (r *Rect) Scale(n int) {
+	r.W *= n
+	r.H *= n
+}
+
+type Square int
+
+func (s Square) Area() int {
+	return int(s) * int(s)
+}
+
+func (s Square) Name() string {
+	return "square"
+}
+
+type Counter struct {
+	n int
+}
+
+func (c *Counter) Area() int {
+	c.n++
+	return c.n
+}
+
+func (c *Counter) Name() string {
+	return "counter"
+}
+
+type NotFound struct {
+	key string
+}
+
+func (e *NotFound) Error() string {
+	return e.key + " not found"
+}
+
+func divmod(a int, b int) (int, int) {
+	return a / b, a % b
+}
+
+func split(s string) (head string, tail string) {
+	if len(s) == 0 {
+		return
+	}
+	head = s[:1]
+	tail = s[1:]
+	return
+}
+
+func lookup(key string) (int, error) {
+	if key == "a" {
+		return 1, nil
+	}
+	if key == "b" {
+		return 0, errors.New("b is broken")
+	}
+	return 0, &NotFound{key: key}
+}
+
+func forward(key string) (int, error) {
+	return lookup(key)
+}
+
+func total(shapes []Shape) int {
+	sum := 0
+	for _, s := range shapes {
+		sum += s.Area()
+	}
+	return sum
+}
+
+func (r Rect) Add(w, h int) Rect {
+	return Rect{r.W + w, r.H + h}
+}
+
+func rect(w int) Rect {
+	println("rect", w)
+	return Rect{W: w, H: 1}
+}
+
+func side(n int) int {
+	println("side", n)
+	return n
+}
+
+func shape(n int) Shape {
+	println("shape", n)
+	return Square(n)
+}
+
+func main() {
+	// the receiver and the arguments are evaluated from the left
+	println(rect(1).Add(side(2), side(3)).Area(), shape(4).Area()+side(5))
+	q, m := divmod(side(7), side(2))
+	println(q, m)
+
+	r := Rect{W: 2, H: 3}
+	r.Scale(2)
+	p := &r
+	println(r.Area(), p.Area(), r.Name(), Square(5).Area())
+
+	q, m = divmod(17, 5)
+	h, t := split("hello")
+	e1, e2 := split("")
+	println(q, m, h, t, len(e1), len(e2))
+
+	var c Counter
+	shapes := []Shape{r, Square(3), &c, Rect{1, 1}}
+	println(total(shapes), total(shapes), c.n)
+	for _, s := range shapes {
+		fmt.Println(s.Name(), s.Area())
+	}
+	fmt.Println(shapes[0], shapes[1], shapes[3])
+
+	for _, key := range []string{"a", "b", "c"} {
+		v, err := forward(key)
+		if err != nil {
+			println("error:", err.Error())
+			fmt.Println(err)
+			continue
+		}
+		println("value:", v)
+	}
+
+	var err error
+	println(err == nil, err != nil)
+	_, err = lookup("x")
+	println(err == nil, err != nil)
+	eof := errors.New("EOF")
+	var x error = eof
+	var a any = "str"
+	println(x == eof, x == errors.New("EOF"), a == "str", a == "other", a != 1)
+
+	var s Shape = Square(4)
+	var any1 any = s
+	fmt.Printf("%v %d %s\n", any1, s.Area(), err)
+	os.Exit(r.Area())
+}
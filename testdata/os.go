@@ -0,0 +1,63 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"os"
+)
+
+const path = "/tmp/gompiler-os-test.txt"
+
+func main() {
+	println(len(os.Args) > 0, len(os.Args[0]) > 0)
+	_, ok := os.LookupEnv("GOMPILER_UNDEFINED_VARIABLE")
+	println(ok, os.Getenv("GOMPILER_UNDEFINED_VARIABLE") == "", os.Getenv("PATH") != "")
+
+	os.Stdout.Write([]byte("to stdout\n"))
+	n, err := os.Stderr.WriteString("to stderr\n")
+	println(n, err == nil)
+	fmt.Fprintf(os.Stderr, "%s %d\n", "fprintf", 1)
+	fmt.Fprintln(os.Stdout, "fprintln", true)
+
+	f, err := os.Create(path)
+	if err != nil {
+		println(err.Error())
+		os.Exit(1)
+	}
+	fmt.Fprint(f, "hello ", "file\n")
+	f.Write([]byte("second line\n"))
+	println(f.Name(), f.Close() == nil)
+	fmt.Println(f.Close())
+	_, err = f.Write([]byte("x"))
+	fmt.Println(err)
+
+	data, err := os.ReadFile(path)
+	fmt.Printf("%q %v\n", string(data), err)
+
+	f, err = os.Open(path)
+	buf := make([]byte, 8)
+	total := 0
+	for {
+		n, err := f.Read(buf)
+		if err == io.EOF {
+			break
+		}
+		total += n
+		print(string(buf[:n]), "|")
+	}
+	println(total)
+	f.Close()
+
+	err = os.WriteFile(path, []byte("replaced\n"), 0644)
+	data, _ = os.ReadFile(path)
+	print(string(data))
+
+	_, err = os.Open("/nonexistent/file")
+	fmt.Println(err)
+	_, err = os.ReadFile("/")
+	fmt.Println(err)
+	var nilFile *os.File
+	_, err = nilFile.Read(buf)
+	fmt.Println(err, err == os.ErrInvalid)
+	os.Exit(7)
+}
@@ -0,0 +1,17 @@
+package calc
+
+import "example.com/packages/util"
+
+func Sum(p util.Pair[int]) int {
+	return util.Max(p.First, p.Second) + p.Second
+}
+
+func Twice(x int) int {
+	return x + x
+}
+
+var Base = util.Counter * 2
+
+func init() {
+	print("calc init\n")
+}
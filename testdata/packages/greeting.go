@@ -0,0 +1,7 @@
+package main
+
+func greeting() string {
+	return "hello from " + name + "\n"
+}
+
+var name string = "greeting.go"
@@ -0,0 +1,16 @@
+package main
+
+import (
+	"os"
+
+	"example.com/packages/calc"
+	"example.com/packages/util"
+)
+
+func main() {
+	print(greeting())
+	print(util.Name + "\n")
+	p := util.Pair[int]{First: 20, Second: 1}
+	print(util.Max("calc", "util") + "\n")
+	os.Exit(calc.Sum(p) + calc.Twice(util.Counter) + calc.Base - 22)
+}
@@ -0,0 +1,22 @@
+package util
+
+var Name string = "util"
+
+var Counter int = 10
+
+type Pair[T any] struct {
+	First  T
+	Second T
+}
+
+func Max[T ~int | ~string](a T, b T) T {
+	if a > b {
+		return a
+	}
+	return b
+}
+
+func init() {
+	print("util init\n")
+	Counter = Counter + 1
+}
@@ -0,0 +1,56 @@
+package main
+
+type Point struct {
+	x int
+	y int
+}
+
+func move(p *Point, dx int) {
+	p.x = p.x + dx
+}
+
+func Deref[T any](p *T) T {
+	return *p
+}
+
+// traced prints before it returns, which is before its caller prints anything
+func traced(n int) int {
+	println("in traced", n)
+	return n
+}
+
+func tracedName(s string) string {
+	println("in tracedName", s)
+	return s
+}
+
+func printTraced(a int, b int) {
+	println(traced(a), traced(b))
+}
+
+func main() {
+	print("int ", 42, " ", -7, " ", 0, "\n")
+	print(true, false, "\n")
+	println("println", 1, -9223372036854775807-1, 9223372036854775807, true)
+	println()
+
+	var p *Point
+	println("nil pointer", p, p == nil)
+
+	x := 10
+	px := &x
+	*px = *px + 5
+	println("x =", x, Deref(px))
+
+	q := &Point{x: 1, y: 2}
+	move(q, 10)
+	println(q.x, q.y, q != nil)
+
+	r := new(Point)
+	r.y = 3
+	println(r.x, r.y)
+
+	printTraced(1, 2)
+	println(tracedName("a"), traced(3), tracedName("b"), true)
+	print(tracedName("c"), traced(4), "\n")
+}
@@ -0,0 +1,124 @@
+package main
+
+import "os"
+
+const (
+	first  = 'a'
+	count  = 5
+	prefix = "p:"
+)
+
+type Stack struct {
+	items []int
+}
+
+func push(s *Stack, v int) {
+	s.items = append(s.items, v)
+}
+
+func pop(s *Stack) int {
+	v := s.items[len(s.items)-1]
+	s.items = s.items[:len(s.items)-1]
+	return v
+}
+
+func sum(xs ...int) int {
+	total := 0
+	for _, x := range xs {
+		total += x
+	}
+	return total
+}
+
+func reverse(b []byte) []byte {
+	for i, j := 0, len(b)-1; i < j; i++ {
+		b[i], b[j] = b[j], b[i]
+		j--
+	}
+	return b
+}
+
+func upper(s string) string {
+	b := []byte(s)
+	for i := range b {
+		if b[i] >= 'a' && b[i] <= 'z' {
+			b[i] -= 'a' - 'A'
+		}
+	}
+	return string(b)
+}
+
+func classify(n int) string {
+	switch {
+	case n < 0:
+		return "negative"
+	case n == 0:
+		return "zero"
+	case n%2 == 0:
+		return "even"
+	}
+	return "odd"
+}
+
+func main() {
+	var b []byte
+	for i := 0; i < count; i++ {
+		b = append(b, byte(first+i))
+	}
+	b = append(b, "xyz"...)
+	println(len(b), string(b))
+	r := string(reverse(b[:count]))
+	println(r, string(b))
+
+	s := []int{1, 2, 3}
+	s = append(s, s...)
+	t := s[2:4]
+	t[0] = 30
+	println(sum(s...), sum(), sum(1, 2, count), len(t), s[2])
+
+	nums := make([]int, 3, 10)
+	nums[1] = 5
+	println(len(nums), cap(nums), nums[0], nums[1])
+
+	st := &Stack{}
+	for i := range 4 {
+		push(st, i*i)
+	}
+	println(pop(st), pop(st), len(st.items))
+
+	for i := -1; i < 4; i++ {
+		switch k := classify(i); k {
+		case "zero":
+			println(i, "is zero")
+		case "even", "odd":
+			println(i, "is", k)
+			if k == "odd" {
+				break
+			}
+			println("  and even")
+		default:
+			println(i, k)
+		}
+	}
+
+	str := "hello, world"
+	println(str[7:], str[:5], str[1], upper(str), prefix+str[len(str)-5:])
+
+	var c byte = 250
+	c += 10
+	x := 0x7f
+	println(c, x>>2, x&0xf, x|0x100, x^1, ^x, x<<3, x&^3)
+
+	n := 0
+	for {
+		n++
+		if n%3 == 0 {
+			continue
+		}
+		if n > 10 {
+			break
+		}
+	}
+	println(n)
+	os.Exit(sum(s...) - 40)
+}
@@ -0,0 +1,43 @@
+package main
+
+import "os"
+
+type Celsius int
+
+type Point struct {
+	x int
+	y int
+}
+
+type Label struct {
+	name string
+	at   Point
+}
+
+func mid(a Point, b Point) Point {
+	var p Point
+	p.x = (a.x + b.x) / 2
+	p.y = (a.y + b.y) / 2
+	return p
+}
+
+func greet(name string) string {
+	if name == "" {
+		return "hello, nobody\n"
+	}
+	return "hello, " + name + "\n"
+}
+
+func main() {
+	p := mid(Point{x: 2, y: 10}, Point{6, 20})
+	l := Label{name: "mid", at: p}
+	print(greet(l.name))
+	print(greet(""))
+	if "abc" < "abd" && !(l.at.x == 0) {
+		print("less\n")
+	} else {
+		print("not less\n")
+	}
+	var t Celsius = 37
+	os.Exit(int(t)%10 + l.at.y/3)
+}
@@ -0,0 +1,73 @@
+package main
+
+type node struct {
+	value int
+	next  *node
+}
+
+func factorial(n, acc int) int {
+	if n <= 1 {
+		return acc
+	}
+	return factorial(n-1, acc*n)
+}
+
+func gcd(a, b int) int {
+	if b == 0 {
+		return a
+	}
+	return gcd(b, a%b)
+}
+
+func countDown(n int) {
+	if n == 0 {
+		println("liftoff")
+		return
+	}
+	countDown(n - 1)
+}
+
+func isEven(n int) bool {
+	if n == 0 {
+		return true
+	}
+	if n == 1 {
+		return false
+	}
+	return isEven(n - 2)
+}
+
+// sum walks the list with a pointer and is compiled from the AST
+func sum(list *node, acc int) int {
+	if list == nil {
+		return acc
+	}
+	return sum(list.next, acc+list.value)
+}
+
+func repeat(s string, n int, acc string) string {
+	if n == 0 {
+		return acc
+	}
+	return repeat(s, n-1, acc+s)
+}
+
+// swap returns the arguments in the other order after n swaps
+func swap(a, b, n int) (int, int) {
+	if n == 0 {
+		return a, b
+	}
+	return swap(b, a, n-1)
+}
+
+func main() {
+	println(factorial(10, 1), gcd(1071, 462), isEven(10000), isEven(7777))
+	countDown(1000)
+	var list *node
+	for i := 1; i <= 1000; i++ {
+		list = &node{i, list}
+	}
+	println(sum(list, 0), repeat("ab", 5, ">"))
+	a, b := swap(1, 2, 7)
+	println(a, b)
+}
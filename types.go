@@ -0,0 +1,354 @@
+package main
+
+import (
+	"fmt"
+	"go/ast"
+	"strings"
+)
+
+// pointerType is the Data of the object of a pointer type *T
+type pointerType struct {
+	elem *ast.Object
+}
+
+// sliceType is the Data of the object of a slice type []T
+type sliceType struct {
+	elem *ast.Object
+}
+
+// tupleType is the Data of the type of a call returning multiple values. e.g. (int, error)
+type tupleType struct {
+	types []*ast.Object
+}
+
+var (
+	globalNil = &ast.Object{
+		Kind: ast.Con,
+		Name: "nil",
+		Decl: nil,
+		Data: nil,
+		Type: nil,
+	}
+
+	// untypedNil is the type of nil, which is assignable to every pointer type
+	untypedNil = &ast.Object{
+		Kind: ast.Typ,
+		Name: "untyped nil",
+		Decl: nil,
+		Data: nil,
+		Type: nil,
+	}
+
+	// globalError is the predeclared type error
+	globalError = newErrorType()
+
+	pointerTypes = map[*ast.Object]*ast.Object{} // pointer types by element type
+	sliceTypes   = map[*ast.Object]*ast.Object{} // slice types by element type
+)
+
+// newErrorType declares type error interface { Error() string }
+func newErrorType() *ast.Object {
+	result := &ast.Field{Type: &ast.Ident{Name: "string", Obj: globalString}}
+	method := &ast.Field{
+		Names: []*ast.Ident{ast.NewIdent("Error")},
+		Type:  &ast.FuncType{Params: &ast.FieldList{}, Results: &ast.FieldList{List: []*ast.Field{result}}},
+	}
+	spec := &ast.TypeSpec{
+		Name: ast.NewIdent("error"),
+		Type: &ast.InterfaceType{Methods: &ast.FieldList{List: []*ast.Field{method}}},
+	}
+	typ := &ast.Object{
+		Kind: ast.Typ,
+		Name: "error",
+		Decl: spec,
+		Data: nil,
+		Type: nil,
+	}
+	spec.Name.Obj = typ
+	return typ
+}
+
+type structField struct {
+	name   string
+	typ    *ast.Object
+	offset int
+}
+
+// sizeOf returns the size of a value of typ in bytes. every value is a sequence of 8 bytes words
+func sizeOf(typ *ast.Object) int {
+	switch u := underlying(typ); u {
+	case globalInt, globalBool, globalByte, untypedNil:
+		return 8
+	case globalString, globalAny:
+		return 16 // ptr, len or type, data
+	default:
+		if isPointer(u) {
+			return 8
+		}
+		if isSlice(u) {
+			return 24 // ptr, len, cap
+		}
+		if isInterface(u) {
+			return 16
+		}
+		if isTuple(u) {
+			size := 0
+			for _, t := range tupleTypes(u) {
+				size += sizeOf(t)
+			}
+			return size
+		}
+		if !isStruct(u) {
+			must(fmt.Errorf("unexpected type %s", typ.Name))
+		}
+		size := 0
+		for _, field := range structFields(u) {
+			size += sizeOf(field.typ)
+		}
+		return size
+	}
+}
+
+// underlying returns the underlying type of a defined type like type MyInt int.
+// struct types are their own underlying type because their fields are looked up from the declaration
+func underlying(typ *ast.Object) *ast.Object {
+	spec, ok := typ.Decl.(*ast.TypeSpec)
+	if !ok { // predeclared type
+		return typ
+	}
+	switch spec.Type.(type) {
+	case *ast.StructType, *ast.InterfaceType:
+		return typ
+	}
+	if spec.TypeParams != nil && typ.Data == nil {
+		must(fmt.Errorf("generic type %s is used without instantiation", typ.Name))
+	}
+
+	var u *ast.Object
+	withTypeArgs(instanceTypeArgs(typ), func() {
+		u = underlying(getType(spec.Type))
+	})
+	return u
+}
+
+func isStruct(typ *ast.Object) bool {
+	spec, ok := typ.Decl.(*ast.TypeSpec)
+	if !ok {
+		return false
+	}
+	_, ok = spec.Type.(*ast.StructType)
+	return ok
+}
+
+// structFields returns the fields of a struct type with their offsets.
+// field types of a generic struct are resolved with the type arguments of the instance.
+func structFields(typ *ast.Object) []structField {
+	if !isStruct(typ) {
+		must(fmt.Errorf("%s is not a struct type", typ.Name))
+	}
+	structType := typ.Decl.(*ast.TypeSpec).Type.(*ast.StructType)
+
+	var fields []structField
+	withTypeArgs(instanceTypeArgs(typ), func() {
+		offset := 0
+		for _, field := range structType.Fields.List {
+			fieldType := getType(field.Type)
+			for _, name := range field.Names {
+				fields = append(fields, structField{name: name.Name, typ: fieldType, offset: offset})
+				offset += sizeOf(fieldType)
+			}
+		}
+	})
+	return fields
+}
+
+func lookupField(typ *ast.Object, name string) structField {
+	if isPointer(underlying(typ)) { // p.x is (*p).x
+		typ = elemType(typ)
+	}
+	for _, field := range structFields(underlying(typ)) {
+		if field.name == name {
+			return field
+		}
+	}
+	must(fmt.Errorf("type %s has no field %s", typ.Name, name))
+	return structField{}
+}
+
+// hasPointers reports whether values of typ may hold pointers, which the collector scans
+func hasPointers(typ *ast.Object) bool {
+	u := underlying(typ)
+	if !isStruct(u) {
+		return u != globalInt && u != globalBool && u != globalByte
+	}
+	for _, field := range structFields(u) {
+		if hasPointers(field.typ) {
+			return true
+		}
+	}
+	return false
+}
+
+func isComparable(typ *ast.Object) bool {
+	u := underlying(typ)
+	if !isStruct(u) {
+		return u == globalInt || u == globalString || u == globalBool || u == globalByte || isPointer(u)
+	}
+	for _, field := range structFields(u) {
+		if !isComparable(field.typ) {
+			return false
+		}
+	}
+	return true
+}
+
+// typeString returns the name of typ used in symbols. declared types are qualified by their package. e.g. main.MyInt
+func typeString(typ *ast.Object) string {
+	if universe.Lookup(typ.Name) == typ { // error
+		return typ.Name
+	}
+	if _, ok := typ.Decl.(*ast.TypeSpec); ok && typ.Data == nil {
+		return pkgOf(typ).path + "." + typ.Name
+	}
+	if isPointer(typ) {
+		return "*" + typeString(elemType(typ))
+	}
+	if isSlice(typ) {
+		return "[]" + typeString(sliceElem(typ))
+	}
+	return typ.Name
+}
+
+// pointerTo returns the pointer type *elem. the same object is returned for the same element type
+func pointerTo(elem *ast.Object) *ast.Object {
+	if typ, ok := pointerTypes[elem]; ok {
+		return typ
+	}
+	typ := &ast.Object{
+		Kind: ast.Typ,
+		Name: "*" + elem.Name,
+		Decl: nil,
+		Data: &pointerType{elem: elem},
+		Type: nil,
+	}
+	pointerTypes[elem] = typ
+	return typ
+}
+
+func isPointer(typ *ast.Object) bool {
+	_, ok := typ.Data.(*pointerType)
+	return ok
+}
+
+// elemType returns T of a pointer type *T
+func elemType(typ *ast.Object) *ast.Object {
+	ptr, ok := underlying(typ).Data.(*pointerType)
+	if !ok {
+		must(fmt.Errorf("invalid indirect of %s", typ.Name))
+	}
+	return ptr.elem
+}
+
+// sliceOf returns the slice type []elem. the same object is returned for the same element type
+func sliceOf(elem *ast.Object) *ast.Object {
+	if typ, ok := sliceTypes[elem]; ok {
+		return typ
+	}
+	typ := &ast.Object{
+		Kind: ast.Typ,
+		Name: "[]" + elem.Name,
+		Decl: nil,
+		Data: &sliceType{elem: elem},
+		Type: nil,
+	}
+	sliceTypes[elem] = typ
+	return typ
+}
+
+func isSlice(typ *ast.Object) bool {
+	_, ok := typ.Data.(*sliceType)
+	return ok
+}
+
+// sliceElem returns T of a slice type []T
+func sliceElem(typ *ast.Object) *ast.Object {
+	slice, ok := underlying(typ).Data.(*sliceType)
+	if !ok {
+		must(fmt.Errorf("%s is not a slice", typ.Name))
+	}
+	return slice.elem
+}
+
+// elemSize returns the size of an element of a slice of elem in memory. bytes are packed, other values take words
+func elemSize(elem *ast.Object) int {
+	if underlying(elem) == globalByte {
+		return 1
+	}
+	return sizeOf(elem)
+}
+
+// isInterface reports whether typ is any or an interface type declared like type Reader interface { ... }
+func isInterface(typ *ast.Object) bool {
+	u := underlying(typ)
+	if u == globalAny {
+		return true
+	}
+	spec, ok := u.Decl.(*ast.TypeSpec)
+	if !ok {
+		return false
+	}
+	_, ok = spec.Type.(*ast.InterfaceType)
+	return ok
+}
+
+// tupleOf returns the type of multiple results
+func tupleOf(types []*ast.Object) *ast.Object {
+	var names []string
+	for _, t := range types {
+		names = append(names, t.Name)
+	}
+	return &ast.Object{
+		Kind: ast.Typ,
+		Name: "(" + strings.Join(names, ", ") + ")",
+		Decl: nil,
+		Data: &tupleType{types: types},
+		Type: nil,
+	}
+}
+
+func isTuple(typ *ast.Object) bool {
+	_, ok := typ.Data.(*tupleType)
+	return ok
+}
+
+// tupleTypes returns the types of the values of a tuple, or typ itself if it is a single value
+func tupleTypes(typ *ast.Object) []*ast.Object {
+	tuple, ok := typ.Data.(*tupleType)
+	if !ok {
+		return []*ast.Object{typ}
+	}
+	return tuple.types
+}
+
+// isTypeExpr reports whether expr denotes a type rather than a value. e.g. T in *T
+func isTypeExpr(expr ast.Expr) bool {
+	switch e := expr.(type) {
+	case *ast.Ident:
+		return e.Obj != nil && e.Obj.Kind == ast.Typ
+	case *ast.ParenExpr:
+		return isTypeExpr(e.X)
+	case *ast.StarExpr:
+		return isTypeExpr(e.X)
+	case *ast.IndexExpr:
+		return isTypeExpr(e.X)
+	case *ast.IndexListExpr:
+		return isTypeExpr(e.X)
+	case *ast.ArrayType, *ast.InterfaceType:
+		return true
+	case *ast.SelectorExpr:
+		if ident := qualifiedIdent(e); ident != nil {
+			return isTypeExpr(ident)
+		}
+	}
+	return false
+}
@@ -0,0 +1,1386 @@
+package main
+
+import (
+	"fmt"
+	"math/bits"
+	"strconv"
+	"strings"
+)
+
+// the wasm backend assembles the output to a WebAssembly module for WASI preview 1, like the wasm port of Go does
+// with its own instructions. the functions lowered to the IR are emitted by wasmIR in instructions of wasm on locals,
+// and the runtime in x86-64 is assembled an instruction at a time. each function of the assembly, which is a label called
+// or whose address is taken, is a function of wasm: calls and returns are call and return of wasm, indirect calls are
+// call_indirect of the table of the functions, and the addresses of the functions are their indexes in the table.
+// the jumps in a function set pc to the block of the label and branch to a loop dispatching pc with br_table,
+// and the jumps to other functions and the code falling through to the next function are tail calls.
+// the registers of x86-64 are globals of the module and each instruction of them computes on the operand stack of wasm.
+// the stack of the compiled code, which the stack machine of emitExpr pushes values to, is in the linear memory
+// below the arguments and the environment like on Linux, so the frames and the collector work as they are.
+// a call pushes a word to the stack for the return address, which is 0, to keep the frames of x86-64.
+// syscalls are made by $syscall, which maps write, read, open, close and exit to WASI and mmap to memory.grow
+const (
+	wasmPage      = 1 << 16
+	wasmDataStart = 4096    // the address of the data of the assembly. the addresses below are the scratch memory of $syscall
+	wasmStackSize = 8 << 20 // like the default of Linux
+	wasmArgsSize  = 1 << 20 // the arguments and the environment above the stack
+
+	wasmIovec      = 0  // the iovec of fd_write and fd_read
+	wasmResult     = 8  // the result of fd_write, fd_read and path_open
+	wasmErrnoTable = 16 // wasmErrnos
+	wasmDot        = 96 // "." to open the preopened directory
+	wasmArgSizes   = 104
+	wasmFdstat     = 120 // the fdstat of fd_fdstat_get
+
+	wasmReadRights  = 1<<1 | 1<<14               // fd_read and fd_readdir
+	wasmWriteRights = 1<<0 | 1<<6 | 1<<8 | 1<<22 // fd_datasync, fd_write, fd_allocate and fd_filestat_set_size
+)
+
+// wasmErrnos are the Linux errno of the WASI errno
+var wasmErrnos = []byte{
+	0, 7, 13, 98, 99, 97, 11, 114, 9, 74, 16, 125, 10, 103, 111, 104, 35, 89, 33, 122, 17, 14, 27, 113, 43, 84, 115, 4,
+	22, 5, 106, 21, 40, 24, 31, 90, 72, 36, 100, 102, 101, 23, 105, 19, 2, 8, 37, 67, 12, 42, 92, 28, 38, 107, 20, 39,
+	131, 88, 95, 25, 6, 75, 130, 1, 32, 71, 93, 91, 34, 30, 29, 3, 116, 110, 26, 18, 13,
+}
+
+// the functions of the module. the imports come first
+const (
+	wasmFdWrite = iota
+	wasmFdRead
+	wasmFdClose
+	wasmProcExit
+	wasmArgsSizesGet
+	wasmArgsGet
+	wasmEnvironSizesGet
+	wasmEnvironGet
+	wasmPathOpen
+	wasmFdFdstatGet
+	wasmSyscall
+	wasmStart // the functions of the assembly follow
+)
+
+var wasmImports = []struct {
+	name string
+	typ  int
+}{
+	{"fd_write", 0}, {"fd_read", 0}, {"fd_close", 1}, {"proc_exit", 2},
+	{"args_sizes_get", 3}, {"args_get", 3}, {"environ_sizes_get", 3}, {"environ_get", 3}, {"path_open", 6},
+	{"fd_fdstat_get", 3},
+}
+
+const (
+	wasmI32 = 0x7f
+	wasmI64 = 0x7e
+)
+
+// wasmTypes are the types of the functions by the index in wasmImports
+var wasmTypes = [][2][]byte{
+	{{wasmI32, wasmI32, wasmI32, wasmI32}, {wasmI32}},
+	{{wasmI32}, {wasmI32}},
+	{{wasmI32}, nil},
+	{{wasmI32, wasmI32}, {wasmI32}},
+	{nil, nil},
+	{{wasmI64, wasmI64, wasmI64, wasmI64, wasmI64}, {wasmI64}},
+	{{wasmI32, wasmI32, wasmI32, wasmI32, wasmI32, wasmI64, wasmI64, wasmI32, wasmI32}, {wasmI32}},
+}
+
+var wasmOps = map[string][]byte{
+	"unreachable": {0x00}, "block": {0x02, 0x40}, "loop": {0x03, 0x40}, "if": {0x04, 0x40}, "end": {0x0b},
+	"return": {0x0f}, "drop": {0x1a}, "select": {0x1b}, "memory.grow": {0x40, 0x00},
+	"i32.eqz": {0x45}, "i32.eq": {0x46}, "i32.add": {0x6a}, "i32.mul": {0x6c},
+	"i64.eqz": {0x50}, "i64.eq": {0x51}, "i64.ne": {0x52}, "i64.lt_s": {0x53}, "i64.lt_u": {0x54}, "i64.gt_s": {0x55},
+	"i64.gt_u": {0x56}, "i64.le_s": {0x57}, "i64.le_u": {0x58}, "i64.ge_s": {0x59}, "i64.ge_u": {0x5a},
+	"i64.clz": {0x79}, "i64.add": {0x7c}, "i64.sub": {0x7d}, "i64.mul": {0x7e}, "i64.div_s": {0x7f}, "i64.div_u": {0x80},
+	"i64.rem_s": {0x81}, "i64.rem_u": {0x82}, "i64.and": {0x83}, "i64.or": {0x84}, "i64.xor": {0x85},
+	"i64.shl": {0x86}, "i64.shr_s": {0x87}, "i64.shr_u": {0x88},
+	"i32.wrap_i64": {0xa7}, "i64.extend_i32_u": {0xad},
+	"i64.extend8_s": {0xc2}, "i64.extend16_s": {0xc3}, "i64.extend32_s": {0xc4},
+	"memory.copy": {0xfc, 10, 0, 0}, "memory.fill": {0xfc, 11, 0},
+}
+
+var wasmMemOps = map[string]byte{
+	"i32.load": 0x28, "i64.load": 0x29, "i32.load8_u": 0x2d, "i64.load8_u": 0x31, "i64.load16_u": 0x33, "i64.load32_u": 0x35,
+	"i32.store": 0x36, "i64.store": 0x37, "i64.store8": 0x3c, "i64.store16": 0x3d, "i64.store32": 0x3e,
+}
+
+// wasmCode is the code of a function
+type wasmCode []byte
+
+func (c *wasmCode) op(names ...string) {
+	for _, name := range names {
+		b, ok := wasmOps[name]
+		if !ok {
+			must(fmt.Errorf("wasm: unknown instruction %s", name))
+		}
+		*c = append(*c, b...)
+	}
+}
+
+// mem appends a load or a store at the address on the stack
+func (c *wasmCode) mem(name string) {
+	*c = append(*c, wasmMemOps[name], 0)
+	*c = wasmUleb(*c, 0)
+}
+
+func (c *wasmCode) i32(v int32) {
+	*c = wasmSleb(append(*c, 0x41), int64(v))
+}
+
+func (c *wasmCode) i64(v int64) {
+	*c = wasmSleb(append(*c, 0x42), v)
+}
+
+func (c *wasmCode) get(local int) { *c = wasmUleb(append(*c, 0x20), uint64(local)) }
+func (c *wasmCode) set(local int) { *c = wasmUleb(append(*c, 0x21), uint64(local)) }
+func (c *wasmCode) tee(local int) { *c = wasmUleb(append(*c, 0x22), uint64(local)) }
+func (c *wasmCode) br(depth int)  { *c = wasmUleb(append(*c, 0x0c), uint64(depth)) }
+func (c *wasmCode) brIf(depth int) {
+	*c = wasmUleb(append(*c, 0x0d), uint64(depth))
+}
+func (c *wasmCode) call(f int) { *c = wasmUleb(append(*c, 0x10), uint64(f)) }
+
+// callIndirect calls the function of the table at the i32 on the stack, which has the type typ
+func (c *wasmCode) callIndirect(typ int) { *c = append(wasmUleb(append(*c, 0x11), uint64(typ)), 0) }
+
+func wasmUleb(b []byte, v uint64) []byte {
+	for {
+		c := byte(v & 0x7f)
+		v >>= 7
+		if v == 0 {
+			return append(b, c)
+		}
+		b = append(b, c|0x80)
+	}
+}
+
+func wasmSleb(b []byte, v int64) []byte {
+	for {
+		c := byte(v & 0x7f)
+		v >>= 7
+		if v == 0 && c&0x40 == 0 || v == -1 && c&0x40 != 0 {
+			return append(b, c)
+		}
+		b = append(b, c|0x80)
+	}
+}
+
+func wasmName(b []byte, s string) []byte {
+	return append(wasmUleb(b, uint64(len(s))), s...)
+}
+
+func wasmSection(b []byte, id byte, content []byte) []byte {
+	return append(wasmUleb(append(b, id), uint64(len(content))), content...)
+}
+
+// the registers of x86-64 are the globals by their numbers in the encoding of the instructions,
+// and the numbers from wasmLocals are the locals of the functions
+const (
+	wasmFlagL = wasmLocals + iota // the operands of the last cmp like riscv64, as the flags of x86-64
+	wasmFlagR
+	wasmT // scratch
+	wasmU
+	wasmPC // i32 locals
+	wasmA
+	wasmP
+	wasmQ
+	wasmN
+	wasmK
+	wasmFP // i64 locals of the functions emitted by wasmIR: the frame pointer and the registers of the IR
+	wasmV
+
+	wasmLocals = 16
+	wasmVars   = 16 // the registers of the IR in locals
+)
+
+var wasmRegs = map[string]int{
+	"rax": 0, "rcx": 1, "rdx": 2, "rbx": 3, "rsp": 4, "rbp": 5, "rsi": 6, "rdi": 7,
+	"r8": 8, "r9": 9, "r10": 10, "r11": 11, "r12": 12, "r13": 13, "r14": 14, "r15": 15,
+}
+
+// wasmBlock is the lines of a block from a label to the next
+type wasmBlock struct {
+	start, end int
+}
+
+// wasmFunc is the blocks of a function from its label to the label of the next function
+type wasmFunc struct {
+	name   string
+	blocks []wasmBlock
+}
+
+// wasmLabel is the function and the block of a label of the code
+type wasmLabel struct {
+	fn, block int
+}
+
+type wasmAssembler struct {
+	lines   []*asmLine
+	funcs   []*wasmFunc
+	labels  map[string]wasmLabel // the functions and the blocks of the labels of the code
+	symbols map[string]int64     // the addresses of the labels of the data
+	data    []byte               // the memory from wasmDataStart
+	bodies  [][]byte             // the code of $_start and the functions
+	code    wasmCode
+	fn      int // the function being assembled
+	cur     int // the block being assembled
+	nest    int // the blocks, loops and ifs opened in the block
+}
+
+func assembleWasm(lines []*asmLine) []byte {
+	w := &wasmAssembler{lines: lines, labels: map[string]wasmLabel{}, symbols: map[string]int64{}}
+	w.layout()
+	w.start()
+	for i := range w.funcs {
+		w.function(i)
+	}
+
+	var b []byte
+	b = append(b, 0, 'a', 's', 'm', 1, 0, 0, 0)
+
+	var types []byte
+	types = wasmUleb(types, uint64(len(wasmTypes)))
+	for _, t := range wasmTypes {
+		types = append(types, 0x60)
+		types = append(wasmUleb(types, uint64(len(t[0]))), t[0]...)
+		types = append(wasmUleb(types, uint64(len(t[1]))), t[1]...)
+	}
+	b = wasmSection(b, 1, types)
+
+	var imports []byte
+	imports = wasmUleb(imports, uint64(len(wasmImports)))
+	for _, imp := range wasmImports {
+		imports = wasmName(imports, "wasi_snapshot_preview1")
+		imports = wasmName(imports, imp.name)
+		imports = append(imports, 0x00, byte(imp.typ))
+	}
+	b = wasmSection(b, 2, imports)
+
+	funcs := wasmUleb(nil, uint64(2+len(w.funcs)))
+	funcs = append(funcs, 5, 4) // $syscall and $_start
+	for range w.funcs {
+		funcs = append(funcs, 4)
+	}
+	b = wasmSection(b, 3, funcs)
+
+	// the table of the functions for call_indirect. the index 0 traps like a call of nil
+	b = wasmSection(b, 4, wasmUleb([]byte{1, 0x70, 0}, uint64(len(w.funcs)+1)))
+
+	stackTop := w.stackTop()
+	b = wasmSection(b, 5, wasmUleb([]byte{1, 0}, uint64((stackTop+wasmArgsSize+wasmPage-1)/wasmPage)))
+
+	globals := []byte{wasmLocals}
+	for i := 0; i < wasmLocals; i++ {
+		globals = append(globals, wasmI64, 1)
+		c := wasmCode(globals)
+		c.i64(0)
+		c.op("end")
+		globals = c
+	}
+	b = wasmSection(b, 6, globals)
+
+	var exports []byte
+	exports = append(exports, 2)
+	exports = append(wasmName(exports, "memory"), 0x02, 0)
+	exports = append(wasmName(exports, "_start"), 0x00, wasmStart)
+	b = wasmSection(b, 7, exports)
+
+	elems := wasmCode{1, 0}
+	elems.i32(1)
+	elems.op("end")
+	elems = wasmUleb(elems, uint64(len(w.funcs)))
+	for i := range w.funcs {
+		elems = wasmUleb(elems, uint64(wasmStart+1+i))
+	}
+	b = wasmSection(b, 9, elems)
+
+	code := wasmUleb(nil, uint64(1+len(w.bodies)))
+	syscall := wasmSyscallCode()
+	code = append(wasmUleb(code, uint64(len(syscall))), syscall...)
+	for _, body := range w.bodies {
+		code = append(wasmUleb(code, uint64(len(body))), body...)
+	}
+	b = wasmSection(b, 10, code)
+
+	var data []byte
+	data = append(data, 3)
+	for _, segment := range []struct {
+		addr  int32
+		bytes []byte
+	}{{wasmErrnoTable, wasmErrnos}, {wasmDot, []byte(".")}, {wasmDataStart, w.data}} {
+		data = append(data, 0)
+		c := wasmCode{}
+		c.i32(segment.addr)
+		c.op("end")
+		data = append(data, c...)
+		data = append(wasmUleb(data, uint64(len(segment.bytes))), segment.bytes...)
+	}
+	b = wasmSection(b, 11, data)
+	return b
+}
+
+func (w *wasmAssembler) stackTop() int64 {
+	return (wasmDataStart+int64(len(w.data))+15)&^15 + wasmStackSize
+}
+
+func isDataDirective(directive string) bool {
+	switch directive {
+	case ".quad", ".byte", ".zero", ".ascii", ".string":
+		return true
+	}
+	return false
+}
+
+// isDataLabel reports whether the label at lines[i] in the .text section is followed by data
+func (w *wasmAssembler) isDataLabel(i int) bool {
+	for _, line := range w.lines[i+1:] {
+		s := strings.TrimSpace(line.text)
+		switch {
+		case s == "", strings.HasPrefix(s, "#"), isAsmLabel(line):
+			continue
+		case line.op == "":
+			directive, _, _ := strings.Cut(s, " ")
+			return isDataDirective(directive)
+		}
+		return false
+	}
+	return false
+}
+
+// layout splits the code to the functions and their blocks, and puts the data to the memory
+func (w *wasmAssembler) layout() {
+	text := true
+	type fixup struct {
+		offset int
+		sym    string
+	}
+	var fixups []fixup
+	type label struct {
+		name string
+		line int
+	}
+	var labels []label // the labels of the code
+	type jump struct {
+		line   int
+		target string
+	}
+	var jumps []jump
+	entries := map[string]bool{"_start": true} // the labels of the functions, which are called or whose addresses are taken
+	for i, line := range w.lines {
+		s := strings.TrimSpace(line.text)
+		switch {
+		case s == "", strings.HasPrefix(s, "#"):
+		case line.native:
+			if len(labels) == 0 {
+				must(fmt.Errorf("wasm: instruction before labels: %s", s))
+			}
+			op, arg, _ := strings.Cut(s, " ")
+			_, err := strconv.ParseInt(arg, 0, 64)
+			switch {
+			case op == "jump":
+				jumps = append(jumps, jump{i, w.symbolName(arg)})
+			case op == "call", op == "i64.const" && err != nil:
+				entries[w.symbolName(arg)] = true
+			}
+		case isAsmLabel(line):
+			name := asmLabel(line)
+			if !text || w.isDataLabel(i) {
+				w.symbols[name] = wasmDataStart + int64(len(w.data))
+				continue
+			}
+			if len(labels) == 0 {
+				entries[name] = true
+			}
+			labels = append(labels, label{name, i})
+		case line.op == "":
+			text2, _ := cutAsmComment(s)
+			directive, args, _ := strings.Cut(text2, " ")
+			switch directive {
+			case ".text":
+				text = true
+			case ".data", ".section": // .section .rodata
+				text = false
+			case ".global":
+			case ".quad":
+				for _, arg := range splitAsmOperands(args) {
+					v, err := strconv.ParseInt(arg, 0, 64)
+					if err != nil {
+						fixups = append(fixups, fixup{len(w.data), arg})
+						entries[w.symbolName(arg)] = true
+					}
+					w.data = append(w.data, make([]byte, 8)...)
+					for j := 0; j < 8; j++ {
+						w.data[len(w.data)-8+j] = byte(v >> (8 * j))
+					}
+				}
+			case ".byte":
+				for _, arg := range splitAsmOperands(args) {
+					v, err := strconv.ParseInt(arg, 0, 64)
+					must(err)
+					w.data = append(w.data, byte(v))
+				}
+			case ".zero":
+				n, err := strconv.Atoi(strings.TrimSpace(args))
+				must(err)
+				w.data = append(w.data, make([]byte, n)...)
+			case ".ascii", ".string":
+				w.data = append(w.data, gasUnquote(strings.TrimSpace(args))...)
+				if directive == ".string" {
+					w.data = append(w.data, 0)
+				}
+			default:
+				must(fmt.Errorf("wasm: unsupported directive %s", directive))
+			}
+		default:
+			if len(labels) == 0 {
+				must(fmt.Errorf("wasm: instruction before labels: %s", s))
+			}
+			for _, arg := range line.args {
+				op := parseX86Operand(arg)
+				switch {
+				case op.sym == "":
+				case line.op[0] == 'j':
+					jumps = append(jumps, jump{i, w.symbolName(op.sym)})
+				default: // calls and the addresses of the functions
+					entries[w.symbolName(op.sym)] = true
+				}
+			}
+		}
+	}
+
+	// the jumps to the labels in other functions are tail calls, so the labels are the entries of functions too
+	for {
+		fn := map[string]string{}
+		name := ""
+		for _, l := range labels {
+			if entries[l.name] {
+				name = l.name
+			}
+			fn[l.name] = name
+		}
+		n := len(entries)
+		k := 0
+		for _, j := range jumps {
+			for k+1 < len(labels) && labels[k+1].line < j.line {
+				k++
+			}
+			if fn[j.target] != fn[labels[k].name] {
+				entries[j.target] = true
+			}
+		}
+		if len(entries) == n {
+			break
+		}
+	}
+
+	for i, l := range labels {
+		if entries[l.name] {
+			w.funcs = append(w.funcs, &wasmFunc{name: l.name})
+		}
+		f := w.funcs[len(w.funcs)-1]
+		end := len(w.lines)
+		if i+1 < len(labels) {
+			end = labels[i+1].line
+		}
+		w.labels[l.name] = wasmLabel{len(w.funcs) - 1, len(f.blocks)}
+		f.blocks = append(f.blocks, wasmBlock{l.line + 1, end})
+	}
+	for _, f := range fixups {
+		v := w.symbol(f.sym)
+		for j := 0; j < 8; j++ {
+			w.data[f.offset+j] = byte(v >> (8 * j))
+		}
+	}
+}
+
+// symbolName returns the name of the symbol in an expression like main.x+8
+func (w *wasmAssembler) symbolName(expr string) string {
+	name, _ := splitAsmSymbol(expr)
+	return name
+}
+
+// symbol returns the value of a symbol like main.x+8, which is the address of data or the index of a function in the table
+func (w *wasmAssembler) symbol(expr string) int64 {
+	name, offset := splitAsmSymbol(expr)
+	if l, ok := w.labels[name]; ok {
+		if w.funcs[l.fn].name != name || offset != 0 {
+			must(fmt.Errorf("wasm: the address of %s is not a function", expr))
+		}
+		return int64(l.fn) + 1
+	}
+	if addr, ok := w.symbols[name]; ok {
+		return addr + offset
+	}
+	must(fmt.Errorf("wasm: undefined symbol %s", name))
+	return 0
+}
+
+// start assembles $_start, which puts the arguments and the environment to the stack like Linux and calls _start of the assembly
+func (w *wasmAssembler) start() {
+	w.code = wasmCode{2, 4, wasmI64, 6, wasmI32}
+	c := &w.code
+	rsp := wasmRegs["rsp"]
+	stackTop := int32(w.stackTop())
+
+	// the pointers and the strings of the arguments from stackTop, and then the environment
+	c.i32(wasmArgSizes)
+	c.i32(wasmArgSizes + 4)
+	c.call(wasmArgsSizesGet)
+	c.op("drop")
+	c.i32(wasmArgSizes + 8)
+	c.i32(wasmArgSizes + 12)
+	c.call(wasmEnvironSizesGet)
+	c.op("drop")
+	c.i32(stackTop)
+	w.set(wasmP)
+	c.i32(wasmArgSizes)
+	c.mem("i32.load")
+	w.set(wasmN)
+	w.get(wasmP) // the strings after the pointers and NULL
+	w.get(wasmN)
+	c.i32(1)
+	c.op("i32.add")
+	c.i32(4)
+	c.op("i32.mul")
+	c.op("i32.add")
+	w.set(wasmQ)
+	w.get(wasmP)
+	w.get(wasmQ)
+	c.call(wasmArgsGet)
+	c.op("drop")
+	w.get(wasmQ)
+	c.i32(wasmArgSizes + 4)
+	c.mem("i32.load")
+	c.op("i32.add")
+	w.set(wasmA)
+	c.i32(wasmArgSizes + 8)
+	c.mem("i32.load")
+	w.set(wasmK)
+	w.get(wasmA)
+	w.get(wasmA)
+	w.get(wasmK)
+	c.i32(1)
+	c.op("i32.add")
+	c.i32(4)
+	c.op("i32.mul")
+	c.op("i32.add")
+	c.call(wasmEnvironGet)
+	c.op("drop")
+
+	// argc, argv, NULL, envp and NULL below stackTop, aligned to 16 bytes
+	c.i64(int64(stackTop))
+	w.get(wasmN)
+	w.get(wasmK)
+	c.op("i32.add")
+	c.i32(3)
+	c.op("i32.add")
+	c.i32(8)
+	c.op("i32.mul")
+	c.op("i64.extend_i32_u")
+	c.op("i64.sub")
+	c.i64(-16)
+	c.op("i64.and")
+	w.tee(rsp)
+	c.op("i32.wrap_i64")
+	w.tee(wasmQ)
+	w.get(wasmN)
+	c.op("i64.extend_i32_u")
+	c.mem("i64.store")
+	w.copyPointers(wasmP, wasmN)
+	w.copyPointers(wasmA, wasmK)
+
+	entry, ok := w.labels["_start"]
+	if !ok {
+		must(fmt.Errorf("wasm: no _start"))
+	}
+	c.call(wasmStart + 1 + entry.fn)
+	c.op("unreachable", "end")
+	w.bodies = append(w.bodies, w.code)
+}
+
+// function assembles the function i, whose blocks are dispatched by pc in a loop
+func (w *wasmAssembler) function(i int) {
+	w.code = wasmCode{3, 4, wasmI64, 6, wasmI32, 1 + wasmVars, wasmI64}
+	c := &w.code
+	w.fn = i
+	f := w.funcs[i]
+	c.op("loop")
+	for range f.blocks {
+		c.op("block")
+	}
+	w.get(wasmPC)
+	*c = wasmUleb(append(*c, 0x0e), uint64(len(f.blocks)))
+	for i := range f.blocks {
+		*c = wasmUleb(*c, uint64(i))
+	}
+	*c = wasmUleb(*c, 0)
+	for i, block := range f.blocks {
+		c.op("end")
+		w.cur = i
+		for j := block.start; j < block.end; j++ {
+			line := w.lines[j]
+			if line.native {
+				w.native(line.text)
+				continue
+			}
+			if line.op == "" {
+				continue
+			}
+			var args []x86Operand
+			for _, arg := range line.args {
+				args = append(args, parseX86Operand(arg))
+			}
+			w.instr(line.op, args, w.lines[j+1:])
+		}
+	}
+	c.op("end")
+	if i+1 < len(w.funcs) { // the code falls through to the next function
+		c.call(wasmStart + 2 + i)
+		c.op("return")
+	} else {
+		c.op("unreachable")
+	}
+	c.op("end")
+	w.bodies = append(w.bodies, w.code)
+}
+
+// copyPointers stores the n i32 pointers at the local src to the i64 words after the local wasmQ, which is moved past them and NULL
+func (w *wasmAssembler) copyPointers(src, n int) {
+	c := &w.code
+	c.op("block", "loop")
+	w.get(wasmQ)
+	c.i32(8)
+	c.op("i32.add")
+	w.set(wasmQ)
+	w.get(n)
+	c.op("i32.eqz")
+	c.brIf(1)
+	w.get(wasmQ)
+	w.get(src)
+	c.mem("i32.load")
+	c.op("i64.extend_i32_u")
+	c.mem("i64.store")
+	w.get(src)
+	c.i32(4)
+	c.op("i32.add")
+	w.set(src)
+	w.get(n)
+	c.i32(-1)
+	c.op("i32.add")
+	w.set(n)
+	c.br(0)
+	c.op("end", "end")
+}
+
+// jump branches to the loop dispatching the block of the label in the function, or tail calls the function of the label
+func (w *wasmAssembler) jump(expr string) {
+	l, ok := w.labels[w.symbolName(expr)]
+	if !ok {
+		must(fmt.Errorf("wasm: undefined label %s", expr))
+	}
+	if l.fn != w.fn {
+		w.code.call(wasmStart + 1 + l.fn)
+		w.code.op("return")
+		return
+	}
+	w.code.i32(int32(l.block))
+	w.set(wasmPC)
+	w.code.br(len(w.funcs[w.fn].blocks) - 1 - w.cur + w.nest)
+}
+
+// native assembles an instruction emitted by wasmIR, which is an instruction of wasm with the locals and the globals
+// by their names and the constants by their symbols, or jump to a label
+func (w *wasmAssembler) native(text string) {
+	c := &w.code
+	op, arg, _ := strings.Cut(strings.TrimSpace(text), " ")
+	switch op {
+	case "local.get", "global.get":
+		w.get(w.variable(arg))
+	case "local.set", "global.set":
+		w.set(w.variable(arg))
+	case "local.tee":
+		w.tee(w.variable(arg))
+	case "i64.const":
+		if v, err := strconv.ParseInt(arg, 0, 64); err == nil {
+			c.i64(v)
+		} else {
+			c.i64(w.symbol(arg))
+		}
+	case "jump":
+		w.jump(arg)
+	case "call":
+		c.call(wasmStart + int(w.symbol(arg)))
+	case "call_indirect":
+		c.callIndirect(4)
+	case "if":
+		c.op("if")
+		w.nest++
+	case "end":
+		c.op("end")
+		w.nest--
+	default:
+		if _, ok := wasmMemOps[op]; ok {
+			c.mem(op)
+		} else {
+			c.op(op)
+		}
+	}
+}
+
+// variable returns the local fp or vN of wasmIR, or the global of a register
+func (w *wasmAssembler) variable(name string) int {
+	if name == "fp" {
+		return wasmFP
+	}
+	if n, err := strconv.Atoi(strings.TrimPrefix(name, "v")); err == nil && name[0] == 'v' {
+		return wasmV + n
+	}
+	return w.reg(name)
+}
+
+// get pushes the register, which is a global, or the local v
+func (w *wasmAssembler) get(v int) {
+	if v < wasmLocals {
+		w.code = wasmUleb(append(w.code, 0x23), uint64(v))
+		return
+	}
+	w.code.get(v - wasmLocals)
+}
+
+// set pops to the register or the local v
+func (w *wasmAssembler) set(v int) {
+	if v < wasmLocals {
+		w.code = wasmUleb(append(w.code, 0x24), uint64(v))
+		return
+	}
+	w.code.set(v - wasmLocals)
+}
+
+func (w *wasmAssembler) tee(v int) {
+	if v < wasmLocals {
+		w.set(v)
+		w.get(v)
+		return
+	}
+	w.code.tee(v - wasmLocals)
+}
+
+// add adds n to the i64 register or local v
+func (w *wasmAssembler) add(v int, n int64) {
+	w.get(v)
+	w.code.i64(n)
+	w.code.op("i64.add")
+	w.set(v)
+}
+
+func (w *wasmAssembler) reg(r string) int {
+	l, ok := wasmRegs[r]
+	if !ok {
+		must(fmt.Errorf("wasm: unknown register %s", r))
+	}
+	return l
+}
+
+// addr pushes the address of the memory operand m as i64
+func (w *wasmAssembler) addr(m x86Operand) {
+	c := &w.code
+	v := m.disp
+	if m.sym != "" {
+		v += w.symbol(m.sym)
+	}
+	c.i64(v)
+	if m.base != "" && m.base != "rip" {
+		w.get(w.reg(m.base))
+		c.op("i64.add")
+	}
+	if m.index != "" {
+		w.get(w.reg(m.index))
+		c.i64(int64(bits.TrailingZeros(uint(m.scale))))
+		c.op("i64.shl", "i64.add")
+	}
+}
+
+// read pushes the operand as i64. memory is zero extended from width bits
+func (w *wasmAssembler) read(op x86Operand, width int) {
+	c := &w.code
+	switch {
+	case op.reg != "":
+		w.get(w.reg(op.reg))
+	case op.isImm:
+		c.i64(op.imm)
+	default:
+		w.addr(op)
+		c.op("i32.wrap_i64")
+		c.mem(map[int]string{8: "i64.load8_u", 16: "i64.load16_u", 32: "i64.load32_u", 64: "i64.load"}[width])
+	}
+}
+
+// write pops an i64 to the operand of width bits. bytes of registers keep the other bits like x86-64,
+// while 32 bits are zero extended
+func (w *wasmAssembler) write(op x86Operand, width int) {
+	c := &w.code
+	if op.reg == "" {
+		w.set(wasmT)
+		w.addr(op)
+		c.op("i32.wrap_i64")
+		w.get(wasmT)
+		c.mem(map[int]string{8: "i64.store8", 16: "i64.store16", 32: "i64.store32", 64: "i64.store"}[width])
+		return
+	}
+	r := w.reg(op.reg)
+	switch width {
+	case 8, 16:
+		mask := int64(1)<<width - 1
+		w.set(wasmT)
+		w.get(r)
+		c.i64(^mask)
+		c.op("i64.and")
+		w.get(wasmT)
+		c.i64(mask)
+		c.op("i64.and", "i64.or")
+	case 32:
+		c.i64(0xffffffff)
+		c.op("i64.and")
+	}
+	w.set(r)
+}
+
+// sext sign extends the low width bits of the i64 on the stack
+func (w *wasmAssembler) sext(width int) {
+	switch width {
+	case 8:
+		w.code.op("i64.extend8_s")
+	case 16:
+		w.code.op("i64.extend16_s")
+	case 32:
+		w.code.op("i64.extend32_s")
+	}
+}
+
+// setFlags keeps the operands of cmp src, dst as the flags
+func (w *wasmAssembler) setFlags(src, dst x86Operand, width int) {
+	w.read(dst, width)
+	w.sext(width)
+	w.set(wasmFlagL)
+	w.read(src, width)
+	w.sext(width)
+	w.set(wasmFlagR)
+}
+
+// setResultFlags pops the result of width bits and sets the flags like test, which compares it with 0
+func (w *wasmAssembler) setResultFlags(width int) {
+	w.sext(width)
+	w.set(wasmFlagL)
+	w.code.i64(0)
+	w.set(wasmFlagR)
+}
+
+// cond pushes cond of the flags as i32
+func (w *wasmAssembler) cond(cond string) {
+	c := &w.code
+	w.get(wasmFlagL)
+	w.get(wasmFlagR)
+	switch cond {
+	case "mi", "pl":
+		c.op("i64.sub")
+		c.i64(0)
+		c.op(map[string]string{"mi": "i64.lt_s", "pl": "i64.ge_s"}[cond])
+	default:
+		c.op(map[string]string{
+			"eq": "i64.eq", "ne": "i64.ne", "lt": "i64.lt_s", "le": "i64.le_s", "gt": "i64.gt_s", "ge": "i64.ge_s",
+			"lo": "i64.lt_u", "ls": "i64.le_u", "hi": "i64.gt_u", "hs": "i64.ge_u",
+		}[cond])
+	}
+}
+
+func (w *wasmAssembler) instr(op string, args []x86Operand, rest []*asmLine) {
+	c := &w.code
+	rax, rcx, rdx, rsp, rbp := 0, 1, 2, 4, 5
+	switch op {
+	case "ret":
+		w.add(rsp, 8)
+		c.op("return")
+		return
+	case "leave":
+		w.get(rbp)
+		w.tee(rsp)
+		c.op("i32.wrap_i64")
+		c.mem("i64.load")
+		w.set(rbp)
+		w.add(rsp, 8)
+		return
+	case "syscall":
+		for _, r := range []string{"rax", "rdi", "rsi", "rdx", "r10"} {
+			w.get(w.reg(r))
+		}
+		c.call(wasmSyscall)
+		w.set(rax)
+		return
+	case "cqto", "cqo":
+		w.get(rax)
+		c.i64(63)
+		c.op("i64.shr_s")
+		w.set(rdx)
+		return
+	case "callq", "call":
+		indirect := args[0].reg != "" || args[0].mem
+		if indirect {
+			w.read(args[0], 64)
+			c.op("i32.wrap_i64")
+			w.set(wasmA)
+		}
+		w.add(rsp, -8) // the return address
+		w.get(rsp)
+		c.op("i32.wrap_i64")
+		c.i64(0)
+		c.mem("i64.store")
+		if indirect {
+			w.get(wasmA)
+			c.callIndirect(4)
+		} else {
+			c.call(wasmStart + int(w.symbol(args[0].sym)))
+		}
+		return
+	case "jmp":
+		if args[0].reg != "" { // a tail call of the function
+			w.get(w.reg(args[0].reg))
+			c.op("i32.wrap_i64")
+			c.callIndirect(4)
+			c.op("return")
+			return
+		}
+		w.jump(args[0].sym)
+		return
+	case "rep", "repe":
+		w.repeat(op + " " + args[0].sym)
+		return
+	case "movzbq", "movzbl", "movzwq", "movzwl", "movsbq", "movsbl", "movswq", "movslq":
+		from := map[byte]int{'b': 8, 'w': 16, 'l': 32}[op[4]]
+		w.read(args[0], from)
+		if op[3] == 's' {
+			w.sext(from)
+		} else if args[0].reg != "" {
+			c.i64(int64(1)<<from - 1)
+			c.op("i64.and")
+		}
+		w.write(args[1], x86Width(op))
+		return
+	}
+	if cond, ok := arm64Conds[strings.TrimPrefix(op, "j")]; ok && op[0] == 'j' {
+		w.cond(cond)
+		c.op("if")
+		w.nest++
+		w.jump(args[0].sym)
+		w.nest--
+		c.op("end")
+		return
+	}
+	if cond, ok := arm64Conds[strings.TrimPrefix(op, "set")]; ok && strings.HasPrefix(op, "set") {
+		w.cond(cond)
+		c.op("i64.extend_i32_u")
+		w.write(args[0], 8)
+		return
+	}
+	if cond, ok := arm64Conds[strings.TrimSuffix(strings.TrimPrefix(op, "cmov"), "q")]; ok && strings.HasPrefix(op, "cmov") {
+		w.cond(cond)
+		c.op("if")
+		w.read(args[0], 64)
+		w.write(args[1], 64)
+		c.op("end")
+		return
+	}
+
+	width := x86Width(op)
+	base := op[:len(op)-1]
+	switch base {
+	case "mov":
+		w.read(args[0], width)
+		w.write(args[1], width)
+	case "lea":
+		w.addr(args[0])
+		w.write(args[1], 64)
+	case "push":
+		w.read(args[0], 64)
+		w.set(wasmU)
+		w.add(rsp, -8)
+		w.get(rsp)
+		c.op("i32.wrap_i64")
+		w.get(wasmU)
+		c.mem("i64.store")
+	case "pop":
+		w.get(rsp)
+		c.op("i32.wrap_i64")
+		c.mem("i64.load")
+		w.set(wasmU)
+		w.add(rsp, 8)
+		w.get(wasmU)
+		w.write(args[0], 64)
+	case "add", "sub", "and", "or", "xor":
+		flags := flagsRead(rest)
+		if flags && base == "sub" { // sub sets the flags like cmp
+			w.setFlags(args[0], args[1], width)
+			flags = false
+		}
+		w.read(args[1], width)
+		w.read(args[0], width)
+		c.op("i64." + base)
+		w.writeResult(args[1], width, flags)
+	case "cmp":
+		w.setFlags(args[0], args[1], width)
+	case "test":
+		w.read(args[1], width)
+		w.read(args[0], width)
+		c.op("i64.and")
+		w.setResultFlags(width)
+	case "inc", "dec", "neg", "not":
+		flags := base != "not" && flagsRead(rest)
+		switch base {
+		case "inc", "dec":
+			w.read(args[0], width)
+			c.i64(map[string]int64{"inc": 1, "dec": -1}[base])
+			c.op("i64.add")
+		case "neg":
+			if flags { // like cmp of the operand and 0
+				c.i64(0)
+				w.set(wasmFlagL)
+				w.read(args[0], width)
+				w.sext(width)
+				w.set(wasmFlagR)
+				flags = false
+			}
+			c.i64(0)
+			w.read(args[0], width)
+			c.op("i64.sub")
+		case "not":
+			w.read(args[0], width)
+			c.i64(-1)
+			c.op("i64.xor")
+		}
+		w.writeResult(args[0], width, flags)
+	case "imul":
+		if len(args) == 3 { // imulq $n, src, dst
+			w.read(args[1], 64)
+			w.read(args[0], 64)
+			c.op("i64.mul")
+			w.write(args[2], 64)
+			return
+		}
+		w.read(args[1], 64)
+		w.read(args[0], 64)
+		c.op("i64.mul")
+		w.write(args[1], 64)
+	case "idiv", "div":
+		sign := map[string]string{"idiv": "_s", "div": "_u"}[base]
+		w.read(args[0], 64)
+		w.set(wasmU)
+		w.get(rax)
+		w.get(wasmU)
+		c.op("i64.div" + sign)
+		w.get(rax)
+		w.get(wasmU)
+		c.op("i64.rem" + sign)
+		w.set(rdx)
+		w.set(rax)
+	case "shl", "sal", "shr", "sar":
+		dst := args[len(args)-1]
+		w.read(dst, width)
+		switch {
+		case len(args) == 1:
+			c.i64(1)
+		case args[0].isImm:
+			c.i64(args[0].imm)
+		default: // %cl
+			w.get(rcx)
+		}
+		c.op(map[string]string{"shl": "i64.shl", "sal": "i64.shl", "shr": "i64.shr_u", "sar": "i64.shr_s"}[base])
+		w.writeResult(dst, width, flagsRead(rest))
+	case "bts", "btr":
+		// the bit string at the memory operand, indexed by a register
+		bit := w.reg(args[0].reg)
+		w.addr(args[1])
+		w.get(bit)
+		c.i64(6)
+		c.op("i64.shr_u")
+		c.i64(3)
+		c.op("i64.shl", "i64.add", "i32.wrap_i64")
+		w.tee(wasmA)
+		w.get(wasmA)
+		c.mem("i64.load")
+		c.i64(1)
+		w.get(bit)
+		c.op("i64.shl")
+		if base == "bts" {
+			c.op("i64.or")
+		} else {
+			c.i64(-1)
+			c.op("i64.xor", "i64.and")
+		}
+		c.mem("i64.store")
+	case "bsr":
+		c.i64(63)
+		w.read(args[0], 64)
+		c.op("i64.clz", "i64.sub")
+		w.write(args[1], 64)
+	default:
+		must(fmt.Errorf("wasm: unsupported instruction %s", op))
+	}
+}
+
+// writeResult pops the result to dst, and sets the flags from it if they are read
+func (w *wasmAssembler) writeResult(dst x86Operand, width int, flags bool) {
+	if !flags {
+		w.write(dst, width)
+		return
+	}
+	w.tee(wasmU)
+	w.write(dst, width)
+	w.get(wasmU)
+	w.setResultFlags(width)
+}
+
+// repeat lowers rep movsb and rep stosb to memory.copy and memory.fill, and repe cmpsb to a loop
+func (w *wasmAssembler) repeat(op string) {
+	c := &w.code
+	rax, rcx, rsi, rdi := 0, 1, 6, 7
+	switch op {
+	case "rep movsb":
+		w.get(rdi)
+		c.op("i32.wrap_i64")
+		w.get(rsi)
+		c.op("i32.wrap_i64")
+		w.get(rcx)
+		c.op("i32.wrap_i64", "memory.copy")
+		w.get(rsi)
+		w.get(rcx)
+		c.op("i64.add")
+		w.set(rsi)
+	case "rep stosb":
+		w.get(rdi)
+		c.op("i32.wrap_i64")
+		w.get(rax)
+		c.op("i32.wrap_i64")
+		w.get(rcx)
+		c.op("i32.wrap_i64", "memory.fill")
+	case "repe cmpsb":
+		c.op("block")
+		w.get(rcx)
+		c.op("i64.eqz")
+		c.brIf(0)
+		c.op("loop")
+		w.get(rsi)
+		c.op("i32.wrap_i64")
+		c.mem("i64.load8_u")
+		w.set(wasmFlagL)
+		w.get(rdi)
+		c.op("i32.wrap_i64")
+		c.mem("i64.load8_u")
+		w.set(wasmFlagR)
+		w.add(rsi, 1)
+		w.add(rdi, 1)
+		w.add(rcx, -1)
+		w.get(wasmFlagL)
+		w.get(wasmFlagR)
+		c.op("i64.ne")
+		c.brIf(1)
+		w.get(rcx)
+		c.op("i64.eqz", "i32.eqz")
+		c.brIf(0)
+		c.op("end", "end")
+		return
+	default:
+		must(fmt.Errorf("wasm: unsupported instruction %s", op))
+	}
+	w.get(rdi)
+	w.get(rcx)
+	c.op("i64.add")
+	w.set(rdi)
+	c.i64(0)
+	w.set(rcx)
+}
+
+// wasmSyscallCode returns $syscall, which makes the x86-64 syscall of the number and the arguments in rdi, rsi, rdx and r10.
+// unknown numbers return -ENOSYS
+func wasmSyscallCode() []byte {
+	c := &wasmCode{}
+	*c = append(*c, 2, 4, wasmI32, 1, wasmI64)
+	nr, a, b, errno, path, n, oflags, rights := 0, 1, 2, 5, 6, 7, 8, 9
+	is := func(v int64) {
+		c.get(nr)
+		c.i64(v)
+		c.op("i64.eq", "if")
+	}
+	// errnoReturn returns -errno of Linux for the errno of WASI on the stack if it isn't 0
+	errnoReturn := func() {
+		c.tee(errno)
+		c.op("if")
+		c.i64(0)
+		c.get(errno)
+		*c = append(*c, wasmMemOps["i32.load8_u"], 0)
+		*c = wasmUleb(*c, wasmErrnoTable)
+		c.op("i64.extend_i32_u", "i64.sub", "return", "end")
+	}
+
+	for _, f := range []struct {
+		nr   int64
+		call int
+	}{{1, wasmFdWrite}, {0, wasmFdRead}} {
+		is(f.nr)
+		c.i32(wasmIovec)
+		c.get(b)
+		c.op("i32.wrap_i64")
+		c.mem("i32.store")
+		c.i32(wasmIovec + 4)
+		c.get(3)
+		c.op("i32.wrap_i64")
+		c.mem("i32.store")
+		c.get(a)
+		c.op("i32.wrap_i64")
+		c.i32(wasmIovec)
+		c.i32(1)
+		c.i32(wasmResult)
+		c.call(f.call)
+		if f.nr == 0 { // a directory can't be read in WASI, while it is EISDIR on Linux
+			c.tee(errno)
+			c.i32(76) // ENOTCAPABLE
+			c.op("i32.eq", "if")
+			c.get(a)
+			c.op("i32.wrap_i64")
+			c.i32(wasmFdstat)
+			c.call(wasmFdFdstatGet)
+			c.op("drop")
+			c.i32(wasmFdstat)
+			*c = append(*c, wasmMemOps["i32.load8_u"], 0, 0)
+			c.i32(3) // FILETYPE_DIRECTORY
+			c.op("i32.eq", "if")
+			c.i64(-21) // EISDIR
+			c.op("return", "end", "end")
+			c.get(errno)
+		}
+		errnoReturn()
+		c.i32(wasmResult)
+		c.mem("i32.load")
+		c.op("i64.extend_i32_u", "return", "end")
+	}
+
+	is(3) // close
+	c.get(a)
+	c.op("i32.wrap_i64")
+	c.call(wasmFdClose)
+	errnoReturn()
+	c.i64(0)
+	c.op("return", "end")
+
+	is(9) // mmap grows the memory by the pages of the length
+	c.get(b)
+	c.i64(wasmPage - 1)
+	c.op("i64.add")
+	c.i64(16)
+	c.op("i64.shr_u", "i32.wrap_i64", "memory.grow")
+	c.tee(errno)
+	c.i32(-1)
+	c.op("i32.eq", "if")
+	c.i64(-12) // ENOMEM
+	c.op("return", "end")
+	c.get(errno)
+	c.op("i64.extend_i32_u")
+	c.i64(16)
+	c.op("i64.shl", "return", "end")
+
+	for _, v := range []int64{60, 231} { // exit and exit_group
+		is(v)
+		c.get(a)
+		c.op("i32.wrap_i64")
+		c.call(wasmProcExit)
+		c.op("unreachable", "end")
+	}
+
+	// open opens the path relative to the preopened directory of / by path_open
+	is(2)
+	c.get(a)
+	c.op("i32.wrap_i64")
+	c.tee(path)
+	*c = append(*c, wasmMemOps["i32.load8_u"], 0, 0)
+	c.i32('/')
+	c.op("i32.eq", "if")
+	c.get(path)
+	c.i32(1)
+	c.op("i32.add")
+	c.set(path)
+	c.op("end")
+	c.op("block", "loop") // strlen
+	c.get(path)
+	c.get(n)
+	c.op("i32.add")
+	*c = append(*c, wasmMemOps["i32.load8_u"], 0, 0)
+	c.op("i32.eqz")
+	c.brIf(1)
+	c.get(n)
+	c.i32(1)
+	c.op("i32.add")
+	c.set(n)
+	c.br(0)
+	c.op("end", "end")
+	c.get(n)
+	c.op("i32.eqz", "if")
+	c.i32(wasmDot)
+	c.set(path)
+	c.i32(1)
+	c.set(n)
+	c.op("end")
+	// O_CREAT, O_EXCL and O_TRUNC to the oflags of WASI
+	c.get(b)
+	c.i64(6)
+	c.op("i64.shr_u")
+	c.i64(1)
+	c.op("i64.and")
+	c.get(b)
+	c.i64(5)
+	c.op("i64.shr_u")
+	c.i64(4)
+	c.op("i64.and", "i64.or")
+	c.get(b)
+	c.i64(6)
+	c.op("i64.shr_u")
+	c.i64(8)
+	c.op("i64.and", "i64.or", "i32.wrap_i64")
+	c.set(oflags)
+	// the rights of files and directories, which are all but sock_shutdown. WASI opens the file for reading
+	// and writing by the rights, so the rights to read and write are by O_ACCMODE
+	c.i64(0x0fffffff &^ wasmReadRights &^ wasmWriteRights)
+	c.set(rights)
+	for _, r := range []struct {
+		notMode, rights int64
+	}{{1, wasmReadRights}, {0, wasmWriteRights}} { // O_WRONLY doesn't read and O_RDONLY doesn't write
+		c.get(b)
+		c.i64(3)
+		c.op("i64.and")
+		c.i64(r.notMode)
+		c.op("i64.ne", "if")
+		c.get(rights)
+		c.i64(r.rights)
+		c.op("i64.or")
+		c.set(rights)
+		c.op("end")
+	}
+	c.i32(3) // the fd of /
+	c.i32(1) // follow symlinks
+	c.get(path)
+	c.get(n)
+	c.get(oflags)
+	c.get(rights)
+	c.get(rights)
+	c.get(b) // O_APPEND to FDFLAGS_APPEND
+	c.i64(10)
+	c.op("i64.shr_u")
+	c.i64(1)
+	c.op("i64.and", "i32.wrap_i64")
+	c.i32(wasmResult)
+	c.call(wasmPathOpen)
+	errnoReturn()
+	c.i32(wasmResult)
+	c.mem("i32.load")
+	c.op("i64.extend_i32_u", "return", "end")
+
+	c.i64(-38) // ENOSYS
+	c.op("end")
+	return *c
+}
@@ -0,0 +1,674 @@
+package main
+
+import (
+	"encoding/binary"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// the built-in assembler encodes the x86-64 instructions of the output to an object like GNU as, so -obj doesn't need binutils.
+// it chooses the same encodings as GNU as: the shortest immediates, the forms of rax like addq $1000, %rax,
+// and jumps to labels in the same section relaxed to rel8 when the target is near
+const (
+	rX86_64_64    = 1
+	rX86_64_PC32  = 2
+	rX86_64_PLT32 = 4
+	rX86_64_32S   = 11
+)
+
+var x86RegNums = map[string]int{
+	"rax": 0, "rcx": 1, "rdx": 2, "rbx": 3, "rsp": 4, "rbp": 5, "rsi": 6, "rdi": 7,
+	"r8": 8, "r9": 9, "r10": 10, "r11": 11, "r12": 12, "r13": 13, "r14": 14, "r15": 15,
+}
+
+var x86Conds = map[string]int{
+	"o": 0, "no": 1, "b": 2, "c": 2, "nae": 2, "ae": 3, "nb": 3, "nc": 3, "e": 4, "z": 4, "ne": 5, "nz": 5,
+	"be": 6, "na": 6, "a": 7, "nbe": 7, "s": 8, "ns": 9, "p": 10, "pe": 10, "np": 11, "po": 11,
+	"l": 12, "nge": 12, "ge": 13, "nl": 13, "le": 14, "ng": 14, "g": 15, "nle": 15,
+}
+
+// x86ALU are the digits of the arithmetic instructions in their opcodes
+var x86ALU = map[string]int{"add": 0, "or": 1, "and": 4, "sub": 5, "xor": 6, "cmp": 7}
+
+var x86Shifts = map[string]int{"shl": 4, "sal": 4, "shr": 5, "sar": 7}
+
+// x86Fixup is a field of an instruction or data referring to a symbol, which is patched or relocated after the layout
+type x86Fixup struct {
+	offset int // of the field in the instruction
+	size   int // 4 or 8
+	typ    uint32
+	sym    string
+	addend int64
+}
+
+// x86Inst is an instruction, data or a label in a section
+type x86Inst struct {
+	b      []byte
+	fixups []x86Fixup
+	label  string // the label defined here
+	branch string // the target of jmp or jcc, which is relaxed to rel8 when it is near in the same section
+	cc     int    // the condition of jcc, or -1 for jmp
+	short  bool
+	offset int // in the section
+}
+
+type x86Section struct {
+	*objSection
+	insts []*x86Inst
+}
+
+type x86Assembler struct {
+	sections  []*x86Section
+	cur       *x86Section
+	labels    map[string]*x86Inst
+	sectOf    map[*x86Inst]*x86Section
+	globals   map[string]bool
+	order     []any // the sections and the symbols in the order they are made or mentioned, which is the order of the symbol table
+	mentioned map[string]bool
+}
+
+// assembleX86 encodes lines of x86-64 AT&T assembly to an object
+func assembleX86(lines []*asmLine) *object {
+	a := &x86Assembler{labels: map[string]*x86Inst{}, sectOf: map[*x86Inst]*x86Section{}, globals: map[string]bool{}, mentioned: map[string]bool{}}
+	// GNU as makes .text, .data and .bss first
+	a.section(".text")
+	a.section(".data")
+	a.section(".bss")
+	a.section(".text")
+	for _, line := range lines {
+		a.line(line)
+	}
+	for _, s := range a.sections {
+		a.layout(s)
+	}
+	return a.object()
+}
+
+func (a *x86Assembler) section(name string) {
+	for _, s := range a.sections {
+		if s.name == name {
+			a.cur = s
+			return
+		}
+	}
+	s := &x86Section{objSection: &objSection{name: name, typ: shtProgbits}}
+	switch {
+	case name == ".text":
+		s.flags = shfAlloc | shfExecinstr
+	case name == ".bss":
+		s.typ = shtNobits
+		s.flags = shfAlloc | shfWrite
+	case strings.HasPrefix(name, ".rodata"):
+		s.flags = shfAlloc
+	default:
+		s.flags = shfAlloc | shfWrite
+	}
+	a.sections = append(a.sections, s)
+	a.order = append(a.order, s)
+	a.cur = s
+}
+
+func (a *x86Assembler) add(inst *x86Inst) {
+	a.cur.insts = append(a.cur.insts, inst)
+	a.sectOf[inst] = a.cur
+}
+
+func (a *x86Assembler) line(line *asmLine) {
+	s := strings.TrimSpace(line.text)
+	switch {
+	case s == "", strings.HasPrefix(s, "#"):
+	case isAsmLabel(line):
+		name := asmLabel(line)
+		if _, ok := a.labels[name]; ok {
+			must(fmt.Errorf("as: symbol %s is already defined", name))
+		}
+		inst := &x86Inst{label: name}
+		a.labels[name] = inst
+		a.add(inst)
+		a.mention(name)
+	case line.op == "":
+		text, _ := cutAsmComment(s)
+		directive, args, _ := strings.Cut(text, " ")
+		args = strings.TrimSpace(args)
+		switch directive {
+		case ".text", ".data":
+			a.section(directive)
+		case ".section":
+			name, _, _ := strings.Cut(args, ",")
+			a.section(strings.TrimSpace(name))
+		case ".global", ".globl":
+			a.globals[asmUnquote(args)] = true
+			a.mention(asmUnquote(args))
+		case ".quad":
+			for _, arg := range splitAsmOperands(args) {
+				if v, err := strconv.ParseInt(arg, 0, 64); err == nil {
+					a.add(&x86Inst{b: binary.LittleEndian.AppendUint64(nil, uint64(v))})
+					continue
+				}
+				name, offset := splitAsmSymbol(arg)
+				a.mention(name)
+				a.add(&x86Inst{b: make([]byte, 8), fixups: []x86Fixup{{size: 8, typ: rX86_64_64, sym: name, addend: offset}}})
+			}
+		case ".byte":
+			var b []byte
+			for _, arg := range splitAsmOperands(args) {
+				v, err := strconv.ParseInt(arg, 0, 64)
+				must(err)
+				b = append(b, byte(v))
+			}
+			a.add(&x86Inst{b: b})
+		case ".zero":
+			n, err := strconv.Atoi(args)
+			must(err)
+			a.add(&x86Inst{b: make([]byte, n)})
+		case ".ascii", ".string":
+			b := gasUnquote(args)
+			if directive == ".string" {
+				b = append(b, 0)
+			}
+			a.add(&x86Inst{b: b})
+		default:
+			must(fmt.Errorf("as: unsupported directive %s", directive))
+		}
+	default:
+		var args []x86Operand
+		for _, arg := range line.args {
+			if line.op == "rep" || line.op == "repe" {
+				args = append(args, x86Operand{sym: arg})
+				continue
+			}
+			op := parseX86Operand(arg)
+			if op.sym != "" {
+				name, _ := splitAsmSymbol(op.sym)
+				a.mention(name)
+			}
+			if op.sym != "" && !op.mem && line.op[0] != 'j' && !strings.HasPrefix(line.op, "call") {
+				op.mem, op.scale = true, 1 // an absolute address like leaq .S0, %rax
+			}
+			args = append(args, op)
+		}
+		a.add(a.instr(line.op, args))
+	}
+}
+
+// mention adds a symbol to the symbol table when it is defined or referred to for the first time, like GNU as.
+// the labels starting with .L are not in the table
+func (a *x86Assembler) mention(name string) {
+	if !a.mentioned[name] && !strings.HasPrefix(name, ".L") {
+		a.mentioned[name] = true
+		a.order = append(a.order, name)
+	}
+}
+
+// local reports whether sym is a label in the section s, which branches and pc-relative fields resolve without relocations
+func (a *x86Assembler) local(sym string, s *x86Section) bool {
+	label, ok := a.labels[sym]
+	return ok && !a.globals[sym] && a.sectOf[label] == s
+}
+
+func (a *x86Assembler) size(inst *x86Inst) int {
+	switch {
+	case inst.branch == "":
+		return len(inst.b)
+	case inst.short:
+		return 2
+	case inst.cc < 0:
+		return 5
+	}
+	return 6
+}
+
+// layout relaxes the branches and places the instructions of s. branches start short and get rel32 until all fit
+func (a *x86Assembler) layout(s *x86Section) {
+	for _, inst := range s.insts {
+		inst.short = inst.branch != "" && a.local(inst.branch, s)
+	}
+	for {
+		offset := 0
+		for _, inst := range s.insts {
+			inst.offset = offset
+			offset += a.size(inst)
+		}
+		changed := false
+		for _, inst := range s.insts {
+			if inst.short {
+				if disp := a.labels[inst.branch].offset - (inst.offset + 2); disp < -128 || disp > 127 {
+					inst.short = false
+					changed = true
+				}
+			}
+		}
+		if !changed {
+			break
+		}
+	}
+	for _, inst := range s.insts {
+		if inst.branch != "" {
+			a.encodeBranch(inst)
+		}
+		s.data = append(s.data, inst.b...)
+	}
+	if s.typ == shtNobits && len(s.data) > 0 {
+		must(fmt.Errorf("as: data in %s", s.name))
+	}
+}
+
+func (a *x86Assembler) encodeBranch(inst *x86Inst) {
+	switch {
+	case inst.short && inst.cc < 0:
+		inst.b = []byte{0xeb, 0}
+	case inst.short:
+		inst.b = []byte{0x70 + byte(inst.cc), 0}
+	case inst.cc < 0:
+		inst.b = []byte{0xe9, 0, 0, 0, 0}
+		inst.fixups = []x86Fixup{{offset: 1, size: 4, typ: rX86_64_PLT32, sym: inst.branch, addend: -4}}
+		return
+	default:
+		inst.b = []byte{0x0f, 0x80 + byte(inst.cc), 0, 0, 0, 0}
+		inst.fixups = []x86Fixup{{offset: 2, size: 4, typ: rX86_64_PC32, sym: inst.branch, addend: -4}}
+		return
+	}
+	inst.b[1] = byte(a.labels[inst.branch].offset - (inst.offset + 2))
+}
+
+// object resolves the fixups to the labels in the same section and makes relocations of the others.
+// like GNU as, relocations of local labels refer to the symbols of their sections
+func (a *x86Assembler) object() *object {
+	obj := &object{}
+	sectionSyms := map[*objSection]*objSymbol{}
+	syms := map[string]*objSymbol{}
+	used := map[*objSymbol]bool{}
+	for _, o := range a.order {
+		switch o := o.(type) {
+		case *x86Section:
+			sym := &objSymbol{section: o.objSection, isSection: true}
+			sectionSyms[o.objSection] = sym
+			obj.symbols = append(obj.symbols, sym)
+		case string:
+			sym := &objSymbol{name: o, global: true} // undefined
+			if label, ok := a.labels[o]; ok {
+				sym = &objSymbol{name: o, section: a.sectOf[label].objSection, value: int64(label.offset), global: a.globals[o]}
+			}
+			syms[o] = sym
+			obj.symbols = append(obj.symbols, sym)
+		}
+	}
+	for name := range a.globals {
+		if _, ok := syms[name]; !ok {
+			must(fmt.Errorf("as: global symbol %s is not defined", name))
+		}
+	}
+	for _, s := range a.sections {
+		for _, inst := range s.insts {
+			for _, f := range inst.fixups {
+				p := inst.offset + f.offset
+				label, defined := a.labels[f.sym]
+				switch {
+				case defined && !a.globals[f.sym] && f.typ != rX86_64_64 && a.sectOf[label] == s:
+					binary.LittleEndian.PutUint32(s.data[p:], uint32(int64(label.offset)+f.addend-int64(p)))
+				case defined && !a.globals[f.sym]:
+					sym := sectionSyms[a.sectOf[label].objSection]
+					used[sym] = true
+					s.relocs = append(s.relocs, objReloc{offset: int64(p), typ: f.typ, sym: sym, addend: int64(label.offset) + f.addend})
+				default:
+					sym := syms[f.sym]
+					used[sym] = true
+					s.relocs = append(s.relocs, objReloc{offset: int64(p), typ: f.typ, sym: sym, addend: f.addend})
+				}
+			}
+		}
+		obj.sections = append(obj.sections, s.objSection)
+	}
+	// the symbols of the sections without relocations are dropped like GNU as, and the global symbols follow the local ones
+	var locals, globals []*objSymbol
+	for _, sym := range obj.symbols {
+		switch {
+		case sym.isSection && !used[sym]:
+		case sym.global:
+			globals = append(globals, sym)
+		default:
+			locals = append(locals, sym)
+		}
+	}
+	obj.symbols = append(locals, globals...)
+	return obj
+}
+
+// x86Enc is an instruction being encoded
+type x86Enc struct {
+	prefix []byte // the legacy prefixes before REX
+	rex    byte
+	rex8   bool // spl, bpl, sil and dil need REX
+	opcode []byte
+	modrm  []byte // modrm, sib and displacement
+	imm    []byte
+	fixup  *x86Fixup
+	dispAt int // the offset of the displacement in modrm
+}
+
+func (e *x86Enc) inst() *x86Inst {
+	var b []byte
+	b = append(b, e.prefix...)
+	if e.rex != 0 || e.rex8 {
+		b = append(b, 0x40|e.rex)
+	}
+	b = append(b, e.opcode...)
+	inst := &x86Inst{}
+	if e.fixup != nil {
+		f := *e.fixup
+		f.offset = len(b) + e.dispAt
+		if f.typ == rX86_64_PC32 { // relative to the end of the instruction
+			f.addend -= int64(len(e.modrm) - e.dispAt + len(e.imm))
+		}
+		inst.fixups = []x86Fixup{f}
+	}
+	b = append(b, e.modrm...)
+	b = append(b, e.imm...)
+	inst.b = b
+	return inst
+}
+
+func x86RegNum(op x86Operand) int {
+	n, ok := x86RegNums[op.reg]
+	if !ok {
+		must(fmt.Errorf("as: unknown register %s", op.reg))
+	}
+	return n
+}
+
+// x86Encode encodes an instruction of opcode with the field reg, a register or a digit, and the operand rm
+func x86Encode(width int, opcode []byte, reg int, rm x86Operand, imm []byte) *x86Inst {
+	return x86EncodeRex8(width, opcode, reg, false, rm, imm)
+}
+
+// x86EncodeR encodes an instruction of opcode with the register r in the field reg
+func x86EncodeR(width int, opcode []byte, r x86Operand, rm x86Operand, imm []byte) *x86Inst {
+	n := x86RegNum(r)
+	return x86EncodeRex8(width, opcode, n, r.width == 8 && n >= 4 && n < 8, rm, imm)
+}
+
+func x86EncodeRex8(width int, opcode []byte, reg int, rex8 bool, rm x86Operand, imm []byte) *x86Inst {
+	e := &x86Enc{opcode: opcode, imm: imm, rex8: rex8}
+	if width == 16 {
+		e.prefix = []byte{0x66}
+	}
+	if width == 64 {
+		e.rex |= 8
+	}
+	if reg >= 8 {
+		e.rex |= 4
+	}
+	e.encodeRM(reg, rm)
+	return e.inst()
+}
+
+func (e *x86Enc) encodeRM(reg int, rm x86Operand) {
+	if !rm.mem {
+		n := x86RegNum(rm)
+		if n >= 8 {
+			e.rex |= 1
+		}
+		e.rex8 = e.rex8 || rm.width == 8 && n >= 4 && n < 8
+		e.modrm = []byte{0xc0 | byte(reg&7)<<3 | byte(n&7)}
+		return
+	}
+	sym, offset := "", rm.disp
+	if rm.sym != "" {
+		var off int64
+		sym, off = splitAsmSymbol(rm.sym)
+		offset += off
+	}
+	index := 4 // none
+	if rm.index != "" {
+		index = x86RegNums[rm.index]
+		if index >= 8 {
+			e.rex |= 2
+		}
+	}
+	scale := map[int]byte{1: 0, 2: 1, 4: 2, 8: 3}[rm.scale]
+	disp32 := func() {
+		e.dispAt = len(e.modrm)
+		e.modrm = binary.LittleEndian.AppendUint32(e.modrm, uint32(offset))
+		if sym != "" {
+			typ := uint32(rX86_64_32S)
+			if rm.base == "rip" {
+				typ = rX86_64_PC32
+			}
+			e.fixup = &x86Fixup{size: 4, typ: typ, sym: sym, addend: offset}
+			binary.LittleEndian.PutUint32(e.modrm[e.dispAt:], 0)
+		}
+	}
+	switch rm.base {
+	case "rip":
+		e.modrm = []byte{byte(reg&7)<<3 | 5}
+		disp32()
+	case "": // an absolute address like .S0
+		e.modrm = []byte{byte(reg&7)<<3 | 4, scale<<6 | byte(index&7)<<3 | 5}
+		disp32()
+	default:
+		base := x86RegNums[rm.base]
+		if base >= 8 {
+			e.rex |= 1
+		}
+		var mod byte
+		switch {
+		case sym != "":
+			mod = 2
+		case offset == 0 && base&7 != 5:
+			mod = 0
+		case offset >= -128 && offset <= 127:
+			mod = 1
+		default:
+			mod = 2
+		}
+		if base&7 == 4 || rm.index != "" {
+			e.modrm = []byte{mod<<6 | byte(reg&7)<<3 | 4, scale<<6 | byte(index&7)<<3 | byte(base&7)}
+		} else {
+			e.modrm = []byte{mod<<6 | byte(reg&7)<<3 | byte(base&7)}
+		}
+		switch mod {
+		case 1:
+			e.modrm = append(e.modrm, byte(offset))
+		case 2:
+			disp32()
+		}
+	}
+}
+
+func fitsInt8(v int64) bool  { return v >= -128 && v <= 127 }
+func fitsInt32(v int64) bool { return v >= -1<<31 && v < 1<<31 }
+
+// x86Imm returns the immediate of bits, which is 32 for 64-bit operands
+func x86Imm(v int64, bits int) []byte {
+	switch bits {
+	case 8:
+		return []byte{byte(v)}
+	case 16:
+		return binary.LittleEndian.AppendUint16(nil, uint16(v))
+	}
+	return binary.LittleEndian.AppendUint32(nil, uint32(v))
+}
+
+// x86Opcode returns the opcode for the operands of width, which is op8 for bytes and op8+1 otherwise
+func x86Opcode(width int, op8 byte) []byte {
+	if width == 8 {
+		return []byte{op8}
+	}
+	return []byte{op8 + 1}
+}
+
+// x86OpReg encodes an instruction with the register in the opcode like pushq %rbx
+func x86OpReg(width int, opcode byte, r x86Operand, imm []byte) *x86Inst {
+	e := &x86Enc{imm: imm}
+	if width == 16 {
+		e.prefix = []byte{0x66}
+	}
+	if width == 64 {
+		e.rex |= 8
+	}
+	n := x86RegNum(r)
+	if n >= 8 {
+		e.rex |= 1
+	}
+	e.rex8 = r.width == 8 && n >= 4 && n < 8
+	e.opcode = []byte{opcode + byte(n&7)}
+	return e.inst()
+}
+
+func (a *x86Assembler) instr(op string, args []x86Operand) *x86Inst {
+	switch op {
+	case "ret":
+		return &x86Inst{b: []byte{0xc3}}
+	case "leave":
+		return &x86Inst{b: []byte{0xc9}}
+	case "cqto", "cqo":
+		return &x86Inst{b: []byte{0x48, 0x99}}
+	case "syscall":
+		return &x86Inst{b: []byte{0x0f, 0x05}}
+	case "rep", "repe":
+		b, ok := map[string][]byte{"rep movsb": {0xf3, 0xa4}, "rep stosb": {0xf3, 0xaa}, "repe cmpsb": {0xf3, 0xa6}}[op+" "+args[0].sym]
+		if !ok {
+			must(fmt.Errorf("as: unsupported instruction %s %s", op, args[0].sym))
+		}
+		return &x86Inst{b: b}
+	case "callq", "call", "jmp":
+		digit := map[bool]int{true: 2, false: 4}[op != "jmp"]
+		switch {
+		case args[0].reg != "", args[0].mem:
+			return x86Encode(32, []byte{0xff}, digit, args[0], nil)
+		case op == "jmp":
+			return &x86Inst{branch: asmUnquote(args[0].sym), cc: -1}
+		}
+		sym, offset := splitAsmSymbol(args[0].sym)
+		return &x86Inst{b: []byte{0xe8, 0, 0, 0, 0}, fixups: []x86Fixup{{offset: 1, size: 4, typ: rX86_64_PLT32, sym: sym, addend: offset - 4}}}
+	case "movzbq", "movzbl", "movzwq", "movzwl", "movsbq", "movsbl", "movswq", "movswl", "movslq":
+		width := x86Width(op)
+		if op == "movslq" {
+			return x86EncodeR(width, []byte{0x63}, args[1], args[0], nil)
+		}
+		opcode := map[string]byte{"movzb": 0xb6, "movzw": 0xb7, "movsb": 0xbe, "movsw": 0xbf}[op[:5]]
+		return x86EncodeR(width, []byte{0x0f, opcode}, args[1], args[0], nil)
+	}
+	if cc, ok := x86Conds[strings.TrimPrefix(op, "j")]; ok && op[0] == 'j' {
+		return &x86Inst{branch: asmUnquote(args[0].sym), cc: cc}
+	}
+	if cc, ok := x86Conds[strings.TrimPrefix(op, "set")]; ok && strings.HasPrefix(op, "set") {
+		return x86Encode(8, []byte{0x0f, 0x90 + byte(cc)}, 0, args[0], nil)
+	}
+	if cc, ok := x86Conds[strings.TrimSuffix(strings.TrimPrefix(op, "cmov"), op[len(op)-1:])]; ok && strings.HasPrefix(op, "cmov") {
+		return x86EncodeR(x86Width(op), []byte{0x0f, 0x40 + byte(cc)}, args[1], args[0], nil)
+	}
+	base := op[:len(op)-1]
+	if !x86Suffixed[base] {
+		must(fmt.Errorf("as: unsupported instruction %s", op))
+	}
+	width := x86Width(op)
+	src, dst := args[0], args[len(args)-1]
+	if n, ok := x86ALU[base]; ok {
+		switch {
+		case src.isImm && width == 8 && !dst.mem && x86RegNum(dst) == 0: // addb $1, %al
+			return &x86Inst{b: []byte{byte(n)<<3 | 4, byte(src.imm)}}
+		case src.isImm && width == 8:
+			return x86Encode(width, []byte{0x80}, n, dst, x86Imm(src.imm, 8))
+		case src.isImm && fitsInt8(src.imm):
+			return x86Encode(width, []byte{0x83}, n, dst, x86Imm(src.imm, 8))
+		case src.isImm && !dst.mem && x86RegNum(dst) == 0: // addq $1000, %rax
+			e := &x86Enc{opcode: []byte{byte(n)<<3 | 5}, imm: x86Imm(src.imm, width)}
+			if width == 64 {
+				e.rex = 8
+			} else if width == 16 {
+				e.prefix = []byte{0x66}
+			}
+			return e.inst()
+		case src.isImm:
+			return x86Encode(width, []byte{0x81}, n, dst, x86Imm(src.imm, width))
+		case src.mem:
+			return x86EncodeR(width, x86Opcode(width, byte(n)<<3|2), dst, src, nil)
+		}
+		return x86EncodeR(width, x86Opcode(width, byte(n)<<3), src, dst, nil)
+	}
+	if n, ok := x86Shifts[base]; ok {
+		switch {
+		case len(args) == 1 || src.isImm && src.imm == 1:
+			return x86Encode(width, x86Opcode(width, 0xd0), n, dst, nil)
+		case src.isImm:
+			return x86Encode(width, x86Opcode(width, 0xc0), n, dst, x86Imm(src.imm, 8))
+		case src.reg == "rcx" && src.width == 8:
+			return x86Encode(width, x86Opcode(width, 0xd2), n, dst, nil)
+		}
+		must(fmt.Errorf("as: unsupported operand of %s", op))
+	}
+	switch base {
+	case "mov":
+		switch {
+		case src.isImm && dst.mem:
+			return x86Encode(width, x86Opcode(width, 0xc6), 0, dst, x86Imm(src.imm, width))
+		case src.isImm && width == 64 && fitsInt32(src.imm):
+			return x86Encode(width, []byte{0xc7}, 0, dst, x86Imm(src.imm, 32))
+		case src.isImm && width == 64: // movabs
+			return x86OpReg(width, 0xb8, dst, binary.LittleEndian.AppendUint64(nil, uint64(src.imm)))
+		case src.isImm:
+			return x86OpReg(width, map[bool]byte{true: 0xb0, false: 0xb8}[width == 8], dst, x86Imm(src.imm, width))
+		case src.mem:
+			return x86EncodeR(width, x86Opcode(width, 0x8a), dst, src, nil)
+		}
+		return x86EncodeR(width, x86Opcode(width, 0x88), src, dst, nil)
+	case "test":
+		switch {
+		case src.isImm && !dst.mem && x86RegNum(dst) == 0:
+			e := &x86Enc{opcode: x86Opcode(width, 0xa8), imm: x86Imm(src.imm, width)}
+			if width == 64 {
+				e.rex = 8
+			}
+			return e.inst()
+		case src.isImm:
+			return x86Encode(width, x86Opcode(width, 0xf6), 0, dst, x86Imm(src.imm, width))
+		case src.mem:
+			src, dst = dst, src
+		}
+		return x86EncodeR(width, x86Opcode(width, 0x84), src, dst, nil)
+	case "lea":
+		return x86EncodeR(width, []byte{0x8d}, dst, src, nil)
+	case "push":
+		switch {
+		case src.isImm && fitsInt8(src.imm):
+			return &x86Inst{b: []byte{0x6a, byte(src.imm)}}
+		case src.isImm:
+			return &x86Inst{b: append([]byte{0x68}, x86Imm(src.imm, 32)...)}
+		case src.mem:
+			return x86Encode(32, []byte{0xff}, 6, src, nil)
+		}
+		return x86OpReg(32, 0x50, src, nil)
+	case "pop":
+		if src.mem {
+			return x86Encode(32, []byte{0x8f}, 0, src, nil)
+		}
+		return x86OpReg(32, 0x58, src, nil)
+	case "inc", "dec":
+		return x86Encode(width, x86Opcode(width, 0xfe), map[string]int{"inc": 0, "dec": 1}[base], dst, nil)
+	case "not", "neg", "div", "idiv":
+		return x86Encode(width, x86Opcode(width, 0xf6), map[string]int{"not": 2, "neg": 3, "div": 6, "idiv": 7}[base], dst, nil)
+	case "imul":
+		rm := dst // imulq $n, %rax multiplies rax
+		if len(args) == 3 {
+			rm = args[1]
+		}
+		switch {
+		case src.isImm && fitsInt8(src.imm):
+			return x86EncodeR(width, []byte{0x6b}, dst, rm, x86Imm(src.imm, 8))
+		case src.isImm:
+			return x86EncodeR(width, []byte{0x69}, dst, rm, x86Imm(src.imm, width))
+		}
+		return x86EncodeR(width, []byte{0x0f, 0xaf}, dst, src, nil)
+	case "bts", "btr":
+		if src.isImm {
+			must(fmt.Errorf("as: unsupported operand of %s", op))
+		}
+		return x86EncodeR(width, []byte{0x0f, map[string]byte{"bts": 0xab, "btr": 0xb3}[base]}, src, dst, nil)
+	case "bsr":
+		return x86EncodeR(width, []byte{0x0f, 0xbd}, dst, src, nil)
+	}
+	must(fmt.Errorf("as: unsupported instruction %s", op))
+	return nil
+}